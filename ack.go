@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Active Alerts & Acknowledgments
+=============================
+*/
+
+type ack struct {
+	Fingerprint string    `json:"fingerprint"`
+	By          string    `json:"by"`
+	Comment     string    `json:"comment,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// ackKey scopes a fingerprint to its tenant: two tenants may send the same
+// label set, and one must not acknowledge the other's alert.
+type ackKey struct {
+	tenant, fp string
+}
+
+// trackedAlert is an active alert and when it was last reported firing.
+type trackedAlert struct {
+	alert Alert
+	seen  time.Time
+}
+
+type alertTracker struct {
+	mu        sync.Mutex
+	active    map[ackKey]trackedAlert
+	acks      map[ackKey]ack
+	lastPrune time.Time
+}
+
+var (
+	tracker = &alertTracker{active: make(map[ackKey]trackedAlert), acks: make(map[ackKey]ack)}
+
+	ackSuppress = flag.Duration("ack-suppress", 4*time.Hour, "suppress repeat notifications for acknowledged alerts for this long")
+	activeTTL   = flag.Duration("active-ttl", 24*time.Hour,
+		"forget an active alert, and its acknowledgment, when it has not been reported firing for this long; keep it above Alertmanager's repeat_interval")
+)
+
+// fingerprint prefers the value computed by Alertmanager and falls back to
+// a hash of the sorted label set.
+func (a Alert) fingerprint() string {
+	if a.Fingerprint != "" {
+		return a.Fingerprint
+	}
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(a.Labels[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// observe records firing alerts and forgets resolved ones along with any
+// acknowledgment, so the next firing starts unacknowledged.
+func (t *alertTracker) observe(tenant string, a Alert, now time.Time) {
+	k := ackKey{tenant, a.fingerprint()}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(now)
+	if a.Status == "resolved" {
+		delete(t.active, k)
+		delete(t.acks, k)
+		return
+	}
+	t.active[k] = trackedAlert{a, now}
+}
+
+// pruneLocked forgets alerts whose resolution never arrived, at most once
+// a minute. t.mu is held.
+func (t *alertTracker) pruneLocked(now time.Time) {
+	if now.Sub(t.lastPrune) < time.Minute {
+		return
+	}
+	t.lastPrune = now
+	for k, ta := range t.active {
+		if now.Sub(ta.seen) >= *activeTTL {
+			delete(t.active, k)
+			delete(t.acks, k)
+		}
+	}
+}
+
+// snapshot returns the tenant's active alerts by fingerprint.
+func (t *alertTracker) snapshot(tenant string, now time.Time) map[string]Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(now)
+	out := make(map[string]Alert)
+	for k, ta := range t.active {
+		if k.tenant == tenant {
+			out[k.fp] = ta.alert
+		}
+	}
+	return out
+}
+
+func (t *alertTracker) acknowledge(tenant, fp, by, comment string, now time.Time) (ack, bool) {
+	k := ackKey{tenant, fp}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pruneLocked(now)
+	if _, ok := t.active[k]; !ok {
+		return ack{}, false
+	}
+	a := ack{Fingerprint: fp, By: by, Comment: comment, At: now}
+	t.acks[k] = a
+	return a, true
+}
+
+func (t *alertTracker) ackFor(tenant, fp string) (ack, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	a, ok := t.acks[ackKey{tenant, fp}]
+	return a, ok
+}
+
+// suppressed reports whether a repeat notification for the tenant's fp
+// falls inside the acknowledgment window.
+func (t *alertTracker) suppressed(tenant, fp string, now time.Time) bool {
+	a, ok := t.ackFor(tenant, fp)
+	return ok && now.Sub(a.At) < *ackSuppress
+}
+
+// unacked drops firing alerts acknowledged within -ack-suppress, for the
+// notification sinks; resolutions always go out.
+func unacked(ctx context.Context, alerts []Alert) []Alert {
+	now := clock.Now()
+	var out []Alert
+	for _, a := range alerts {
+		if a.Status != "resolved" && tracker.suppressed(tenantOf(ctx), a.fingerprint(), now) {
+			tracef(ctx, "ack", a.Fingerprint, "suppressed, acknowledged within -ack-suppress")
+			continue
+		}
+		out = append(out, a)
+	}
+	return out
+}
+
+/*
+=============================
+ Ack Handler
+=============================
+*/
+
+// ackHandler acknowledges an active alert. An ack holds back its repeat
+// notifications, so it is mounted behind the admin token; "by" names the
+// responder for the record.
+func ackHandler(w http.ResponseWriter, r *http.Request) {
+	fp := r.PathValue("fingerprint")
+
+	var req struct {
+		By      string `json:"by"`
+		Comment string `json:"comment"`
+	}
+	body, err := bodyReader(w, r)
+	if err == nil {
+		err = json.NewDecoder(body).Decode(&req)
+	}
+	if err != nil || req.By == "" {
+		http.Error(w, `body must be JSON with a non-empty "by"`, http.StatusBadRequest)
+		return
+	}
+
+	a, ok := tracker.acknowledge(tenantOf(r.Context()), fp, req.By, req.Comment, clock.Now())
+	if !ok {
+		http.Error(w, "no active alert with that fingerprint", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a)
+}
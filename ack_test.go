@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAlertTracker(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	disk := Alert{Status: "firing", Labels: map[string]string{"alertname": "Disk"}}
+	fp := disk.fingerprint()
+
+	tests := []struct {
+		name    string
+		steps   func(tr *alertTracker)
+		tenant  string
+		at      time.Time
+		wantAck bool
+		wantSup bool
+	}{
+		{"acknowledged", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+		}, "team-a", start.Add(time.Hour), true, true},
+		{"window over", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+		}, "team-a", start.Add(*ackSuppress), true, false},
+		{"other tenant's alert", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+		}, "team-b", start.Add(time.Hour), false, false},
+		{"acknowledged by other tenant", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.observe("team-b", disk, start)
+			tr.acknowledge("team-b", fp, "ops", "", start)
+		}, "team-a", start.Add(time.Hour), false, false},
+		{"not active", func(tr *alertTracker) {
+			tr.acknowledge("team-a", fp, "ops", "", start)
+		}, "team-a", start.Add(time.Hour), false, false},
+		{"resolved", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+			resolved := disk
+			resolved.Status = "resolved"
+			tr.observe("team-a", resolved, start.Add(time.Minute))
+		}, "team-a", start.Add(time.Hour), false, false},
+		{"resolution never came", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+			tr.snapshot("team-a", start.Add(*activeTTL))
+		}, "team-a", start.Add(time.Hour), false, false},
+		{"still repeating", func(tr *alertTracker) {
+			tr.observe("team-a", disk, start)
+			tr.acknowledge("team-a", fp, "ops", "", start)
+			tr.observe("team-a", disk, start.Add(*activeTTL-time.Hour))
+			tr.snapshot("team-a", start.Add(*activeTTL))
+		}, "team-a", start.Add(time.Hour), true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := &alertTracker{active: make(map[ackKey]trackedAlert), acks: make(map[ackKey]ack)}
+			tt.steps(tr)
+			if _, ok := tr.ackFor(tt.tenant, fp); ok != tt.wantAck {
+				t.Errorf("acknowledged = %v, want %v", ok, tt.wantAck)
+			}
+			if got := tr.suppressed(tt.tenant, fp, tt.at); got != tt.wantSup {
+				t.Errorf("suppressed = %v, want %v", got, tt.wantSup)
+			}
+		})
+	}
+}
+
+func TestUnacked(t *testing.T) {
+	defer func(tr *alertTracker) { tracker = tr }(tracker)
+	tracker = &alertTracker{active: make(map[ackKey]trackedAlert), acks: make(map[ackKey]ack)}
+	now := clock.Now()
+
+	firing := Alert{Status: "firing", Labels: map[string]string{"alertname": "Disk"}}
+	resolved := Alert{Status: "resolved", Labels: map[string]string{"alertname": "Disk"}}
+	other := Alert{Status: "firing", Labels: map[string]string{"alertname": "CPU"}}
+	tracker.observe("team-a", firing, now)
+	tracker.observe("team-a", other, now)
+	tracker.acknowledge("team-a", firing.fingerprint(), "ops", "", now)
+
+	tests := []struct {
+		tenant string
+		want   int
+	}{
+		{"team-a", 2}, // the resolution and CPU
+		{"team-b", 3},
+	}
+	for _, tt := range tests {
+		got := unacked(withTenantValue(context.Background(), tt.tenant), []Alert{firing, resolved, other})
+		if len(got) != tt.want {
+			t.Errorf("%s: %d alert(s) left, want %d", tt.tenant, len(got), tt.want)
+		}
+	}
+}
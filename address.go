@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+)
+
+/*
+=============================
+ Address Extraction
+=============================
+*/
+
+var addressLabelsFlag = flag.String("address-labels", "instance", "comma-separated labels an alert's address is taken from, in order, e.g. instance,node,pod_ip: the ip field and inventory use the first one set, site, region and -reverse-dns the first holding an IP address")
+
+// addressLabels is -address-labels, checked.
+var addressLabels = []string{"instance"}
+
+func loadAddressLabels() error {
+	names := splitList(*addressLabelsFlag)
+	if len(names) == 0 {
+		return errors.New("-address-labels needs at least one label")
+	}
+	for _, name := range names {
+		if !labelName.MatchString(name) {
+			return fmt.Errorf("invalid label %q in -address-labels", name)
+		}
+	}
+	addressLabels = names
+	return nil
+}
+
+// alertHosts lists the hosts of the address labels an alert carries, in
+// the order of -address-labels.
+func alertHosts(labels map[string]string) []string {
+	var hosts []string
+	for _, name := range addressLabels {
+		if host := instanceHost(labels[name]); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// alertAddr is the first IP address among the alert's address labels, so a
+// node name in instance does not hide the pod_ip next to it.
+func alertAddr(labels map[string]string) (netip.Addr, bool) {
+	for _, host := range alertHosts(labels) {
+		if addr, err := netip.ParseAddr(host); err == nil {
+			return addr.Unmap(), true
+		}
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+)
+
+/*
+=============================
+ Admin Listener
+=============================
+*/
+
+var adminListen = flag.String("admin-listen", "",
+	"address of a second listener for the admin endpoints (/metrics, /healthz, /render, /selftest, /api/check-upstream, and /debug/pprof/ and /debug/vars with -admin-token-file), e.g. 127.0.0.1:9090; empty serves them on -listen, without the debug endpoints")
+
+// adminRoutes mounts the admin endpoints. The debug endpoints are only
+// offered on the admin listener, never next to the webhook, and take the
+// admin token like /render.
+func adminRoutes(mux *http.ServeMux, withDebug bool) {
+	mux.HandleFunc("GET /metrics", metricsHandler)
+	mux.HandleFunc("GET /healthz", healthHandler)
+	mux.HandleFunc("POST /render", withAdminToken(renderHandler))
+	mux.HandleFunc("POST /selftest", withAdminToken(selftestHandler))
+	mux.HandleFunc("POST /api/check-upstream", withAdminToken(checkUpstreamHandler))
+	if withDebug {
+		mux.HandleFunc("/debug/pprof/", withAdminToken(pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", withAdminToken(pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", withAdminToken(pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", withAdminToken(pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", withAdminToken(pprof.Trace))
+		mux.HandleFunc("GET /debug/vars", withAdminToken(expvar.Handler().ServeHTTP))
+	}
+}
+
+// withAdminToken answers 401 without the admin token, and 403 while no
+// token is configured.
+func withAdminToken(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := authorizeAdmin(r); err != nil {
+			code := http.StatusUnauthorized
+			if errors.Is(err, errAdminDisabled) {
+				code = http.StatusForbidden
+			} else {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		h(w, r)
+	}
+}
+
+// /debug/vars adds the runtime and the bridge's internals to expvar's
+// cmdline and memstats.
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() any { return runtime.NumGoroutine() }))
+	expvar.Publish("queue", expvar.Func(queueVars))
+	expvar.Publish("breakers", expvar.Func(func() any {
+		out := map[string]string{}
+		for name, state := range breakerStates() {
+			out[name] = breakerState(state).String()
+		}
+		return out
+	}))
+	expvar.Publish("sinks", expvar.Func(func() any { return enabledSinkNames() }))
+}
+
+type queueStats struct {
+	Priority    int   `json:"priority"`
+	Normal      int   `json:"normal"`
+	Capacity    int   `json:"capacity"`
+	Workers     int   `json:"workers"`
+	Closed      bool  `json:"closed"`
+	Flushed     int64 `json:"flushed"`
+	Interrupted int64 `json:"interrupted"`
+}
+
+func queueVars() any {
+	if queue == nil {
+		return nil
+	}
+	queue.mu.Lock()
+	closed := queue.closed
+	queue.mu.Unlock()
+	return queueStats{
+		Priority:    len(queue.priority),
+		Normal:      len(queue.normal),
+		Capacity:    cap(queue.normal),
+		Workers:     *queueWorkers,
+		Closed:      closed,
+		Flushed:     queue.flushed.Load(),
+		Interrupted: queue.interrupted.Load(),
+	}
+}
+
+// startAdmin serves the admin endpoints on -admin-listen, plain HTTP, and
+// stops it last on shutdown so /metrics can be watched while draining.
+func startAdmin() error {
+	ln, err := net.Listen("tcp", *adminListen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	adminRoutes(mux, true)
+	server := &http.Server{
+		Handler:     withMetrics(mux, withAccessLog(withConfig(withRequestID(withTenant(mux))))),
+		ReadTimeout: 5 * time.Second,
+		// No write deadline: a CPU profile takes as long as it was asked for.
+	}
+	go server.Serve(ln)
+	slog.Info("admin listening", "addr", ln.Addr().String())
+
+	lifecycle.onShutdown("admin", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	})
+	return nil
+}
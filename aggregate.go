@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Repeat Aggregation
+=============================
+*/
+
+var aggregateInterval = flag.Duration("aggregate-interval", 0,
+	"collapse repeat firings of the same alert into one entry per interval carrying a repeats count; 0 writes every firing")
+
+// pendingRepeat holds the newest repeat of an alert that has already been
+// written once in the current interval.
+type pendingRepeat struct {
+	tenant   string
+	fileName string
+	entry    JSONLog
+	repeats  int
+}
+
+type repeatAggregator struct {
+	mu      sync.Mutex
+	pending map[string]*pendingRepeat
+}
+
+var repeats = &repeatAggregator{pending: make(map[string]*pendingRepeat)}
+
+// absorb reports whether a firing entry was folded into the pending
+// repeat for its alert. The first firing in an interval is never absorbed,
+// so new alerts are written without delay.
+func (a *repeatAggregator) absorb(tenant, fp, fileName string, entry JSONLog) bool {
+	if *aggregateInterval <= 0 {
+		return false
+	}
+	key := tenant + "/" + fp
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[key]
+	if !ok {
+		a.pending[key] = &pendingRepeat{tenant: tenant}
+		return false
+	}
+	p.fileName, p.entry = fileName, entry
+	p.repeats++
+	return true
+}
+
+// forget flushes and drops an alert's pending repeats, e.g. once it resolves.
+func (a *repeatAggregator) forget(tenant, fp string) {
+	key := tenant + "/" + fp
+
+	a.mu.Lock()
+	p, ok := a.pending[key]
+	delete(a.pending, key)
+	a.mu.Unlock()
+
+	if ok && p.repeats > 0 {
+		p.write(fp)
+	}
+}
+
+// flush writes one consolidated entry per alert that repeated during the
+// interval. Alerts that did not repeat are dropped, so their next firing
+// is written straight away again.
+func (a *repeatAggregator) flush() {
+	a.mu.Lock()
+	due := make(map[string]*pendingRepeat)
+	for key, p := range a.pending {
+		if p.repeats > 0 {
+			due[key] = &pendingRepeat{tenant: p.tenant, fileName: p.fileName, entry: p.entry, repeats: p.repeats}
+			p.repeats = 0
+		} else {
+			delete(a.pending, key)
+		}
+	}
+	a.mu.Unlock()
+
+	for key, p := range due {
+		p.write(key[len(p.tenant)+1:])
+	}
+}
+
+func (p *pendingRepeat) write(fp string) {
+	p.entry.Repeats = p.repeats
+	ctx := withTenantValue(context.Background(), p.tenant)
+	commitEntry(ctx, fp, p.fileName, p.entry, clock.Now())
+}
+
+func runAggregation(done <-chan struct{}) {
+	var every time.Duration
+	withSettings(func() { every = *aggregateInterval })
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			withSettings(repeats.flush)
+		}
+	}
+}
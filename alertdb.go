@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+/*
+=============================
+ Alert History Database
+=============================
+*/
+
+var historyDB = flag.String("history-db", "", "SQLite file recording every alert handed to the sinks and how its deliveries went, queried with GET /api/v1/alerts; empty disables it")
+
+// alertDB is nil unless -history-db is set.
+var alertDB *sql.DB
+
+// Times are Unix milliseconds; an alert without endsAt has a NULL ends_at.
+const alertDBSchema = `
+CREATE TABLE IF NOT EXISTS alerts (
+	id          INTEGER PRIMARY KEY,
+	received_at INTEGER NOT NULL,
+	tenant      TEXT NOT NULL,
+	request_id  TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	alertname   TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	starts_at   INTEGER,
+	ends_at     INTEGER,
+	labels      TEXT NOT NULL,
+	annotations TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS alerts_received ON alerts (tenant, received_at);
+CREATE INDEX IF NOT EXISTS alerts_alertname ON alerts (tenant, alertname, received_at);
+CREATE TABLE IF NOT EXISTS deliveries (
+	fingerprint TEXT NOT NULL,
+	request_id  TEXT NOT NULL,
+	tenant      TEXT NOT NULL,
+	sink        TEXT NOT NULL,
+	target      TEXT NOT NULL,
+	at          INTEGER NOT NULL,
+	result      TEXT NOT NULL,
+	error       TEXT NOT NULL,
+	retries     INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS deliveries_alert ON deliveries (fingerprint, request_id);
+`
+
+// openAlertDB opens -history-db, creating the tables on first use.
+func openAlertDB() error {
+	if *historyDB == "" {
+		return nil
+	}
+	db, err := sql.Open("sqlite", "file:"+*historyDB+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	// SQLite takes one writer at a time; queue workers wait their turn here
+	// rather than on SQLITE_BUSY.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(alertDBSchema); err != nil {
+		db.Close()
+		return err
+	}
+	alertDB = db
+	return nil
+}
+
+func closeAlertDB() error {
+	if alertDB == nil {
+		return nil
+	}
+	return alertDB.Close()
+}
+
+func unixMilli(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UnixMilli()
+}
+
+// recordAlerts stores the alerts of a request as the sinks get them:
+// processed and redacted. Duplicates dropped by -dedup-window are not
+// stored. A database error is logged and does not hold up delivery.
+func recordAlerts(ctx context.Context, tenant string, alerts []Alert) {
+	db := alertDB
+	if db == nil || len(alerts) == 0 {
+		return
+	}
+	err := func() error {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		now := clock.Now().UnixMilli()
+		for _, a := range alerts {
+			labels, _ := json.Marshal(a.Labels)
+			annotations, _ := json.Marshal(a.Annotations)
+			_, err := tx.Exec(`INSERT INTO alerts (received_at, tenant, request_id, fingerprint, alertname, status, starts_at, ends_at, labels, annotations)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				now, tenant, requestID(ctx), a.Fingerprint, a.Labels["alertname"], safeValue(a.Status, "firing"),
+				unixMilli(a.StartsAt), unixMilli(a.EndsAt), string(labels), string(annotations))
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	}()
+	if err != nil {
+		slog.Error("recording alert history", "req_id", requestID(ctx), "alerts", len(alerts), "err", err)
+	}
+}
+
+// recordDeliveryOutcome stores one notification attempt next to the alert.
+func recordDeliveryOutcome(d delivery) {
+	db := alertDB
+	if db == nil {
+		return
+	}
+	_, err := db.Exec(`INSERT INTO deliveries (fingerprint, request_id, tenant, sink, target, at, result, error, retries)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.Fingerprint, d.RequestID, d.Tenant, d.Sink, d.Target, d.At.UnixMilli(), d.Result, d.Error, d.Retries)
+	if err != nil {
+		slog.Error("recording delivery history", "req_id", d.RequestID, "sink", d.Sink, "err", err)
+	}
+}
+
+// pruneAlertDB drops what is older than -retention-days, like the files.
+func pruneAlertDB(now time.Time) {
+	db := alertDB
+	if db == nil || *retentionDays <= 0 {
+		return
+	}
+	cutoff := now.AddDate(0, 0, -*retentionDays).UnixMilli()
+	for _, q := range []string{
+		`DELETE FROM alerts WHERE received_at < ?`,
+		`DELETE FROM deliveries WHERE at < ?`,
+	} {
+		if _, err := db.Exec(q, cutoff); err != nil {
+			slog.Error("pruning alert history", "err", err)
+			return
+		}
+	}
+}
+
+type historyAlert struct {
+	ReceivedAt  time.Time         `json:"received_at"`
+	RequestID   string            `json:"req_id,omitempty"`
+	Fingerprint string            `json:"fingerprint"`
+	Status      string            `json:"status"`
+	StartsAt    *time.Time        `json:"startsAt,omitempty"`
+	EndsAt      *time.Time        `json:"endsAt,omitempty"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Deliveries  []delivery        `json:"deliveries"`
+}
+
+func fromUnixMilli(v sql.NullInt64) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := time.UnixMilli(v.Int64)
+	return &t
+}
+
+// queryAlertHistory returns the tenant's alerts received in [from, to),
+// newest first, each with its delivery attempts.
+func queryAlertHistory(ctx context.Context, db *sql.DB, tenant string, from, to time.Time, alertname, status string, limit int) ([]historyAlert, error) {
+	where := []string{"tenant = ?"}
+	args := []any{tenant}
+	if !from.IsZero() {
+		where = append(where, "received_at >= ?")
+		args = append(args, from.UnixMilli())
+	}
+	if !to.IsZero() {
+		where = append(where, "received_at < ?")
+		args = append(args, to.UnixMilli())
+	}
+	if alertname != "" {
+		where = append(where, "alertname = ?")
+		args = append(args, alertname)
+	}
+	if status != "" {
+		where = append(where, "status = ?")
+		args = append(args, status)
+	}
+	query := `SELECT received_at, request_id, fingerprint, status, starts_at, ends_at, labels, annotations
+		FROM alerts WHERE ` + strings.Join(where, " AND ") + ` ORDER BY received_at DESC, id DESC`
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	out := []historyAlert{}
+	for rows.Next() {
+		var a historyAlert
+		var received int64
+		var startsAt, endsAt sql.NullInt64
+		var labels, annotations string
+		if err := rows.Scan(&received, &a.RequestID, &a.Fingerprint, &a.Status, &startsAt, &endsAt, &labels, &annotations); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		a.ReceivedAt = time.UnixMilli(received)
+		a.StartsAt, a.EndsAt = fromUnixMilli(startsAt), fromUnixMilli(endsAt)
+		json.Unmarshal([]byte(labels), &a.Labels)
+		json.Unmarshal([]byte(annotations), &a.Annotations)
+		out = append(out, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The one connection is free again, so the deliveries can be read.
+	for i := range out {
+		if out[i].Deliveries, err = queryDeliveries(ctx, db, out[i].Fingerprint, out[i].RequestID); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func queryDeliveries(ctx context.Context, db *sql.DB, fingerprint, reqID string) ([]delivery, error) {
+	rows, err := db.QueryContext(ctx, `SELECT tenant, sink, target, at, result, error, retries
+		FROM deliveries WHERE fingerprint = ? AND request_id = ? ORDER BY at`, fingerprint, reqID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []delivery{}
+	for rows.Next() {
+		d := delivery{Fingerprint: fingerprint, RequestID: reqID}
+		var at int64
+		if err := rows.Scan(&d.Tenant, &d.Sink, &d.Target, &at, &d.Result, &d.Error, &d.Retries); err != nil {
+			return nil, err
+		}
+		d.At = time.UnixMilli(at)
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// alertHistoryHandler answers GET /api/v1/alerts: the tenant's stored
+// alerts, filtered by from/to (receive time), alertname and status.
+func alertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	db := alertDB
+	if db == nil {
+		http.Error(w, "alert history is disabled; set -history-db", http.StatusNotFound)
+		return
+	}
+	q := r.URL.Query()
+	from, err := parseTimeParam(q.Get("from"), false)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), true)
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := q.Get("status")
+	if status != "" && status != "firing" && status != "resolved" {
+		http.Error(w, "invalid status: want firing or resolved", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	alerts, err := queryAlertHistory(r.Context(), db, tenantOf(r.Context()), from, to, q.Get("alertname"), status, limit)
+	if err != nil {
+		slog.Error("querying alert history", "req_id", requestID(r.Context()), "err", err)
+		http.Error(w, "querying alert history failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Alerts []historyAlert `json:"alerts"`
+	}{alerts})
+}
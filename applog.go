@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Application Log
+=============================
+*/
+
+var (
+	logLevel   = flag.String("log-level", "info", "application log level: debug, info, warn or error (debug logs every HTTP request)")
+	logFormat  = flag.String("log-format", "text", "application log format: text or json")
+	appLogFile = flag.String("log-file", "", "file the application log is appended to; empty logs to stderr. Reopened on SIGHUP, for logrotate")
+)
+
+var (
+	logLevelVar slog.LevelVar
+	logOut      struct {
+		mu   sync.Mutex
+		file *os.File
+	}
+)
+
+// loadLogging (re)builds the application logger. The standard logger is
+// routed through it too, so nothing bypasses the level or format.
+func loadLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log-level %q", *logLevel)
+	}
+	if *logFormat != "text" && *logFormat != "json" {
+		return fmt.Errorf("invalid -log-format %q", *logFormat)
+	}
+
+	var out io.Writer = os.Stderr
+	var file *os.File
+	if *appLogFile != "" {
+		f, err := os.OpenFile(*appLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		out, file = f, f
+	}
+
+	opts := &slog.HandlerOptions{Level: &logLevelVar}
+	var h slog.Handler = slog.NewTextHandler(out, opts)
+	if *logFormat == "json" {
+		h = slog.NewJSONHandler(out, opts)
+	}
+	logLevelVar.Set(level)
+	slog.SetDefault(slog.New(h))
+	log.SetFlags(0)
+
+	logOut.mu.Lock()
+	old := logOut.file
+	logOut.file = file
+	logOut.mu.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// syncAppLog flushes the log file, last thing on shutdown.
+func syncAppLog() {
+	logOut.mu.Lock()
+	defer logOut.mu.Unlock()
+	if logOut.file != nil {
+		logOut.file.Sync()
+	}
+}
+
+// statusRecorder keeps the status a handler answered with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *statusRecorder) Unwrap() http.ResponseWriter { return s.ResponseWriter }
+
+// withAccessLog logs every request at debug level, and failed ones as
+// warnings.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tail" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelDebug
+		if status >= 400 {
+			level = slog.LevelWarn
+		}
+		slog.Log(r.Context(), level, "http request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+			"req_id", w.Header().Get(requestIDHeader))
+	})
+}
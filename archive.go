@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Raw Payload Archive
+=============================
+*/
+
+var (
+	archiveDir           = flag.String("archive-dir", "", "keep every webhook body as received, with its headers less credentials, in day files here (a subdirectory per tenant), gzipped once the day is over; replay takes them; empty disables the archive")
+	archiveRetentionDays = flag.Int("archive-retention-days", 0, "delete archive files older than this many days (0 keeps everything), independent of -retention-days")
+)
+
+// archiveSkipHeaders are left out of archived requests, so the archive
+// holds no secrets; replays pass them with -H. The body is kept gunzipped,
+// so its Content-Encoding goes too.
+var archiveSkipHeaders = map[string]bool{
+	"Authorization": true, "Proxy-Authorization": true, "Cookie": true, http.CanonicalHeaderKey(tenantKeyHeader): true,
+	"Content-Encoding": true,
+}
+
+var archivedTotal = newCounterVec("archived_requests_total", "Webhook bodies written to the archive, by tenant.", "tenant")
+
+// archiveMu serializes appends, so the lines of two requests never mix.
+var archiveMu sync.Mutex
+
+func archiveEnabled() bool {
+	return *archiveDir != ""
+}
+
+func archiveTenantDir(tenant string) string {
+	if tenant == "" {
+		return *archiveDir
+	}
+	return filepath.Join(*archiveDir, tenant)
+}
+
+func archivePath(tenant string, day time.Time) string {
+	return filepath.Join(archiveTenantDir(tenant), "archive_"+day.Format("20060102")+".jsonl")
+}
+
+// isArchiveFile reports whether path is an archive day file, plain or
+// gzipped.
+func isArchiveFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, "archive_") && (strings.HasSuffix(name, ".jsonl") || strings.HasSuffix(name, ".jsonl.gz"))
+}
+
+// archiveRequest appends the body, byte for byte as it came in, to the
+// tenant's archive of the day. body_raw keeps it exact where a JSON
+// field would be re-encoded; a failed write is logged and does not hold up
+// the delivery.
+func archiveRequest(r *http.Request, body []byte) {
+	if !archiveEnabled() {
+		return
+	}
+	ctx := r.Context()
+	c := capturedRequest{
+		ReceivedAt: clock.Now(),
+		RequestID:  requestID(ctx),
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    map[string][]string{},
+		BodyRaw:    body,
+	}
+	for k, vs := range r.Header {
+		if !archiveSkipHeaders[http.CanonicalHeaderKey(k)] {
+			c.Headers[k] = vs
+		}
+	}
+	line, err := json.Marshal(c)
+	if err == nil {
+		err = appendArchive(tenantOf(ctx), c.ReceivedAt, append(line, '\n'))
+	}
+	if err != nil {
+		slog.Error("archiving request", "req_id", c.RequestID, "err", err)
+		return
+	}
+	archivedTotal.add(tenantOf(ctx), 1)
+}
+
+func appendArchive(tenant string, now time.Time, line []byte) error {
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	if err := makeDir(archiveTenantDir(tenant)); err != nil {
+		return err
+	}
+	f, err := openLogFile(archivePath(tenant, now), os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// runArchive gzips the archives of past days and applies
+// -archive-retention-days, hourly.
+func runArchive(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		withSettings(func() { maintainArchive(clock.Now()) })
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// maintainArchive works on past days only; today's file is still being
+// appended to.
+func maintainArchive(now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, tenant := range allTenants() {
+		paths, err := filepath.Glob(filepath.Join(archiveTenantDir(tenant), "archive_*.jsonl*"))
+		if err != nil {
+			continue
+		}
+		for _, path := range paths {
+			if !isArchiveFile(path) {
+				continue
+			}
+			stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "archive_"), ".gz"), ".jsonl")
+			day, err := time.ParseInLocation("20060102", stamp, now.Location())
+			if err != nil || !day.Before(today) {
+				continue
+			}
+			if *archiveRetentionDays > 0 && !now.Before(day.AddDate(0, 0, *archiveRetentionDays+1)) {
+				if err := os.Remove(path); err != nil {
+					slog.Error("removing expired archive", "path", path, "err", err)
+				}
+				continue
+			}
+			if strings.HasSuffix(path, ".jsonl") {
+				if err := compressFile(path); err != nil {
+					slog.Error("compressing archive", "path", path, "err", err)
+				}
+			}
+		}
+	}
+}
+
+// readArchive calls fn with each request of an archive file, in the order
+// they came in. It stops at the size the file had when opened, so a replay
+// into an instance archiving to the same file ends.
+func readArchive(path string, fn func(capturedRequest) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	var in io.Reader = io.LimitReader(f, st.Size())
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		in = gz
+	}
+	sc := bufio.NewScanner(in)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var c capturedRequest
+		if err := json.Unmarshal(sc.Bytes(), &c); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+/*
+=============================
+ Webhook Authentication
+=============================
+*/
+
+var (
+	authMode         = flag.String("auth", "none", "authentication of POST /alerts, /grafana and /api/v1/write: none, bearer, basic or hmac")
+	authTokenFile    = flag.String("auth-token-file", "", "file holding the bearer token (-auth=bearer)")
+	authUser         = flag.String("auth-user", "", "username for -auth=basic")
+	authPasswordFile = flag.String("auth-password-file", "", "file holding the password for -auth=basic")
+	authSecretFile   = flag.String("auth-hmac-secret-file", "", "file holding the shared secret for -auth=hmac")
+	authHMACHeader   = flag.String("auth-hmac-header", "X-Signature", "header carrying sha256=<hex HMAC-SHA256 of the body> for -auth=hmac")
+	adminTokenFile   = flag.String("admin-token-file", "", "file holding the bearer token of admin endpoints (POST /render, POST /selftest, POST /api/check-upstream, POST /api/deadletters/replay) and of acknowledging alerts and creating, changing and deleting silences; empty disables them")
+)
+
+// authSecret is the token, password or HMAC key of the current mode, read
+// from its file on every (re)load so it can be rotated with SIGHUP.
+var authSecret []byte
+
+// adminToken guards the admin endpoints, independently of -auth.
+var adminToken []byte
+
+func loadAuth() error {
+	adminToken = nil
+	if *adminTokenFile != "" {
+		token, err := readSecret(*adminTokenFile)
+		if err != nil {
+			return err
+		}
+		adminToken = token
+	}
+
+	authSecret = nil
+	var file string
+	switch *authMode {
+	case "none":
+		return nil
+	case "bearer":
+		file = *authTokenFile
+	case "basic":
+		if *authUser == "" {
+			return errors.New("-auth=basic needs -auth-user")
+		}
+		file = *authPasswordFile
+	case "hmac":
+		if *authHMACHeader == "" {
+			return errors.New("-auth=hmac needs -auth-hmac-header")
+		}
+		file = *authSecretFile
+	default:
+		return fmt.Errorf("invalid -auth %q", *authMode)
+	}
+	if file == "" {
+		return fmt.Errorf("-auth=%s needs its secret file", *authMode)
+	}
+	secret, err := readSecret(file)
+	if err != nil {
+		return err
+	}
+	authSecret = secret
+	return nil
+}
+
+func readSecret(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	secret := bytes.TrimSpace(data)
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%s is empty", file)
+	}
+	return secret, nil
+}
+
+var errAdminDisabled = errors.New("admin endpoints are disabled, set -admin-token-file")
+
+// authorizeAdmin checks the bearer token of an admin request.
+func authorizeAdmin(r *http.Request) error {
+	if adminToken == nil {
+		return errAdminDisabled
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || !secretEqual(token, adminToken) {
+		return errors.New("invalid admin token")
+	}
+	return nil
+}
+
+// authorize checks the credentials of an /alerts request. For HMAC the body
+// is read to verify it and put back for the handler; it is read within
+// -max-body-bytes, as sent, so an unsigned request cannot make the bridge
+// hold more.
+func authorize(w http.ResponseWriter, r *http.Request) error {
+	switch *authMode {
+	case "bearer":
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !secretEqual(token, authSecret) {
+			return errors.New("invalid bearer token")
+		}
+	case "basic":
+		user, password, ok := r.BasicAuth()
+		// Both are compared so a wrong user takes as long as a wrong password.
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(*authUser)) == 1
+		if !ok || !secretEqual(password, authSecret) || !userOK {
+			return errors.New("invalid credentials")
+		}
+	case "hmac":
+		sig, ok := strings.CutPrefix(r.Header.Get(*authHMACHeader), "sha256=")
+		got, err := hex.DecodeString(sig)
+		if !ok || err != nil {
+			return fmt.Errorf("missing or malformed %s header", *authHMACHeader)
+		}
+		var in io.Reader = r.Body
+		if *maxBodyBytes > 0 {
+			in = http.MaxBytesReader(w, r.Body, *maxBodyBytes)
+		}
+		body, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if !hmac.Equal(got, bodySignature(body)) {
+			return errors.New("signature does not match the body")
+		}
+	}
+	return nil
+}
+
+func secretEqual(given string, secret []byte) bool {
+	return subtle.ConstantTimeCompare([]byte(given), secret) == 1
+}
+
+func bodySignature(body []byte) []byte {
+	mac := hmac.New(sha256.New, authSecret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// refuse answers a request authorize turned down: 413 for a body too large
+// to check, else 401.
+func refuse(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if c := authChallenge(); c != "" {
+		w.Header().Set("WWW-Authenticate", c)
+	}
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// authChallenge is the WWW-Authenticate header of a 401, if the mode has
+// one.
+func authChallenge() string {
+	switch *authMode {
+	case "bearer":
+		return `Bearer realm="alerts"`
+	case "basic":
+		return `Basic realm="alerts"`
+	}
+	return ""
+}
+
+// signRequest adds this instance's own credentials, for requests it sends
+// to itself such as the smoke test.
+func signRequest(req *http.Request, body []byte) {
+	switch *authMode {
+	case "bearer":
+		req.Header.Set("Authorization", "Bearer "+string(authSecret))
+	case "basic":
+		req.SetBasicAuth(*authUser, string(authSecret))
+	case "hmac":
+		req.Header.Set(*authHMACHeader, "sha256="+hex.EncodeToString(bodySignature(body)))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	defer func(mode, user, header string, secret []byte, limit int64) {
+		*authMode, *authUser, *authHMACHeader, authSecret, *maxBodyBytes = mode, user, header, secret, limit
+	}(*authMode, *authUser, *authHMACHeader, authSecret, *maxBodyBytes)
+	*authUser, *authHMACHeader, authSecret, *maxBodyBytes = "alertmanager", "X-Signature", []byte("secret"), 64
+
+	body := `{"alerts":[]}`
+	sign := func(key, body string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name   string
+		mode   string
+		header func(r *http.Request)
+		body   string
+		want   int // status refuse answers with, 0 when authorized
+	}{
+		{"none", "none", func(r *http.Request) {}, body, 0},
+		{"bearer", "bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secret") }, body, 0},
+		{"bearer missing", "bearer", func(r *http.Request) {}, body, http.StatusUnauthorized},
+		{"bearer wrong", "bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer secreT") }, body, http.StatusUnauthorized},
+		{"bearer as basic", "bearer", func(r *http.Request) { r.SetBasicAuth("alertmanager", "secret") }, body, http.StatusUnauthorized},
+		{"basic", "basic", func(r *http.Request) { r.SetBasicAuth("alertmanager", "secret") }, body, 0},
+		{"basic wrong user", "basic", func(r *http.Request) { r.SetBasicAuth("grafana", "secret") }, body, http.StatusUnauthorized},
+		{"basic wrong password", "basic", func(r *http.Request) { r.SetBasicAuth("alertmanager", "guess") }, body, http.StatusUnauthorized},
+		{"basic missing", "basic", func(r *http.Request) {}, body, http.StatusUnauthorized},
+		{"hmac", "hmac", func(r *http.Request) { r.Header.Set("X-Signature", sign("secret", body)) }, body, 0},
+		{"hmac other key", "hmac", func(r *http.Request) { r.Header.Set("X-Signature", sign("guess", body)) }, body, http.StatusUnauthorized},
+		{"hmac other body", "hmac", func(r *http.Request) { r.Header.Set("X-Signature", sign("secret", `{}`)) }, body, http.StatusUnauthorized},
+		{"hmac no prefix", "hmac", func(r *http.Request) {
+			r.Header.Set("X-Signature", strings.TrimPrefix(sign("secret", body), "sha256="))
+		}, body, http.StatusUnauthorized},
+		{"hmac not hex", "hmac", func(r *http.Request) { r.Header.Set("X-Signature", "sha256=zz") }, body, http.StatusUnauthorized},
+		{"hmac missing", "hmac", func(r *http.Request) {}, body, http.StatusUnauthorized},
+		{"hmac body too large", "hmac", func(r *http.Request) {
+			r.Header.Set("X-Signature", sign("secret", strings.Repeat("x", 65)))
+		}, strings.Repeat("x", 65), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*authMode = tt.mode
+			r := httptest.NewRequest("POST", "/alerts", strings.NewReader(tt.body))
+			tt.header(r)
+			w := httptest.NewRecorder()
+			err := authorize(w, r)
+			if tt.want == 0 {
+				if err != nil {
+					t.Fatalf("authorize: %v", err)
+				}
+				// HMAC read the body; the handler still needs it.
+				if got, _ := io.ReadAll(r.Body); string(got) != tt.body {
+					t.Errorf("body after authorize = %q, want %q", got, tt.body)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("authorize accepted the request")
+			}
+			refuse(w, err)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != authChallenge() {
+				t.Errorf("challenge %q, want %q", w.Header().Get("WWW-Authenticate"), authChallenge())
+			}
+		})
+	}
+}
+
+func TestLoadAuth(t *testing.T) {
+	defer func(mode, user, token, password, hmacFile, admin string) {
+		*authMode, *authUser, *authTokenFile, *authPasswordFile, *authSecretFile, *adminTokenFile = mode, user, token, password, hmacFile, admin
+		loadAuth()
+	}(*authMode, *authUser, *authTokenFile, *authPasswordFile, *authSecretFile, *adminTokenFile)
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(secret, []byte("  s3cret\n"), 0o600)
+	os.WriteFile(empty, []byte("\n"), 0o600)
+
+	tests := []struct {
+		name       string
+		mode, user string
+		file       string
+		wantErr    bool
+	}{
+		{"none", "none", "", "", false},
+		{"bearer", "bearer", "", secret, false},
+		{"bearer no file", "bearer", "", "", true},
+		{"bearer empty file", "bearer", "", empty, true},
+		{"bearer missing file", "bearer", "", filepath.Join(dir, "nope"), true},
+		{"basic", "basic", "alertmanager", secret, false},
+		{"basic no user", "basic", "", secret, true},
+		{"hmac", "hmac", "", secret, false},
+		{"unknown", "digest", "", secret, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*authMode, *authUser, *adminTokenFile = tt.mode, tt.user, ""
+			*authTokenFile, *authPasswordFile, *authSecretFile = tt.file, tt.file, tt.file
+			err := loadAuth()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadAuth error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && tt.mode != "none" && string(authSecret) != "s3cret" {
+				t.Errorf("secret %q, want it trimmed", authSecret)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Circuit Breakers (network sinks)
+=============================
+*/
+
+var (
+	breakerFailures = flag.Int("breaker-failures", 5, "consecutive failures that open a sink's circuit breaker")
+	breakerOpenFor  = flag.Duration("breaker-open", 30*time.Second, "how long an open breaker rejects deliveries before probing")
+	breakerProbes   = flag.Int("breaker-probes", 1, "successful half-open probes needed to close a breaker again")
+)
+
+// errBreakerOpen is returned instead of attempting a delivery, so callers
+// fail fast rather than queueing work for a sink that is known to be down.
+var errBreakerOpen = errors.New("circuit breaker open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	}
+	return "closed"
+}
+
+type circuitBreaker struct {
+	name string
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	probes   int
+	inFlight bool
+	openedAt time.Time
+}
+
+var (
+	breakersMu sync.Mutex
+	breakers   = make(map[string]*circuitBreaker)
+)
+
+var (
+	breakerRejected = newCounterVec("breaker_rejected_total", "Deliveries rejected because the sink's breaker was open.", "sink")
+	breakerOpened   = newCounterVec("breaker_opened_total", "Times a sink's breaker tripped open.", "sink")
+	_               = newGaugeFunc("breaker_state", "Sink breaker state (0 closed, 1 half-open, 2 open).", "sink", breakerStates)
+)
+
+func validBreakerOptions() error {
+	if *breakerFailures < 1 {
+		return fmt.Errorf("invalid -breaker-failures %d", *breakerFailures)
+	}
+	if *breakerOpenFor <= 0 {
+		return fmt.Errorf("invalid -breaker-open %s", *breakerOpenFor)
+	}
+	if *breakerProbes < 1 {
+		return fmt.Errorf("invalid -breaker-probes %d", *breakerProbes)
+	}
+	return nil
+}
+
+// breakerFor returns the breaker guarding the named sink, creating it on
+// first use.
+func breakerFor(sink string) *circuitBreaker {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	b, ok := breakers[sink]
+	if !ok {
+		b = &circuitBreaker{name: sink}
+		breakers[sink] = b
+	}
+	return b
+}
+
+// allow reports whether a delivery may be attempted. Once the open period
+// has passed, one probe at a time is let through.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen && since(b.openedAt) >= *breakerOpenFor {
+		b.state, b.probes, b.inFlight = breakerHalfOpen, 0, false
+	}
+	switch {
+	case b.state == breakerOpen, b.state == breakerHalfOpen && b.inFlight:
+		breakerRejected.add(b.name, 1)
+		return fmt.Errorf("%s: %w", b.name, errBreakerOpen)
+	case b.state == breakerHalfOpen:
+		b.inFlight = true
+	}
+	return nil
+}
+
+// record feeds the outcome of an allowed delivery back into the breaker.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.inFlight = false
+	if err == nil {
+		b.failures = 0
+		if b.state == breakerHalfOpen {
+			b.probes++
+			if b.probes >= *breakerProbes {
+				b.state = breakerClosed
+			}
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= *breakerFailures {
+		b.state, b.openedAt = breakerOpen, clock.Now()
+		breakerOpened.add(b.name, 1)
+	}
+}
+
+// call runs one delivery through the breaker.
+func (b *circuitBreaker) call(deliver func() error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := deliver()
+	b.record(err)
+	return err
+}
+
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func breakerStates() map[string]float64 {
+	breakersMu.Lock()
+	defer breakersMu.Unlock()
+	out := make(map[string]float64, len(breakers))
+	for name, b := range breakers {
+		out[name] = float64(b.current())
+	}
+	return out
+}
+
+/*
+=============================
+ Health
+=============================
+*/
+
+type healthReport struct {
+	Status string            `json:"status"`
+	Sinks  map[string]string `json:"sinks"`
+}
+
+// healthHandler stays 200 while the receiver itself works; an open sink
+// breaker only marks the instance degraded.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	rep := healthReport{Status: "ok", Sinks: make(map[string]string)}
+	for name, state := range breakerStates() {
+		rep.Sinks[name] = breakerState(state).String()
+		if breakerState(state) != breakerClosed {
+			rep.Status = "degraded"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
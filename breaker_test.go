@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	defer func(failures, probes int, openFor time.Duration, c Clock) {
+		*breakerFailures, *breakerProbes, *breakerOpenFor, clock = failures, probes, openFor, c
+	}(*breakerFailures, *breakerProbes, *breakerOpenFor, clock)
+	*breakerFailures, *breakerOpenFor = 3, 30*time.Second
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+
+	// Each step is a delivery at an offset: ok, fail, or probe (allowed
+	// but not finished yet).
+	type step struct {
+		at        time.Duration
+		outcome   string
+		wantOpen  bool // rejected without being tried
+		wantState breakerState
+	}
+	tests := []struct {
+		name   string
+		probes int
+		steps  []step
+	}{
+		{"stays closed below the threshold", 1, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "ok", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+		}},
+		{"opens at the threshold", 1, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerOpen},
+			{29 * time.Second, "ok", true, breakerOpen},
+		}},
+		{"probe closes it", 1, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerOpen},
+			{30 * time.Second, "ok", false, breakerClosed},
+			{30 * time.Second, "ok", false, breakerClosed},
+		}},
+		{"failed probe opens it again", 1, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerOpen},
+			{30 * time.Second, "fail", false, breakerOpen},
+			{59 * time.Second, "ok", true, breakerOpen},
+			{60 * time.Second, "ok", false, breakerClosed},
+		}},
+		{"one probe at a time", 1, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerOpen},
+			{30 * time.Second, "probe", false, breakerHalfOpen},
+			{31 * time.Second, "ok", true, breakerHalfOpen},
+		}},
+		{"several probes needed", 2, []step{
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerClosed},
+			{0, "fail", false, breakerOpen},
+			{30 * time.Second, "ok", false, breakerHalfOpen},
+			{30 * time.Second, "ok", false, breakerClosed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*breakerProbes = tt.probes
+			b := &circuitBreaker{name: "test"}
+			for i, s := range tt.steps {
+				clock = fixedClock(start.Add(s.at))
+				tried := false
+				var err error
+				if s.outcome == "probe" {
+					err = b.allow()
+					tried = err == nil
+				} else {
+					err = b.call(func() error {
+						tried = true
+						if s.outcome == "fail" {
+							return errors.New("down")
+						}
+						return nil
+					})
+				}
+				if rejected := errors.Is(err, errBreakerOpen); rejected != s.wantOpen || rejected == tried {
+					t.Fatalf("step %d: tried %v, err %v; want rejected %v", i+1, tried, err, s.wantOpen)
+				}
+				if got := b.current(); got != s.wantState {
+					t.Fatalf("step %d: state %s, want %s", i+1, got, s.wantState)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Raw Request Capture
+=============================
+*/
+
+var (
+	captureDir = flag.String("capture-dir", "", "persist every raw /alerts request into this directory for later replay")
+	captureMax = flag.Int("capture-max", 1000, "number of capture files kept before the oldest are removed")
+)
+
+type capturedRequest struct {
+	ReceivedAt time.Time           `json:"received_at"`
+	RequestID  string              `json:"req_id,omitempty"`
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+	Body       json.RawMessage     `json:"body,omitempty"`
+	BodyRaw    []byte              `json:"body_raw,omitempty"`
+}
+
+type captureStore struct {
+	mu    sync.Mutex
+	files []string
+	ready bool
+}
+
+var captures = &captureStore{}
+
+// captureRequest buffers the request body so the handler can still read
+// it, and stores a copy in the capture directory.
+func captureRequest(r *http.Request) {
+	if *captureDir == "" {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	c := capturedRequest{
+		ReceivedAt: clock.Now(),
+		RequestID:  requestID(r.Context()),
+		Method:     r.Method,
+		Path:       r.URL.RequestURI(),
+		RemoteAddr: r.RemoteAddr,
+		Headers:    r.Header.Clone(),
+	}
+	if json.Valid(body) {
+		c.Body = body
+	} else {
+		c.BodyRaw = body
+	}
+	_ = captures.save(c)
+}
+
+func (s *captureStore) save(c capturedRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ready {
+		if err := os.MkdirAll(*captureDir, 0755); err != nil {
+			return err
+		}
+		existing, _ := filepath.Glob(filepath.Join(*captureDir, "capture_*.json"))
+		sort.Strings(existing)
+		s.files = existing
+		s.ready = true
+	}
+
+	name := filepath.Join(*captureDir, fmt.Sprintf("capture_%s_%s.json",
+		c.ReceivedAt.UTC().Format("20060102T150405.000000000"), c.RequestID))
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return err
+	}
+	s.files = append(s.files, name)
+
+	for len(s.files) > *captureMax && *captureMax > 0 {
+		os.Remove(s.files[0])
+		s.files = s.files[1:]
+	}
+	return nil
+}
+
+/*
+=============================
+ Replay Command (replay)
+=============================
+*/
+
+// Headers that describe the original connection rather than the payload.
+var replaySkipHeaders = map[string]bool{
+	"Host": true, "Content-Length": true, "Connection": true, "Accept-Encoding": true,
+	"Transfer-Encoding": true, "X-Request-Id": true,
+}
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags http.Header
+
+func (h headerFlags) String() string { return "" }
+
+func (h *headerFlags) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("want Name: value, got %q", s)
+	}
+	if *h == nil {
+		*h = headerFlags{}
+	}
+	http.Header(*h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
+
+// runReplay re-sends captured and archived requests, and hands
+// dead-lettered alerts to the sinks that gave up on them.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the instance to replay against")
+	interval := fs.Duration("interval", 0, "pause between requests")
+	realtime := fs.Bool("realtime", false, "reproduce the original spacing between captures")
+	var headers headerFlags
+	fs.Var(&headers, "H", "extra `header: value` for dead-letter replays, which need the admin token (Authorization: Bearer ...) and, with tenancy, the tenant's X-API-Key; repeatable")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("usage: replay [flags] <capture, archive or dead-letter file, or directory>...")
+	}
+
+	files, err := expandCaptureArgs(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var prev time.Time
+	failed := 0
+	for i, file := range files {
+		if isDeadLetterFile(file) {
+			summary, err := replayDeadLetterFile(&http.Client{}, strings.TrimRight(*target, "/"), http.Header(headers), file)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+				failed++
+				continue
+			}
+			fmt.Printf("%s -> %s\n", filepath.Base(file), summary)
+			continue
+		}
+		if isArchiveFile(file) {
+			sent, errs := 0, 0
+			err := readArchive(file, func(c capturedRequest) error {
+				if sent+errs > 0 && *interval > 0 {
+					time.Sleep(*interval)
+				}
+				if _, err := replayOne(client, strings.TrimRight(*target, "/"), c); err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %s: %v\n", file, c.RequestID, err)
+					errs++
+					return nil
+				}
+				sent++
+				return nil
+			})
+			if err != nil || errs > 0 {
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+				}
+				failed++
+			}
+			fmt.Printf("%s -> %d requests replayed, %d failed\n", filepath.Base(file), sent, errs)
+			continue
+		}
+
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var c capturedRequest
+		if err := json.Unmarshal(data, &c); err != nil {
+			fmt.Fprintf(os.Stderr, "skip %s: %v\n", file, err)
+			continue
+		}
+
+		if i > 0 {
+			if *realtime && !prev.IsZero() {
+				time.Sleep(c.ReceivedAt.Sub(prev))
+			} else if *interval > 0 {
+				time.Sleep(*interval)
+			}
+		}
+		prev = c.ReceivedAt
+
+		status, err := replayOne(client, strings.TrimRight(*target, "/"), c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s -> %s\n", filepath.Base(file), status)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replays failed", failed, len(files))
+	}
+	return nil
+}
+
+func replayOne(client *http.Client, target string, c capturedRequest) (string, error) {
+	body := []byte(c.Body)
+	if len(body) == 0 {
+		body = c.BodyRaw
+	}
+	method := c.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequest(method, target+c.Path, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	for k, vs := range c.Headers {
+		if replaySkipHeaders[http.CanonicalHeaderKey(k)] {
+			continue
+		}
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if c.RequestID != "" {
+		req.Header.Set("X-Replay-Of", c.RequestID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Status, nil
+}
+
+func expandCaptureArgs(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		st, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !st.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		letters, err := filepath.Glob(filepath.Join(arg, "*deadletter_*.log"))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, letters...)
+		archives, err := filepath.Glob(filepath.Join(arg, "archive_*.jsonl*"))
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, archives...)
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+/*
+=============================
+ Chat Sinks (Slack, Teams)
+=============================
+*/
+
+var (
+	slackWebhookURL = flag.String("slack-webhook-url", "", "Slack incoming webhook URL alert messages (Block Kit) are posted to; $VARS are expanded; empty disables the slack sink")
+	teamsWebhookURL = flag.String("teams-webhook-url", "", "Microsoft Teams workflow webhook URL alert cards (Adaptive Cards) are posted to; $VARS are expanded; empty disables the teams sink")
+	chatTitle       = flag.String("chat-title",
+		`[{{ .Status | toUpper }}] {{ .Labels.alertname }}{{ with .Labels.hostname }} on {{ . }}{{ end }}`,
+		"title template of each alert in Slack and Teams messages (data: one alert, with .Status, .Labels, .Annotations, .StartsAt)")
+	chatTimeout = flag.Duration("chat-timeout", 10*time.Second, "deadline for one Slack or Teams post")
+)
+
+const (
+	slackSink = "slack"
+	teamsSink = "teams"
+
+	// chatMaxAlerts keeps a message below Slack's block and Teams' card
+	// size limits; larger batches are split.
+	chatMaxAlerts = 20
+)
+
+var chatTitleTmpl *texttemplate.Template
+
+func slackEnabled() bool { return *slackWebhookURL != "" }
+func teamsEnabled() bool { return *teamsWebhookURL != "" }
+
+func loadChat() error {
+	for _, u := range []string{*slackWebhookURL, *teamsWebhookURL} {
+		if u == "" {
+			continue
+		}
+		if err := validChatURL(u); err != nil {
+			return err
+		}
+	}
+	t, err := texttemplate.New("chat-title").Funcs(templateFuncs).Option("missingkey=zero").Parse(*chatTitle)
+	if err != nil {
+		return fmt.Errorf("invalid -chat-title: %w", err)
+	}
+	chatTitleTmpl = t
+	return nil
+}
+
+func validChatURL(raw string) error {
+	u, err := url.Parse(os.ExpandEnv(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid chat webhook url %q", raw)
+	}
+	return nil
+}
+
+// chatTarget is the webhook URL without its path, which carries the
+// secret, for the delivery history and logs.
+func chatTarget(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "?"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// chatAlert is what the message of one alert is made of.
+type chatAlert struct {
+	Alert
+	Title    string
+	Severity string
+	Host     string
+	Links    []chatLink
+}
+
+type chatLink struct {
+	Title, URL string
+}
+
+// chatAlerts renders the titles and collects the links of a batch: the
+// runbook annotation, the alert's source and the Alertmanager it came from.
+func chatAlerts(ctx context.Context, alerts []Alert) ([]chatAlert, error) {
+	group := alertGroupOf(ctx)
+	data := newTemplateData(group, alerts)
+	out := make([]chatAlert, len(alerts))
+	for i, a := range alerts {
+		var title bytes.Buffer
+		if err := chatTitleTmpl.Execute(&title, data.Alerts[i]); err != nil {
+			return nil, fmt.Errorf("chat title: %w", err)
+		}
+		c := chatAlert{
+			Alert:    a,
+			Title:    strings.Join(strings.Fields(title.String()), " "),
+			Severity: safeValue(a.Labels["severity"], "none"),
+			Host:     safeHostname(a.Labels),
+		}
+		if u := safeValue(a.Annotations["runbook_url"], a.Annotations["runbook"]); u != "" {
+			c.Links = append(c.Links, chatLink{"Runbook", u})
+		}
+		if a.GeneratorURL != "" {
+			c.Links = append(c.Links, chatLink{"Source", a.GeneratorURL})
+		}
+		if group.ExternalURL != "" {
+			c.Links = append(c.Links, chatLink{"Alertmanager", group.ExternalURL + "/#/alerts?receiver=" + url.QueryEscape(data.Receiver)})
+		}
+		out[i] = c
+	}
+	return out, nil
+}
+
+// chatColor is the severity's color from -severity-map, or a built-in one.
+func chatColor(a Alert) string {
+	if a.Status == "resolved" {
+		return "#2e7d32"
+	}
+	if c := severityOf(a).Color; c != "" {
+		return c
+	}
+	switch a.Labels["severity"] {
+	case "critical":
+		return "#d32f2f"
+	case "warning":
+		return "#f9a825"
+	case "info":
+		return "#1976d2"
+	}
+	return "#757575"
+}
+
+// chatSink posts one message per chunk of a batch. Each chunk retries on
+// its own, so a failure does not resend the chunks already posted.
+type chatSink struct {
+	name   string
+	url    func(r *route) string
+	build  func(alerts []chatAlert) ([]byte, error)
+	client *http.Client
+}
+
+func newChatSink(name string) *chatSink {
+	s := &chatSink{name: name, client: &http.Client{Timeout: *chatTimeout}}
+	switch name {
+	case slackSink:
+		s.url = func(r *route) string { return safeValue(r.SlackURL, *slackWebhookURL) }
+		s.build = slackMessage
+	case teamsSink:
+		s.url = func(r *route) string { return safeValue(r.TeamsURL, *teamsWebhookURL) }
+		s.build = teamsMessage
+	}
+	return s
+}
+
+func (s *chatSink) Name() string { return s.name }
+
+func (s *chatSink) Write(ctx context.Context, a Alert) error {
+	return s.WriteBatch(ctx, []Alert{a})
+}
+
+func (s *chatSink) WriteBatch(ctx context.Context, alerts []Alert) error {
+	target := os.ExpandEnv(s.url(routeOf(ctx)))
+	rendered, err := chatAlerts(ctx, alerts)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errGaveUp, err)
+	}
+	var errs []error
+	for start := 0; start < len(rendered); start += chatMaxAlerts {
+		chunk := rendered[start:min(start+chatMaxAlerts, len(rendered))]
+		body, err := s.build(chunk)
+		if err == nil {
+			err = withRetries(ctx, s.name, func() error {
+				return breakerFor(s.name).call(func() error { return s.post(ctx, target, body) })
+			})
+		}
+		for _, c := range chunk {
+			if recordDelivery(ctx, s.name, chatTarget(target), c.Alert, err) != nil {
+				errs = append(errs, targetError{target: chatTarget(target), fingerprint: c.Fingerprint, err: fmt.Errorf("%w: %w", errGaveUp, err)})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *chatSink) post(ctx context.Context, target string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	injectTrace(ctx, req)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+/*
+=============================
+ Slack Messages (Block Kit)
+=============================
+*/
+
+type slackPayload struct {
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// slackAttachment carries the color bar, which Block Kit alone has not.
+type slackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+type slackBlock struct {
+	Type     string      `json:"type"`
+	Text     *slackText  `json:"text,omitempty"`
+	Elements []slackText `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// slackEscape escapes the characters mrkdwn gives a meaning.
+var slackEscape = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackMessage(alerts []chatAlert) ([]byte, error) {
+	p := slackPayload{Text: alerts[0].Title}
+	if len(alerts) > 1 {
+		p.Text = fmt.Sprintf("%s (+%d more)", alerts[0].Title, len(alerts)-1)
+	}
+	for _, a := range alerts {
+		text := "*" + slackEscape.Replace(a.Title) + "*"
+		if s := a.Annotations["summary"]; s != "" {
+			text += "\n" + slackEscape.Replace(s)
+		}
+		if d := a.Annotations["description"]; d != "" {
+			text += "\n" + slackEscape.Replace(d)
+		}
+		var links []string
+		for _, l := range a.Links {
+			links = append(links, "<"+slackEscape.Replace(l.URL)+"|"+l.Title+">")
+		}
+		if len(links) > 0 {
+			text += "\n" + strings.Join(links, " · ")
+		}
+		meta := fmt.Sprintf("Severity: *%s* · Host: %s · Started: %s",
+			slackEscape.Replace(a.Severity), slackEscape.Replace(a.Host), a.StartsAt.UTC().Format(time.RFC3339))
+		if a.Status == "resolved" && !a.EndsAt.IsZero() {
+			meta += " · Resolved: " + a.EndsAt.UTC().Format(time.RFC3339)
+		}
+		p.Attachments = append(p.Attachments, slackAttachment{
+			Color: chatColor(a.Alert),
+			Blocks: []slackBlock{
+				{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}},
+				{Type: "context", Elements: []slackText{{Type: "mrkdwn", Text: meta}}},
+			},
+		})
+	}
+	return json.Marshal(p)
+}
+
+/*
+=============================
+ Teams Messages (Adaptive Cards)
+=============================
+*/
+
+type teamsPayload struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     teamsCard `json:"content"`
+}
+
+type teamsCard struct {
+	Schema  string         `json:"$schema"`
+	Type    string         `json:"type"`
+	Version string         `json:"version"`
+	MSTeams map[string]any `json:"msteams"`
+	Body    []teamsElement `json:"body"`
+}
+
+// teamsElement is any card element; only the fields of its type are set.
+type teamsElement struct {
+	Type      string         `json:"type"`
+	Style     string         `json:"style,omitempty"`
+	Separator bool           `json:"separator,omitempty"`
+	Text      string         `json:"text,omitempty"`
+	Weight    string         `json:"weight,omitempty"`
+	Size      string         `json:"size,omitempty"`
+	Wrap      bool           `json:"wrap,omitempty"`
+	Items     []teamsElement `json:"items,omitempty"`
+	Facts     []teamsFact    `json:"facts,omitempty"`
+	Actions   []teamsAction  `json:"actions,omitempty"`
+}
+
+type teamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type teamsAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// teamsStyle picks the container style for an alert. Cards only take
+// named styles, so Teams follows the importance from -severity-map, or
+// the severity label, rather than the color.
+func teamsStyle(a Alert) string {
+	if a.Status == "resolved" {
+		return "good"
+	}
+	switch safeValue(severityOf(a).Importance, a.Labels["severity"]) {
+	case "high", "critical":
+		return "attention"
+	case "normal", "warning":
+		return "warning"
+	}
+	return "accent"
+}
+
+func teamsMessage(alerts []chatAlert) ([]byte, error) {
+	card := teamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		MSTeams: map[string]any{"width": "Full"},
+	}
+	for i, a := range alerts {
+		items := []teamsElement{{Type: "TextBlock", Text: a.Title, Weight: "Bolder", Size: "Medium", Wrap: true}}
+		for _, key := range []string{"summary", "description"} {
+			if v := a.Annotations[key]; v != "" {
+				items = append(items, teamsElement{Type: "TextBlock", Text: v, Wrap: true})
+			}
+		}
+		facts := []teamsFact{
+			{"Severity", a.Severity},
+			{"Host", a.Host},
+			{"Started", a.StartsAt.UTC().Format(time.RFC3339)},
+		}
+		if a.Status == "resolved" && !a.EndsAt.IsZero() {
+			facts = append(facts, teamsFact{"Resolved", a.EndsAt.UTC().Format(time.RFC3339)})
+		}
+		items = append(items, teamsElement{Type: "FactSet", Facts: facts})
+		if len(a.Links) > 0 {
+			set := teamsElement{Type: "ActionSet"}
+			for _, l := range a.Links {
+				set.Actions = append(set.Actions, teamsAction{Type: "Action.OpenUrl", Title: l.Title, URL: l.URL})
+			}
+			items = append(items, set)
+		}
+		card.Body = append(card.Body, teamsElement{Type: "Container", Style: teamsStyle(a.Alert), Separator: i > 0, Items: items})
+	}
+	return json.Marshal(teamsPayload{
+		Type:        "message",
+		Attachments: []teamsAttachment{{ContentType: "application/vnd.microsoft.card.adaptive", Content: card}},
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Clock
+=============================
+*/
+
+// Clock is the single source of "now" for timestamps, file naming and
+// time windows. Network I/O deadlines stay on the wall clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().In(logLocation) }
+
+// fixedClock always reports the same instant; golden tests use it.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+var clock Clock = systemClock{}
+
+func since(t time.Time) time.Duration {
+	return clock.Now().Sub(t)
+}
+
+/*
+=============================
+ Timestamp Format & Zone
+=============================
+*/
+
+var (
+	tsLayoutFlag = flag.String("ts-layout", defaultTSLayout, `layout of the entry "ts" and "ack_at" (Go reference time), or rfc3339 / rfc3339-millis; it has to sort like the time it shows`)
+	timezone     = flag.String("timezone", "Local", "time zone of timestamps, day files and their names: Local, UTC or a name such as Europe/Berlin")
+)
+
+// defaultTSLayout is also how records written before -ts-layout was set
+// are read.
+const defaultTSLayout = "2006-01-02 15:04"
+
+var tsLayoutPresets = map[string]string{
+	"rfc3339":        time.RFC3339,
+	"rfc3339-millis": "2006-01-02T15:04:05.000Z07:00",
+}
+
+// Set by loadTimeFormat.
+var (
+	tsLayout    = defaultTSLayout
+	logLocation = time.Local
+	tsPattern   = regexp.MustCompile(layoutPattern(defaultTSLayout, time.Local))
+)
+
+// loadTimeFormat checks -ts-layout and -timezone. History, search and
+// the file listing compare "ts" as text, so a layout must order like the
+// times it formats.
+func loadTimeFormat() error {
+	loc, err := time.LoadLocation(*timezone)
+	if err != nil {
+		return fmt.Errorf("invalid -timezone: %w", err)
+	}
+	layout := *tsLayoutFlag
+	if p, ok := tsLayoutPresets[layout]; ok {
+		layout = p
+	}
+	if layout == "" {
+		return errors.New("-ts-layout must not be empty")
+	}
+
+	samples := []time.Time{
+		time.Date(2025, 12, 31, 23, 59, 59, 999e6, loc),
+		time.Date(2026, 1, 1, 0, 0, 0, 0, loc),
+		time.Date(2026, 1, 9, 9, 9, 9, 0, loc),
+		time.Date(2026, 1, 10, 10, 10, 10, 0, loc),
+		time.Date(2026, 7, 1, 12, 0, 0, 0, loc),
+		time.Date(2026, 10, 1, 12, 30, 0, 0, loc),
+	}
+	texts := make([]string, len(samples))
+	for i, s := range samples {
+		texts[i] = s.Format(layout)
+		back, err := time.ParseInLocation(layout, texts[i], loc)
+		if err != nil || !back.Truncate(time.Minute).Equal(s.Truncate(time.Minute)) {
+			return fmt.Errorf("-ts-layout %q must show the date and time at least to the minute", layout)
+		}
+	}
+	if !sort.StringsAreSorted(texts) {
+		return fmt.Errorf("-ts-layout %q does not sort by time; start with the year and pad numbers with zeros", layout)
+	}
+
+	tsLayout, logLocation = layout, loc
+	tsPattern = regexp.MustCompile(layoutPattern(layout, loc))
+	return nil
+}
+
+// layoutPattern is a regular expression for times formatted with layout:
+// digits and letters stand for any, the rest as is. Winter and summer
+// time are both allowed for, in case the offset is shown.
+func layoutPattern(layout string, loc *time.Location) string {
+	var alts []string
+	for _, s := range []time.Time{time.Date(2026, 1, 15, 10, 11, 12, 123456789, loc), time.Date(2026, 7, 15, 10, 11, 12, 123456789, loc)} {
+		if p := charClasses(s.Format(layout)); len(alts) == 0 || alts[0] != p {
+			alts = append(alts, p)
+		}
+	}
+	return "^(?:" + strings.Join(alts, "|") + ")$"
+}
+
+func charClasses(text string) string {
+	var b strings.Builder
+	for _, c := range text {
+		switch {
+		case c >= '0' && c <= '9':
+			b.WriteString(`\d`)
+		case c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z':
+			b.WriteString(`[A-Za-z]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+/*
+=============================
+ Entry Timestamp Source
+=============================
+*/
+
+var timestampSource = flag.String("timestamp-source", "received",
+	`where the entry "ts" comes from: received (webhook arrival) or startsAt (alert start, for backfill/replay)`)
+
+func validTimestampSource(s string) bool {
+	return s == "received" || s == "startsAt"
+}
+
+// entryTimestamp falls back to the receive time when the alert carries no
+// usable StartsAt, including one skewed into the future.
+func entryTimestamp(alert Alert, received time.Time) time.Time {
+	if _, skewed := alertSkew(alert, received); skewed {
+		return received
+	}
+	if *timestampSource == "startsAt" && !alert.StartsAt.IsZero() {
+		return alert.StartsAt.In(received.Location())
+	}
+	return received
+}
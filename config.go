@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Configuration File & Reload
+=============================
+*/
+
+var (
+	configFile = flag.String("config", "", "YAML file of settings keyed by flag name (e.g. listen, log-dir, normalize-rules); reloaded on SIGHUP")
+	listen     = flag.String("listen", ":8080", "HTTP listen address")
+	logDirFlag = flag.String("log-dir", defaultLogDir(), "directory of the day files (tenants get a subdirectory each), created if missing; /var/log by default for root, else under the user's home, and %ProgramData%\\alertbridge\\logs on Windows")
+	logPrefixF = flag.String("log-prefix", "app_hivemq_", "file name prefix of output files, {{.Prefix}} in -log-file-name")
+)
+
+// envPrefix names the environment overrides: -log-dir is ALERTBRIDGE_LOG_DIR.
+const envPrefix = "ALERTBRIDGE_"
+
+// Settings that are only read at start-up; a reload keeps their old value.
+var restartOnly = []string{
+	"config", "listen", "retention-days", "report-at", "aggregate-interval", "quota-per-hour", "rollup",
+	"queue-depth", "queue-workers", "drain-timeout", "shutdown-timeout", "email-digest-interval",
+	"tls-cert-file", "tls-key-file", "tls-client-ca-file", "tls-reload-interval",
+	"encrypt", "encrypt-key-file", "encrypt-recipients", "encrypt-identity", "integrity", "integrity-key-file",
+	"dev-listen", "dev-smtp-addr", "admin-listen", "history-db", "silence-state", "timezone",
+	"otlp-endpoint", "otel-service-name", "otel-sample-ratio", "spool-dir", "preflight", "archive-dir",
+}
+
+// configMu lets a reload wait for in-flight alert requests and hold new
+// ones back until the new settings are complete. A reload sets every flag,
+// so whatever reads them outside a request holds it too (withSettings).
+var configMu sync.RWMutex
+
+// withSettings runs fn with the settings held still, as withConfig does for
+// a request. Background work goes through it for each round, and reads the
+// durations it waits for in it; the waiting itself is done without.
+func withSettings(fn func()) {
+	configMu.RLock()
+	defer configMu.RUnlock()
+	fn()
+}
+
+// cmdline holds the flags given on the command line; they beat the file
+// and the environment on every (re)load.
+var cmdline map[string]string
+
+// initConfig layers the config file and environment under the command
+// line. Call it once, right after the flags were parsed.
+func initConfig(fs *flag.FlagSet) error {
+	cmdline = map[string]string{}
+	fs.Visit(func(f *flag.Flag) { cmdline[f.Name] = f.Value.String() })
+	return applyConfig(fs)
+}
+
+// pinFlag sets a flag as if it had been given on the command line, so that
+// reloads keep it.
+func pinFlag(name, value string) {
+	if cmdline == nil {
+		cmdline = map[string]string{}
+	}
+	cmdline[name] = value
+	flag.Set(name, value)
+}
+
+// applyConfig resets every flag to its default and applies, in order of
+// precedence, the config file, the environment and the command line.
+func applyConfig(fs *flag.FlagSet) error {
+	settings := map[string]string{}
+	path, ok := cmdline["config"]
+	if !ok {
+		path = os.Getenv(envName("config"))
+	}
+	if path != "" {
+		if err := readConfigFile(fs, path, settings); err != nil {
+			return err
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			settings[f.Name] = v
+		}
+	})
+	for name, v := range cmdline {
+		settings[name] = v
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := settings[f.Name]
+		if !ok {
+			v = f.DefValue
+		}
+		if err := fs.Set(f.Name, v); err != nil {
+			errs = append(errs, fmt.Errorf("setting %s: %w", f.Name, err))
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile accepts scalars, lists (joined with commas) and maps
+// (joined as k=v pairs, e.g. for tenants).
+func readConfigFile(fs *flag.FlagSet, path string, into map[string]string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for name, v := range raw {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		s, err := configValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, name, err)
+		}
+		into[name] = s
+	}
+	return nil
+}
+
+func configValue(v any) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return x, nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case int, float64:
+		return fmt.Sprint(x), nil
+	case []any:
+		parts := make([]string, len(x))
+		for i, item := range x {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]any:
+		keys := make([]string, 0, len(x))
+		for k := range x {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			s, err := configValue(x[k])
+			if err != nil {
+				return "", err
+			}
+			parts[i] = k + "=" + s
+		}
+		return strings.Join(parts, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// reloadConfig re-reads the file and every rule file it points at. If
+// anything fails to load, the previous settings stay in force.
+func reloadConfig(fs *flag.FlagSet) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	before := map[string]string{}
+	fs.VisitAll(func(f *flag.Flag) { before[f.Name] = f.Value.String() })
+
+	err := applyConfig(fs)
+	if err == nil {
+		for _, name := range restartOnly {
+			if f := fs.Lookup(name); f != nil && f.Value.String() != before[name] {
+				slog.Warn("config reload: setting changed, takes effect after a restart", "setting", name)
+				fs.Set(name, before[name])
+			}
+		}
+		err = loadSettings()
+	}
+	if err != nil {
+		for name, v := range before {
+			fs.Set(name, v)
+		}
+		if restoreErr := loadSettings(); restoreErr != nil {
+			slog.Error("config reload: restoring previous settings", "err", restoreErr)
+		}
+		slog.Error("config reload failed, keeping previous settings", "err", err)
+		return
+	}
+	slog.Info("config reloaded")
+}
+
+// withConfig holds a read lock on the settings for the whole request, so a
+// request never sees half of a reload. The live tail streams indefinitely
+// and would block reloads, so it runs without.
+func withConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tail" {
+			configMu.RLock()
+			hold := &configHold{held: true}
+			defer hold.release()
+			r = r.WithContext(context.WithValue(r.Context(), configLockKey{}, hold))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type configLockKey struct{}
+
+// configHold is a request's read lock on the settings; only the request's
+// own goroutine uses it.
+type configHold struct{ held bool }
+
+func (h *configHold) release() {
+	if h.held {
+		h.held = false
+		configMu.RUnlock()
+	}
+}
+
+func (h *configHold) retake() {
+	if !h.held {
+		configMu.RLock()
+		h.held = true
+	}
+}
+
+// releaseConfig gives up the request's read lock early, for handlers that
+// go on to wait on something that itself needs the lock.
+func releaseConfig(ctx context.Context) {
+	if h, ok := ctx.Value(configLockKey{}).(*configHold); ok {
+		h.release()
+	}
+}
+
+// holdConfig takes the lock back after releaseConfig, for a handler with
+// more to do once it has waited; a reload may have come in between.
+func holdConfig(ctx context.Context) {
+	if h, ok := ctx.Value(configLockKey{}).(*configHold); ok {
+		h.retake()
+	}
+}
+
+// watchReload reloads on SIGHUP until done is closed.
+func watchReload(done <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			reloadConfig(flag.CommandLine)
+		}
+	}
+}
+
+func validPathSettings() error {
+	if *logDirFlag == "" {
+		return errors.New("-log-dir must not be empty")
+	}
+	if *logPrefixF == "" || strings.ContainsAny(*logPrefixF, `/\`) {
+		return fmt.Errorf("invalid -log-prefix %q", *logPrefixF)
+	}
+	logDir, logPrefix = *logDirFlag, *logPrefixF
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Dashboard
+=============================
+*/
+
+var dashboardRefresh = flag.Duration("dashboard-refresh", 10*time.Second, "how often the dashboard at / reloads itself; 0 turns auto-refresh off")
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTmpl = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"ago": func(t time.Time) string { return since(t).Round(time.Second).String() },
+}).Parse(dashboardHTML))
+
+// recentAlertsKept is how many alerts the dashboard remembers.
+const recentAlertsKept = 50
+
+type recentAlert struct {
+	At          time.Time `json:"at"`
+	Tenant      string    `json:"tenant,omitempty"`
+	RequestID   string    `json:"req_id,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	Alertname   string    `json:"alertname"`
+	Host        string    `json:"host"`
+	Severity    string    `json:"severity"`
+	Status      string    `json:"status"`
+
+	// Sinks is the latest attempt per sink, filled in when shown.
+	Sinks map[string]delivery `json:"sinks"`
+}
+
+// recentAlertLog keeps the latest alerts handed to the sinks, newest
+// last.
+type recentAlertLog struct {
+	mu     sync.Mutex
+	alerts []recentAlert
+}
+
+var recentAlerts = &recentAlertLog{}
+
+func (l *recentAlertLog) add(ctx context.Context, tenant string, alerts []Alert) {
+	now := clock.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, a := range alerts {
+		l.alerts = append(l.alerts, recentAlert{
+			At:          now,
+			Tenant:      tenant,
+			RequestID:   requestID(ctx),
+			Fingerprint: a.Fingerprint,
+			Alertname:   a.Labels["alertname"],
+			Host:        safeHostname(a.Labels),
+			Severity:    a.Labels["severity"],
+			Status:      safeValue(a.Status, "firing"),
+		})
+	}
+	if n := len(l.alerts) - recentAlertsKept; n > 0 {
+		l.alerts = append(l.alerts[:0:0], l.alerts[n:]...)
+	}
+}
+
+// list returns the tenant's alerts, newest first.
+func (l *recentAlertLog) list(tenant string) []recentAlert {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := []recentAlert{}
+	for i := len(l.alerts) - 1; i >= 0; i-- {
+		if l.alerts[i].Tenant == tenant {
+			out = append(out, l.alerts[i])
+		}
+	}
+	return out
+}
+
+type dashboardData struct {
+	Now     time.Time     `json:"now"`
+	Refresh int           `json:"refresh_sec"`
+	Alerts  []recentAlert `json:"alerts"`
+	Queue   struct {
+		Priority int `json:"priority"`
+		Normal   int `json:"normal"`
+		Capacity int `json:"capacity"`
+		Workers  int `json:"workers"`
+	} `json:"queue"`
+	Sinks  []sinkHealth `json:"sinks"`
+	Errors []delivery   `json:"errors"`
+}
+
+type sinkHealth struct {
+	Name        string     `json:"name"`
+	Breaker     string     `json:"breaker"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// dashboardErrorsShown is how many failed deliveries the dashboard lists.
+const dashboardErrorsShown = 10
+
+func newDashboardData(tenant string) dashboardData {
+	data := dashboardData{
+		Now:     clock.Now(),
+		Refresh: int(dashboardRefresh.Seconds()),
+		Alerts:  recentAlerts.list(tenant),
+	}
+
+	type key struct{ fingerprint, reqID string }
+	shown := make(map[key]int, len(data.Alerts))
+	for i, a := range data.Alerts {
+		shown[key{a.Fingerprint, a.RequestID}] = i
+		data.Alerts[i].Sinks = map[string]delivery{}
+	}
+	// Newest first, so the first attempt seen per sink is the latest.
+	deliveries.query(func(d delivery) bool {
+		if i, ok := shown[key{d.Fingerprint, d.RequestID}]; ok && d.Tenant == tenant {
+			if _, seen := data.Alerts[i].Sinks[d.Sink]; !seen {
+				data.Alerts[i].Sinks[d.Sink] = d
+			}
+		}
+		return false
+	}, 0)
+	data.Errors = deliveries.query(func(d delivery) bool {
+		return d.Tenant == tenant && d.Result == "failed"
+	}, dashboardErrorsShown)
+
+	if q := queue; q != nil {
+		data.Queue.Priority, data.Queue.Normal = len(q.priority), len(q.normal)
+		data.Queue.Capacity = cap(q.normal)
+	}
+	data.Queue.Workers = *queueWorkers
+
+	// Sinks without a circuit breaker, such as the files, show as closed.
+	states, lastOK := breakerStates(), deliveries.lastSuccess()
+	names := map[string]bool{}
+	for name := range states {
+		names[name] = true
+	}
+	for name := range lastOK {
+		names[name] = true
+	}
+	for name := range names {
+		s := sinkHealth{Name: name, Breaker: breakerState(states[name]).String()}
+		if at, ok := lastOK[name]; ok {
+			t := time.Unix(int64(at), 0)
+			s.LastSuccess = &t
+		}
+		data.Sinks = append(data.Sinks, s)
+	}
+	sort.Slice(data.Sinks, func(i, j int) bool { return data.Sinks[i].Name < data.Sinks[j].Name })
+	return data
+}
+
+// dashboardHandler serves a page of the recent alerts and how their
+// deliveries went, the queue and the sinks, for looking at the bridge
+// from a browser.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	dashboardTmpl.Execute(w, newDashboardData(tenantOf(r.Context())))
+}
+
+func dashboardJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newDashboardData(tenantOf(r.Context())))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Dead-Letter File
+=============================
+*/
+
+var deadLetterDirFlag = flag.String("dead-letter-dir", "", "directory of the dead-letter files; empty keeps them next to the tenant's output")
+
+// deadLetter is one entry that could not be written to the normal output:
+// an oversized entry, or an alert a sink gave up on after its retries. The
+// file name carries no date prefix, so history readers skip it.
+type deadLetter struct {
+	At          time.Time `json:"at"`
+	Reason      string    `json:"reason"`
+	RequestID   string    `json:"req_id,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Sink        string    `json:"sink,omitempty"`
+	Target      string    `json:"target,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Route       string    `json:"route,omitempty"`
+	Alert       *Alert    `json:"alert,omitempty"`
+	Entry       *JSONLog  `json:"entry,omitempty"`
+}
+
+var deadLetterMu sync.Mutex
+
+func deadLetterDir(tenant string) string {
+	if *deadLetterDirFlag != "" {
+		return *deadLetterDirFlag
+	}
+	return tenantDir(tenant)
+}
+
+func deadLetterPath(dir string, now time.Time) string {
+	return filepath.Join(dir, logPrefix+"deadletter_"+now.Format("20060102")+".log")
+}
+
+func writeDeadLetter(dir string, d deadLetter) error {
+	deadLetterMu.Lock()
+	defer deadLetterMu.Unlock()
+
+	if err := makeDir(dir); err != nil {
+		return err
+	}
+
+	file, err := openLogFile(deadLetterPath(dir, d.At), os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(d)
+}
+
+/*
+=============================
+ Dead-Letter Replay
+=============================
+*/
+
+type replayResult struct {
+	Delivered int `json:"delivered"`
+	Failed    int `json:"failed"`
+	Duplicate int `json:"duplicate"`
+	OverQuota int `json:"over_quota"`
+	Skipped   int `json:"skipped"`
+}
+
+// deadLetterReplayHandler takes dead-letter records (JSON lines) and hands
+// each alert once more to the sink that gave up on it, through today's
+// routing tree. Entries dead-lettered for being oversized carry no alert
+// and are skipped. With tenancy, a tenant's key only replays its records.
+//
+// Replays take the admin token, as the records are not checked against
+// what was received. Like webhook deliveries, they are rate-limited, count
+// against the quotas and go through the filters and redaction again.
+func deadLetterReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if !rateLimit(w, r) {
+		return
+	}
+
+	// The records are held until all have decoded, so the body is bounded
+	// by -max-body-bytes like a webhook's.
+	body, err := bodyReader(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+	var records []deadLetter
+	dec := json.NewDecoder(body)
+	for {
+		var d deadLetter
+		err := dec.Decode(&d)
+		if err == io.EOF {
+			break
+		}
+		if readRejectReason(err) == rejectTooLarge {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("record %d: %v", len(records)+1, err), http.StatusBadRequest)
+			return
+		}
+		records = append(records, d)
+	}
+
+	// Retries can take a while; the response waits for all of them.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	ctx := r.Context()
+	source := quotaSource(r, tenantOf(ctx))
+	var res replayResult
+	for _, d := range records {
+		if d.Alert == nil || d.Tenant != tenantOf(ctx) {
+			res.Skipped++
+			continue
+		}
+		if admitted, _, _ := quotas.admit(source, d.Tenant, []Alert{*d.Alert}); len(admitted) == 0 {
+			tracef(ctx, "quota", d.Alert.fingerprint(), "replay over %d entries/h, skipped", *quotaPerHour)
+			res.OverQuota++
+			continue
+		}
+		switch replayDeadLetter(ctx, d) {
+		case replayDelivered:
+			res.Delivered++
+		case replayFailed:
+			res.Failed++
+		case replayDuplicate:
+			res.Duplicate++
+		default:
+			res.Skipped++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+type replayOutcome int
+
+const (
+	replaySkipped replayOutcome = iota
+	replayDelivered
+	replayFailed
+	replayDuplicate
+)
+
+// replayDeadLetter delivers one record to its sink on the route that now
+// takes the alert there, preferring the route it was first sent on. The
+// dedup window applies per sink, so replaying the same file twice does
+// not deliver twice.
+func replayDeadLetter(ctx context.Context, d deadLetter) replayOutcome {
+	fp := d.Alert.fingerprint()
+	a := transformAlert(ctx, *d.Alert)
+	sinksMu.RLock()
+	s := sinkBy[d.Sink]
+	sinksMu.RUnlock()
+	if s == nil {
+		tracef(ctx, "replay", fp, "sink %q is not enabled, skipped", d.Sink)
+		return replaySkipped
+	}
+
+	var target *route
+	for _, r := range currentRoute().routesFor(a.Labels) {
+		if !slices.Contains(r.Sinks, d.Sink) {
+			continue
+		}
+		if target == nil || r.Name == d.Route {
+			target = r
+		}
+	}
+	if target == nil || len(target.sinkAlerts(d.Sink, []Alert{a})) == 0 {
+		tracef(ctx, "replay", fp, "no route sends it to %s any more, skipped", d.Sink)
+		return replaySkipped
+	}
+	if dedup.duplicate(d.Tenant+"/replay:"+d.Sink, a, clock.Now()) {
+		tracef(ctx, "replay", fp, "duplicate within %s, dropped", *dedupWindow)
+		return replayDuplicate
+	}
+
+	tracef(ctx, "replay", fp, "dead letter of request %s for %s via route %s", d.RequestID, d.Sink, target.Name)
+	ctx = withRoute(ctx, target)
+	if d.Target != "" {
+		ctx = context.WithValue(ctx, replayTargetKey{}, d.Target)
+	}
+	if deliver(ctx, s, []Alert{a}) > 0 {
+		return replayFailed
+	}
+	return replayDelivered
+}
+
+type replayTargetKey struct{}
+
+// replayTarget is the one target of a multi-target sink a replayed dead
+// letter is for; empty means all of them.
+func replayTarget(ctx context.Context) string {
+	t, _ := ctx.Value(replayTargetKey{}).(string)
+	return t
+}
+
+func isDeadLetterFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.Contains(name, "deadletter_") && strings.HasSuffix(name, ".log")
+}
+
+// replayDeadLetterFile posts a dead-letter file to a running instance.
+func replayDeadLetterFile(client *http.Client, target string, headers http.Header, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, target+"/api/deadletters/replay", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var res replayResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	if res.Failed > 0 {
+		return "", fmt.Errorf("%d delivered, %d failed again (dead-lettered anew), %d duplicate, %d over quota, %d skipped",
+			res.Delivered, res.Failed, res.Duplicate, res.OverQuota, res.Skipped)
+	}
+	return fmt.Sprintf("%d delivered, %d duplicate, %d over quota, %d skipped", res.Delivered, res.Duplicate, res.OverQuota, res.Skipped), nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeadLetterReplayNeedsAdminToken(t *testing.T) {
+	defer func(token []byte) { adminToken = token }(adminToken)
+
+	tests := []struct {
+		name   string
+		token  []byte // configured
+		header string
+		want   int
+	}{
+		{"admin disabled", nil, "Bearer secret", http.StatusForbidden},
+		{"no token", []byte("secret"), "", http.StatusUnauthorized},
+		{"wrong token", []byte("secret"), "Bearer guess", http.StatusUnauthorized},
+		{"admin token", []byte("secret"), "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminToken = tt.token
+			r := httptest.NewRequest("POST", "/api/deadletters/replay", strings.NewReader(""))
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			withAdminToken(deadLetterReplayHandler)(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
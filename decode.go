@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+/*
+=============================
+ Payload Decoding Modes
+=============================
+*/
+
+var decodeMode = flag.String("decode-mode", "default",
+	"payload decoding: default, lenient (coerce odd fields and warn) or strict (reject anything non-conformant)")
+
+// decodePayload reads an Alertmanager webhook body according to the
+// configured mode. Warnings describe fields that lenient mode repaired or
+// dropped; they never make the request fail.
+func decodePayload(r io.Reader) (AlertmanagerPayload, []string, error) {
+	switch *decodeMode {
+	case "lenient":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return AlertmanagerPayload{}, nil, err
+		}
+		return decodeLenient(data)
+	case "strict":
+		p, err := decodeStrict(r)
+		return p, nil, err
+	default:
+		var p AlertmanagerPayload
+		err := json.NewDecoder(r).Decode(&p)
+		return p, nil, err
+	}
+}
+
+func validDecodeMode(m string) bool {
+	return m == "default" || m == "lenient" || m == "strict"
+}
+
+/*
+=============================
+ Lenient
+=============================
+*/
+
+func decodeLenient(data []byte) (AlertmanagerPayload, []string, error) {
+	var payload AlertmanagerPayload
+	var warnings []string
+	warn := func(format string, args ...any) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return payload, nil, err
+	}
+	group := func(name string) any {
+		var v any
+		dec := json.NewDecoder(bytes.NewReader(raw[name]))
+		dec.UseNumber()
+		dec.Decode(&v)
+		return v
+	}
+	payload.Version = lenientString(group("version"))
+	payload.GroupKey = lenientString(group("groupKey"))
+	payload.Status = lenientString(group("status"))
+	payload.Receiver = lenientString(group("receiver"))
+	payload.ExternalURL = lenientString(group("externalURL"))
+	payload.GroupLabels = lenientMap(group("groupLabels"), "groupLabels", warn)
+	payload.CommonLabels = lenientMap(group("commonLabels"), "commonLabels", warn)
+	payload.CommonAnnotations = lenientMap(group("commonAnnotations"), "commonAnnotations", warn)
+
+	alertsRaw, ok := raw["alerts"]
+	if !ok || string(alertsRaw) == "null" {
+		warn("payload has no alerts section")
+		return payload, warnings, nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(alertsRaw, &items); err != nil {
+		warn("alerts is not an array: %v", err)
+		return payload, warnings, nil
+	}
+
+	for i, item := range items {
+		var fields map[string]any
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.UseNumber()
+		if err := dec.Decode(&fields); err != nil || fields == nil {
+			warn("alerts[%d]: not an object, skipped", i)
+			continue
+		}
+
+		alert := Alert{
+			Status:      lenientString(fields["status"]),
+			Fingerprint: lenientString(fields["fingerprint"]),
+			Labels:      lenientMap(fields["labels"], fmt.Sprintf("alerts[%d].labels", i), warn),
+			Annotations: lenientMap(fields["annotations"], fmt.Sprintf("alerts[%d].annotations", i), warn),
+		}
+		alert.GeneratorURL = lenientString(fields["generatorURL"])
+		alert.StartsAt = lenientTime(fields["startsAt"], fmt.Sprintf("alerts[%d].startsAt", i), warn)
+		alert.EndsAt = lenientTime(fields["endsAt"], fmt.Sprintf("alerts[%d].endsAt", i), warn)
+		payload.Alerts = append(payload.Alerts, alert)
+	}
+	return payload, warnings, nil
+}
+
+func lenientString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	case bool:
+		return strconv.FormatBool(x)
+	default:
+		b, _ := json.Marshal(x)
+		return string(b)
+	}
+}
+
+func lenientMap(v any, path string, warn func(string, ...any)) map[string]string {
+	out := map[string]string{}
+	if v == nil {
+		return out
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		warn("%s: expected an object, dropped", path)
+		return out
+	}
+	for k, val := range m {
+		if _, isString := val.(string); !isString && val != nil {
+			warn("%s.%s: coerced %s to string", path, k, jsonKind(val))
+		}
+		out[k] = lenientString(val)
+	}
+	return out
+}
+
+func jsonKind(v any) string {
+	switch v.(type) {
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func lenientTime(v any, path string, warn func(string, ...any)) time.Time {
+	s, ok := v.(string)
+	if !ok || s == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		warn("%s: unparseable time %q, ignored", path, s)
+		return time.Time{}
+	}
+	return t
+}
+
+/*
+=============================
+ Strict
+=============================
+*/
+
+// The complete Alertmanager v4 webhook schema; strict mode rejects
+// anything outside it.
+type strictPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []strictAlert     `json:"alerts"`
+}
+
+type strictAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+func decodeStrict(r io.Reader) (AlertmanagerPayload, error) {
+	var sp strictPayload
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sp); err != nil {
+		return AlertmanagerPayload{}, err
+	}
+
+	p, err := sp.group(len(sp.Alerts))
+	if err != nil {
+		return AlertmanagerPayload{}, err
+	}
+	for i, a := range sp.Alerts {
+		alert, err := a.alert(i)
+		if err != nil {
+			return AlertmanagerPayload{}, err
+		}
+		p.Alerts = append(p.Alerts, alert)
+	}
+	return p, nil
+}
+
+// group checks the fields of a strict payload with n alerts besides the
+// alerts themselves.
+func (sp strictPayload) group(n int) (AlertmanagerPayload, error) {
+	if sp.Version != "4" {
+		return AlertmanagerPayload{}, fmt.Errorf("unsupported webhook version %q", sp.Version)
+	}
+	if sp.Status != "firing" && sp.Status != "resolved" {
+		return AlertmanagerPayload{}, fmt.Errorf("invalid group status %q", sp.Status)
+	}
+	if n == 0 {
+		return AlertmanagerPayload{}, errors.New("payload contains no alerts")
+	}
+	return AlertmanagerPayload{
+		Version:           sp.Version,
+		GroupKey:          sp.GroupKey,
+		Status:            sp.Status,
+		Receiver:          sp.Receiver,
+		GroupLabels:       sp.GroupLabels,
+		CommonLabels:      sp.CommonLabels,
+		CommonAnnotations: sp.CommonAnnotations,
+		ExternalURL:       sp.ExternalURL,
+	}, nil
+}
+
+// alert checks alerts[i] of a strict payload.
+func (a strictAlert) alert(i int) (Alert, error) {
+	if a.Status != "firing" && a.Status != "resolved" {
+		return Alert{}, fmt.Errorf("alerts[%d]: invalid status %q", i, a.Status)
+	}
+	if a.Labels["alertname"] == "" {
+		return Alert{}, fmt.Errorf("alerts[%d]: missing alertname label", i)
+	}
+	if a.StartsAt.IsZero() {
+		return Alert{}, fmt.Errorf("alerts[%d]: missing startsAt", i)
+	}
+	return Alert{
+		Status:       a.Status,
+		StartsAt:     a.StartsAt,
+		EndsAt:       a.EndsAt,
+		Labels:       a.Labels,
+		Annotations:  a.Annotations,
+		GeneratorURL: a.GeneratorURL,
+		Fingerprint:  a.Fingerprint,
+	}, nil
+}
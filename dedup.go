@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Deduplication Window
+=============================
+*/
+
+var dedupWindow = flag.Duration("dedup-window", 0,
+	"drop a repeat of an alert (same fingerprint and status) seen within this window, before any sink; 0 disables deduplication")
+
+var dedupSuppressed = newCounterVec("dedup_suppressed_total", "Alerts dropped as duplicates within -dedup-window.", "status")
+
+// dedupCache remembers when each alert was last let through, per status,
+// so a resolution or a re-fire after it always passes.
+type dedupCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+var dedup = &dedupCache{seen: make(map[string]time.Time)}
+
+// duplicate reports whether the alert was already let through within the
+// window, and records it if not.
+func (c *dedupCache) duplicate(tenant string, a Alert, now time.Time) bool {
+	if *dedupWindow <= 0 {
+		return false
+	}
+	status := safeValue(a.Status, "firing")
+	fp := a.fingerprint()
+	key := tenant + "/" + fp + "/" + status
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.lastSweep) > *dedupWindow {
+		for k, at := range c.seen {
+			if now.Sub(at) >= *dedupWindow {
+				delete(c.seen, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	if at, ok := c.seen[key]; ok && now.Sub(at) < *dedupWindow {
+		dedupSuppressed.add(status, 1)
+		return true
+	}
+	c.seen[key] = now
+	for _, other := range []string{"firing", "resolved"} {
+		if other != status {
+			delete(c.seen, tenant+"/"+fp+"/"+other)
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedup(t *testing.T) {
+	defer func(w time.Duration) { *dedupWindow = w }(*dedupWindow)
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	labels := map[string]string{"alertname": "Disk", "instance": "broker-1"}
+
+	type seen struct {
+		tenant, status string
+		at             time.Duration
+		wantDup        bool
+	}
+	tests := []struct {
+		name   string
+		window time.Duration
+		seq    []seen
+	}{
+		{"off", 0, []seen{{"", "firing", 0, false}, {"", "firing", 0, false}}},
+		{"repeat within the window", time.Minute, []seen{{"", "firing", 0, false}, {"", "firing", 30 * time.Second, true}}},
+		{"repeat after the window", time.Minute, []seen{{"", "firing", 0, false}, {"", "firing", time.Minute, false}}},
+		{"dropped repeats do not extend it", time.Minute, []seen{
+			{"", "firing", 0, false}, {"", "firing", 50 * time.Second, true}, {"", "firing", 70 * time.Second, false}}},
+		{"resolution passes", time.Minute, []seen{{"", "firing", 0, false}, {"", "resolved", time.Second, false}}},
+		{"re-fire after resolution passes", time.Minute, []seen{
+			{"", "firing", 0, false}, {"", "resolved", time.Second, false}, {"", "firing", 2 * time.Second, false}}},
+		{"repeated resolution dropped", time.Minute, []seen{{"", "resolved", 0, false}, {"", "resolved", time.Second, true}}},
+		{"empty status is firing", time.Minute, []seen{{"", "", 0, false}, {"", "firing", time.Second, true}}},
+		{"tenants apart", time.Minute, []seen{{"team-a", "firing", 0, false}, {"team-b", "firing", time.Second, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*dedupWindow = tt.window
+			c := &dedupCache{seen: make(map[string]time.Time)}
+			for i, s := range tt.seq {
+				a := Alert{Status: s.status, Labels: labels}
+				if got := c.duplicate(s.tenant, a, start.Add(s.at)); got != s.wantDup {
+					t.Errorf("alert %d: duplicate = %v, want %v", i+1, got, s.wantDup)
+				}
+			}
+		})
+	}
+}
+
+// The sweep drops what fell out of the window, so the cache stays small.
+func TestDedupSweep(t *testing.T) {
+	defer func(w time.Duration) { *dedupWindow = w }(*dedupWindow)
+	*dedupWindow = time.Minute
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+
+	c := &dedupCache{seen: make(map[string]time.Time)}
+	for i, name := range []string{"A", "B", "C"} {
+		c.duplicate("", Alert{Labels: map[string]string{"alertname": name}}, start.Add(time.Duration(i)*time.Second))
+	}
+	c.duplicate("", Alert{Labels: map[string]string{"alertname": "D"}}, start.Add(2*time.Minute))
+	if len(c.seen) != 1 {
+		t.Errorf("%d alert(s) remembered, want only the last", len(c.seen))
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Delivery History
+=============================
+*/
+
+var deliveryHistory = flag.Int("delivery-history", 10000, "number of notification attempts kept for /api/deliveries")
+
+type delivery struct {
+	Fingerprint string    `json:"fingerprint"`
+	RequestID   string    `json:"req_id,omitempty"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Sink        string    `json:"sink"`
+	Target      string    `json:"target"`
+	At          time.Time `json:"at"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+	Retries     int       `json:"retries"`
+}
+
+// deliveryLog is a fixed-size ring of the most recent attempts.
+type deliveryLog struct {
+	mu   sync.Mutex
+	ring []delivery
+	next int
+	full bool
+	last map[string]time.Time // last success per sink
+}
+
+var deliveries = &deliveryLog{last: make(map[string]time.Time)}
+
+func (l *deliveryLog) record(d delivery, err error) {
+	d.Result = "ok"
+	if err != nil {
+		d.Result = "failed"
+		d.Error = err.Error()
+	}
+
+	if err != nil {
+		deliveryFailed.add(d.Sink, 1)
+	}
+	recordDeliveryOutcome(d)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err == nil && d.At.After(l.last[d.Sink]) {
+		l.last[d.Sink] = d.At
+	}
+	if l.ring == nil {
+		if *deliveryHistory <= 0 {
+			return
+		}
+		l.ring = make([]delivery, *deliveryHistory)
+	}
+	l.ring[l.next] = d
+	l.next = (l.next + 1) % len(l.ring)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+func (l *deliveryLog) lastSuccess() map[string]float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make(map[string]float64, len(l.last))
+	for sink, at := range l.last {
+		out[sink] = float64(at.Unix())
+	}
+	return out
+}
+
+// query returns matching attempts, newest first.
+func (l *deliveryLog) query(match func(delivery) bool, limit int) []delivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.ring)
+	}
+	out := []delivery{}
+	for i := 1; i <= n && (limit <= 0 || len(out) < limit); i++ {
+		d := l.ring[(l.next-i+len(l.ring))%len(l.ring)]
+		if match(d) {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+func deliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fp, reqID, sink := q.Get("fingerprint"), q.Get("req_id"), q.Get("sink")
+	tenant := tenantOf(r.Context())
+
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	out := deliveries.query(func(d delivery) bool {
+		return d.Tenant == tenant &&
+			(fp == "" || d.Fingerprint == fp) &&
+			(reqID == "" || d.RequestID == reqID) &&
+			(sink == "" || d.Sink == sink)
+	}, limit)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
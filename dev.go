@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Dev Mode (dev)
+=============================
+*/
+
+var (
+	devSMTPAddr = flag.String("dev-smtp-addr", "127.0.0.1:1025", "dev mode: address of the in-process SMTP capture server")
+	devListen   = flag.String("dev-listen", "127.0.0.1:8080", "dev mode: HTTP listen address")
+)
+
+// runDev starts the receiver together with an SMTP sink that keeps every
+// message in memory, browsable under /dev/mail.
+func runDev(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if err := initConfig(flag.CommandLine); err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	smtpLn, err := net.Listen("tcp", *devSMTPAddr)
+	if err != nil {
+		return err
+	}
+	go devMailbox.serveSMTP(ctx, smtpLn)
+	useDevSMTP(smtpLn.Addr().String())
+
+	ln, err := net.Listen("tcp", *devListen)
+	if err != nil {
+		return err
+	}
+	slog.Info("dev mode", "smtp", smtpLn.Addr().String(), "mail_viewer", "http://"+ln.Addr().String()+"/dev/mail")
+
+	return serve(ctx, ln, func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /dev/mail", devMailListHandler)
+		mux.HandleFunc("GET /dev/api/mail", devMailJSONHandler)
+		mux.HandleFunc("DELETE /dev/api/mail", devMailClearHandler)
+		mux.HandleFunc("GET /dev/mail/{id}", devMailViewHandler)
+		mux.HandleFunc("GET /dev/mail/{id}/raw", devMailRawHandler)
+	})
+}
+
+// useDevSMTP points alert emails at the capture server unless a real
+// relay was configured explicitly.
+func useDevSMTP(addr string) {
+	if *smtpHost != "" {
+		return
+	}
+	host, port, _ := net.SplitHostPort(addr)
+	pinFlag("smtp-host", host)
+	pinFlag("smtp-port", port)
+	pinFlag("smtp-tls", "none")
+	if *emailTo == "" {
+		pinFlag("email-to", "oncall@example.com")
+	}
+}
+
+/*
+=============================
+ SMTP Capture Server
+=============================
+*/
+
+type capturedMail struct {
+	ID         int       `json:"id"`
+	ReceivedAt time.Time `json:"received_at"`
+	From       string    `json:"from"`
+	To         []string  `json:"to"`
+	Subject    string    `json:"subject"`
+	Size       int       `json:"size"`
+
+	raw []byte
+}
+
+type mailbox struct {
+	mu     sync.Mutex
+	nextID int
+	mails  []capturedMail
+}
+
+var devMailbox = &mailbox{}
+
+const devMailboxLimit = 500
+
+func (m *mailbox) add(from string, to []string, raw []byte) {
+	msg := capturedMail{ReceivedAt: clock.Now(), From: from, To: to, Size: len(raw), raw: raw}
+	if parsed, err := mail.ReadMessage(bytes.NewReader(raw)); err == nil {
+		dec := new(mime.WordDecoder)
+		if subj, err := dec.DecodeHeader(parsed.Header.Get("Subject")); err == nil {
+			msg.Subject = subj
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextID++
+	msg.ID = m.nextID
+	m.mails = append(m.mails, msg)
+	if len(m.mails) > devMailboxLimit {
+		m.mails = m.mails[len(m.mails)-devMailboxLimit:]
+	}
+}
+
+func (m *mailbox) list() []capturedMail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]capturedMail, len(m.mails))
+	for i := range m.mails {
+		out[len(m.mails)-1-i] = m.mails[i]
+	}
+	return out
+}
+
+func (m *mailbox) get(id int) (capturedMail, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.mails {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return capturedMail{}, false
+}
+
+func (m *mailbox) clear() {
+	m.mu.Lock()
+	m.mails = nil
+	m.mu.Unlock()
+}
+
+func (m *mailbox) serveSMTP(ctx context.Context, ln net.Listener) {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go m.handleSMTP(conn)
+	}
+}
+
+// handleSMTP speaks just enough RFC 5321 for net/smtp and common clients.
+func (m *mailbox) handleSMTP(conn net.Conn) {
+	defer conn.Close()
+	tp := textproto.NewConn(conn)
+
+	var from string
+	var rcpts []string
+	tp.PrintfLine("220 hivemq-alert-logger dev SMTP capture ready")
+
+	for {
+		conn.SetDeadline(time.Now().Add(5 * time.Minute))
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			tp.PrintfLine("250-hivemq-alert-logger")
+			tp.PrintfLine("250-8BITMIME")
+			tp.PrintfLine("250 AUTH PLAIN LOGIN")
+		case "HELO":
+			tp.PrintfLine("250 hivemq-alert-logger")
+		case "AUTH":
+			// Accept any credentials so auth-enabled configs work unchanged.
+			if strings.HasPrefix(strings.ToUpper(arg), "LOGIN") {
+				tp.PrintfLine("334 VXNlcm5hbWU6")
+				tp.ReadLine()
+				tp.PrintfLine("334 UGFzc3dvcmQ6")
+				tp.ReadLine()
+			}
+			tp.PrintfLine("235 2.7.0 Authentication successful")
+		case "MAIL":
+			from = smtpPath(arg)
+			rcpts = nil
+			tp.PrintfLine("250 2.1.0 OK")
+		case "RCPT":
+			rcpts = append(rcpts, smtpPath(arg))
+			tp.PrintfLine("250 2.1.5 OK")
+		case "DATA":
+			if len(rcpts) == 0 {
+				tp.PrintfLine("503 5.5.1 RCPT first")
+				continue
+			}
+			tp.PrintfLine("354 End data with <CR><LF>.<CR><LF>")
+			raw, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			m.add(from, rcpts, raw)
+			tp.PrintfLine("250 2.0.0 OK: queued as %d", len(raw))
+			from, rcpts = "", nil
+		case "RSET":
+			from, rcpts = "", nil
+			tp.PrintfLine("250 2.0.0 OK")
+		case "NOOP":
+			tp.PrintfLine("250 2.0.0 OK")
+		case "QUIT":
+			tp.PrintfLine("221 2.0.0 Bye")
+			return
+		default:
+			tp.PrintfLine("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// smtpPath extracts the address from "FROM:<a@b> SIZE=123".
+func smtpPath(arg string) string {
+	_, rest, _ := strings.Cut(arg, ":")
+	rest = strings.TrimSpace(rest)
+	if i := strings.IndexByte(rest, '>'); strings.HasPrefix(rest, "<") && i > 0 {
+		return rest[1:i]
+	}
+	addr, _, _ := strings.Cut(rest, " ")
+	return addr
+}
+
+/*
+=============================
+ Mail Viewer
+=============================
+*/
+
+var devMailListTmpl = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html><head><title>Captured mail</title>
+<style>body{font-family:Arial,Helvetica,sans-serif;margin:20px}td,th{padding:6px 10px;border-bottom:1px solid #ddd;text-align:left}</style>
+</head><body>
+<h2>Captured mail ({{ len . }})</h2>
+<table>
+<tr><th>#</th><th>Received</th><th>From</th><th>To</th><th>Subject</th><th></th></tr>
+{{ range . }}<tr>
+<td>{{ .ID }}</td><td>{{ .ReceivedAt.Format "2006-01-02 15:04:05" }}</td><td>{{ .From }}</td>
+<td>{{ range $i, $t := .To }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}</td>
+<td><a href="/dev/mail/{{ .ID }}">{{ .Subject }}</a></td><td><a href="/dev/mail/{{ .ID }}/raw">raw</a></td>
+</tr>{{ end }}
+</table>
+</body></html>`))
+
+func devMailListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	devMailListTmpl.Execute(w, devMailbox.list())
+}
+
+func devMailJSONHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(devMailbox.list())
+}
+
+func devMailClearHandler(w http.ResponseWriter, r *http.Request) {
+	devMailbox.clear()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func devMailRawHandler(w http.ResponseWriter, r *http.Request) {
+	msg, ok := lookupDevMail(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(msg.raw)
+}
+
+// devMailViewHandler shows the HTML alternative when present, so the
+// rendered template looks as it would in a mail client.
+func devMailViewHandler(w http.ResponseWriter, r *http.Request) {
+	msg, ok := lookupDevMail(w, r)
+	if !ok {
+		return
+	}
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg.raw))
+	if err != nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(msg.raw)
+		return
+	}
+
+	ctype, body := bestMailPart(textproto.MIMEHeader(parsed.Header), parsed.Body)
+	w.Header().Set("Content-Type", ctype)
+	w.Write(body)
+}
+
+func lookupDevMail(w http.ResponseWriter, r *http.Request) (capturedMail, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return capturedMail{}, false
+	}
+	msg, ok := devMailbox.get(id)
+	if !ok {
+		http.NotFound(w, r)
+	}
+	return msg, ok
+}
+
+func bestMailPart(h textproto.MIMEHeader, body io.Reader) (string, []byte) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		var fallback []byte
+		fallbackType := "text/plain; charset=utf-8"
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			ctype, data := bestMailPart(part.Header, partBody(part))
+			if strings.HasPrefix(ctype, "text/html") {
+				return ctype, data
+			}
+			if fallback == nil {
+				fallback, fallbackType = data, ctype
+			}
+		}
+		return fallbackType, fallback
+	}
+
+	data, _ := io.ReadAll(bufio.NewReader(body))
+	return mediaType + "; charset=utf-8", data
+}
+
+// partBody undoes base64 transfer encoding; multipart.Reader already
+// handles quoted-printable.
+func partBody(p *multipart.Part) io.Reader {
+	if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+		return base64.NewDecoder(base64.StdEncoding, p)
+	}
+	return p
+}
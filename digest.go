@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Email Digests
+=============================
+*/
+
+var (
+	digestInterval     = flag.Duration("email-digest-interval", 0, "collect alert emails per route and send one digest this often; 0 sends one email per webhook request")
+	digestMax          = flag.Int("email-digest-max", 0, "send a route's digest early once it holds this many alerts; 0 waits for the interval")
+	emailDigestSubject = flag.String("email-digest-subject",
+		`[{{ T "subject.digest" | toUpper }}] {{ Tn "subject.digest_alerts" (len .Alerts) }}{{ with .Alerts.Firing }}, {{ Tn "alerts.firing" (len .) }}{{ end }}`,
+		"subject line template of digest emails (same data as the digest templates)")
+)
+
+func digestEnabled() bool {
+	return *digestInterval > 0
+}
+
+func validDigestOptions() error {
+	if *digestInterval < 0 || *digestMax < 0 {
+		return fmt.Errorf("-email-digest-interval and -email-digest-max must not be negative")
+	}
+	if *digestMax > 0 && !digestEnabled() {
+		return fmt.Errorf("-email-digest-max needs -email-digest-interval")
+	}
+	return nil
+}
+
+// digestData is what the digest templates get: the usual data for all
+// alerts of the period, and the same alerts grouped by alertname and
+// severity, most severe first.
+type digestData struct {
+	templateData
+	Groups []digestGroup
+	Since  time.Time
+	Until  time.Time
+}
+
+type digestGroup struct {
+	Alertname string
+	Severity  string
+	Alerts    templateAlerts
+}
+
+// digestBatch is what one route has collected for one tenant. An alert
+// that arrives again replaces its earlier state, so the digest lists
+// every alert once, as it stands.
+type digestBatch struct {
+	tenant string
+	route  *route
+	since  time.Time
+	alerts []Alert
+	index  map[string]int // fingerprint -> position in alerts
+}
+
+type digestBuffer struct {
+	mu      sync.Mutex
+	batches map[string]*digestBatch
+}
+
+var digests = &digestBuffer{batches: make(map[string]*digestBatch)}
+
+func (b *digestBuffer) add(ctx context.Context, alerts []Alert) {
+	r, tenant := routeOf(ctx), tenantOf(ctx)
+	key := tenant + "\x00" + r.Name
+
+	b.mu.Lock()
+	batch := b.batches[key]
+	if batch == nil {
+		batch = &digestBatch{tenant: tenant, route: r, since: clock.Now(), index: map[string]int{}}
+		b.batches[key] = batch
+	}
+	for _, a := range alerts {
+		if i, ok := batch.index[a.Fingerprint]; ok {
+			batch.alerts[i] = a
+		} else {
+			batch.index[a.Fingerprint] = len(batch.alerts)
+			batch.alerts = append(batch.alerts, a)
+		}
+		tracef(ctx, emailSink, a.Fingerprint, "held for the %s digest of route %s", *digestInterval, r.Name)
+	}
+	full := *digestMax > 0 && len(batch.alerts) >= *digestMax
+	if full {
+		delete(b.batches, key)
+	}
+	b.mu.Unlock()
+
+	if full {
+		sendDigest(context.Background(), batch)
+	}
+}
+
+// flush sends every collected digest and returns how many. Retries give
+// up when ctx ends.
+func (b *digestBuffer) flush(ctx context.Context) int {
+	b.mu.Lock()
+	batches := b.batches
+	b.batches = make(map[string]*digestBatch)
+	b.mu.Unlock()
+
+	keys := make([]string, 0, len(batches))
+	for k := range batches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		sendDigest(ctx, batches[k])
+	}
+	return len(keys)
+}
+
+func runDigests(done <-chan struct{}) {
+	var every time.Duration
+	withSettings(func() { every = *digestInterval })
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		withSettings(func() { digests.flush(context.Background()) })
+	}
+}
+
+// sendDigest renders and sends one batch. It is retried like any sink
+// write; alerts of a digest that cannot be sent are dead-lettered.
+func sendDigest(ctx context.Context, batch *digestBatch) {
+	ctx = withRoute(withTenantValue(ctx, batch.tenant), batch.route)
+	t := batch.route.emailTemplates(batch.tenant)
+	data := newDigestData(batch.alerts, batch.since, clock.Now())
+	msg, err := t.execute(t.digestSubject, emailDigestHTMLTemplate, emailDigestTextTemplate, data)
+	if err != nil {
+		slog.Error("rendering email digest", "route", batch.route.Name, "err", err)
+	} else {
+		err = withRetries(ctx, emailSink, func() error {
+			return deliverEmail(ctx, batch.route, msg, batch.alerts)
+		})
+	}
+	if err != nil {
+		for _, a := range batch.alerts {
+			deadLetterAlert(ctx, emailSink, "", a, err)
+		}
+	}
+}
+
+func newDigestData(alerts []Alert, since, until time.Time) digestData {
+	data := digestData{templateData: newTemplateData(alertGroup{}, alerts), Since: since, Until: until}
+	index := map[[2]string]int{}
+	for _, a := range data.Alerts {
+		key := [2]string{a.Labels["alertname"], a.Labels["severity"]}
+		i, ok := index[key]
+		if !ok {
+			i = len(data.Groups)
+			index[key] = i
+			data.Groups = append(data.Groups, digestGroup{Alertname: key[0], Severity: key[1]})
+		}
+		data.Groups[i].Alerts = append(data.Groups[i].Alerts, a)
+	}
+	sort.SliceStable(data.Groups, func(i, j int) bool {
+		gi, gj := data.Groups[i], data.Groups[j]
+		if ri, rj := severityRank(gi.Severity), severityRank(gj.Severity); ri != rj {
+			return ri < rj
+		}
+		return gi.Alertname < gj.Alertname
+	})
+	return data
+}
+
+func severityRank(s string) int {
+	switch s {
+	case "critical":
+		return 0
+	case "warning":
+		return 1
+	case "info":
+		return 2
+	}
+	return 3
+}
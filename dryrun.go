@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+/*
+=============================
+ Dry Run (-dry-run, log_only)
+=============================
+*/
+
+var dryRun = flag.Bool("dry-run", false, "decode, transform, route and render alerts as usual, but print what each route's sinks would be given to stdout instead of writing records, sending email or forwarding; routes can set log_only for themselves; POST /render answers with the same per request")
+
+var dryRunAlerts = newCounterVec("dry_run_alerts_total", "Alerts printed instead of delivered, by route (-dry-run or log_only).", "route")
+
+// dryRunOutput is one route's share of a request, as printed. Deliveries
+// lists the fingerprints each sink would have been given, after silences
+// and notify_resolved.
+type dryRunOutput struct {
+	DryRun bool   `json:"dry_run"`
+	ReqID  string `json:"req_id,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	renderedRoute
+	Deliveries map[string][]string `json:"deliveries"`
+}
+
+var dryRunMu sync.Mutex
+
+// logOnly reports whether the route's alerts are printed rather than
+// delivered.
+func (r *route) logOnly() bool {
+	return *dryRun || (r.LogOnly != nil && *r.LogOnly)
+}
+
+// printDryRun writes what a route would have delivered as one JSON line.
+func printDryRun(ctx context.Context, rt *route, alerts, audible []Alert, deliveries map[string][]Alert) {
+	out := dryRunOutput{
+		DryRun:        true,
+		ReqID:         requestID(ctx),
+		Tenant:        tenantOf(ctx),
+		renderedRoute: renderRoute(ctx, rt, alertGroupOf(ctx), alerts, audible),
+		Deliveries:    map[string][]string{},
+	}
+	if len(deliveries[emailSink]) == 0 {
+		out.Email, out.MoreEmails = nil, nil
+	}
+	for name, alerts := range deliveries {
+		for _, a := range alerts {
+			out.Deliveries[name] = append(out.Deliveries[name], a.Fingerprint)
+		}
+	}
+	line, err := json.Marshal(out)
+	if err != nil {
+		slog.Warn("dry run output failed", "route", rt.Name, "req_id", out.ReqID, "err", err)
+		return
+	}
+	dryRunMu.Lock()
+	os.Stdout.Write(append(line, '\n'))
+	dryRunMu.Unlock()
+	dryRunAlerts.add(rt.Name, len(alerts))
+	tracef(ctx, "dry-run", "", "route %s: %d alert(s) printed, not delivered", rt.Name, len(alerts))
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+)
+
+/*
+=============================
+ Email Notifications (SMTP)
+=============================
+*/
+
+var (
+	smtpHost         = flag.String("smtp-host", "", "SMTP server for alert emails; empty disables email")
+	smtpPort         = flag.Int("smtp-port", 587, "SMTP server port")
+	smtpTLS          = flag.String("smtp-tls", "starttls", "SMTP transport security: starttls, tls (implicit, usually port 465) or none")
+	smtpUser         = flag.String("smtp-user", "", "SMTP username (PLAIN auth); empty skips authentication")
+	smtpPasswordFile = flag.String("smtp-password-file", "", "file holding the SMTP password")
+	smtpTimeout      = flag.Duration("smtp-timeout", 10*time.Second, "deadline for one SMTP conversation")
+	emailFrom        = flag.String("email-from", "HiveMQ Alerts <hivemq-alerts@localhost>", "From address of alert emails")
+	emailTo          = flag.String("email-to", "", "comma-separated recipients of alert emails")
+	emailTemplates   = flag.String("email-templates", "", "glob of template files defining "+emailHTMLTemplate+" and "+emailTextTemplate+" (optionally "+emailResolvedHTMLTemplate+" and "+emailResolvedTextTemplate+"); empty uses the built-in ones")
+	emailSubject     = flag.String("email-subject",
+		`[{{ T (print "status." .Status) | toUpper }}{{ if eq .Status "firing" }}:{{ len .Alerts.Firing }}{{ end }}] {{ or .CommonLabels.alertname (T "subject.fallback") }}`,
+		"subject line template (same data as the body templates)")
+)
+
+// The resolved templates are optional; without them resolution emails use
+// the regular ones.
+const (
+	emailHTMLTemplate         = "hivemq.email.html"
+	emailTextTemplate         = "hivemq.email.text"
+	emailResolvedHTMLTemplate = "hivemq.email.resolved.html"
+	emailResolvedTextTemplate = "hivemq.email.resolved.text"
+	emailDigestHTMLTemplate   = "hivemq.email.digest.html"
+	emailDigestTextTemplate   = "hivemq.email.digest.text"
+	emailSink                 = "email"
+)
+
+// emailTemplateSet is one parsed set of body and subject templates: the
+// global one or a route's, for one locale.
+type emailTemplateSet struct {
+	html          *htmltemplate.Template
+	text          *texttemplate.Template
+	subject       *texttemplate.Template
+	digestSubject *texttemplate.Template
+
+	glob    string
+	locale  *emailLocale
+	mu      sync.Mutex
+	locales map[string]*emailTemplateSet // the set parsed for other locales
+}
+
+var (
+	smtpPassword string
+	emailTmpl    *emailTemplateSet
+)
+
+func emailEnabled() bool {
+	return *smtpHost != ""
+}
+
+func emailRecipients() ([]*mail.Address, error) {
+	return mail.ParseAddressList(*emailTo)
+}
+
+// loadEmail checks the SMTP settings and parses the templates. The body
+// templates are parsed twice: as HTML (escaped) and as plain text.
+func loadEmail() error {
+	if !emailEnabled() {
+		return nil
+	}
+	switch *smtpTLS {
+	case "starttls", "tls", "none":
+	default:
+		return fmt.Errorf("invalid -smtp-tls %q", *smtpTLS)
+	}
+	if strings.TrimSpace(*emailTo) == "" {
+		return errors.New("-smtp-host is set but -email-to is empty")
+	}
+	if _, err := emailRecipients(); err != nil {
+		return fmt.Errorf("invalid -email-to: %w", err)
+	}
+	if _, err := mail.ParseAddress(*emailFrom); err != nil {
+		return fmt.Errorf("invalid -email-from: %w", err)
+	}
+	if *smtpPasswordFile != "" {
+		data, err := os.ReadFile(*smtpPasswordFile)
+		if err != nil {
+			return err
+		}
+		smtpPassword = strings.TrimSpace(string(data))
+	}
+	return loadEmailTemplates()
+}
+
+func loadEmailTemplates() error {
+	set, err := parseEmailTemplates(*emailTemplates)
+	if err != nil {
+		return err
+	}
+	emailTmpl = set
+	return nil
+}
+
+// parseEmailTemplates parses a template set for -email-locale.
+func parseEmailTemplates(glob string) (*emailTemplateSet, error) {
+	return parseLocalizedTemplates(glob, defaultLocale)
+}
+
+// parseLocalizedTemplates parses the templates of glob, or the built-in
+// ones, with the T, Tn and localDate of locale l.
+func parseLocalizedTemplates(glob string, l *emailLocale) (*emailTemplateSet, error) {
+	sources, err := emailTemplateSources(glob, l)
+	if err != nil {
+		return nil, err
+	}
+
+	html := htmltemplate.New("email").Funcs(templateFuncs).Funcs(l.funcs())
+	text := texttemplate.New("email").Funcs(templateFuncs).Funcs(l.funcs()).Option("missingkey=zero")
+	for name, src := range sources {
+		if _, err := html.New(name).Parse(src); err != nil {
+			return nil, err
+		}
+		if _, err := text.New(name).Parse(src); err != nil {
+			return nil, err
+		}
+	}
+	if html.Lookup(emailHTMLTemplate) == nil {
+		return nil, fmt.Errorf("email templates do not define %q", emailHTMLTemplate)
+	}
+	// Template sets written before digests existed get the built-in ones.
+	if html.Lookup(emailDigestHTMLTemplate) == nil {
+		src, err := fs.ReadFile(exampleFS, "hivemq-digest.tmpl")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := html.New("hivemq-digest.tmpl").Parse(string(src)); err != nil {
+			return nil, err
+		}
+		if _, err := text.New("hivemq-digest.tmpl").Parse(string(src)); err != nil {
+			return nil, err
+		}
+	}
+	subject, err := texttemplate.New("subject").Funcs(templateFuncs).Funcs(l.funcs()).Option("missingkey=zero").Parse(*emailSubject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -email-subject: %w", err)
+	}
+	digestSubject, err := texttemplate.New("digest-subject").Funcs(templateFuncs).Funcs(l.funcs()).Option("missingkey=zero").Parse(*emailDigestSubject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -email-digest-subject: %w", err)
+	}
+	return &emailTemplateSet{html: html, text: text, subject: subject, digestSubject: digestSubject, glob: glob, locale: l}, nil
+}
+
+// emailTemplateSources reads the templates of glob, or the built-in ones:
+// in English those Alertmanager can render too, in other languages
+// hivemq-i18n.tmpl with the words from the catalog.
+func emailTemplateSources(glob string, l *emailLocale) (map[string]string, error) {
+	sources := map[string]string{}
+	if glob == "" {
+		builtin := []string{"hivemq-email.tmpl", "hivemq-text.tmpl", "hivemq-resolved.tmpl", "hivemq-digest.tmpl"}
+		if !l.english() {
+			builtin = []string{"hivemq-i18n.tmpl"}
+		}
+		for _, name := range builtin {
+			data, err := fs.ReadFile(exampleFS, name)
+			if err != nil {
+				return nil, err
+			}
+			sources[name] = string(data)
+		}
+		return sources, nil
+	}
+
+	files, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("email templates %q match no files", glob)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		sources[file] = string(data)
+	}
+	return sources, nil
+}
+
+/*
+=============================
+ Alert Emails
+=============================
+*/
+
+type emailMessage struct {
+	Subject    string
+	Text       string
+	HTML       string
+	RequestID  string
+	To         string // recipients; empty means -email-to
+	Cc         string
+	Importance string // high, normal or low; empty sets no priority headers
+
+	Attachments []emailAttachment
+}
+
+type emailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// renderAlertEmail renders one email for a group of processed alerts with
+// the global templates.
+func renderAlertEmail(g alertGroup, alerts []Alert) (emailMessage, error) {
+	return emailTmpl.render(g, alerts)
+}
+
+// render renders one email for a group of processed alerts. A group with
+// nothing firing any more gets the resolution templates.
+func (t *emailTemplateSet) render(g alertGroup, alerts []Alert) (emailMessage, error) {
+	data := newTemplateData(g, alerts)
+	htmlName, textName := emailHTMLTemplate, emailTextTemplate
+	if data.Status == "resolved" && t.html.Lookup(emailResolvedHTMLTemplate) != nil {
+		htmlName, textName = emailResolvedHTMLTemplate, emailResolvedTextTemplate
+	}
+
+	return t.execute(t.subject, htmlName, textName, data)
+}
+
+func (t *emailTemplateSet) execute(subjectT *texttemplate.Template, htmlName, textName string, data any) (emailMessage, error) {
+	var subject, html, text bytes.Buffer
+	if err := subjectT.Execute(&subject, data); err != nil {
+		return emailMessage{}, fmt.Errorf("subject: %w", err)
+	}
+	if err := t.html.ExecuteTemplate(&html, htmlName, data); err != nil {
+		return emailMessage{}, err
+	}
+	if t.text.Lookup(textName) != nil {
+		if err := t.text.ExecuteTemplate(&text, textName, data); err != nil {
+			return emailMessage{}, err
+		}
+	}
+	return emailMessage{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    strings.TrimSpace(html.String()) + "\n",
+	}, nil
+}
+
+// notifyEmail sends one email per webhook request, as Alertmanager already
+// grouped the alerts; deliverGroup has left out the silenced and recently
+// acknowledged ones. It runs on a queue worker, so a slow relay never
+// holds up the webhook response.
+func notifyEmail(ctx context.Context, alerts []Alert) error {
+	if !emailEnabled() || len(alerts) == 0 {
+		return nil
+	}
+	notify := make([]Alert, 0, len(alerts))
+	for _, a := range alerts {
+		notify = append(notify, withAck(tenantOf(ctx), a))
+	}
+	if digestEnabled() {
+		digests.add(ctx, notify)
+		return nil
+	}
+
+	// Alerts steering their own recipients get emails of their own; a
+	// retry sends every part again.
+	r := routeOf(ctx)
+	var errs []error
+	for _, part := range r.emailParts(ctx, notify) {
+		msg, err := r.emailTemplates(tenantOf(ctx)).render(alertGroupOf(ctx), part.alerts)
+		if err != nil {
+			slog.Error("rendering email", "req_id", requestID(ctx), "err", err)
+			tracef(ctx, emailSink, "", "render failed: %v", err)
+			return err
+		}
+		msg.RequestID, msg.To, msg.Cc = requestID(ctx), part.to, part.cc
+		errs = append(errs, deliverEmail(ctx, r, msg, part.alerts))
+	}
+	return errors.Join(errs...)
+}
+
+// deliverEmail sends msg to its recipients, by default the route's, and
+// notes the delivery of each alert in it.
+func deliverEmail(ctx context.Context, r *route, msg emailMessage, alerts []Alert) error {
+	if msg.To == "" {
+		msg.To = r.recipients(tenantOf(ctx))
+	}
+	msg.Importance = importanceOf(alerts)
+	if a := payloadAttachment(ctx); a != nil {
+		msg.Attachments = append(msg.Attachments, *a)
+	}
+	if msg.Cc != "" {
+		tracef(ctx, emailSink, "", "sending %q to %s, cc %s", msg.Subject, msg.To, msg.Cc)
+	} else {
+		tracef(ctx, emailSink, "", "sending %q to %s", msg.Subject, msg.To)
+	}
+
+	now := clock.Now()
+	err := sendEmail(msg)
+	for _, a := range alerts {
+		deliveries.record(delivery{
+			Fingerprint: a.Fingerprint,
+			RequestID:   msg.RequestID,
+			Tenant:      tenantOf(ctx),
+			Sink:        emailSink,
+			Target:      msg.To,
+			At:          now,
+		}, err)
+	}
+	if err != nil {
+		slog.Warn("sink write failed", "sink", emailSink, "target", msg.To, "req_id", msg.RequestID, "err", err)
+	}
+	return err
+}
+
+// withAck exposes an acknowledgment to templates as ack_by/ack_at
+// annotations, keeping the Alertmanager data model unchanged.
+func withAck(tenant string, a Alert) Alert {
+	k, ok := tracker.ackFor(tenant, a.Fingerprint)
+	if !ok {
+		return a
+	}
+	annotations := make(map[string]string, len(a.Annotations)+2)
+	for key, v := range a.Annotations {
+		annotations[key] = v
+	}
+	annotations["ack_by"] = k.By
+	annotations["ack_at"] = k.At.Format(tsLayout)
+	a.Annotations = annotations
+	return a
+}
+
+// sendTextEmail sends a plain-text message, e.g. a report.
+func sendTextEmail(subject, body string) error {
+	if !emailEnabled() {
+		return nil
+	}
+	return sendEmail(emailMessage{Subject: subject, Text: body})
+}
+
+/*
+=============================
+ SMTP Transport
+=============================
+*/
+
+func sendEmail(msg emailMessage) error {
+	from, _ := mail.ParseAddress(*emailFrom)
+	to, _ := emailRecipients()
+	if msg.To != "" {
+		to, _ = mail.ParseAddressList(msg.To)
+	}
+	var cc []*mail.Address
+	if msg.Cc != "" {
+		cc, _ = mail.ParseAddressList(msg.Cc)
+	}
+	raw, err := composeEmail(from, to, cc, msg)
+	if err != nil {
+		return err
+	}
+	return breakerFor(emailSink).call(func() error {
+		return smtpDeliver(from.Address, append(slices.Clone(to), cc...), raw)
+	})
+}
+
+func composeEmail(from *mail.Address, to, cc []*mail.Address, msg emailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	h := textproto.MIMEHeader{}
+	h.Set("From", from.String())
+	h.Set("To", addressList(to))
+	if len(cc) > 0 {
+		h.Set("Cc", addressList(cc))
+	}
+	h.Set("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	h.Set("Date", clock.Now().Format(time.RFC1123Z))
+	h.Set("Message-ID", "<"+newRequestID()+"@"+emailDomain(from.Address)+">")
+	h.Set("MIME-Version", "1.0")
+	if msg.RequestID != "" {
+		h.Set(requestIDHeader, msg.RequestID)
+	}
+	if msg.Importance != "" {
+		h.Set("Importance", msg.Importance)
+		h.Set("X-Priority", emailImportance[msg.Importance])
+	}
+
+	if len(msg.Attachments) == 0 {
+		boundary := bodyHeader(h, msg)
+		writeHeader(&buf, h)
+		if err := writeBody(&buf, msg, boundary); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	// With attachments, the body is the first part of a mixed message.
+	mixed := multipart.NewWriter(&buf)
+	h.Set("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+	writeHeader(&buf, h)
+	bh := textproto.MIMEHeader{}
+	boundary := bodyHeader(bh, msg)
+	w, err := mixed.CreatePart(bh)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeBody(w, msg, boundary); err != nil {
+		return nil, err
+	}
+	for _, a := range msg.Attachments {
+		w, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {mime.FormatMediaType(a.ContentType, map[string]string{"name": a.Name})},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(w, a.Data); err != nil {
+			return nil, err
+		}
+	}
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bodyHeader sets the Content-Type of the body in h. An HTML body goes
+// with its text version as multipart/alternative; the boundary is
+// returned for writeBody.
+func bodyHeader(h textproto.MIMEHeader, msg emailMessage) string {
+	if msg.HTML == "" {
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		return ""
+	}
+	boundary := multipart.NewWriter(io.Discard).Boundary()
+	h.Set("Content-Type", "multipart/alternative; boundary="+boundary)
+	return boundary
+}
+
+func writeBody(w io.Writer, msg emailMessage, boundary string) error {
+	if boundary == "" {
+		return writeQP(w, msg.Text)
+	}
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for _, part := range []struct{ typ, body string }{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		if strings.TrimSpace(part.body) == "" {
+			continue
+		}
+		w, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.typ + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		if err := writeQP(w, part.body); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeBase64 writes data base64-encoded in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+func writeHeader(buf *bytes.Buffer, h textproto.MIMEHeader) {
+	for _, k := range []string{"From", "To", "Subject", "Date", "Message-ID", requestIDHeader, "Importance", "X-Priority", "MIME-Version", "Content-Type", "Content-Transfer-Encoding"} {
+		if v := h.Get(k); v != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", k, v)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+func writeQP(w interface{ Write([]byte) (int, error) }, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(s, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func emailDomain(addr string) string {
+	if _, domain, ok := strings.Cut(addr, "@"); ok {
+		return domain
+	}
+	return "localhost"
+}
+
+// smtpDeliver runs one SMTP conversation under a single deadline.
+func addressList(addrs []*mail.Address) string {
+	out := make([]string, len(addrs))
+	for i, a := range addrs {
+		out[i] = a.String()
+	}
+	return strings.Join(out, ", ")
+}
+
+func smtpDeliver(from string, to []*mail.Address, raw []byte) error {
+	addr := net.JoinHostPort(*smtpHost, strconv.Itoa(*smtpPort))
+	dialer := &net.Dialer{Timeout: *smtpTimeout}
+	tlsConfig := &tls.Config{ServerName: *smtpHost}
+
+	var conn net.Conn
+	var err error
+	if *smtpTLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(*smtpTimeout))
+
+	c, err := smtp.NewClient(conn, *smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if *smtpTLS == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("smtp: server does not offer STARTTLS (use -smtp-tls none to send in clear text)")
+		}
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if *smtpUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", *smtpUser, smtpPassword, *smtpHost)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := c.Rcpt(rcpt.Address); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+/*
+=============================
+ Encryption at Rest
+=============================
+*/
+
+var (
+	encryptMode       = flag.String("encrypt", "off", "encrypt output: off, line (every appended line) or file (whole day files once the day has rolled over)")
+	encryptKeyFile    = flag.String("encrypt-key-file", "", "AES-256 key (32 raw bytes, hex or base64) used for encryption")
+	encryptRecipients = flag.String("encrypt-recipients", "", "file of age X25519 recipients used for encryption instead of an AES key")
+	encryptIdentity   = flag.String("encrypt-identity", "", "file of age identities used to read age-encrypted output back")
+)
+
+const (
+	encLinePrefix = "ENC1 "
+	encFileMagic  = "HMQENC1\n"
+	encSuffixAES  = ".enc"
+	encSuffixAge  = ".age"
+)
+
+var (
+	aesKey         cipher.AEAD
+	ageRecipients  []age.Recipient
+	ageIdentities  []age.Identity
+	errUnreadable  = errors.New("encrypted output, but no key to read it")
+	unreadableOnce sync.Map
+)
+
+func loadEncryption() error {
+	switch *encryptMode {
+	case "off", "line", "file":
+	default:
+		return fmt.Errorf("invalid -encrypt %q", *encryptMode)
+	}
+	if *encryptKeyFile != "" && *encryptRecipients != "" {
+		return errors.New("-encrypt-key-file and -encrypt-recipients are mutually exclusive")
+	}
+
+	if *encryptKeyFile != "" {
+		raw, err := os.ReadFile(*encryptKeyFile)
+		if err != nil {
+			return err
+		}
+		key, err := parseAESKey(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *encryptKeyFile, err)
+		}
+		block, _ := aes.NewCipher(key)
+		aesKey, _ = cipher.NewGCM(block)
+	}
+	if *encryptRecipients != "" {
+		f, err := os.Open(*encryptRecipients)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if ageRecipients, err = age.ParseRecipients(f); err != nil {
+			return fmt.Errorf("%s: %w", *encryptRecipients, err)
+		}
+	}
+	if *encryptIdentity != "" {
+		f, err := os.Open(*encryptIdentity)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if ageIdentities, err = age.ParseIdentities(f); err != nil {
+			return fmt.Errorf("%s: %w", *encryptIdentity, err)
+		}
+	}
+
+	if *encryptMode != "off" && aesKey == nil && ageRecipients == nil {
+		return errors.New("-encrypt needs -encrypt-key-file or -encrypt-recipients")
+	}
+	return nil
+}
+
+func parseAESKey(raw []byte) ([]byte, error) {
+	if len(raw) == 32 {
+		return raw, nil
+	}
+	s := strings.TrimSpace(string(raw))
+	if k, err := hex.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	if k, err := base64.StdEncoding.DecodeString(s); err == nil && len(k) == 32 {
+		return k, nil
+	}
+	return nil, errors.New("want a 32-byte AES-256 key, raw, hex or base64")
+}
+
+/*
+=============================
+ Per-Line Encryption
+=============================
+*/
+
+// encryptLine turns one JSON line into "ENC1 <base64>", where the payload
+// is nonce||AES-GCM ciphertext or a complete age file.
+func encryptLine(line []byte) ([]byte, error) {
+	var sealed []byte
+	if aesKey != nil {
+		nonce := make([]byte, aesKey.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		sealed = aesKey.Seal(nonce, nonce, line, nil)
+	} else {
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, ageRecipients...)
+		if err != nil {
+			return nil, err
+		}
+		w.Write(line)
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		sealed = buf.Bytes()
+	}
+	return []byte(encLinePrefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func decryptLine(line []byte) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(line[len(encLinePrefix):]))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(sealed, []byte("age-encryption.org/")) {
+		if ageIdentities == nil {
+			return nil, errUnreadable
+		}
+		r, err := age.Decrypt(bytes.NewReader(sealed), ageIdentities...)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+	if aesKey == nil {
+		return nil, errUnreadable
+	}
+	n := aesKey.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("truncated ciphertext")
+	}
+	return aesKey.Open(nil, sealed[:n], sealed[n:], nil)
+}
+
+/*
+=============================
+ Whole-File Encryption
+=============================
+*/
+
+// decryptingReader unwraps a file encrypted by encryptClosedFiles.
+func decryptingReader(path string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(path, encSuffixAge):
+		if ageIdentities == nil {
+			return nil, errUnreadable
+		}
+		return age.Decrypt(r, ageIdentities...)
+	case strings.HasSuffix(path, encSuffixAES):
+		if aesKey == nil {
+			return nil, errUnreadable
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		n := aesKey.NonceSize()
+		if !bytes.HasPrefix(data, []byte(encFileMagic)) || len(data) < len(encFileMagic)+n {
+			return nil, errors.New("not an encrypted output file")
+		}
+		data = data[len(encFileMagic):]
+		plain, err := aesKey.Open(nil, data[:n], data[n:], nil)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(plain), nil
+	}
+	return r, nil
+}
+
+// warnUnreadable logs once per file that it was skipped for lack of a key.
+func warnUnreadable(path string) {
+	if _, seen := unreadableOnce.LoadOrStore(path, true); !seen {
+		slog.Warn("skipping file", "path", path, "err", errUnreadable)
+	}
+}
+
+func runFileEncryption(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		withSettings(func() { encryptClosedFiles(clock.Now()) })
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// encryptClosedFiles encrypts every plain day file older than today. The
+// day file rolls over at midnight, so nothing appends to these any more.
+func encryptClosedFiles(now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, tenant := range allTenants() {
+		files, err := listLogFiles(tenant)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.Compressed || f.Encrypted || !f.Date.Before(today) || rollupPending(f, now) || writer.holds(f.Path) {
+				continue
+			}
+			if err := encryptFile(f.Path); err != nil {
+				slog.Error("encrypting file", "path", f.Path, "err", err)
+			}
+		}
+	}
+}
+
+func encryptFile(path string) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	suffix := encSuffixAES
+	if aesKey == nil {
+		suffix = encSuffixAge
+	}
+	target := path + suffix
+	tmp := target + ".tmp"
+	out, err := openLogFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+
+	if aesKey != nil {
+		nonce := make([]byte, aesKey.NonceSize())
+		if _, err = rand.Read(nonce); err == nil {
+			bw.WriteString(encFileMagic)
+			bw.Write(nonce)
+			_, err = bw.Write(aesKey.Seal(nil, nonce, plain, nil))
+		}
+	} else {
+		var w io.WriteCloser
+		if w, err = age.Encrypt(bw, ageRecipients...); err == nil {
+			w.Write(plain)
+			err = w.Close()
+		}
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Like compressFile: a backfilled line that landed meanwhile leaves
+	// the plain file for the next pass.
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if now, err := os.Stat(path); err != nil || now.Size() != int64(len(plain)) {
+		os.Remove(tmp)
+		return nil
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	fileSummaryMu.Lock()
+	delete(fileSummaryCache, path)
+	fileSummaryMu.Unlock()
+	return os.Remove(path)
+}
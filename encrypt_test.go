@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+// useKeys sets the encryption keys for a test: an AES key from seed, or an
+// age identity when seed is 0, and readers with or without the key.
+func useKeys(t *testing.T, seed byte, canRead bool) {
+	t.Helper()
+	key, recipients, identities := aesKey, ageRecipients, ageIdentities
+	t.Cleanup(func() { aesKey, ageRecipients, ageIdentities = key, recipients, identities })
+	aesKey, ageRecipients, ageIdentities = nil, nil, nil
+	if seed != 0 {
+		block, _ := aes.NewCipher(bytes.Repeat([]byte{seed}, 32))
+		aesKey, _ = cipher.NewGCM(block)
+		return
+	}
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ageRecipients = []age.Recipient{id.Recipient()}
+	if canRead {
+		ageIdentities = []age.Identity{id}
+	}
+}
+
+func TestParseAESKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xab}, 32)
+	tests := []struct {
+		name    string
+		raw     []byte
+		wantErr bool
+	}{
+		{"raw", key, false},
+		{"hex", []byte(hex.EncodeToString(key)), false},
+		{"hex with newline", []byte(hex.EncodeToString(key) + "\n"), false},
+		{"base64", []byte(base64.StdEncoding.EncodeToString(key)), false},
+		{"short raw", key[:31], true},
+		{"short hex", []byte(hex.EncodeToString(key[:20])), true},
+		{"not a key", []byte("correct horse battery staple"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAESKey(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAESKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, key) {
+				t.Errorf("key %x, want %x", got, key)
+			}
+		})
+	}
+}
+
+func TestLineEncryption(t *testing.T) {
+	line := []byte(`{"ts":"2026-10-15 10:00","kpi":"Disk"}`)
+	tests := []struct {
+		name    string
+		seed    byte // AES key; 0 for age
+		readAs  byte // key reading it back; 0 for the same
+		canRead bool
+		tamper  bool
+		wantErr error // nil for any error when wantOK is false
+		wantOK  bool
+	}{
+		{"aes", 1, 0, true, false, nil, true},
+		{"age", 0, 0, true, false, nil, true},
+		{"aes other key", 1, 2, true, false, nil, false},
+		{"aes tampered", 1, 0, true, true, nil, false},
+		{"age without identity", 0, 0, false, false, errUnreadable, false},
+		{"age tampered", 0, 0, true, true, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.seed, tt.canRead)
+			enc, err := encryptLine(line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(enc, []byte(encLinePrefix)) || bytes.Contains(enc, []byte("Disk")) || bytes.ContainsRune(enc, '\n') {
+				t.Fatalf("encrypted line %q", enc)
+			}
+			if tt.tamper {
+				sealed, _ := base64.StdEncoding.DecodeString(string(enc[len(encLinePrefix):]))
+				sealed[len(sealed)-1] ^= 1
+				enc = []byte(encLinePrefix + base64.StdEncoding.EncodeToString(sealed))
+			}
+			if tt.readAs != 0 {
+				useKeys(t, tt.readAs, true)
+			}
+			got, err := decryptLine(enc)
+			if tt.wantOK {
+				if err != nil || !bytes.Equal(got, line) {
+					t.Errorf("decryptLine = %q, %v; want %q", got, err, line)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("decryptLine succeeded with %q", got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecryptLineWithoutKey(t *testing.T) {
+	useKeys(t, 1, true)
+	enc, err := encryptLine([]byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey = nil
+	if _, err := decryptLine(enc); !errors.Is(err, errUnreadable) {
+		t.Errorf("error %v, want %v", err, errUnreadable)
+	}
+}
+
+func TestEncryptFile(t *testing.T) {
+	plain := "{\"ts\":\"2026-10-14 10:00\"}\n{\"ts\":\"2026-10-14 11:00\"}\n"
+	tests := []struct {
+		name       string
+		seed       byte
+		wantSuffix string
+	}{
+		{"aes", 1, encSuffixAES},
+		{"age", 0, encSuffixAge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useKeys(t, tt.seed, true)
+			path := filepath.Join(t.TempDir(), "app_hivemq_202610140001.log")
+			if err := os.WriteFile(path, []byte(plain), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := encryptFile(path); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("plain file left behind: %v", err)
+			}
+			target := path + tt.wantSuffix
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(data), "2026-10-14") {
+				t.Error("encrypted file holds plain text")
+			}
+			r, err := decryptingReader(target, bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || string(got) != plain {
+				t.Errorf("read back %q, %v; want %q", got, err, plain)
+			}
+
+			aesKey, ageIdentities = nil, nil
+			if _, err := decryptingReader(target, bytes.NewReader(data)); !errors.Is(err, errUnreadable) {
+				t.Errorf("without the key: %v, want %v", err, errUnreadable)
+			}
+		})
+	}
+}
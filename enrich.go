@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+
+	"github.com/oschwald/geoip2-golang"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Site / GeoIP Enrichment
+=============================
+*/
+
+var (
+	siteMapFile = flag.String("site-map", "", "YAML file mapping instance CIDRs to site and region")
+	geoIPDBFile = flag.String("geoip-db", "", "MaxMind GeoIP2/GeoLite2 City database used when no -site-map CIDR matches")
+)
+
+type siteRange struct {
+	CIDR   string `yaml:"cidr"`
+	Site   string `yaml:"site"`
+	Region string `yaml:"region"`
+
+	prefix netip.Prefix
+}
+
+var (
+	siteRanges []siteRange
+	geoDB      *geoip2.Reader
+	geoDBPath  string
+)
+
+func loadEnrichment() error {
+	siteRanges = nil
+	if *siteMapFile != "" {
+		data, err := os.ReadFile(*siteMapFile)
+		if err != nil {
+			return err
+		}
+		var ranges []siteRange
+		if err := yaml.Unmarshal(data, &ranges); err != nil {
+			return fmt.Errorf("%s: %w", *siteMapFile, err)
+		}
+		for i := range ranges {
+			p, err := netip.ParsePrefix(ranges[i].CIDR)
+			if err != nil {
+				return fmt.Errorf("%s: %w", *siteMapFile, err)
+			}
+			ranges[i].prefix = p.Masked()
+		}
+		// Most specific prefix first, so the first match wins.
+		sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].prefix.Bits() > ranges[j].prefix.Bits() })
+		siteRanges = ranges
+	}
+	// A replaced database is not closed: cached lookups may still be
+	// revalidating against it in the background.
+	if *geoIPDBFile == "" {
+		geoDB, geoDBPath = nil, ""
+	} else if *geoIPDBFile != geoDBPath {
+		db, err := geoip2.Open(*geoIPDBFile)
+		if err != nil {
+			return fmt.Errorf("geoip: %w", err)
+		}
+		geoDB, geoDBPath = db, *geoIPDBFile
+	}
+	return nil
+}
+
+// locate returns the site and region of an address, or empty strings.
+func locate(addr netip.Addr) (site, region string) {
+	addr = addr.Unmap()
+	for _, r := range siteRanges {
+		if r.prefix.Contains(addr) {
+			return r.Site, r.Region
+		}
+	}
+	if geoDB == nil {
+		return "", ""
+	}
+	v, err := lookups.get("geo:"+addr.String(), func(context.Context) (any, error) {
+		city, err := geoDB.City(net.IP(addr.AsSlice()))
+		if err != nil {
+			return [2]string{}, err
+		}
+		region := city.Country.IsoCode
+		if len(city.Subdivisions) > 0 && city.Subdivisions[0].IsoCode != "" {
+			region += "-" + city.Subdivisions[0].IsoCode
+		}
+		return [2]string{city.City.Names["en"], region}, nil
+	})
+	if err != nil {
+		return "", ""
+	}
+	loc := v.([2]string)
+	return loc[0], loc[1]
+}
+
+// enrichAlert adds "site" and "region" labels derived from the alert's IP
+// address (-address-labels) and, with -reverse-dns, a missing "hostname". Labels the alert already
+// carries are left alone.
+func enrichAlert(a Alert) (Alert, bool) {
+	wantSite := (len(siteRanges) > 0 || geoDB != nil) && (a.Labels["site"] == "" || a.Labels["region"] == "")
+	wantHost := *reverseDNS && a.Labels["hostname"] == ""
+	if !wantSite && !wantHost {
+		return a, false
+	}
+	addr, ok := alertAddr(a.Labels)
+	if !ok {
+		return a, false
+	}
+
+	added := make(map[string]string)
+	if wantSite {
+		site, region := locate(addr)
+		if a.Labels["site"] == "" && site != "" {
+			added["site"] = site
+		}
+		if a.Labels["region"] == "" && region != "" {
+			added["region"] = region
+		}
+	}
+	if wantHost {
+		if name := lookupHostname(addr); name != "" {
+			added["hostname"] = name
+		}
+	}
+	if len(added) == 0 {
+		return a, false
+	}
+
+	labels := make(map[string]string, len(a.Labels)+len(added))
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	for k, v := range added {
+		labels[k] = v
+	}
+	a.Labels = labels
+	return a, true
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"unicode/utf8"
+)
+
+/*
+=============================
+ Entry Size Limit
+=============================
+*/
+
+var (
+	maxEntryBytes  = flag.Int("max-entry-bytes", 0, "maximum serialized size of one output line; 0 disables the limit")
+	oversizePolicy = flag.String("oversize-policy", "truncate", "for entries over -max-entry-bytes: truncate (shorten the summary) or dead-letter")
+)
+
+const truncatedMarker = "…[truncated]"
+
+var errOversize = errors.New("entry exceeds -max-entry-bytes")
+
+func validOversizePolicy(p string) bool {
+	return p == "truncate" || p == "dead-letter"
+}
+
+// lineBudget is the size left for the JSON record once the integrity
+// fields that will be appended are accounted for.
+func lineBudget() int {
+	budget := *maxEntryBytes
+	if *integrityMode != "off" {
+		budget -= len(`,"prev_hash":""`) + 64
+	}
+	if *integrityMode == "hmac" {
+		budget -= len(`,"mac":""`) + 64
+	}
+	return budget
+}
+
+// fitEntry enforces the size limit on an encoded line (without newline).
+// A raw payload copy goes first; then, under the truncate policy, the
+// summary is shortened. errOversize means the entry belongs in the
+// dead-letter file.
+func fitEntry(entry JSONLog, line []byte) ([]byte, error) {
+	budget := lineBudget()
+	if *maxEntryBytes <= 0 || len(line) <= budget {
+		return line, nil
+	}
+	if entry.RawPayload != nil {
+		entry.RawPayload = nil
+		var err error
+		if line, err = encodeEntry(entry); err != nil || len(line) <= budget {
+			return line, err
+		}
+	}
+	if *oversizePolicy != "truncate" {
+		return nil, errOversize
+	}
+
+	summary := entry.Summary
+	for len(line) > budget {
+		cut := len(summary) - (len(line) - budget) - len(truncatedMarker)
+		if cut <= 0 {
+			return nil, fmt.Errorf("%w even with an empty summary", errOversize)
+		}
+		for cut > 0 && !utf8.RuneStart(summary[cut]) {
+			cut--
+		}
+		summary = summary[:cut]
+		entry.Summary = summary + truncatedMarker
+
+		var err error
+		if line, err = encodeEntry(entry); err != nil {
+			return nil, err
+		}
+	}
+	return line, nil
+}
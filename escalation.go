@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Escalation
+=============================
+*/
+
+var (
+	escalateAfterRepeats = flag.Int("escalate-after-repeats", 0, "escalate an alert still firing after this many repeat notifications; 0 disables the count")
+	escalateAfter        = flag.Duration("escalate-after", 0, "escalate an alert still firing this long after it started, checked without waiting for a repeat; 0 disables the timer")
+	escalateRoutes       = flag.String("escalate-routes", "", "comma-separated names of routes (-routes) escalated alerts are sent to as well: level n goes to the n-th, reached after n times -escalate-after-repeats or -escalate-after")
+	escalateSeverities   = flag.String("escalate-severities", "critical", "comma-separated severity label values that escalate")
+)
+
+// escalationLabel carries an escalated alert's level to the routes,
+// templates and the record's escalation_level.
+const escalationLabel = "escalation_level"
+
+var escalationsTotal = newCounterVec("escalations_total", "Alerts that reached an escalation level, by level.", "level")
+
+func escalationEnabled() bool {
+	return *escalateRoutes != "" && (*escalateAfterRepeats > 0 || *escalateAfter > 0)
+}
+
+// validEscalation runs after loadRoutes: the escalation routes must exist.
+func validEscalation() error {
+	if *escalateAfterRepeats < 0 || *escalateAfter < 0 {
+		return errors.New("-escalate-after-repeats and -escalate-after must not be negative")
+	}
+	if *escalateRoutes == "" {
+		return nil
+	}
+	if *escalateAfterRepeats == 0 && *escalateAfter == 0 {
+		return errors.New("-escalate-routes needs -escalate-after-repeats or -escalate-after")
+	}
+	root := currentRoute()
+	for _, name := range splitList(*escalateRoutes) {
+		if root.find(name) == nil {
+			return fmt.Errorf("-escalate-routes: no route named %q", name)
+		}
+	}
+	return nil
+}
+
+// find returns the route of the given name in r's tree.
+func (r *route) find(name string) *route {
+	if r.Name == name {
+		return r
+	}
+	for _, child := range r.Routes {
+		if found := child.find(name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// escalationRoute is the route an alert of the given level goes to; levels
+// past the list stay with the last route.
+func escalationRoute(root *route, level int) *route {
+	names := splitList(*escalateRoutes)
+	if level < 1 || len(names) == 0 {
+		return nil
+	}
+	return root.find(names[min(level, len(names))-1])
+}
+
+// escalationOf is the level an alert was tagged with, 0 when none.
+func escalationOf(a Alert) int {
+	level, _ := strconv.Atoi(a.Labels[escalationLabel])
+	return level
+}
+
+type escalationState struct {
+	tenant  string
+	since   time.Time
+	repeats int
+	level   int
+	alert   Alert // the latest notification, as transformed
+}
+
+// escalationTracker follows the firing alerts of escalating severities.
+type escalationTracker struct {
+	mu     sync.Mutex
+	states map[string]*escalationState
+}
+
+var escalations = &escalationTracker{states: map[string]*escalationState{}}
+
+// observe counts a notification of an alert and returns its level. A
+// resolved alert keeps its last level, so the escalation route hears of
+// it, and is forgotten. Acknowledged alerts do not climb.
+func (e *escalationTracker) observe(tenant, fp string, a Alert, now time.Time) int {
+	if !escalationEnabled() || !slices.Contains(splitList(*escalateSeverities), a.Labels["severity"]) {
+		return 0
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, ok := e.states[fp]
+	if a.Status == "resolved" {
+		delete(e.states, fp)
+		if !ok {
+			return 0
+		}
+		return st.level
+	}
+	if !ok {
+		since := a.StartsAt
+		if since.IsZero() || since.After(now) {
+			since = now
+		}
+		st = &escalationState{since: since}
+		e.states[fp] = st
+	} else {
+		st.repeats++
+	}
+	st.tenant, st.alert = tenant, a
+	e.climb(fp, st, now)
+	return st.level
+}
+
+// climb raises the state's level to what its repeats and age call for and
+// reports whether it rose. e.mu is held.
+func (e *escalationTracker) climb(fp string, st *escalationState, now time.Time) bool {
+	if tracker.suppressed(st.tenant, fp, now) {
+		return false
+	}
+	level := 0
+	if n := *escalateAfterRepeats; n > 0 {
+		level = st.repeats / n
+	}
+	if d := *escalateAfter; d > 0 {
+		level = max(level, int(now.Sub(st.since)/d))
+	}
+	level = min(level, max(1, len(splitList(*escalateRoutes))))
+	if level <= st.level {
+		return false
+	}
+	st.level = level
+	escalationsTotal.add(strconv.Itoa(level), 1)
+	slog.Warn("alert escalated", "fingerprint", fp, "alertname", st.alert.Labels["alertname"], "tenant", st.tenant,
+		"level", level, "repeats", st.repeats, "firing_for", now.Sub(st.since).Round(time.Second))
+	return true
+}
+
+// withEscalation tags an alert with its level, keeping the fingerprint it
+// came with.
+func withEscalation(a Alert, fp string, level int) Alert {
+	labels := make(map[string]string, len(a.Labels)+1)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels[escalationLabel] = strconv.Itoa(level)
+	a.Labels, a.Fingerprint = labels, fp
+	return a
+}
+
+// runEscalation escalates alerts by age between their notifications. The
+// checks and deliveries run with the settings held, like a queued batch.
+func runEscalation(done <-chan struct{}) {
+	for {
+		every := 30 * time.Second
+		withSettings(func() {
+			if d := *escalateAfter; d > 0 {
+				every = min(every, max(d/10, time.Second))
+			}
+		})
+		timer := time.NewTimer(every)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		withSettings(func() {
+			if escalationEnabled() && *escalateAfter > 0 {
+				escalations.check(clock.Now())
+			}
+		})
+	}
+}
+
+// check sends the alerts whose age took them to a new level to that
+// level's route only; their other routes already had this notification.
+func (e *escalationTracker) check(now time.Time) {
+	type due struct {
+		tenant string
+		alert  Alert
+	}
+	var send []due
+	e.mu.Lock()
+	for fp, st := range e.states {
+		if e.climb(fp, st, now) {
+			send = append(send, due{st.tenant, withEscalation(st.alert, fp, st.level)})
+		}
+	}
+	e.mu.Unlock()
+
+	root := currentRoute()
+	for _, d := range send {
+		rt := escalationRoute(root, escalationOf(d.alert))
+		if rt == nil {
+			continue
+		}
+		ctx := context.WithValue(withTenantValue(context.Background(), d.tenant), requestIDKey{}, newRequestID())
+		ctx = withAlertGroup(ctx, alertGroup{Receiver: "alertbridge", GroupLabels: map[string]string{"alertname": d.alert.Labels["alertname"]}})
+		tracef(ctx, "escalate", d.alert.Fingerprint, "level %s after %s, to route %s", d.alert.Labels[escalationLabel], *escalateAfter, rt.Name)
+		deliverRoute(ctx, rt, []Alert{rt.rewriteAlert(ctx, d.alert)})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+/*
+=============================
+ Embedded Examples (examples export)
+=============================
+*/
+
+//go:embed hivemq-email.tmpl hivemq-text.tmpl hivemq-resolved.tmpl hivemq-digest.tmpl hivemq-i18n.tmpl hivemq_rules.yml locales examples testdata/fixtures
+var exampleFS embed.FS
+
+// exampleLayout maps embedded sources to their place in an exported tree.
+var exampleLayout = []struct{ src, dst string }{
+	{"hivemq-email.tmpl", "templates"},
+	{"hivemq-text.tmpl", "templates"},
+	{"hivemq-resolved.tmpl", "templates"},
+	{"hivemq-digest.tmpl", "templates"},
+	{"hivemq-i18n.tmpl", "templates"},
+	{"locales", "locales"},
+	{"hivemq_rules.yml", "config"},
+	{"examples", "config"},
+	{"testdata/fixtures", "fixtures"},
+}
+
+func runExamples(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: examples <list | export [-force] <dir>>")
+	}
+
+	switch args[0] {
+	case "list":
+		return walkExamples(func(src, dst string) error {
+			fmt.Println(dst)
+			return nil
+		})
+	case "export":
+		fs := flag.NewFlagSet("examples export", flag.ExitOnError)
+		force := fs.Bool("force", false, "overwrite files that already exist")
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return errors.New("usage: examples export [-force] <dir>")
+		}
+		return exportExamples(fs.Arg(0), *force)
+	default:
+		return fmt.Errorf("unknown examples command %q", args[0])
+	}
+}
+
+func exportExamples(dir string, force bool) error {
+	return walkExamples(func(src, dst string) error {
+		target := filepath.Join(dir, filepath.FromSlash(dst))
+		if _, err := os.Stat(target); err == nil && !force {
+			fmt.Printf("skip    %s (exists)\n", target)
+			return nil
+		}
+		data, err := exampleFS.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("wrote   %s\n", target)
+		return nil
+	})
+}
+
+func walkExamples(fn func(src, dst string) error) error {
+	for _, entry := range exampleLayout {
+		err := fs.WalkDir(exampleFS, entry.src, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel := path.Base(p)
+			if p != entry.src {
+				rel = p[len(entry.src)+1:]
+			}
+			return fn(p, path.Join(entry.dst, rel))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
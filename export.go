@@ -0,0 +1,157 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ History Export (CSV / XLSX)
+=============================
+*/
+
+var exportColumns = []string{"ts", "ip", "hname", "kpi", "value", "cnt", "app_sub_name"}
+
+func (e JSONLog) row() []string {
+	return []string{e.Timestamp, e.IP, e.Hostname, e.KPI, e.Value, e.Count, e.Summary}
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	from, err := parseTimeParam(q.Get("from"), false)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), true)
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := q.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "xlsx" {
+		http.Error(w, "format must be csv or xlsx", http.StatusBadRequest)
+		return
+	}
+
+	// Exports can outlive the server-wide write timeout.
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	w.Header().Set("Content-Disposition", `attachment; filename="hivemq-alerts.`+format+`"`)
+	if format == "xlsx" {
+		w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		_ = exportXLSX(w, tenantOf(r.Context()), from, to)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	_ = exportCSV(w, tenantOf(r.Context()), from, to)
+}
+
+func exportCSV(w io.Writer, tenant string, from, to time.Time) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportColumns); err != nil {
+		return err
+	}
+
+	rows := 0
+	err := scanHistory(tenant, from, to, func(e JSONLog) error {
+		if err := cw.Write(csvSafe(e.row())); err != nil {
+			return err
+		}
+		if rows++; rows%500 == 0 {
+			cw.Flush()
+		}
+		return cw.Error()
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// csvSafe quotes cells that a spreadsheet would take for a formula, as the
+// values come from webhook text. XLSX needs nothing of the kind: its cells
+// are inline strings, which are never evaluated.
+func csvSafe(cells []string) []string {
+	out := make([]string, len(cells))
+	for i, c := range cells {
+		if c != "" && strings.ContainsRune("=+-@\t\r", rune(c[0])) {
+			c = "'" + c
+		}
+		out[i] = c
+	}
+	return out
+}
+
+/*
+=============================
+ Minimal XLSX Writer
+=============================
+*/
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Alerts" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func exportXLSX(w io.Writer, tenant string, from, to time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	writeXLSXRow(sheet, exportColumns)
+	err = scanHistory(tenant, from, to, func(e JSONLog) error {
+		return writeXLSXRow(sheet, e.row())
+	})
+	if err != nil {
+		return err
+	}
+
+	io.WriteString(sheet, `</sheetData></worksheet>`)
+	return zw.Close()
+}
+
+func writeXLSXRow(w io.Writer, cells []string) error {
+	io.WriteString(w, "<row>")
+	for _, c := range cells {
+		io.WriteString(w, `<c t="inlineStr"><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(w, []byte(c)); err != nil {
+			return err
+		}
+		io.WriteString(w, "</t></is></c>")
+	}
+	_, err := io.WriteString(w, "</row>")
+	return err
+}
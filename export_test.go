@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		cell, want string
+	}{
+		{`=HYPERLINK("http://x")`, `'=HYPERLINK("http://x")`},
+		{"+1+1", "'+1+1"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"disk full", "disk full"},
+		{"a=b", "a=b"},
+		{"2026-10-15 10:00", "2026-10-15 10:00"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := csvSafe([]string{tt.cell})[0]; got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
+
+func TestXLSXRowInlineStrings(t *testing.T) {
+	var b strings.Builder
+	if err := writeXLSXRow(&b, []string{`=HYPERLINK("http://x")`, "<b>"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `<row><c t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://x&#34;)</t></is></c>` +
+		`<c t="inlineStr"><is><t xml:space="preserve">&lt;b&gt;</t></is></c></row>`
+	if b.String() != want {
+		t.Errorf("got  %s\nwant %s", b.String(), want)
+	}
+	if strings.Contains(b.String(), "<f>") {
+		t.Error("row carries a formula")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+/*
+=============================
+ Fault Injection (chaos testing)
+=============================
+*/
+
+var (
+	faultsEnabled  = flag.Bool("fault-injection", false, "enable the -fault-* hooks; never set this in production")
+	faultWriteFail = flag.Float64("fault-write-fail", 0, "probability (0..1) that a sink write fails")
+	faultLatency   = flag.Duration("fault-sink-latency", 0, "extra latency added before every sink write")
+	faultDrop      = flag.Float64("fault-drop", 0, "probability (0..1) that an alert is dropped before reaching any sink")
+	faultDecode    = flag.Float64("fault-decode-fail", 0, "probability (0..1) that a request is rejected as undecodable")
+)
+
+var (
+	errFaultWrite  = errors.New("fault injection: forced write failure")
+	errFaultDecode = errors.New("fault injection: forced decode failure")
+	errFaultDrop   = errors.New("fault injection: alert dropped")
+)
+
+func logFaultConfig() {
+	if !*faultsEnabled {
+		return
+	}
+	slog.Warn("FAULT INJECTION ENABLED",
+		"write_fail", *faultWriteFail, "sink_latency", *faultLatency, "drop", *faultDrop, "decode_fail", *faultDecode)
+}
+
+func chance(p float64) bool {
+	return p > 0 && rand.Float64() < p
+}
+
+func injectDecodeFault() error {
+	if *faultsEnabled && chance(*faultDecode) {
+		return errFaultDecode
+	}
+	return nil
+}
+
+func injectDrop() error {
+	if *faultsEnabled && chance(*faultDrop) {
+		return errFaultDrop
+	}
+	return nil
+}
+
+// injectSinkFault runs in front of every sink write.
+func injectSinkFault() error {
+	if !*faultsEnabled {
+		return nil
+	}
+	if *faultLatency > 0 {
+		time.Sleep(*faultLatency)
+	}
+	if chance(*faultWriteFail) {
+		return errFaultWrite
+	}
+	return nil
+}
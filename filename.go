@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+=============================
+ Output File Names
+=============================
+*/
+
+var logFileName = flag.String("log-file-name", `{{.Dir}}/{{.Prefix}}{{.Date "20060102"}}{{.Seq "%04d"}}.log`,
+	`template of the day file names: {{.Dir}} (log directory, tenant and route file), {{.Prefix}} (-log-prefix), {{.App}}, {{.Date "layout"}} and {{.Seq "%04d"}} (part, counting up with -rotate-size-mb and -rotate-lines); routes can set their own with file_name`)
+
+// appName is {{.App}} in file names.
+const appName = "hivemq"
+
+// fileNamer names the parts of a day file and, as the history, rollups and
+// compression have to find them again, takes such names apart.
+type fileNamer struct {
+	pattern string
+	tmpl    *template.Template
+	layout  string
+	prefix  string
+
+	// re matches the start of a base name; the rest is a suffix such as
+	// ".gz" added after rotation.
+	re      *regexp.Regexp
+	dateIdx int
+	seqIdx  int
+}
+
+// defaultFileNamer is -log-file-name; until the settings are loaded, its
+// default.
+var defaultFileNamer, _ = parseFileName(*logFileName)
+
+// fileNameData is what a file name template sees. A probe records the
+// layout and format instead of filling them in.
+type fileNameData struct {
+	Dir    string
+	Prefix string
+	App    string
+
+	date  time.Time
+	seq   int
+	probe *fileNameProbe
+}
+
+type fileNameProbe struct {
+	layouts, formats []string
+}
+
+const (
+	dirMarker  = "\x00dir\x00"
+	dateMarker = "\x00date\x00"
+	seqMarker  = "\x00seq\x00"
+)
+
+func (d fileNameData) Date(layout string) string {
+	if d.probe != nil {
+		d.probe.layouts = append(d.probe.layouts, layout)
+		return dateMarker
+	}
+	return d.date.Format(layout)
+}
+
+func (d fileNameData) Seq(format string) string {
+	if d.probe != nil {
+		d.probe.formats = append(d.probe.formats, format)
+		return seqMarker
+	}
+	return fmt.Sprintf(format, d.seq)
+}
+
+var seqFormat = regexp.MustCompile(`^%(0[1-9][0-9]?)?d$`)
+
+// parseFileName checks a file name template. The name has to be a .log
+// file directly in {{.Dir}}, with one date that tells the day apart and
+// one sequence.
+func parseFileName(pattern string) (*fileNamer, error) {
+	tmpl, err := template.New("file-name").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	probe := &fileNameProbe{}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, fileNameData{Dir: dirMarker, Prefix: logPrefix, App: appName, probe: probe}); err != nil {
+		return nil, err
+	}
+	if len(probe.layouts) != 1 || len(probe.formats) != 1 {
+		return nil, errors.New(`file name must use {{.Date "layout"}} and {{.Seq "format"}} once each`)
+	}
+	layout, format := probe.layouts[0], probe.formats[0]
+	if !seqFormat.MatchString(format) {
+		return nil, fmt.Errorf("sequence format %q must be %%d or %%0Nd", format)
+	}
+
+	// Both samples must come back as the same day and be as long as each
+	// other, so that the date can be cut out of a name and sorts by day.
+	var dateRE string
+	samples := []time.Time{time.Date(2026, 1, 1, 0, 0, 0, 0, time.Local), time.Date(2026, 12, 28, 23, 59, 59, 0, time.Local)}
+	for _, s := range samples {
+		text := s.Format(layout)
+		back, err := time.ParseInLocation(layout, text, time.Local)
+		if err != nil || back.Year() != s.Year() || back.YearDay() != s.YearDay() {
+			return nil, fmt.Errorf("date layout %q must contain the year, month and day", layout)
+		}
+		if len(text) != len(samples[0].Format(layout)) {
+			return nil, fmt.Errorf("date layout %q must have a fixed width; use zero-padded numbers", layout)
+		}
+		dateRE = charClasses(text)
+	}
+
+	name, ok := strings.CutPrefix(b.String(), dirMarker+"/")
+	if !ok || strings.ContainsAny(name, `/\`) || strings.Contains(name, dirMarker) {
+		return nil, errors.New("file name must be a file directly in {{.Dir}}")
+	}
+	if !strings.HasSuffix(name, ".log") {
+		return nil, errors.New("file name must end in .log")
+	}
+
+	n := &fileNamer{pattern: pattern, tmpl: tmpl, layout: layout, prefix: logPrefix}
+	digits := 1
+	if m := seqFormat.FindStringSubmatch(format); m[1] != "" {
+		digits, _ = strconv.Atoi(m[1][1:])
+	}
+	var re strings.Builder
+	re.WriteString("^")
+	group := 0
+	for name != "" {
+		i := strings.IndexByte(name, 0)
+		if i < 0 {
+			re.WriteString(regexp.QuoteMeta(name))
+			break
+		}
+		re.WriteString(regexp.QuoteMeta(name[:i]))
+		name = name[i:]
+		group++
+		switch {
+		case strings.HasPrefix(name, dateMarker):
+			re.WriteString("(" + dateRE + ")")
+			n.dateIdx, name = group, name[len(dateMarker):]
+		default:
+			re.WriteString(fmt.Sprintf(`(\d{%d,})`, digits))
+			n.seqIdx, name = group, name[len(seqMarker):]
+		}
+	}
+	n.re = regexp.MustCompile(re.String())
+	return n, nil
+}
+
+// loadFileName compiles -log-file-name. It runs after -log-prefix is
+// known, which the template may use.
+func loadFileName() error {
+	n, err := parseFileName(*logFileName)
+	if err != nil {
+		return fmt.Errorf("-log-file-name: %w", err)
+	}
+	defaultFileNamer = n
+	return nil
+}
+
+// path names part seq of day (YYYYMMDD) in dir.
+func (n *fileNamer) path(dir, day string, seq int) string {
+	date, _ := time.ParseInLocation("20060102", day, logLocation)
+	var b strings.Builder
+	n.tmpl.Execute(&b, fileNameData{Dir: dirMarker, Prefix: n.prefix, App: appName, date: date, seq: seq})
+	return filepath.Join(dir, strings.TrimPrefix(b.String(), dirMarker+"/"))
+}
+
+// split takes a name apart into its day (YYYYMMDD) and part number.
+// Anything after the name proper has to be a further extension.
+func (n *fileNamer) split(path string) (day string, seq int, ok bool) {
+	base := filepath.Base(path)
+	m := n.re.FindStringSubmatch(base)
+	if m == nil {
+		return "", 0, false
+	}
+	if rest := base[len(m[0]):]; rest != "" && rest[0] != '.' {
+		return "", 0, false
+	}
+	date, err := time.ParseInLocation(n.layout, m[n.dateIdx], logLocation)
+	if err != nil {
+		return "", 0, false
+	}
+	seq, err = strconv.Atoi(m[n.seqIdx])
+	if err != nil {
+		return "", 0, false
+	}
+	return date.Format("20060102"), seq, true
+}
+
+// fileNamers lists -log-file-name and the file names of the routes, so
+// that files named by any of them are found.
+func fileNamers() []*fileNamer {
+	out := []*fileNamer{defaultFileNamer}
+	seen := map[string]bool{defaultFileNamer.pattern: true}
+	var walk func(r *route)
+	walk = func(r *route) {
+		if r.namer != nil && !seen[r.namer.pattern] {
+			seen[r.namer.pattern] = true
+			out = append(out, r.namer)
+		}
+		for _, child := range r.Routes {
+			walk(child)
+		}
+	}
+	walk(currentRoute())
+	return out
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFileName(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{"default", *logFileName, false},
+		{"app and dashes", `{{.Dir}}/{{.App}}-{{.Date "2006-01-02"}}-{{.Seq "%d"}}.log`, false},
+		{"seq first", `{{.Dir}}/{{.Seq "%03d"}}_{{.Date "20060102"}}.log`, false},
+		{"no seq", `{{.Dir}}/{{.Date "20060102"}}.log`, true},
+		{"two dates", `{{.Dir}}/{{.Date "2006"}}{{.Date "20060102"}}{{.Seq "%d"}}.log`, true},
+		{"no day", `{{.Dir}}/{{.Date "200601"}}{{.Seq "%d"}}.log`, true},
+		{"unpadded day", `{{.Dir}}/{{.Date "2006-1-2"}}{{.Seq "%d"}}.log`, true},
+		{"bad seq format", `{{.Dir}}/{{.Date "20060102"}}{{.Seq "%x"}}.log`, true},
+		{"subdirectory", `{{.Dir}}/x/{{.Date "20060102"}}{{.Seq "%d"}}.log`, true},
+		{"outside dir", `/var/log/{{.Date "20060102"}}{{.Seq "%d"}}.log`, true},
+		{"not .log", `{{.Dir}}/{{.Date "20060102"}}{{.Seq "%d"}}.json`, true},
+		{"unknown field", `{{.Dir}}/{{.Host}}{{.Date "20060102"}}{{.Seq "%d"}}.log`, true},
+		{"bad template", `{{.Dir}}/{{.Date "20060102"}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := parseFileName(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseFileName(%q) error = %v, want error %v", tt.pattern, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			// Whatever a namer names, it takes apart again.
+			path := n.path("/logs", "20261015", 12)
+			day, seq, ok := n.split(path)
+			if !ok || day != "20261015" || seq != 12 {
+				t.Errorf("split(%q) = %q, %d, %v; want 20261015, 12, true", path, day, seq, ok)
+			}
+			if filepath.Dir(path) != "/logs" {
+				t.Errorf("path %q is not in /logs", path)
+			}
+		})
+	}
+}
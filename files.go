@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Output File Index
+=============================
+*/
+
+type fileInfo struct {
+	Name       string     `json:"name"`
+	Path       string     `json:"path"`
+	Size       int64      `json:"size"`
+	Entries    int        `json:"entries"`
+	FirstEntry string     `json:"first_entry,omitempty"`
+	LastEntry  string     `json:"last_entry,omitempty"`
+	Compressed bool       `json:"compressed"`
+	Encrypted  bool       `json:"encrypted"`
+	Modified   time.Time  `json:"modified"`
+	DeleteAt   *time.Time `json:"delete_at,omitempty"`
+}
+
+type fileSummary struct {
+	size    int64
+	modTime time.Time
+	entries int
+	first   string
+	last    string
+
+	firstAt, lastAt time.Time
+}
+
+// Entry counts are cached until the file's size or mtime changes.
+var (
+	fileSummaryMu    sync.Mutex
+	fileSummaryCache = make(map[string]fileSummary)
+)
+
+func filesHandler(w http.ResponseWriter, r *http.Request) {
+	files, err := listLogFiles(tenantOf(r.Context()))
+	if err != nil {
+		http.Error(w, "listing files failed", http.StatusInternalServerError)
+		return
+	}
+
+	out := make([]fileInfo, 0, len(files))
+	for _, f := range files {
+		st, err := os.Stat(f.Path)
+		if err != nil {
+			continue
+		}
+		sum := summarizeFile(f.Path, st)
+
+		info := fileInfo{
+			Name:       filepath.Base(f.Path),
+			Path:       f.Path,
+			Size:       st.Size(),
+			Entries:    sum.entries,
+			FirstEntry: sum.first,
+			LastEntry:  sum.last,
+			Compressed: f.Compressed,
+			Encrypted:  f.Encrypted,
+			Modified:   st.ModTime(),
+		}
+		if t, ok := deletionTime(f); ok {
+			info.DeleteAt = &t
+		}
+		out = append(out, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func summarizeFile(path string, st os.FileInfo) fileSummary {
+	fileSummaryMu.Lock()
+	cached, ok := fileSummaryCache[path]
+	fileSummaryMu.Unlock()
+	if ok && cached.size == st.Size() && cached.modTime.Equal(st.ModTime()) {
+		return cached
+	}
+
+	sum := fileSummary{size: st.Size(), modTime: st.ModTime()}
+	_ = readLogFile(path, func(e JSONLog) error {
+		sum.entries++
+		ts, err := entryTime(e)
+		if err != nil {
+			return nil
+		}
+		if sum.firstAt.IsZero() || ts.Before(sum.firstAt) {
+			sum.firstAt, sum.first = ts, e.Timestamp
+		}
+		if ts.After(sum.lastAt) {
+			sum.lastAt, sum.last = ts, e.Timestamp
+		}
+		return nil
+	})
+
+	fileSummaryMu.Lock()
+	fileSummaryCache[path] = sum
+	fileSummaryMu.Unlock()
+	return sum
+}
+
+/*
+=============================
+ Retention
+=============================
+*/
+
+// deletionTime is the first midnight after the file's day has aged past
+// the retention period.
+func deletionTime(f logFile) (time.Time, bool) {
+	if *retentionDays <= 0 {
+		return time.Time{}, false
+	}
+	return f.Date.AddDate(0, 0, *retentionDays+1), true
+}
+
+func runRetention(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		withSettings(func() {
+			purgeExpiredFiles(clock.Now())
+			pruneAlertDB(clock.Now())
+		})
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeExpiredFiles(now time.Time) {
+	var files []logFile
+	for _, tenant := range allTenants() {
+		fs, err := listLogFiles(tenant)
+		if err != nil {
+			return
+		}
+		files = append(files, fs...)
+	}
+
+	purged := false
+	for _, f := range files {
+		if t, ok := deletionTime(f); ok && !now.Before(t) {
+			if os.Remove(f.Path) == nil {
+				fileSummaryMu.Lock()
+				delete(fileSummaryCache, f.Path)
+				fileSummaryMu.Unlock()
+				purged = true
+			}
+		}
+	}
+
+	if purged {
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		index.prune(today.AddDate(0, 0, -*retentionDays))
+	}
+}
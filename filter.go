@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+/*
+=============================
+ Label & Annotation Filtering
+=============================
+*/
+
+var (
+	labelAllow      = flag.String("label-allow", "", "regexp of label names allowed downstream (anchored); empty allows all")
+	labelDeny       = flag.String("label-deny", "", "regexp of label names never passed downstream (anchored); wins over -label-allow")
+	annotationAllow = flag.String("annotation-allow", "", "regexp of annotation names allowed downstream (anchored); empty allows all")
+	annotationDeny  = flag.String("annotation-deny", "", "regexp of annotation names never passed downstream (anchored); wins over -annotation-allow")
+)
+
+type keyFilter struct {
+	allow, deny *regexp.Regexp
+}
+
+var labelFilter, annotationFilter keyFilter
+
+func compileKeyFilter(allow, deny string) (keyFilter, error) {
+	var f keyFilter
+	var err error
+	if allow != "" {
+		if f.allow, err = regexp.Compile("^(?:" + allow + ")$"); err != nil {
+			return f, fmt.Errorf("allowlist %q: %w", allow, err)
+		}
+	}
+	if deny != "" {
+		if f.deny, err = regexp.Compile("^(?:" + deny + ")$"); err != nil {
+			return f, fmt.Errorf("denylist %q: %w", deny, err)
+		}
+	}
+	return f, nil
+}
+
+func compileFilters() error {
+	var err error
+	if labelFilter, err = compileKeyFilter(*labelAllow, *labelDeny); err != nil {
+		return fmt.Errorf("label filter: %w", err)
+	}
+	if annotationFilter, err = compileKeyFilter(*annotationAllow, *annotationDeny); err != nil {
+		return fmt.Errorf("annotation filter: %w", err)
+	}
+	return nil
+}
+
+func (f keyFilter) keep(name string) bool {
+	if f.deny != nil && f.deny.MatchString(name) {
+		return false
+	}
+	return f.allow == nil || f.allow.MatchString(name)
+}
+
+// apply returns a filtered copy of m and the sorted names it removed.
+func (f keyFilter) apply(m map[string]string) (map[string]string, []string) {
+	if f.allow == nil && f.deny == nil {
+		return m, nil
+	}
+	out := make(map[string]string, len(m))
+	var dropped []string
+	for k, v := range m {
+		if f.keep(k) {
+			out[k] = v
+		} else {
+			dropped = append(dropped, k)
+		}
+	}
+	sort.Strings(dropped)
+	return out, dropped
+}
+
+// filterAlert strips disallowed labels and annotations before the alert
+// reaches any output. The fingerprint is pinned first so that it still
+// identifies the full label set.
+func filterAlert(a Alert) (Alert, []string, []string) {
+	a.Fingerprint = a.fingerprint()
+	var droppedLabels, droppedAnnotations []string
+	a.Labels, droppedLabels = labelFilter.apply(a.Labels)
+	a.Annotations, droppedAnnotations = annotationFilter.apply(a.Annotations)
+	return a, droppedLabels, droppedAnnotations
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Synthetic Payload Generator (gen)
+=============================
+*/
+
+type genRule struct {
+	alertname   string
+	severity    string
+	scope       string
+	summary     string
+	description string
+	value       func(r *rand.Rand) string
+}
+
+// Mirrors hivemq_rules.yml so generated traffic looks like production.
+var genRules = []genRule{
+	{"HiveMQNodeDown", "critical", "node", "HiveMQ node is down", "HiveMQ node {host} is unreachable",
+		func(*rand.Rand) string { return "0" }},
+	{"HiveMQClusterNodeCountMismatch", "critical", "cluster", "HiveMQ cluster node count mismatch", "Expected 33 nodes, but cluster reports {value}",
+		func(r *rand.Rand) string { return fmt.Sprint(28 + r.Intn(5)) }},
+	{"HiveMQHighIncomingConnects", "warning", "node", "High incoming MQTT connect rate", "High number of incoming connects on {host}",
+		func(r *rand.Rand) string { return fmt.Sprint(500 + r.Intn(4000)) }},
+	{"HiveMQJvmHeapHigh", "warning", "node", "HiveMQ JVM heap usage high", "JVM heap usage > 75% on {host}",
+		func(r *rand.Rand) string { return fmt.Sprint(75 + r.Intn(10)) }},
+	{"HiveMQJvmHeapCritical", "critical", "node", "HiveMQ JVM heap critically high", "JVM heap usage > 85% on {host}",
+		func(r *rand.Rand) string { return fmt.Sprint(85 + r.Intn(15)) }},
+}
+
+type genAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+type genPayload struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Alerts            []genAlert        `json:"alerts"`
+}
+
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	count := fs.Int("count", 5, "alerts per payload")
+	payloads := fs.Int("payloads", 1, "number of payloads to generate")
+	severities := fs.String("severities", "critical,warning", "comma-separated severities to draw from")
+	resolved := fs.Float64("resolved", 0.2, "fraction of alerts generated as resolved (0..1)")
+	nodes := fs.Int("nodes", 3, "number of distinct broker nodes")
+	cluster := fs.String("cluster", "hivemq-prod", "cluster label value")
+	extra := fs.String("labels", "", "extra labels added to every alert (k=v,k=v)")
+	target := fs.String("target", "", "POST payloads to this URL instead of printing them")
+	interval := fs.Duration("interval", 0, "pause between payloads when posting")
+	seed := fs.Int64("seed", clock.Now().UnixNano(), "random seed for reproducible output")
+	fs.Parse(args)
+
+	allowed := map[string]bool{}
+	for _, s := range strings.Split(*severities, ",") {
+		allowed[strings.TrimSpace(s)] = true
+	}
+	var rules []genRule
+	for _, rule := range genRules {
+		if allowed[rule.severity] {
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return fmt.Errorf("no HiveMQ rules match severities %q", *severities)
+	}
+
+	extraLabels, err := parseLabelList(*extra)
+	if err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	for i := 0; i < *payloads; i++ {
+		p := generatePayload(rng, rules, *count, *nodes, *resolved, *cluster, extraLabels, clock.Now())
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		enc.Encode(p)
+
+		if *target == "" {
+			os.Stdout.Write(body.Bytes())
+			continue
+		}
+
+		resp, err := http.Post(*target, "application/json", &body)
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		fmt.Fprintf(os.Stderr, "payload %d: %d alerts -> %s\n", i+1, len(p.Alerts), resp.Status)
+
+		if *interval > 0 && i < *payloads-1 {
+			time.Sleep(*interval)
+		}
+	}
+	return nil
+}
+
+func generatePayload(rng *rand.Rand, rules []genRule, count, nodes int, resolvedRatio float64, cluster string, extra map[string]string, now time.Time) genPayload {
+	p := genPayload{
+		Version:           "4",
+		Status:            "firing",
+		Receiver:          "hivemq-email-and-log",
+		GroupLabels:       map[string]string{"cluster": cluster},
+		CommonLabels:      map[string]string{"cluster": cluster, "job": "hivemq"},
+		CommonAnnotations: map[string]string{},
+		ExternalURL:       "http://alertmanager:9093",
+	}
+	p.GroupKey = `{}:{cluster="` + cluster + `"}`
+
+	allResolved := true
+	for i := 0; i < count; i++ {
+		rule := rules[rng.Intn(len(rules))]
+		node := rng.Intn(max(nodes, 1)) + 1
+		host := fmt.Sprintf("hivemq-node-%02d", node)
+
+		labels := map[string]string{
+			"alertname": rule.alertname,
+			"severity":  rule.severity,
+			"scope":     rule.scope,
+			"cluster":   cluster,
+			"job":       "hivemq",
+		}
+		if rule.scope == "cluster" {
+			labels["hostname"] = "hivemq-cluster"
+		} else {
+			labels["hostname"] = host
+			labels["instance"] = fmt.Sprintf("10.20.0.%d:9399", 10+node)
+		}
+		for k, v := range extra {
+			labels[k] = v
+		}
+
+		value := rule.value(rng)
+		a := genAlert{
+			Status: "firing",
+			Labels: labels,
+			Annotations: map[string]string{
+				"summary":       rule.summary,
+				"description":   strings.NewReplacer("{host}", labels["hostname"], "{value}", value).Replace(rule.description),
+				"current_value": value,
+			},
+			StartsAt:     now.Add(-time.Duration(rng.Intn(3600)) * time.Second).UTC().Truncate(time.Second),
+			GeneratorURL: "http://prometheus:9090/graph?g0.expr=" + rule.alertname,
+		}
+		if rng.Float64() < resolvedRatio {
+			a.Status = "resolved"
+			a.EndsAt = now.UTC().Truncate(time.Second)
+		} else {
+			allResolved = false
+		}
+		a.Fingerprint = Alert{Labels: labels}.fingerprint()
+		p.Alerts = append(p.Alerts, a)
+	}
+	if allResolved && count > 0 {
+		p.Status = "resolved"
+	}
+	return p
+}
+
+func parseLabelList(s string) (map[string]string, error) {
+	out := map[string]string{}
+	if s == "" {
+		return out, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid label %q, want key=value", kv)
+		}
+		out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return out, nil
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Golden-File Test Harness (test)
+=============================
+*/
+
+// goldenRenderer turns one payload fixture into the bytes compared against
+// <fixture>.<suffix>.golden.
+type goldenRenderer struct {
+	suffix string
+	render func(payload AlertmanagerPayload, now time.Time) ([]byte, error)
+}
+
+var goldenRenderers = []goldenRenderer{
+	{"log", renderLogGolden},
+	{"email.html", renderEmailGolden},
+}
+
+func runGoldenTest(args []string) error {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	dir := fs.String("fixtures", "testdata/fixtures", "directory of *.json Alertmanager payload fixtures")
+	update := fs.Bool("update", false, "rewrite golden files from the current output")
+	at := fs.String("now", "2024-01-01T00:00:00Z", "fixed receive time used for rendering (RFC3339)")
+	fs.Parse(args)
+
+	now, err := time.Parse(time.RFC3339, *at)
+	if err != nil {
+		return fmt.Errorf("invalid -now: %w", err)
+	}
+	now = now.In(logLocation)
+	clock = fixedClock(now)
+	if err := loadEmailLocale(); err != nil {
+		return err
+	}
+	if err := loadEmailTemplates(); err != nil {
+		return err
+	}
+
+	fixtures, err := filepath.Glob(filepath.Join(*dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(fixtures) == 0 {
+		return fmt.Errorf("no fixtures found in %s", *dir)
+	}
+
+	failed := 0
+	for _, fixture := range fixtures {
+		raw, err := os.ReadFile(fixture)
+		if err != nil {
+			return err
+		}
+		var payload AlertmanagerPayload
+		if err := json.Unmarshal(raw, &payload); err != nil {
+			fmt.Printf("FAIL %s: decode: %v\n", fixture, err)
+			failed++
+			continue
+		}
+
+		base := strings.TrimSuffix(fixture, ".json")
+		for _, r := range goldenRenderers {
+			golden := base + "." + r.suffix + ".golden"
+			got, err := r.render(payload, now)
+			if err != nil {
+				fmt.Printf("FAIL %s [%s]: %v\n", fixture, r.suffix, err)
+				failed++
+				continue
+			}
+
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					return err
+				}
+				fmt.Printf("updated %s\n", golden)
+				continue
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				fmt.Printf("FAIL %s [%s]: %v (run with -update to create it)\n", fixture, r.suffix, err)
+				failed++
+				continue
+			}
+			if !bytes.Equal(got, want) {
+				fmt.Printf("FAIL %s [%s]:\n%s", fixture, r.suffix, lineDiff(string(want), string(got)))
+				failed++
+				continue
+			}
+			fmt.Printf("ok   %s [%s]\n", fixture, r.suffix)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d golden comparison(s) failed", failed)
+	}
+	return nil
+}
+
+func renderLogGolden(payload AlertmanagerPayload, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	for _, alert := range payload.Alerts {
+		if err := enc.Encode(buildEntry(context.Background(), alert, now)); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func renderEmailGolden(payload AlertmanagerPayload, now time.Time) ([]byte, error) {
+	alerts := make([]Alert, len(payload.Alerts))
+	for i, a := range payload.Alerts {
+		a.Fingerprint = a.fingerprint()
+		alerts[i] = a
+	}
+	msg, err := renderAlertEmail(payload.group(), alerts)
+	if err != nil {
+		return nil, err
+	}
+	return []byte("Subject: " + msg.Subject + "\n\n" + msg.HTML), nil
+}
+
+// lineDiff renders a minimal "-want/+got" diff using the longest common
+// subsequence of lines. Fixtures are small, so the quadratic table is fine.
+func lineDiff(want, got string) string {
+	a := strings.Split(want, "\n")
+	b := strings.Split(got, "\n")
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			out.WriteString("    " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out.WriteString("  - " + a[i] + "\n")
+			i++
+		default:
+			out.WriteString("  + " + b[j] + "\n")
+			j++
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Grafana Webhook Intake
+=============================
+*/
+
+const grafanaPath = "/grafana"
+
+var grafanaValueRef = flag.String("grafana-value-ref", "B", "ref ID of the Grafana rule query whose value becomes the current_value annotation (B is the reduce step of Grafana's default rule); an alert with a single value uses that one")
+
+// grafanaPayload is Grafana unified alerting's webhook body: Alertmanager's
+// layout plus the org, a rendered title and message, and per alert the
+// query values and links into Grafana.
+type grafanaPayload struct {
+	Receiver          string            `json:"receiver"`
+	Status            string            `json:"status"`
+	OrgID             json.Number       `json:"orgId"`
+	Alerts            []grafanaAlert    `json:"alerts"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	ExternalURL       string            `json:"externalURL"`
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Title             string            `json:"title"`
+	State             string            `json:"state"`
+	Message           string            `json:"message"`
+}
+
+type grafanaAlert struct {
+	Status       string                 `json:"status"`
+	Labels       map[string]string      `json:"labels"`
+	Annotations  map[string]string      `json:"annotations"`
+	StartsAt     time.Time              `json:"startsAt"`
+	EndsAt       time.Time              `json:"endsAt"`
+	GeneratorURL string                 `json:"generatorURL"`
+	Fingerprint  string                 `json:"fingerprint"`
+	SilenceURL   string                 `json:"silenceURL"`
+	DashboardURL string                 `json:"dashboardURL"`
+	PanelURL     string                 `json:"panelURL"`
+	ImageURL     string                 `json:"imageURL"`
+	Values       map[string]json.Number `json:"values"`
+	ValueString  string                 `json:"valueString"`
+}
+
+// grafanaReserved renames the double-underscore labels and annotations
+// Grafana uses internally; others of that form are dropped.
+var grafanaReserved = map[string]string{
+	"__alert_rule_uid__": "rule_uid",
+	"__dashboardUid__":   "dashboard_uid",
+	"__panelId__":        "panel_id",
+	"__orgId__":          "org_id",
+	"__value_string__":   "value_string",
+}
+
+type intakeKey struct{}
+
+// grafanaHandler takes Grafana webhooks on /grafana and /grafana/{tenant}
+// with the authentication, limits and queue of /alerts.
+func grafanaHandler(w http.ResponseWriter, r *http.Request) {
+	alertHandler(w, r.WithContext(context.WithValue(r.Context(), intakeKey{}, "grafana")))
+}
+
+// decodeIntake decodes a body in the format of the endpoint it came in on.
+func decodeIntake(ctx context.Context, body []byte) (AlertmanagerPayload, []string, error) {
+	if ctx.Value(intakeKey{}) == "grafana" {
+		return decodeGrafana(body)
+	}
+	return decodePayload(bytes.NewReader(body))
+}
+
+// decodeGrafana reads a Grafana body into the Alertmanager model. Apart
+// from strict mode, which checks Grafana's own layout, the alerts are
+// decoded as Alertmanager's and Grafana's fields added on top.
+func decodeGrafana(body []byte) (AlertmanagerPayload, []string, error) {
+	var g grafanaPayload
+	if *decodeMode == "strict" {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&g); err != nil {
+			return AlertmanagerPayload{}, nil, err
+		}
+		if err := checkGrafana(g); err != nil {
+			return AlertmanagerPayload{}, nil, err
+		}
+		p := AlertmanagerPayload{
+			Version:           g.Version,
+			GroupKey:          g.GroupKey,
+			Status:            g.Status,
+			Receiver:          g.Receiver,
+			GroupLabels:       g.GroupLabels,
+			CommonLabels:      g.CommonLabels,
+			CommonAnnotations: g.CommonAnnotations,
+			ExternalURL:       g.ExternalURL,
+		}
+		for _, a := range g.Alerts {
+			p.Alerts = append(p.Alerts, grafanaToAlert(Alert{
+				Status:       a.Status,
+				StartsAt:     a.StartsAt,
+				EndsAt:       a.EndsAt,
+				Labels:       a.Labels,
+				Annotations:  a.Annotations,
+				GeneratorURL: a.GeneratorURL,
+				Fingerprint:  a.Fingerprint,
+			}, a))
+		}
+		return p, nil, nil
+	}
+
+	p, warnings, err := decodePayload(bytes.NewReader(body))
+	if err != nil {
+		return p, warnings, err
+	}
+	if err := json.Unmarshal(body, &g); err != nil {
+		warnings = append(warnings, fmt.Sprintf("grafana fields: %v", err))
+	}
+	byFingerprint := make(map[string]grafanaAlert, len(g.Alerts))
+	for _, a := range g.Alerts {
+		byFingerprint[a.Fingerprint] = a
+	}
+	for i, a := range p.Alerts {
+		// Lenient mode skips unusable items, so the lists only line up
+		// when they are as long.
+		extra, ok := byFingerprint[a.Fingerprint]
+		if len(g.Alerts) == len(p.Alerts) {
+			extra, ok = g.Alerts[i], true
+		}
+		if !ok {
+			extra = grafanaAlert{}
+		}
+		p.Alerts[i] = grafanaToAlert(a, extra)
+	}
+	p.GroupLabels = grafanaLabels(p.GroupLabels)
+	p.CommonLabels = grafanaLabels(p.CommonLabels)
+	return p, warnings, nil
+}
+
+func checkGrafana(g grafanaPayload) error {
+	if g.Version != "1" {
+		return fmt.Errorf("unsupported Grafana webhook version %q", g.Version)
+	}
+	if g.Status != "firing" && g.Status != "resolved" {
+		return fmt.Errorf("invalid group status %q", g.Status)
+	}
+	if len(g.Alerts) == 0 {
+		return errors.New("payload contains no alerts")
+	}
+	for i, a := range g.Alerts {
+		if a.Status != "firing" && a.Status != "resolved" {
+			return fmt.Errorf("alerts[%d]: invalid status %q", i, a.Status)
+		}
+		if a.Labels["alertname"] == "" {
+			return fmt.Errorf("alerts[%d]: missing alertname label", i)
+		}
+		if a.StartsAt.IsZero() {
+			return fmt.Errorf("alerts[%d]: missing startsAt", i)
+		}
+	}
+	return nil
+}
+
+// grafanaToAlert moves Grafana's reserved labels to annotations under
+// plain names and adds its links and the rule's value as annotations,
+// leaving those the alert already carries alone. The alert is labelled
+// source=grafana so routes can tell it from Alertmanager's.
+func grafanaToAlert(a Alert, g grafanaAlert) Alert {
+	annotations := make(map[string]string, len(a.Annotations)+6)
+	set := func(k, v string) {
+		if _, ok := annotations[k]; !ok && v != "" {
+			annotations[k] = v
+		}
+	}
+	for k, v := range a.Annotations {
+		if strings.HasPrefix(k, "__") {
+			if name := grafanaReserved[k]; name != "" {
+				annotations[name] = v
+			}
+			continue
+		}
+		annotations[k] = v
+	}
+	labels := grafanaLabels(a.Labels)
+	for k, v := range a.Labels {
+		if name := grafanaReserved[k]; name != "" {
+			set(name, v)
+		}
+	}
+	if _, ok := labels["source"]; !ok {
+		labels["source"] = "grafana"
+	}
+
+	set("dashboard_url", g.DashboardURL)
+	set("panel_url", g.PanelURL)
+	set("silence_url", g.SilenceURL)
+	set("image_url", g.ImageURL)
+	set("value_string", g.ValueString)
+	if v, ok := g.Values[*grafanaValueRef]; ok {
+		set("current_value", v.String())
+	} else if len(g.Values) == 1 {
+		for _, v := range g.Values {
+			set("current_value", v.String())
+		}
+	}
+	a.Labels, a.Annotations = labels, annotations
+	return a
+}
+
+// grafanaLabels drops Grafana's reserved labels.
+func grafanaLabels(labels map[string]string) map[string]string {
+	out := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		if !strings.HasPrefix(k, "__") {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Heartbeat Watchdog
+=============================
+*/
+
+var (
+	heartbeatTimeout   = flag.Duration("heartbeat-timeout", 0, "raise an alert of the bridge itself when no alert came in for this long, e.g. 15m with Alertmanager's Watchdog repeating every 5m; 0 disables it")
+	heartbeatAlertname = flag.String("heartbeat-alertname", "", "only alerts of this name count as a heartbeat, e.g. Watchdog; empty counts every alert")
+	heartbeatAction    = flag.String("heartbeat-action", "record", "what a silent pipeline does: record (a synthetic AlertPipelineSilent alert through the routes and sinks, resolved when alerts come back), email (a warning to -heartbeat-email-to) or both")
+	heartbeatEmailTo   = flag.String("heartbeat-email-to", "", "recipients of heartbeat warnings; empty means -email-to")
+)
+
+// heartbeatAlertName names the synthetic alert.
+const heartbeatAlertName = "AlertPipelineSilent"
+
+var heartbeatAge = newGaugeFunc("heartbeat_age_seconds", "Seconds since the last alert counting as a heartbeat, by tenant.", "tenant", heartbeat.ages)
+
+func validHeartbeat() error {
+	if *heartbeatTimeout < 0 {
+		return fmt.Errorf("invalid -heartbeat-timeout %s", *heartbeatTimeout)
+	}
+	switch *heartbeatAction {
+	case "record", "email", "both":
+	default:
+		return fmt.Errorf("invalid -heartbeat-action %q, want record, email or both", *heartbeatAction)
+	}
+	if *heartbeatTimeout > 0 && *heartbeatAction != "record" && !emailEnabled() {
+		return fmt.Errorf("-heartbeat-action %s needs -smtp-host", *heartbeatAction)
+	}
+	return nil
+}
+
+// heartbeatWatch keeps the last heartbeat of each tenant and which tenants
+// are reported silent.
+type heartbeatWatch struct {
+	mu     sync.Mutex
+	last   map[string]time.Time
+	silent map[string]time.Time // tenant -> when it was reported
+}
+
+var heartbeat = &heartbeatWatch{last: map[string]time.Time{}, silent: map[string]time.Time{}}
+
+type heartbeatKey struct{}
+
+// observe notes an incoming alert. The bridge's own alerts do not count.
+func (h *heartbeatWatch) observe(ctx context.Context, tenant string, a Alert) {
+	if ctx.Value(heartbeatKey{}) != nil {
+		return
+	}
+	if *heartbeatAlertname != "" && a.Labels["alertname"] != *heartbeatAlertname {
+		return
+	}
+	h.mu.Lock()
+	h.last[tenant] = clock.Now()
+	h.mu.Unlock()
+}
+
+func (h *heartbeatWatch) ages() map[string]float64 {
+	if *heartbeatTimeout <= 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := clock.Now()
+	out := make(map[string]float64, len(h.last))
+	for tenant, at := range h.last {
+		out[safeValue(tenant, "default")] = now.Sub(at).Seconds()
+	}
+	return out
+}
+
+// runHeartbeat checks the tenants for silence. Every tenant starts out as
+// just heard from, so a bridge that never gets an alert is reported too.
+// The checks, and the alerts they raise, run with the settings held.
+func runHeartbeat(done <-chan struct{}) {
+	withSettings(func() {
+		started := clock.Now()
+		heartbeat.mu.Lock()
+		for _, tenant := range allTenants() {
+			if _, ok := heartbeat.last[tenant]; !ok {
+				heartbeat.last[tenant] = started
+			}
+		}
+		heartbeat.mu.Unlock()
+	})
+
+	for {
+		every := 30 * time.Second
+		withSettings(func() {
+			if t := *heartbeatTimeout; t > 0 {
+				every = min(every, max(t/10, time.Second))
+			}
+		})
+		timer := time.NewTimer(every)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		withSettings(func() {
+			if *heartbeatTimeout > 0 {
+				heartbeat.check(clock.Now())
+			}
+		})
+	}
+}
+
+// check reports tenants that went silent and clears those heard from again.
+func (h *heartbeatWatch) check(now time.Time) {
+	type change struct {
+		tenant     string
+		last, went time.Time
+		silent     bool
+	}
+	var changes []change
+	h.mu.Lock()
+	for tenant, last := range h.last {
+		went, reported := h.silent[tenant]
+		switch {
+		case !reported && now.Sub(last) >= *heartbeatTimeout:
+			h.silent[tenant] = now
+			changes = append(changes, change{tenant, last, now, true})
+		case reported && last.After(went):
+			delete(h.silent, tenant)
+			changes = append(changes, change{tenant, last, went, false})
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range changes {
+		if c.silent {
+			slog.Warn("no heartbeat", "tenant", c.tenant, "last", c.last, "timeout", *heartbeatTimeout)
+		} else {
+			slog.Info("heartbeat back", "tenant", c.tenant, "at", c.last)
+		}
+		if *heartbeatAction != "email" {
+			emitHeartbeatAlert(c.tenant, c.last, c.went, c.silent)
+		}
+		if *heartbeatAction != "record" {
+			if err := emailHeartbeat(c.tenant, c.last, c.silent); err != nil {
+				slog.Error("emailing heartbeat warning", "tenant", c.tenant, "err", err)
+			}
+		}
+	}
+}
+
+// heartbeatSubject says what stopped arriving.
+func heartbeatSubject(tenant string) string {
+	what := "No alerts"
+	if *heartbeatAlertname != "" {
+		what = "No " + *heartbeatAlertname + " alert"
+	}
+	if tenant != "" {
+		what += " from tenant " + tenant
+	}
+	return fmt.Sprintf("%s received for %s", what, *heartbeatTimeout)
+}
+
+// emitHeartbeatAlert sends the synthetic alert through the pipeline, like
+// one from Alertmanager; it resolves once alerts arrive again.
+func emitHeartbeatAlert(tenant string, last, went time.Time, silent bool) {
+	host, _ := os.Hostname()
+	a := Alert{
+		Status:   "firing",
+		StartsAt: went,
+		Labels: map[string]string{
+			"alertname": heartbeatAlertName,
+			"severity":  "critical",
+			"hostname":  host,
+			"source":    "alertbridge",
+		},
+		Annotations: map[string]string{
+			"summary":     heartbeatSubject(tenant),
+			"description": fmt.Sprintf("Last heartbeat at %s; the link from Alertmanager to the bridge may be broken.", last.Format(time.RFC3339)),
+		},
+	}
+	if tenant != "" {
+		a.Labels["tenant"] = tenant
+	}
+	if !silent {
+		a.Status, a.EndsAt = "resolved", last
+		a.Annotations["summary"] = "Alerts are arriving again"
+	}
+	a.Fingerprint = a.fingerprint()
+
+	ctx := context.WithValue(context.Background(), heartbeatKey{}, true)
+	ctx = context.WithValue(withTenantValue(ctx, tenant), requestIDKey{}, newRequestID())
+	ctx = withAlertGroup(ctx, alertGroup{Receiver: "alertbridge", GroupLabels: map[string]string{"alertname": heartbeatAlertName}})
+	processAlerts(ctx, tenant, []Alert{a})
+}
+
+func emailHeartbeat(tenant string, last time.Time, silent bool) error {
+	subject := "[alertbridge] " + heartbeatSubject(tenant)
+	text := fmt.Sprintf("%s.\n\nThe last heartbeat came in at %s. Check that Alertmanager is running and can reach the bridge.\n", heartbeatSubject(tenant), last.Format(time.RFC3339))
+	importance := "high"
+	if !silent {
+		subject = "[alertbridge] Alerts are arriving again"
+		text = fmt.Sprintf("A heartbeat came in at %s.\n", last.Format(time.RFC3339))
+		importance = "normal"
+	}
+	return sendEmail(emailMessage{Subject: subject, Text: text, To: *heartbeatEmailTo, Importance: importance})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Log History Reader
+=============================
+*/
+
+type logFile struct {
+	Path       string
+	Date       time.Time
+	Seq        int
+	Compressed bool
+	Encrypted  bool
+}
+
+var logFileSuffixes = []string{".log", ".log.gz", ".log" + encSuffixAES, ".log" + encSuffixAge}
+
+// listLogFiles returns a tenant's day-wise output files, oldest first. Files
+// compressed after rotation (".log.gz", ours or an external logrotate's) or
+// encrypted after rollover are included. Files named by an earlier
+// -log-file-name are not.
+func listLogFiles(tenant string) ([]logFile, error) {
+	matches, err := filepath.Glob(filepath.Join(tenantDir(tenant), "*.log*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+
+	files := make([]logFile, 0, len(matches))
+	for _, path := range matches {
+		if !slices.ContainsFunc(logFileSuffixes, func(s string) bool { return strings.HasSuffix(path, s) }) {
+			continue
+		}
+		day, seq, _, ok := splitPart(path)
+		if !ok {
+			continue
+		}
+		date, err := time.ParseInLocation("20060102", day, logLocation)
+		if err != nil {
+			continue
+		}
+		files = append(files, logFile{
+			Path:       path,
+			Date:       date,
+			Seq:        seq,
+			Compressed: strings.HasSuffix(path, ".gz"),
+			Encrypted:  strings.HasSuffix(path, encSuffixAES) || strings.HasSuffix(path, encSuffixAge),
+		})
+	}
+	// A file name need not start with the date, so sort by it.
+	sort.SliceStable(files, func(i, j int) bool {
+		if !files[i].Date.Equal(files[j].Date) {
+			return files[i].Date.Before(files[j].Date)
+		}
+		return files[i].Seq < files[j].Seq
+	})
+	return files, nil
+}
+
+// scanHistory calls fn for every entry of the tenant with from <= ts < to.
+// A zero bound is open-ended.
+func scanHistory(tenant string, from, to time.Time, fn func(JSONLog) error) error {
+	files, err := listLogFiles(tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if !from.IsZero() && !f.Date.AddDate(0, 0, 1).After(from) {
+			continue
+		}
+		if !to.IsZero() && !f.Date.Before(to) {
+			continue
+		}
+
+		err := readLogFile(f.Path, func(e JSONLog) error {
+			if !inRange(e, from, to) {
+				return nil
+			}
+			e.Tenant = tenant
+			return fn(e)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readLogFile(path string, fn func(JSONLog) error) error {
+	return readLogLines(path, func(line []byte) error {
+		var e JSONLog
+		if err := json.Unmarshal(line, &e); err != nil || e.Timestamp == "" {
+			return nil // skip torn or foreign lines, and rollups
+		}
+		return fn(e)
+	})
+}
+
+// readLogLines calls fn with every raw (decrypted) line of an output file.
+// The slice is only valid during the call. Encrypted content without a
+// key to read it is skipped.
+func readLogLines(path string, fn func([]byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	r, err = decryptingReader(path, r)
+	if errors.Is(err, errUnreadable) {
+		warnUnreadable(path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if bytes.HasPrefix(line, []byte(encLinePrefix)) {
+			plain, err := decryptLine(line)
+			if errors.Is(err, errUnreadable) {
+				warnUnreadable(path)
+			}
+			if err != nil {
+				continue
+			}
+			line = plain
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func entryTime(e JSONLog) (time.Time, error) {
+	t, err := time.ParseInLocation(tsLayout, e.Timestamp, logLocation)
+	if err != nil && tsLayout != defaultTSLayout {
+		return time.ParseInLocation(defaultTSLayout, e.Timestamp, logLocation)
+	}
+	return t, err
+}
+
+// sortNewestFirst orders entries by their parsed ts, newest first. Entries
+// whose ts does not parse go last, in their original order.
+func sortNewestFirst(entries []JSONLog) {
+	type timed struct {
+		ts time.Time
+		e  JSONLog
+	}
+	tmp := make([]timed, len(entries))
+	for i, e := range entries {
+		ts, _ := entryTime(e)
+		tmp[i] = timed{ts, e}
+	}
+	sort.SliceStable(tmp, func(i, j int) bool { return tmp[i].ts.After(tmp[j].ts) })
+	for i := range tmp {
+		entries[i] = tmp[i].e
+	}
+}
+
+func inRange(e JSONLog, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	ts, err := entryTime(e)
+	if err != nil {
+		return false
+	}
+	return (from.IsZero() || !ts.Before(from)) && (to.IsZero() || ts.Before(to))
+}
+
+// parseTimeParam accepts RFC3339, -ts-layout, "2006-01-02 15:04" or a bare
+// date, the latter in -timezone. With endOfDay set, a bare date is moved to
+// the following midnight so that it can be used as an exclusive upper
+// bound.
+func parseTimeParam(v string, endOfDay bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	for _, layout := range []string{tsLayout, defaultTSLayout} {
+		if t, err := time.ParseInLocation(layout, v, logLocation); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, logLocation); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", v)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Across the autumn change, the later entry has the smaller offset and
+// sorts first as a string.
+func TestSortNewestFirstAcrossDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer func(layout string, loc *time.Location) { tsLayout, logLocation = layout, loc }(tsLayout, logLocation)
+	tsLayout, logLocation = time.RFC3339, berlin
+
+	entries := []JSONLog{
+		{Timestamp: "2026-10-25T02:30:00+02:00", KPI: "earlier"},
+		{Timestamp: "garbage", KPI: "unparsed"},
+		{Timestamp: "2026-10-25T02:10:00+01:00", KPI: "later"},
+	}
+	sortNewestFirst(entries)
+
+	want := []string{"later", "earlier", "unparsed"}
+	for i, e := range entries {
+		if e.KPI != want[i] {
+			t.Fatalf("order %d: got %s, want %s", i, e.KPI, want[i])
+		}
+	}
+}
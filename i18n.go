@@ -0,0 +1,471 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Email Localization
+=============================
+*/
+
+var (
+	emailLocaleTag = flag.String("email-locale", "en", "language of alert emails, a tag like de or ja-JP with a message catalog; other than English, the built-in templates are the translated hivemq-i18n.tmpl; routes set their own with email_locale")
+	emailCatalog   = flag.String("email-catalog", "", "glob of go-i18n style message files, one per language and named like active.de.yaml, adding to or overriding the built-in catalogs (de, en, fr, ja)")
+	emailTimezone  = flag.String("email-timezone", "", "IANA time zone the dates of emails are shown in by localDate; routes set their own with email_timezone; empty keeps each time's own zone")
+)
+
+// defaultDateLayout is localDate's layout for a catalog without
+// layout.datetime.
+const defaultDateLayout = "2006-01-02 15:04:05 MST"
+
+var localeTag = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// catalogMessage is one message of a catalog with its plural forms; plain
+// text is the form "other".
+type catalogMessage struct {
+	forms map[string]*texttemplate.Template
+}
+
+// messageCatalog holds the messages of each language by ID.
+type messageCatalog map[string]map[string]*catalogMessage
+
+// Set from -email-catalog, -email-locale and -email-timezone by
+// loadEmailLocale.
+var (
+	catalog       messageCatalog
+	defaultLocale *emailLocale
+)
+
+// loadEmailLocale reads the built-in catalogs and -email-catalog and checks
+// -email-locale against them. It runs before the templates are parsed.
+func loadEmailLocale() error {
+	cat := messageCatalog{}
+	builtin, err := fs.Glob(exampleFS, "locales/*.yaml")
+	if err != nil {
+		return err
+	}
+	for _, name := range builtin {
+		data, err := fs.ReadFile(exampleFS, name)
+		if err != nil {
+			return err
+		}
+		if err := cat.add(name, data); err != nil {
+			return err
+		}
+	}
+	if *emailCatalog != "" {
+		files, err := filepath.Glob(*emailCatalog)
+		if err != nil {
+			return err
+		}
+		if len(files) == 0 {
+			return fmt.Errorf("-email-catalog %q matches no files", *emailCatalog)
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			if err := cat.add(file, data); err != nil {
+				return err
+			}
+		}
+	}
+	l, err := cat.locale(*emailLocaleTag, *emailTimezone)
+	if err != nil {
+		return fmt.Errorf("-email-locale: %w", err)
+	}
+	catalog, defaultLocale = cat, l
+	return nil
+}
+
+// add reads a message file. Its language is in the name, as go-i18n has it:
+// active.de.yaml, de.yml or pt-br.json. Messages are text or a map of
+// plural forms (zero, one, two, few, many, other); other maps nest, their
+// keys joined with dots.
+func (c messageCatalog) add(path string, data []byte) error {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	lang := strings.ToLower(name[strings.LastIndex(name, ".")+1:])
+	if !localeTag.MatchString(lang) {
+		return fmt.Errorf("%s: no language in the file name, want one like active.de.yaml", path)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	if doc.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: want a map of message IDs", path)
+	}
+	if c[lang] == nil {
+		c[lang] = map[string]*catalogMessage{}
+	}
+	if err := addMessages(c[lang], "", doc.Content[0]); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// messageFields are the keys of a message's map. A map with other keys, or
+// that nests, is a group of messages, so a group may hold one named
+// description.
+var messageFields = []string{"id", "description", "hash", "zero", "one", "two", "few", "many", "other"}
+
+func addMessages(msgs map[string]*catalogMessage, prefix string, n *yaml.Node) error {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		id, v := prefix+n.Content[i].Value, n.Content[i+1]
+		forms := map[string]string{}
+		switch {
+		case v.Kind == yaml.ScalarNode:
+			forms["other"] = v.Value
+		case v.Kind == yaml.MappingNode && isMessage(v):
+			if err := v.Decode(&forms); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		case v.Kind == yaml.MappingNode:
+			if err := addMessages(msgs, id+".", v); err != nil {
+				return err
+			}
+			continue
+		default:
+			return fmt.Errorf("%s: want text or plural forms", id)
+		}
+		m := &catalogMessage{forms: map[string]*texttemplate.Template{}}
+		for form, text := range forms {
+			if form == "id" || form == "description" || form == "hash" {
+				continue
+			}
+			t, err := texttemplate.New(id).Option("missingkey=zero").Parse(text)
+			if err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+			m.forms[form] = t
+		}
+		msgs[id] = m
+	}
+	return nil
+}
+
+func isMessage(n *yaml.Node) bool {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if !slices.Contains(messageFields, n.Content[i].Value) || n.Content[i+1].Kind != yaml.ScalarNode {
+			return false
+		}
+	}
+	return true
+}
+
+// emailLocale is the language and time zone an email is written in.
+type emailLocale struct {
+	tag  string
+	tags []string // catalog languages tried in turn: de-at, de, en
+	zone *time.Location
+	cat  messageCatalog
+}
+
+// locale checks a language tag and time zone against the catalog. The tag
+// or its language must have a catalog; messages missing from it come from
+// English.
+func (c messageCatalog) locale(tag, zone string) (*emailLocale, error) {
+	tag = strings.ToLower(strings.ReplaceAll(tag, "_", "-"))
+	if !localeTag.MatchString(tag) {
+		return nil, fmt.Errorf("invalid locale %q, want a language tag like de or ja-JP", tag)
+	}
+	l := &emailLocale{tag: tag, cat: c}
+	for _, t := range []string{tag, strings.SplitN(tag, "-", 2)[0], "en"} {
+		if _, ok := c[t]; ok && !slices.Contains(l.tags, t) {
+			l.tags = append(l.tags, t)
+		}
+	}
+	if len(l.tags) == 0 || l.tags[0] == "en" && !strings.HasPrefix(tag, "en") {
+		return nil, fmt.Errorf("no message catalog for %q (have %s)", tag, strings.Join(c.languages(), ", "))
+	}
+	if zone != "" {
+		loc, err := time.LoadLocation(zone)
+		if err != nil {
+			return nil, fmt.Errorf("time zone %q: %w", zone, err)
+		}
+		l.zone = loc
+	}
+	return l, nil
+}
+
+func (c messageCatalog) languages() []string {
+	var out []string
+	for lang := range c {
+		out = append(out, lang)
+	}
+	slices.Sort(out)
+	return out
+}
+
+// key tells apart the locales a template set is parsed for.
+func (l *emailLocale) key() string {
+	if l.zone == nil {
+		return l.tag
+	}
+	return l.tag + "@" + l.zone.String()
+}
+
+// english reports whether the built-in English templates are used as they
+// are, so that emails stay as they were before there were catalogs.
+func (l *emailLocale) english() bool {
+	return l == nil || l.tag == "en" || strings.HasPrefix(l.tag, "en-")
+}
+
+// funcs are the template functions that speak the locale:
+//
+//	{{ T "label.severity" }}                   a message
+//	{{ T "period" "From" $a "Until" $b }}      a message with its {{ .From }} and {{ .Until }}
+//	{{ Tn "alerts.count" (len .Alerts) }}      a message in the plural form for a count, as {{ .Count }}
+//	{{ .StartsAt | localDate }}                a time in the locale's layout.datetime and time zone
+//	{{ locale }}                               the language tag
+func (l *emailLocale) funcs() map[string]any {
+	return map[string]any{
+		"T":         l.translate,
+		"Tn":        l.translateCount,
+		"localDate": l.formatDate,
+		"locale":    func() string { return l.tag },
+	}
+}
+
+// message finds id in the locale's catalogs, and the language it is in.
+func (l *emailLocale) message(id string) (*catalogMessage, string) {
+	for _, lang := range l.tags {
+		if m := l.cat[lang][id]; m != nil {
+			return m, lang
+		}
+	}
+	return nil, ""
+}
+
+// translate renders message id with data for its {{ }}: one value, or
+// names and values in turn. A message no catalog has comes out as its ID,
+// so it shows up in the email.
+func (l *emailLocale) translate(id string, data ...any) (string, error) {
+	m, _ := l.message(id)
+	if m == nil {
+		return id, nil
+	}
+	var d any
+	switch {
+	case len(data) == 1:
+		d = data[0]
+	case len(data)%2 != 0:
+		return "", fmt.Errorf("T %s: want one value or names and values, got %d arguments", id, len(data))
+	case len(data) > 0:
+		fields := map[string]any{}
+		for i := 0; i < len(data); i += 2 {
+			name, ok := data[i].(string)
+			if !ok {
+				return "", fmt.Errorf("T %s: argument name %v is not a string", id, data[i])
+			}
+			fields[name] = data[i+1]
+		}
+		d = fields
+	}
+	return m.render("other", d), nil
+}
+
+func (l *emailLocale) translateCount(id string, count any) (string, error) {
+	n, err := toCount(count)
+	if err != nil {
+		return "", fmt.Errorf("Tn %s: %w", id, err)
+	}
+	m, lang := l.message(id)
+	if m == nil {
+		return id, nil
+	}
+	form := pluralForm(lang, n)
+	if n == 0 && m.forms["zero"] != nil {
+		form = "zero"
+	}
+	return m.render(form, map[string]any{"Count": n}), nil
+}
+
+func toCount(v any) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case int64:
+		return int(n), nil
+	case float64:
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	}
+	return 0, fmt.Errorf("count %v is not a number", v)
+}
+
+// render executes a plural form, falling back to other and then one.
+func (m *catalogMessage) render(form string, data any) string {
+	t := m.forms[form]
+	for _, f := range []string{"other", "one"} {
+		if t == nil {
+			t = m.forms[f]
+		}
+	}
+	if t == nil {
+		return ""
+	}
+	var buf bytes.Buffer
+	t.Execute(&buf, data)
+	return buf.String()
+}
+
+// formatDate shows t in the locale's time zone and layout.datetime; a zero
+// time, such as the end of an alert still firing, is left empty.
+func (l *emailLocale) formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if l.zone != nil {
+		t = t.In(l.zone)
+	}
+	layout := defaultDateLayout
+	if m, _ := l.message("layout.datetime"); m != nil {
+		layout = m.render("other", nil)
+	}
+	return t.Format(layout)
+}
+
+// pluralForm is the CLDR plural category of a whole number n in lang, for
+// the languages whose rules differ from English's.
+func pluralForm(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	lang, _, _ = strings.Cut(lang, "-")
+	switch lang {
+	case "ja", "zh", "ko", "th", "vi", "id", "ms":
+		return "other"
+	case "fr", "pt":
+		if n <= 1 {
+			return "one"
+		}
+	case "ru", "uk", "be", "sr", "hr", "bs":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "pl":
+		switch {
+		case n == 1:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		}
+		return "many"
+	case "cs", "sk":
+		switch {
+		case n == 1:
+			return "one"
+		case n >= 2 && n <= 4:
+			return "few"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+	}
+	return "other"
+}
+
+/*
+=============================
+ Localized Template Sets
+=============================
+*/
+
+// localized is the template set parsed for l: the same sources with the
+// locale's functions, and the translated built-in templates in place of
+// the English ones. Sets are parsed once per locale and kept.
+func (t *emailTemplateSet) localized(l *emailLocale) (*emailTemplateSet, error) {
+	if t == nil || l == nil || t.locale != nil && l.key() == t.locale.key() {
+		return t, nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if set, ok := t.locales[l.key()]; ok {
+		return set, nil
+	}
+	set, err := parseLocalizedTemplates(t.glob, l)
+	if err != nil {
+		return nil, fmt.Errorf("email templates in %s: %w", l.tag, err)
+	}
+	if t.locales == nil {
+		t.locales = map[string]*emailTemplateSet{}
+	}
+	t.locales[l.key()] = set
+	return set, nil
+}
+
+// localizedOrDefault is t in locale l; a set that does not parse in l, as
+// a tenant's may not, is used as it is, and logged.
+func (t *emailTemplateSet) localizedOrDefault(l *emailLocale) *emailTemplateSet {
+	set, err := t.localized(l)
+	if err != nil {
+		slog.Warn("email templates not localized, sending in the default locale", "err", err)
+		return t
+	}
+	return set
+}
+
+// emailLocaleOf is the locale of a route's emails: its email_locale and
+// email_timezone, else -email-locale and -email-timezone.
+func (r *route) emailLocaleOf() *emailLocale {
+	if r.locale != nil {
+		return r.locale
+	}
+	return defaultLocale
+}
+
+// compileLocale checks the route's email_locale and email_timezone and
+// parses its templates for them, so a set that does not fails the load.
+func (r *route) compileLocale() error {
+	r.locale = nil
+	if r.EmailLocale == "" && r.EmailTimezone == "" {
+		return nil
+	}
+	tag, zone := r.EmailLocale, r.EmailTimezone
+	if tag == "" {
+		tag = *emailLocaleTag
+	}
+	if zone == "" {
+		zone = *emailTimezone
+	}
+	l, err := catalog.locale(tag, zone)
+	if err != nil {
+		return fmt.Errorf("email_locale: %w", err)
+	}
+	set := r.emailT
+	if set == nil {
+		set = emailTmpl
+	}
+	if _, err := set.localized(l); err != nil {
+		return err
+	}
+	r.locale = l
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sealedFile writes n lines sealed as the writer would and returns them.
+func sealedFile(t *testing.T, path string, n int) []string {
+	t.Helper()
+	c := &hashChain{last: make(map[string]string)}
+	var lines []string
+	for i := range n {
+		sealed := c.seal(path, []byte(`{"kpi":"Disk","cnt":"`+strings.Repeat("1", i+1)+`"}`))
+		c.commit(path, sealed)
+		lines = append(lines, string(sealed))
+	}
+	writeLines(t, path, lines)
+	return lines
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	defer func(mode string, key []byte) { *integrityMode, integrityKey = mode, key }(*integrityMode, integrityKey)
+
+	tests := []struct {
+		name    string
+		mode    string
+		edit    func(lines []string) []string
+		readKey string // key verifying; "" for the one sealing
+		want    string // part of the first problem, "" for none
+	}{
+		{"chain intact", "chain", nil, "", ""},
+		{"hmac intact", "hmac", nil, "", ""},
+		{"line edited", "chain", func(l []string) []string {
+			l[1] = strings.Replace(l[1], "Disk", "Dusk", 1)
+			return l
+		}, "", "prev_hash does not match"},
+		{"line removed", "chain", func(l []string) []string { return slices.Delete(l, 1, 2) }, "", "prev_hash does not match"},
+		{"lines swapped", "chain", func(l []string) []string {
+			l[0], l[1] = l[1], l[0]
+			return l
+		}, "", "prev_hash does not match"},
+		{"unsealed line inserted", "chain", func(l []string) []string {
+			return slices.Insert(l, 2, `{"kpi":"Fake"}`)
+		}, "", "unsealed line after sealing began"},
+		{"unsealed lines before sealing", "chain", func(l []string) []string {
+			return slices.Insert(l, 0, `{"kpi":"Old"}`)
+		}, "", "prev_hash does not match"},
+		{"not JSON", "chain", func(l []string) []string { return append(l, "garbage") }, "", "not a JSON line"},
+		{"last line edited", "hmac", func(l []string) []string {
+			l[2] = strings.Replace(l[2], `"cnt":"111"`, `"cnt":"999"`, 1)
+			return l
+		}, "", "mac mismatch"},
+		{"mac under another key", "hmac", nil, "other", "mac mismatch"},
+		{"mac without key", "hmac", nil, "none", "no -integrity-key-file"},
+		{"mac moved", "hmac", func(l []string) []string {
+			i := strings.LastIndex(l[0], `,"mac":`)
+			l[0] = `{"mac":` + l[0][i+len(`,"mac":`):len(l[0])-1] + "," + l[0][1:i] + "}"
+			return l
+		}, "", "mac is not the last field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*integrityMode, integrityKey = tt.mode, []byte("secret")
+			path := filepath.Join(t.TempDir(), "app_hivemq_202610150001.log")
+			lines := sealedFile(t, path, 3)
+			if tt.edit != nil {
+				writeLines(t, path, tt.edit(lines))
+			}
+			switch tt.readKey {
+			case "none":
+				integrityKey = nil
+			case "":
+			default:
+				integrityKey = []byte(tt.readKey)
+			}
+
+			_, _, problems := verifyChain(path)
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("problems %v, want none", problems)
+				}
+				return
+			}
+			if len(problems) == 0 || !strings.Contains(problems[0].msg, tt.want) {
+				t.Errorf("problems %v, want %q first", problems, tt.want)
+			}
+		})
+	}
+}
+
+// A restarted writer picks the chain up from the file's last line.
+func TestHashChainResumes(t *testing.T) {
+	defer func(mode string) { *integrityMode = mode }(*integrityMode)
+	*integrityMode = "chain"
+
+	path := filepath.Join(t.TempDir(), "app_hivemq_202610150001.log")
+	lines := sealedFile(t, path, 2)
+	c := &hashChain{last: make(map[string]string)}
+	sealed := c.seal(path, []byte(`{"kpi":"CPU"}`))
+	writeLines(t, path, append(lines, string(sealed)))
+
+	n, head, problems := verifyChain(path)
+	if n != 3 || len(problems) > 0 {
+		t.Fatalf("%d line(s), problems %v", n, problems)
+	}
+	if head != lineHash(sealed) {
+		t.Errorf("head %s, want the hash of the last line", head)
+	}
+}
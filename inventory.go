@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Inventory Enrichment
+=============================
+*/
+
+var inventoryFile = flag.String("inventory", "", "CSV or YAML host inventory: a host and/or ip column plus labels (datacenter, rack, owner_team, escalation_contact, ...) merged into matching alerts")
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// hostInventory indexes the inventory's labels by lower-cased host name
+// and by IP.
+var hostInventory struct {
+	byHost map[string]map[string]string
+	byIP   map[string]map[string]string
+}
+
+func loadInventory() error {
+	hostInventory.byHost, hostInventory.byIP = nil, nil
+	if *inventoryFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*inventoryFile)
+	if err != nil {
+		return err
+	}
+	var rows []map[string]string
+	switch strings.ToLower(filepath.Ext(*inventoryFile)) {
+	case ".csv":
+		rows, err = readInventoryCSV(data)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(data, &rows)
+	default:
+		err = fmt.Errorf("want a .csv, .yml or .yaml file")
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", *inventoryFile, err)
+	}
+
+	byHost := map[string]map[string]string{}
+	byIP := map[string]map[string]string{}
+	for i, row := range rows {
+		host, ip := strings.ToLower(row["host"]), row["ip"]
+		if host == "" && ip == "" {
+			return fmt.Errorf("%s: entry %d has neither host nor ip", *inventoryFile, i+1)
+		}
+		labels := map[string]string{}
+		for k, v := range row {
+			if k == "host" || k == "ip" || v == "" {
+				continue
+			}
+			if !labelName.MatchString(k) {
+				return fmt.Errorf("%s: entry %d: %q is not a valid label name", *inventoryFile, i+1, k)
+			}
+			labels[k] = v
+		}
+		if host != "" {
+			byHost[host] = labels
+		}
+		if ip != "" {
+			byIP[ip] = labels
+		}
+	}
+	hostInventory.byHost, hostInventory.byIP = byHost, byIP
+	return nil
+}
+
+// readInventoryCSV reads rows keyed by the header line.
+func readInventoryCSV(data []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comment = '#'
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			row[strings.TrimSpace(col)] = strings.TrimSpace(rec[i])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// inventoryFor finds the entry of an alert by its hostname label, then by
+// the host of each address label (-address-labels) as a name or an IP.
+func inventoryFor(labels map[string]string) map[string]string {
+	if hostInventory.byHost == nil {
+		return nil
+	}
+	if e, ok := hostInventory.byHost[strings.ToLower(labels["hostname"])]; ok {
+		return e
+	}
+	for _, host := range alertHosts(labels) {
+		if e, ok := hostInventory.byHost[strings.ToLower(host)]; ok {
+			return e
+		}
+		if e, ok := hostInventory.byIP[host]; ok {
+			return e
+		}
+	}
+	return nil
+}
+
+// addInventory merges the alert's inventory labels, leaving the ones it
+// already carries alone, and returns the names it added.
+func addInventory(a Alert) (Alert, []string) {
+	entry := inventoryFor(a.Labels)
+	var added []string
+	for k := range entry {
+		if a.Labels[k] == "" {
+			added = append(added, k)
+		}
+	}
+	if len(added) == 0 {
+		return a, nil
+	}
+	labels := make(map[string]string, len(a.Labels)+len(added))
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	for _, k := range added {
+		labels[k] = entry[k]
+	}
+	a.Labels = labels
+	return a, added
+}
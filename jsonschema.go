@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Output JSON Schema
+=============================
+*/
+
+// recordFields describes every key of the emitted record. The published
+// JSON Schema and validate-output are both derived from this table.
+type recordField struct {
+	name     string
+	typ      string // "string", "integer" or "object"
+	desc     string
+	since    int
+	required bool
+	pattern  string
+	format   string
+	min      *int
+
+	// timestamp fields follow -ts-layout; their pattern is derived from it.
+	timestamp bool
+}
+
+var (
+	minZero = 0
+	minOne  = 1
+)
+
+var recordFields = []recordField{
+	{name: "ts", typ: "string", desc: "entry time in -ts-layout and -timezone, by default local to the minute", since: 1, required: true, timestamp: true},
+	{name: "ip", typ: "string", desc: `instance IP or "NA"`, since: 1, required: true},
+	{name: "hname", typ: "string", desc: `hostname label or "unknown"`, since: 1, required: true},
+	{name: "kpi", typ: "string", desc: "alertname", since: 1, required: true},
+	{name: "value", typ: "string", desc: `value mapped from the severity by -severity-map, "1" by default`, since: 1, required: true},
+	{name: "cnt", typ: "string", desc: `current_value annotation or "NA"`, since: 1, required: true},
+	{name: "app_sub_name", typ: "string", desc: "summary annotation", since: 1, required: true},
+	{name: "req_id", typ: "string", desc: "correlation ID of the webhook request", since: 1},
+	{name: "ack_by", typ: "string", desc: "who acknowledged the alert", since: 1},
+	{name: "ack_at", typ: "string", desc: "acknowledgment time, laid out like ts", since: 1, timestamp: true},
+	{name: "silenced_by", typ: "string", desc: "ID of the silence that held back notifications of the alert", since: 1},
+	{name: "threshold", typ: "string", desc: "threshold annotation", since: 1},
+	{name: "margin", typ: "string", desc: "value minus threshold", since: 1},
+	{name: "margin_pct", typ: "string", desc: "margin as percent of the threshold", since: 1},
+	{name: "site", typ: "string", desc: "site of the instance", since: 1},
+	{name: "region", typ: "string", desc: "region of the instance", since: 1},
+	{name: "skew", typ: "string", desc: "how far startsAt lay in the future", since: 1},
+	{name: "datacenter", typ: "string", desc: "datacenter label, e.g. from -inventory", since: 1},
+	{name: "rack", typ: "string", desc: "rack label, e.g. from -inventory", since: 1},
+	{name: "owner_team", typ: "string", desc: "owner_team label, e.g. from -inventory", since: 1},
+	{name: "escalation_contact", typ: "string", desc: "escalation_contact label, e.g. from -inventory", since: 1},
+	{name: "repeats", typ: "integer", desc: "repeat firings folded into this entry", since: 1, min: &minOne},
+	{name: "escalation_level", typ: "integer", desc: "escalation level reached by a repeated critical (-escalate-routes)", since: 1, min: &minOne},
+	{name: "raw_payload", typ: "object", desc: "the Alertmanager request, redacted (-raw-payload)", since: 1},
+	{name: "prev_hash", typ: "string", desc: "sha256 of the previous line in the file", since: 1, pattern: `^[0-9a-f]{64}$`},
+	{name: "mac", typ: "string", desc: "HMAC-SHA256 of the line up to this field", since: 1, pattern: `^[0-9a-f]{64}$`},
+	{name: "schema", typ: "integer", desc: "schema version", since: 2, required: true},
+	{name: "starts_at", typ: "string", desc: "alert startsAt", since: 2, format: "date-time"},
+	{name: "ends_at", typ: "string", desc: "alert endsAt, resolved alerts only", since: 2, format: "date-time"},
+	{name: "duration_sec", typ: "integer", desc: "firing duration of a resolved alert", since: 2, min: &minZero},
+}
+
+var fieldPatterns = func() map[string]*regexp.Regexp {
+	m := map[string]*regexp.Regexp{}
+	for _, f := range recordFields {
+		if f.pattern != "" {
+			m[f.name] = regexp.MustCompile(f.pattern)
+		}
+	}
+	return m
+}()
+
+func recordSchema(version int) map[string]any {
+	props := map[string]any{}
+	var required []string
+	for _, f := range recordFields {
+		if f.since > version {
+			continue
+		}
+		p := map[string]any{"type": f.typ, "description": f.desc}
+		if f.pattern != "" {
+			p["pattern"] = f.pattern
+		}
+		if f.timestamp {
+			p["pattern"] = tsPattern.String()
+		}
+		if f.format != "" {
+			p["format"] = f.format
+		}
+		if f.min != nil {
+			p["minimum"] = *f.min
+		}
+		if f.name == "schema" {
+			p["const"] = version
+		}
+		props[f.name] = p
+		if f.required {
+			required = append(required, f.name)
+		}
+	}
+	return map[string]any{
+		"$schema":              "https://json-schema.org/draft/2020-12/schema",
+		"$id":                  fmt.Sprintf("/schema/logs/v%d.json", version),
+		"title":                fmt.Sprintf("HiveMQ alert log record, schema version %d", version),
+		"type":                 "object",
+		"properties":           props,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("file")
+	v, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "v"), ".json"))
+	if err != nil || !strings.HasSuffix(name, ".json") || validSchemaVersion(v) != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(recordSchema(v))
+}
+
+/*
+=============================
+ Record Validation
+=============================
+*/
+
+// validateRecord checks one output line against the schema of the given
+// version, or of the version the line declares.
+func validateRecord(line []byte, version int) []string {
+	var rec map[string]any
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&rec); err != nil {
+		return []string{"not a JSON object: " + err.Error()}
+	}
+	if _, ok := rec["rollup"]; ok {
+		return nil // end-of-day rollup, not an alert record
+	}
+	if n, ok := rec["schema"].(json.Number); ok {
+		if v, err := n.Int64(); err == nil && validSchemaVersion(int(v)) == nil {
+			version = int(v)
+		}
+	}
+
+	var problems []string
+	fields := map[string]recordField{}
+	for _, f := range recordFields {
+		if f.since > version {
+			continue
+		}
+		fields[f.name] = f
+		if _, ok := rec[f.name]; f.required && !ok {
+			problems = append(problems, "missing "+f.name)
+		}
+	}
+
+	keys := make([]string, 0, len(rec))
+	for k := range rec {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		f, ok := fields[k]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unexpected field %s for schema v%d", k, version))
+			continue
+		}
+		if err := checkField(f, rec[k], version); err != nil {
+			problems = append(problems, k+": "+err.Error())
+		}
+	}
+	return problems
+}
+
+func checkField(f recordField, v any, version int) error {
+	switch f.typ {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return errors.New("must be a string")
+		}
+		if re := fieldPatterns[f.name]; re != nil && !re.MatchString(s) {
+			return fmt.Errorf("%q does not match %s", s, f.pattern)
+		}
+		if f.timestamp && !tsPattern.MatchString(s) {
+			return fmt.Errorf("%q does not match -ts-layout %q", s, tsLayout)
+		}
+		if f.format == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				return fmt.Errorf("%q is not an RFC3339 date-time", s)
+			}
+		}
+	case "object":
+		if _, ok := v.(map[string]any); !ok {
+			return errors.New("must be an object")
+		}
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return errors.New("must be an integer")
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		if f.min != nil && i < int64(*f.min) {
+			return fmt.Errorf("must be >= %d", *f.min)
+		}
+		if f.name == "schema" && i != int64(version) {
+			return fmt.Errorf("must be %d", version)
+		}
+	}
+	return nil
+}
+
+/*
+=============================
+ Validate Command (validate-output)
+=============================
+*/
+
+func runValidateOutput(args []string) error {
+	fs := flag.NewFlagSet("validate-output", flag.ExitOnError)
+	version := fs.Int("schema-version", 1, "schema version for lines that do not declare one")
+	dir := fs.String("dir", logDir, "output directory to check when no files are given")
+	maxReport := fs.Int("max-problems", 20, "problems printed per file")
+	fs.StringVar(encryptKeyFile, "encrypt-key-file", "", "AES-256 key for reading encrypted output")
+	fs.StringVar(encryptIdentity, "encrypt-identity", "", "age identities for reading encrypted output")
+	fs.Parse(args)
+
+	if err := validSchemaVersion(*version); err != nil {
+		return err
+	}
+	if err := loadEncryption(); err != nil {
+		return err
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		logDir = *dir
+		if err := loadTenants(); err != nil {
+			return err
+		}
+		for _, tenant := range allTenants() {
+			files, err := listLogFiles(tenant)
+			if err != nil {
+				return err
+			}
+			for _, f := range files {
+				paths = append(paths, f.Path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no output files found in %s", *dir)
+	}
+
+	bad := 0
+	for _, path := range paths {
+		lines, invalid := 0, 0
+		err := readLogLines(path, func(line []byte) error {
+			lines++
+			problems := validateRecord(line, *version)
+			if len(problems) == 0 {
+				return nil
+			}
+			if invalid++; invalid <= *maxReport {
+				fmt.Printf("%s:%d: %s\n", path, lines, strings.Join(problems, "; "))
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if invalid > 0 {
+			bad++
+			fmt.Printf("FAIL %s: %d of %d line(s) invalid\n", path, invalid, lines)
+			continue
+		}
+		fmt.Printf("ok   %s: %d line(s)\n", path, lines)
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d file(s) failed validation", bad)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+/*
+=============================
+ Kafka Sink
+=============================
+*/
+
+var (
+	kafkaBrokers       = flag.String("kafka-brokers", "", "comma-separated bootstrap brokers, host:port; empty disables the kafka sink")
+	kafkaTopic         = flag.String("kafka-topic", "hivemq-alerts", "topic the records are published to")
+	kafkaKeyLabel      = flag.String("kafka-key-label", "hostname", "label whose value is the message key, so one host's alerts stay in order on one partition (murmur2, as the Java client); empty spreads the records over the partitions")
+	kafkaAcks          = flag.String("kafka-acks", "all", "acknowledgement waited for: all, one or none")
+	kafkaSASLMechanism = flag.String("kafka-sasl-mechanism", "", "SASL authentication: plain, scram-sha-256 or scram-sha-512; empty connects without")
+	kafkaUser          = flag.String("kafka-user", "", "SASL user name")
+	kafkaPasswordFile  = flag.String("kafka-password-file", "", "file holding the SASL password")
+	kafkaTLS           = flag.Bool("kafka-tls", false, "connect to the brokers over TLS")
+	kafkaCAFile        = flag.String("kafka-ca-file", "", "PEM CA bundle for verifying the brokers; empty uses the system roots")
+	kafkaCertFile      = flag.String("kafka-cert-file", "", "PEM client certificate for brokers that require one")
+	kafkaKeyFile       = flag.String("kafka-key-file", "", "PEM key of -kafka-cert-file")
+	kafkaTimeout       = flag.Duration("kafka-timeout", 10*time.Second, "deadline for connecting and for one write to be acknowledged")
+)
+
+var kafkaAckLevels = map[string]kafka.RequiredAcks{"all": kafka.RequireAll, "one": kafka.RequireOne, "none": kafka.RequireNone}
+
+const kafkaSink = "kafka"
+
+func kafkaEnabled() bool {
+	return *kafkaBrokers != ""
+}
+
+// kafkaOutput publishes the record of each alert, as the file sink writes
+// it, with the request ID, tenant and fingerprint as headers.
+type kafkaOutput struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSink() (Sink, error) {
+	acks, ok := kafkaAckLevels[*kafkaAcks]
+	if !ok {
+		return nil, fmt.Errorf("kafka sink: invalid -kafka-acks %q, want all, one or none", *kafkaAcks)
+	}
+	if *kafkaTopic == "" {
+		return nil, errors.New("kafka sink needs -kafka-topic")
+	}
+	transport := &kafka.Transport{DialTimeout: *kafkaTimeout, ClientID: "alertbridge"}
+	if *kafkaTLS {
+		conf, err := kafkaTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("kafka sink: %w", err)
+		}
+		transport.TLS = conf
+	}
+	if *kafkaSASLMechanism != "" {
+		mechanism, err := kafkaSASL()
+		if err != nil {
+			return nil, fmt.Errorf("kafka sink: %w", err)
+		}
+		transport.SASL = mechanism
+	}
+	return &kafkaOutput{writer: &kafka.Writer{
+		Addr:         kafka.TCP(splitList(*kafkaBrokers)...),
+		Topic:        *kafkaTopic,
+		Balancer:     &kafka.Murmur2Balancer{},
+		RequiredAcks: acks,
+		// Retries are ours, with the backoff and dead-lettering of the
+		// other sinks; a write is one batch.
+		MaxAttempts:  1,
+		BatchTimeout: time.Millisecond,
+		WriteTimeout: *kafkaTimeout,
+		ReadTimeout:  *kafkaTimeout,
+		Transport:    transport,
+	}}, nil
+}
+
+func kafkaTLSConfig() (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *kafkaCAFile != "" {
+		pem, err := os.ReadFile(*kafkaCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", *kafkaCAFile)
+		}
+	}
+	if *kafkaCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(*kafkaCertFile, *kafkaKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+func kafkaSASL() (sasl.Mechanism, error) {
+	if *kafkaUser == "" || *kafkaPasswordFile == "" {
+		return nil, errors.New("SASL needs -kafka-user and -kafka-password-file")
+	}
+	password, err := readSecret(*kafkaPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	switch *kafkaSASLMechanism {
+	case "plain":
+		return plain.Mechanism{Username: *kafkaUser, Password: string(password)}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, *kafkaUser, string(password))
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, *kafkaUser, string(password))
+	}
+	return nil, fmt.Errorf("invalid -kafka-sasl-mechanism %q, want plain, scram-sha-256 or scram-sha-512", *kafkaSASLMechanism)
+}
+
+func (*kafkaOutput) Name() string { return kafkaSink }
+
+func (k *kafkaOutput) Write(ctx context.Context, a Alert) error {
+	return k.WriteBatch(ctx, []Alert{a})
+}
+
+// WriteBatch publishes a request's alerts in one write. Only the records
+// the brokers did not take are sent again.
+func (k *kafkaOutput) WriteBatch(ctx context.Context, alerts []Alert) error {
+	var errs []error
+	var pending []Alert
+	var msgs []kafka.Message
+	for _, a := range alerts {
+		line, err := recordLine(ctx, a)
+		if err != nil {
+			recordDelivery(ctx, kafkaSink, *kafkaTopic, a, err)
+			errs = append(errs, targetError{target: *kafkaTopic, fingerprint: a.Fingerprint, err: fmt.Errorf("%w: %w", errGaveUp, err)})
+			continue
+		}
+		pending = append(pending, a)
+		msgs = append(msgs, kafkaMessage(ctx, a, line))
+	}
+
+	if len(msgs) == 0 {
+		return errors.Join(errs...)
+	}
+	failed := make([]error, len(msgs))
+	err := withRetries(ctx, kafkaSink, func() error {
+		err := breakerFor(kafkaSink).call(func() error { return k.writer.WriteMessages(ctx, msgs...) })
+		var perMessage kafka.WriteErrors
+		if !errors.As(err, &perMessage) {
+			for i := range failed {
+				failed[i] = err
+			}
+			return err
+		}
+		var retry []kafka.Message
+		var retryAlerts []Alert
+		var retryFailed []error
+		for i, e := range perMessage {
+			if e != nil {
+				retry, retryAlerts, retryFailed = append(retry, msgs[i]), append(retryAlerts, pending[i]), append(retryFailed, e)
+				continue
+			}
+			recordDelivery(ctx, kafkaSink, *kafkaTopic, pending[i], nil)
+		}
+		msgs, pending, failed = retry, retryAlerts, retryFailed
+		return err
+	})
+	for i, a := range pending {
+		if err == nil {
+			recordDelivery(ctx, kafkaSink, *kafkaTopic, a, nil)
+			continue
+		}
+		recordDelivery(ctx, kafkaSink, *kafkaTopic, a, failed[i])
+		errs = append(errs, targetError{target: *kafkaTopic, fingerprint: a.Fingerprint, err: fmt.Errorf("%w: %w", errGaveUp, failed[i])})
+	}
+	return errors.Join(errs...)
+}
+
+func kafkaMessage(ctx context.Context, a Alert, line []byte) kafka.Message {
+	msg := kafka.Message{
+		Value: line,
+		Headers: []kafka.Header{
+			{Key: "req_id", Value: []byte(requestID(ctx))},
+			{Key: "fingerprint", Value: []byte(a.Fingerprint)},
+		},
+	}
+	if tenant := tenantOf(ctx); tenant != "" {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: "tenant", Value: []byte(tenant)})
+	}
+	if *kafkaKeyLabel != "" {
+		if v := a.Labels[*kafkaKeyLabel]; v != "" {
+			msg.Key = []byte(v)
+		}
+	}
+	return msg
+}
+
+func (k *kafkaOutput) close() {
+	k.writer.Close()
+}
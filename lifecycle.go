@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Lifecycle (Shutdown)
+=============================
+*/
+
+var shutdownTimeout = flag.Duration("shutdown-timeout", 60*time.Second,
+	"deadline of the whole shutdown: HTTP requests, queue drain (at most -drain-timeout), digests, summaries and file sync")
+
+// shutdownStep is one stage of an orderly stop. Steps run in the order
+// they were added, and each still runs after the deadline passed, with a
+// done context, so that it can give up quickly rather than be skipped.
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+type lifecycleManager struct {
+	mu    sync.Mutex
+	steps []shutdownStep
+}
+
+var lifecycle = &lifecycleManager{}
+
+func (l *lifecycleManager) onShutdown(name string, run func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.steps = append(l.steps, shutdownStep{name, run})
+}
+
+// shutdown runs every step within -shutdown-timeout and clears the list,
+// so that a later serve starts afresh.
+func (l *lifecycleManager) shutdown() error {
+	l.mu.Lock()
+	steps := l.steps
+	l.steps = nil
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	var errs []error
+	for _, s := range steps {
+		start := time.Now()
+		err := s.run(ctx)
+		if err != nil {
+			slog.Warn("shutdown step failed", "step", s.name, "duration", time.Since(start), "err", err)
+			errs = append(errs, err)
+			continue
+		}
+		slog.Debug("shutdown step done", "step", s.name, "duration", time.Since(start))
+	}
+	return errors.Join(errs...)
+}
+
+// abortGrace is how long workers get to stop once the drain deadline
+// cancelled their batches.
+const abortGrace = 5 * time.Second
+
+// drainStats counts the alerts of a queue drain: written, cut short at the
+// deadline (their failed writes are dead-lettered), or never started.
+type drainStats struct {
+	flushed, interrupted, abandoned int
+}
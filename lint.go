@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"text/template/parse"
+)
+
+/*
+=============================
+ Template Linter (lint-templates)
+=============================
+*/
+
+// Builtins of text/template, typed where the result is predictable.
+var lintBuiltins = map[string]any{
+	"and": func(...any) any { return nil }, "or": func(...any) any { return nil },
+	"not": func(any) bool { return false }, "len": func(any) int { return 0 },
+	"index": func(any, ...any) any { return nil }, "slice": func(any, ...any) any { return nil },
+	"print": fmt.Sprint, "printf": fmt.Sprintf, "println": fmt.Sprintln,
+	"eq": func(any, ...any) bool { return false }, "ne": func(any, any) bool { return false },
+	"lt": func(any, any) bool { return false }, "le": func(any, any) bool { return false },
+	"gt": func(any, any) bool { return false }, "ge": func(any, any) bool { return false },
+	"html": func(...any) string { return "" }, "js": func(...any) string { return "" },
+	"urlquery": func(...any) string { return "" }, "call": func(any, ...any) any { return nil },
+}
+
+// The functions of a locale; the nil one is never called.
+var lintLocaleFuncs = (*emailLocale)(nil).funcs()
+
+type templateLinter struct {
+	trees   map[string]*parse.Tree
+	current *parse.Tree
+	issues  []string
+}
+
+func runLintTemplates(args []string) error {
+	fs := flag.NewFlagSet("lint-templates", flag.ExitOnError)
+	routes := fs.String("routes", "", "routing tree to check for unreachable routes")
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		// hivemq-i18n.tmpl defines the same templates as the English
+		// ones, in their place; it is linted when named.
+		all, _ := filepath.Glob("*.tmpl")
+		for _, f := range all {
+			if f != "hivemq-i18n.tmpl" {
+				files = append(files, f)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return errors.New("usage: lint-templates [-routes routes.yml] <file.tmpl>...")
+	}
+
+	issues := lintTemplateFiles(files)
+	if *routes != "" {
+		routeIssues, err := lintRoutes(*routes)
+		if err != nil {
+			return err
+		}
+		issues = append(issues, routeIssues...)
+	}
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("%d issue(s) found", len(issues))
+	}
+	fmt.Printf("%d template file(s) ok\n", len(files))
+	return nil
+}
+
+// lintTemplateFiles parses every file (so cross-file {{ template }} calls
+// resolve) and then type-checks each defined template against
+// templateData.
+func lintTemplateFiles(files []string) []string {
+	l := &templateLinter{trees: map[string]*parse.Tree{}}
+
+	for _, file := range files {
+		text, err := os.ReadFile(file)
+		if err != nil {
+			l.issues = append(l.issues, err.Error())
+			continue
+		}
+		trees, err := parse.Parse(file, string(text), "", "", templateFuncs, lintLocaleFuncs, lintBuiltins)
+		if err != nil {
+			l.issues = append(l.issues, err.Error())
+			continue
+		}
+		for name, tree := range trees {
+			if _, dup := l.trees[name]; dup && name != file {
+				l.issues = append(l.issues, fmt.Sprintf("%s: template %q defined more than once", file, name))
+			}
+			l.trees[name] = tree
+		}
+	}
+
+	names := make([]string, 0, len(l.trees))
+	for name := range l.trees {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	root := reflect.TypeOf(templateData{})
+	for _, name := range names {
+		l.current = l.trees[name]
+		if l.current.Root != nil {
+			l.walk(l.current.Root, root, map[string]reflect.Type{"$": root})
+		}
+	}
+	return l.issues
+}
+
+func (l *templateLinter) report(n parse.Node, format string, args ...any) {
+	loc, _ := l.current.ErrorContext(n)
+	l.issues = append(l.issues, loc+": "+fmt.Sprintf(format, args...))
+}
+
+func (l *templateLinter) walk(n parse.Node, dot reflect.Type, vars map[string]reflect.Type) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			l.walk(child, dot, vars)
+		}
+	case *parse.ActionNode:
+		l.pipe(n.Pipe, dot, vars)
+	case *parse.IfNode:
+		l.pipe(n.Pipe, dot, vars)
+		l.walk(n.List, dot, copyVars(vars))
+		l.walk(n.ElseList, dot, copyVars(vars))
+	case *parse.WithNode:
+		inner := copyVars(vars)
+		t := l.pipe(n.Pipe, dot, inner)
+		l.walk(n.List, t, inner)
+		l.walk(n.ElseList, dot, copyVars(vars))
+	case *parse.RangeNode:
+		inner := copyVars(vars)
+		decl := n.Pipe.Decl
+		n.Pipe.Decl = nil
+		t := l.pipe(n.Pipe, dot, inner)
+		n.Pipe.Decl = decl
+
+		key, elem, ok := rangeTypes(t)
+		if !ok {
+			l.report(n, "cannot range over %s", typeName(t))
+		}
+		switch len(decl) {
+		case 1:
+			inner[decl[0].Ident[0]] = elem
+		case 2:
+			inner[decl[0].Ident[0]] = key
+			inner[decl[1].Ident[0]] = elem
+		}
+		l.walk(n.List, elem, inner)
+		l.walk(n.ElseList, dot, copyVars(vars))
+	case *parse.TemplateNode:
+		if _, ok := l.trees[n.Name]; !ok {
+			l.report(n, "template %q is not defined", n.Name)
+		}
+		if n.Pipe != nil {
+			l.pipe(n.Pipe, dot, vars)
+		}
+	}
+}
+
+// pipe returns the static type of a pipeline, or nil when unknown.
+func (l *templateLinter) pipe(p *parse.PipeNode, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	var t reflect.Type
+	for _, cmd := range p.Cmds {
+		for _, arg := range cmd.Args[1:] {
+			l.arg(arg, dot, vars)
+		}
+		t = l.arg(cmd.Args[0], dot, vars)
+	}
+	for _, v := range p.Decl {
+		vars[v.Ident[0]] = t
+	}
+	return t
+}
+
+func (l *templateLinter) arg(n parse.Node, dot reflect.Type, vars map[string]reflect.Type) reflect.Type {
+	switch n := n.(type) {
+	case *parse.DotNode:
+		return dot
+	case *parse.FieldNode:
+		return l.fields(n, dot, n.Ident)
+	case *parse.VariableNode:
+		return l.fields(n, vars[n.Ident[0]], n.Ident[1:])
+	case *parse.ChainNode:
+		return l.fields(n, l.arg(n.Node, dot, vars), n.Field)
+	case *parse.PipeNode:
+		return l.pipe(n, dot, copyVars(vars))
+	case *parse.IdentifierNode:
+		fn, ok := templateFuncs[n.Ident]
+		if !ok {
+			fn, ok = lintLocaleFuncs[n.Ident]
+		}
+		if !ok {
+			fn = lintBuiltins[n.Ident]
+		}
+		if ft := reflect.TypeOf(fn); ft != nil && ft.NumOut() > 0 {
+			return ft.Out(0)
+		}
+	case *parse.StringNode:
+		return reflect.TypeOf("")
+	case *parse.BoolNode:
+		return reflect.TypeOf(true)
+	}
+	return nil
+}
+
+func (l *templateLinter) fields(n parse.Node, t reflect.Type, names []string) reflect.Type {
+	for _, name := range names {
+		if t == nil {
+			return nil
+		}
+		next, ok := lookupMember(t, name)
+		if !ok {
+			l.report(n, "%s has no field or method %q", typeName(t), name)
+			return nil
+		}
+		t = next
+	}
+	return t
+}
+
+// lookupMember resolves .Name the way text/template does: methods first,
+// then struct fields or map keys. A nil type means "unknown, stop checking".
+func lookupMember(t reflect.Type, name string) (reflect.Type, bool) {
+	if t.Kind() == reflect.Interface {
+		return nil, true
+	}
+	for _, candidate := range []reflect.Type{t, reflect.PointerTo(t)} {
+		if m, ok := candidate.MethodByName(name); ok {
+			if m.Type.NumOut() == 0 {
+				return nil, true
+			}
+			return m.Type.Out(0), true
+		}
+	}
+
+	base := t
+	if base.Kind() == reflect.Pointer {
+		base = base.Elem()
+	}
+	switch base.Kind() {
+	case reflect.Struct:
+		if f, ok := base.FieldByName(name); ok && f.IsExported() {
+			return f.Type, true
+		}
+	case reflect.Map:
+		if base.Key().Kind() == reflect.String {
+			return base.Elem(), true
+		}
+	}
+	return nil, false
+}
+
+func rangeTypes(t reflect.Type) (key, elem reflect.Type, ok bool) {
+	if t == nil {
+		return nil, nil, true
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return reflect.TypeOf(0), t.Elem(), true
+	case reflect.Map:
+		return t.Key(), t.Elem(), true
+	case reflect.Int:
+		return nil, t, true
+	case reflect.Interface:
+		return nil, nil, true
+	}
+	return nil, nil, false
+}
+
+func typeName(t reflect.Type) string {
+	if t.Name() != "" {
+		return t.Name()
+	}
+	return t.String()
+}
+
+func copyVars(vars map[string]reflect.Type) map[string]reflect.Type {
+	out := make(map[string]reflect.Type, len(vars))
+	for k, v := range vars {
+		out[k] = v
+	}
+	return out
+}
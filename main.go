@@ -3,12 +3,32 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const alertsPath = "/alerts"
+
+// Set from -log-dir and -log-prefix by loadSettings.
+var (
+	logDir    = defaultLogDir()
+	logPrefix = "app_hivemq_"
 )
 
 /*
@@ -18,15 +38,25 @@ import (
 */
 
 type AlertmanagerPayload struct {
-	Alerts []Alert `json:"alerts"`
+	Version           string            `json:"version,omitempty"`
+	GroupKey          string            `json:"groupKey,omitempty"`
+	Status            string            `json:"status,omitempty"`
+	Receiver          string            `json:"receiver,omitempty"`
+	GroupLabels       map[string]string `json:"groupLabels,omitempty"`
+	CommonLabels      map[string]string `json:"commonLabels,omitempty"`
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+	ExternalURL       string            `json:"externalURL,omitempty"`
+	Alerts            []Alert           `json:"alerts"`
 }
 
 type Alert struct {
-	Status      string            `json:"status"`
-	StartsAt    time.Time         `json:"startsAt"`
-	EndsAt      time.Time         `json:"endsAt"`
-	Labels      map[string]string `json:"labels"`
-	Annotations map[string]string `json:"annotations"`
+	Status       string            `json:"status"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+	Fingerprint  string            `json:"fingerprint"`
 }
 
 /*
@@ -43,6 +73,46 @@ type JSONLog struct {
 	Value     string `json:"value"`
 	Count     string `json:"cnt"`
 	Summary   string `json:"app_sub_name"`
+	RequestID string `json:"req_id,omitempty"`
+	AckBy     string `json:"ack_by,omitempty"`
+	AckAt     string `json:"ack_at,omitempty"`
+	Silenced  string `json:"silenced_by,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+	Margin    string `json:"margin,omitempty"`
+	MarginPct string `json:"margin_pct,omitempty"`
+	Site      string `json:"site,omitempty"`
+	Region    string `json:"region,omitempty"`
+	Skew      string `json:"skew,omitempty"`
+
+	// From the -inventory labels of the same names.
+	Datacenter string `json:"datacenter,omitempty"`
+	Rack       string `json:"rack,omitempty"`
+	OwnerTeam  string `json:"owner_team,omitempty"`
+	Escalation string `json:"escalation_contact,omitempty"`
+
+	Repeats         int `json:"repeats,omitempty"`
+	EscalationLevel int `json:"escalation_level,omitempty"`
+
+	// The request the alert came in, with -raw-payload=log.
+	RawPayload json.RawMessage `json:"raw_payload,omitempty"`
+
+	// Schema version 2 and later.
+	Schema      int    `json:"schema,omitempty"`
+	StartsAt    string `json:"starts_at,omitempty"`
+	EndsAt      string `json:"ends_at,omitempty"`
+	DurationSec *int64 `json:"duration_sec,omitempty"`
+
+	// Tenant is implied by the file's directory and never written.
+	Tenant string `json:"-"`
+	// Priority marks entries that travel in the priority lane.
+	Priority bool `json:"-"`
+	// Severity is only kept for the end-of-day rollup tally.
+	Severity string `json:"-"`
+	// custom lays the record out by the route's record_schema.
+	custom *customRecord
+	// annotations is the text of the alert's other annotations, for the
+	// search index.
+	annotations string
 }
 
 /*
@@ -51,27 +121,383 @@ type JSONLog struct {
 =============================
 */
 
+var subcommands = map[string]func(args []string) error{
+	"gen":      runGen,
+	"test":     runGoldenTest,
+	"replay":   runReplay,
+	"dev":      runDev,
+	"examples": runExamples,
+
+	"check-upstream":  runCheckUpstream,
+	"lint-templates":  runLintTemplates,
+	"selftest":        runSelftestCommand,
+	"smoke":           runSmoke,
+	"validate-output": runValidateOutput,
+	"verify-log":      runVerifyLog,
+}
+
+var retentionDays = flag.Int("retention-days", 0, "delete output files older than this many days (0 keeps everything)")
+
 func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	flag.Parse()
+	if err := initConfig(flag.CommandLine); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := serve(ctx, ln, nil); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// loadSettings validates the flags and (re)loads everything derived from
+// them. It runs at start-up and again on every configuration reload.
+func loadSettings() error {
+	if err := loadLogging(); err != nil {
+		return err
+	}
+	if err := loadTimeFormat(); err != nil {
+		return err
+	}
+	if err := validPathSettings(); err != nil {
+		return err
+	}
+	if err := loadFileModes(); err != nil {
+		return err
+	}
+	if err := validPreflight(); err != nil {
+		return err
+	}
+	if err := loadFileName(); err != nil {
+		return err
+	}
+	if !validDecodeMode(*decodeMode) {
+		return fmt.Errorf("invalid -decode-mode %q", *decodeMode)
+	}
+	if err := validSchemaVersion(*schemaVersion); err != nil {
+		return err
+	}
+	if err := validQuotaOptions(); err != nil {
+		return err
+	}
+	if err := validRateOptions(); err != nil {
+		return err
+	}
+	if err := validBreakerOptions(); err != nil {
+		return err
+	}
+	if err := validQueueOptions(); err != nil {
+		return err
+	}
+	if err := validDigestOptions(); err != nil {
+		return err
+	}
+	if err := validBodyLimit(); err != nil {
+		return err
+	}
+	if err := validStreamBatch(); err != nil {
+		return err
+	}
+	if err := validRetryOptions(); err != nil {
+		return err
+	}
+	if err := validRawPayloadMode(); err != nil {
+		return err
+	}
+	if err := validHeartbeat(); err != nil {
+		return err
+	}
+	if err := validTLSOptions(); err != nil {
+		return err
+	}
+	if err := validRotateOptions(); err != nil {
+		return err
+	}
+	if err := validEncodingOptions(); err != nil {
+		return err
+	}
+	if !validOversizePolicy(*oversizePolicy) {
+		return fmt.Errorf("invalid -oversize-policy %q", *oversizePolicy)
+	}
+	if !validTimestampSource(*timestampSource) {
+		return fmt.Errorf("invalid -timestamp-source %q", *timestampSource)
+	}
+	logFaultConfig()
+	if err := logStubConfig(); err != nil {
+		return err
+	}
+	if err := loadAuth(); err != nil {
+		return err
+	}
+	if err := loadEmailLocale(); err != nil {
+		return err
+	}
+	if err := loadTenants(); err != nil {
+		return err
+	}
+	if err := compileFilters(); err != nil {
+		return err
+	}
+	if err := loadNormalizeRules(); err != nil {
+		return err
+	}
+	if err := loadRecordMapping(); err != nil {
+		return err
+	}
+	if err := loadSanitizers(); err != nil {
+		return err
+	}
+	if err := loadUnitRules(); err != nil {
+		return err
+	}
+	if err := loadSeverityMap(); err != nil {
+		return err
+	}
+	if err := loadAddressLabels(); err != nil {
+		return err
+	}
+	if err := loadEnrichment(); err != nil {
+		return err
+	}
+	if err := loadInventory(); err != nil {
+		return err
+	}
+	if err := loadRedactRules(); err != nil {
+		return err
+	}
+	if err := loadRemoteWriteRules(); err != nil {
+		return err
+	}
+	if err := loadEmail(); err != nil {
+		return err
+	}
+	if err := loadMQTT(); err != nil {
+		return err
+	}
+	if err := loadWebhooks(); err != nil {
+		return err
+	}
+	if err := loadChat(); err != nil {
+		return err
+	}
+	if err := loadSinks(); err != nil {
+		return err
+	}
+	if err := loadRoutes(); err != nil {
+		return err
+	}
+	if err := validEscalation(); err != nil {
+		return err
+	}
+	if err := validSpool(); err != nil {
+		return err
+	}
+	if err := loadSilences(); err != nil {
+		return err
+	}
+	if *reportAt != "" {
+		if _, err := nextReportTime(*reportAt, clock.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serve runs the receiver on ln until ctx is cancelled. Subcommands use
+// routes to mount extra handlers next to the standard ones.
+func serve(ctx context.Context, ln net.Listener, routes func(mux *http.ServeMux)) error {
+	if err := loadSettings(); err != nil {
+		return err
+	}
+	if err := loadEncryption(); err != nil {
+		return err
+	}
+	if err := loadIntegrity(); err != nil {
+		return err
+	}
+	if err := setupTracing(); err != nil {
+		return err
+	}
+	if err := openAlertDB(); err != nil {
+		return err
+	}
+	if err := loadSpool(); err != nil {
+		return err
+	}
+	if err := preflightDirs(); err != nil {
+		return err
+	}
+	if err := loadSilenceState(); err != nil {
+		return err
+	}
+	if tlsEnabled() {
+		var err error
+		if ln, err = tlsListener(ln); err != nil {
+			return err
+		}
+		go runCertReload(ctx.Done())
+	}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("/alerts", alertHandler)
+	mux.HandleFunc(alertsPath, withSpan("POST /alerts", alertHandler))
+	mux.HandleFunc(alertsPath+"/{tenant}", withSpan("POST /alerts", alertHandler))
+	mux.HandleFunc(grafanaPath, withSpan("POST /grafana", grafanaHandler))
+	mux.HandleFunc(grafanaPath+"/{tenant}", withSpan("POST /grafana", grafanaHandler))
+	mux.HandleFunc("GET /api/export", exportHandler)
+	mux.HandleFunc("GET /api/search", searchHandler)
+	mux.HandleFunc("GET /api/stats/alerts", statsHandler)
+	mux.HandleFunc("GET /api/files", filesHandler)
+	mux.HandleFunc("GET /api/tail", tailHandler)
+	mux.HandleFunc("POST /api/alerts/{fingerprint}/ack", withAdminToken(ackHandler))
+	mux.HandleFunc("GET /api/requests/{id}", requestLookupHandler)
+	mux.HandleFunc("GET /api/deliveries", deliveriesHandler)
+	mux.HandleFunc("GET /api/v1/alerts", alertHistoryHandler)
+	mux.HandleFunc("GET /api/silences", silenceListHandler)
+	mux.HandleFunc("POST /api/silences", withAdminToken(silenceCreateHandler))
+	mux.HandleFunc("GET /api/silences/{id}", silenceGetHandler)
+	mux.HandleFunc("PUT /api/silences/{id}", withAdminToken(silenceUpdateHandler))
+	mux.HandleFunc("DELETE /api/silences/{id}", withAdminToken(silenceDeleteHandler))
+	mux.HandleFunc("GET /api/dashboard", dashboardJSONHandler)
+	mux.HandleFunc("GET /{$}", dashboardHandler)
+	mux.HandleFunc("POST /api/deadletters/replay", withAdminToken(deadLetterReplayHandler))
+	mux.HandleFunc("GET /api/traces/{id}", traceHandler)
+	mux.HandleFunc("GET /api/stub/requests", stubRequestsHandler)
+	mux.HandleFunc("GET /readyz", readyHandler)
+	mux.HandleFunc("GET /schema/logs/{file}", schemaHandler)
+	remoteWriteRoutes(mux)
+	if *adminListen == "" {
+		adminRoutes(mux, false)
+	}
+	if routes != nil {
+		routes(mux)
+	}
 
 	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      mux,
+		Handler:      withMetrics(mux, withAccessLog(withConfig(withRequestID(withTenant(mux))))),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
 
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
+	if err := index.load(); err != nil {
+		slog.Warn("search index incomplete", "err", err)
+	}
+	queue = startQueue()
+	if *retentionDays > 0 {
+		go runRetention(ctx.Done())
+	}
+	if archiveEnabled() {
+		go runArchive(ctx.Done())
+	}
+	if *reportAt != "" {
+		go runDailyReport(ctx.Done())
+	}
+	if *aggregateInterval > 0 {
+		go runAggregation(ctx.Done())
+	}
+	if *encryptMode == "file" {
+		go runFileEncryption(ctx.Done())
+	}
+	if *quotaPerHour > 0 {
+		go runQuotas(ctx.Done())
+	}
+	if *rollupEnabled {
+		go runRollup(ctx.Done())
+	}
+	go runRotation(ctx.Done())
+	go runFlush(ctx.Done())
+	go runHeartbeat(ctx.Done())
+	go runEscalation(ctx.Done())
+	if spoolEnabled() {
+		go runSpool(ctx.Done())
+	}
+	if digestEnabled() {
+		go runDigests(ctx.Done())
+	}
+	if *compressRotated {
+		go runCompression(ctx.Done())
+	}
 
-	go server.ListenAndServe()
+	lifecycle.onShutdown("http", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	})
+	lifecycle.onShutdown("queue", func(ctx context.Context) error {
+		stats := queue.drain(ctx)
+		slog.Info("queue drained", "flushed", stats.flushed, "interrupted", stats.interrupted, "abandoned", stats.abandoned)
+		if stats.abandoned > 0 {
+			return fmt.Errorf("%d alert(s) abandoned in the queue", stats.abandoned)
+		}
+		return nil
+	})
+	lifecycle.onShutdown("digests", func(ctx context.Context) error {
+		if n := digests.flush(ctx); n > 0 {
+			slog.Info("digests flushed", "digests", n)
+		}
+		return nil
+	})
+	lifecycle.onShutdown("summaries", func(context.Context) error {
+		repeats.flush()
+		quotas.flush()
+		return nil
+	})
+	lifecycle.onShutdown("sinks", func(context.Context) error {
+		sinksMu.RLock()
+		defer sinksMu.RUnlock()
+		for _, s := range sinkSet {
+			closeSink(s)
+		}
+		closeMQTT()
+		return nil
+	})
+	lifecycle.onShutdown("files", func(context.Context) error {
+		n, err := writer.closeAll()
+		slog.Info("files synced", "files", n)
+		return err
+	})
+	lifecycle.onShutdown("history-db", func(context.Context) error {
+		return closeAlertDB()
+	})
+	lifecycle.onShutdown("tracing", shutdownTracing)
 
-	<-ctx.Done()
+	if *adminListen != "" {
+		if err := startAdmin(); err != nil {
+			return err
+		}
+	}
+	// Reloads start once everything above has read its settings, and have
+	// stopped before the shutdown reads them again.
+	reloads := make(chan struct{})
+	go func() {
+		defer close(reloads)
+		watchReload(ctx.Done())
+	}()
+	go server.Serve(ln)
+	slog.Info("listening", "addr", ln.Addr().String(), "scheme", listenScheme())
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	server.Shutdown(shutdownCtx)
+	<-ctx.Done()
+	<-reloads
+	slog.Info("shutting down", "delay", *shutdownDelay, "drain_timeout", *drainTimeout, "timeout", *shutdownTimeout)
+	beginShutdown()
+	err := lifecycle.shutdown()
+	syncAppLog()
+	slog.Info("stopped")
+	return err
 }
 
 /*
@@ -83,53 +509,399 @@ func main() {
 func alertHandler(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	var payload AlertmanagerPayload
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if !rateLimit(w, r) {
+		return
+	}
+	if err := authorize(w, r); err != nil {
+		refuse(w, err)
+		return
+	}
+
+	tenant, code, err := alertTenant(r)
+	if err != nil {
+		http.Error(w, err.Error(), code)
+		return
+	}
+	r = r.WithContext(withTenantValue(r.Context(), tenant))
+	spanAttrs(r.Context(), attribute.String("tenant", tenant))
+
+	ctx := startTrace(r)
+	captureRequest(r)
+
+	if *stubMode {
+		stub.respond(w, r)
 		return
 	}
 
-	for _, alert := range payload.Alerts {
-		writeJSONLog(alert)
+	source := quotaSource(r, tenant)
+	admit := func(ctx context.Context, alerts []Alert) bool {
+		return admitAlerts(ctx, w, source, tenant, alerts)
+	}
+	payload, body, ok := readPayload(ctx, w, r, admit)
+	if !ok {
+		return
 	}
 
+	if body != nil {
+		ctx = withRawPayload(ctx, body)
+	}
+	if !admit(withAlertGroup(ctx, payload.group()), payload.Alerts) {
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// admitFunc takes decoded alerts through the quotas into the write queue,
+// answering the request itself when they are refused.
+type admitFunc func(ctx context.Context, alerts []Alert) bool
+
+func admitAlerts(ctx context.Context, w http.ResponseWriter, source, tenant string, alerts []Alert) bool {
+	countReceived(alerts)
+	alerts, over, ok := quotas.admit(source, tenant, alerts)
+	if !ok {
+		tracef(ctx, "quota", "", "rejected: %s %s is over %d entries/h", *quotaBy, source, *quotaPerHour)
+		w.Header().Set("Retry-After", strconv.Itoa(int(quotas.retryAfter().Seconds())+1))
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	if over > 0 {
+		tracef(ctx, "quota", "", "%d alert(s) over quota summarised", over)
+	}
+
+	if err := queue.enqueue(ctx, tenant, alerts); err != nil {
+		tracef(ctx, "queue", "", "rejected: %v", err)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return false
+	}
+	tracef(ctx, "queue", "", "queued %d alert(s)", len(alerts))
+	return true
+}
+
+// readPayload reads and decodes the webhook body, answering the request
+// itself when it is rejected. body is nil when the alerts were decoded as
+// the body streamed in, in which case batches of them may already have
+// gone to admit; see streamDecode.
+func readPayload(ctx context.Context, w http.ResponseWriter, r *http.Request, admit admitFunc) (AlertmanagerPayload, []byte, bool) {
+	in, err := bodyReader(w, r)
+	if err == nil && streamDecode(ctx) {
+		payload, ok := readStreamed(ctx, w, in, admit)
+		return payload, nil, ok
+	}
+	var body []byte
+	if err == nil {
+		body, err = io.ReadAll(in)
+	}
+	if err != nil {
+		reason := readRejectReason(err)
+		slog.Warn("payload rejected", "req_id", requestID(ctx), "reason", reason, "err", err)
+		tracef(ctx, "decode", "", "rejected: %v", err)
+		rejectPayload(w, requestID(ctx), reason, err, nil)
+		return AlertmanagerPayload{}, nil, false
+	}
+	archiveRequest(r, body)
+	body, repaired := repairUTF8(body)
+	if repaired {
+		tracef(ctx, "utf8", "", "invalid UTF-8 in body, applied %s policy", *invalidUTF8)
+	}
+
+	_, decodeSpan := startSpan(ctx, "decode", attribute.String("decode.mode", *decodeMode), attribute.Int("body.bytes", len(body)))
+	if *decodeMode != "lenient" {
+		problems, err := checkPayload(body)
+		if err != nil || len(problems) > 0 {
+			reason := rejectSchema
+			if err != nil {
+				reason = rejectJSON
+			} else {
+				err = fmt.Errorf("payload does not match the Alertmanager webhook schema: %d problem(s)", len(problems))
+			}
+			endSpan(decodeSpan, err)
+			slog.Warn("payload rejected", "req_id", requestID(ctx), "reason", reason, "err", err, "problems", len(problems))
+			tracef(ctx, "decode", "", "rejected: %v", err)
+			rejectPayload(w, requestID(ctx), reason, err, problems)
+			return AlertmanagerPayload{}, nil, false
+		}
+	}
+	payload, warnings, err := decodeIntake(ctx, body)
+	if err == nil {
+		err = injectDecodeFault()
+	}
+	decodeSpan.SetAttributes(attribute.Int("alerts", len(payload.Alerts)))
+	endSpan(decodeSpan, err)
+	if err != nil {
+		slog.Warn("payload rejected", "req_id", requestID(ctx), "mode", *decodeMode, "err", err)
+		tracef(ctx, "decode", "", "rejected (%s mode): %v", *decodeMode, err)
+		reason := rejectSchema
+		var syntax *json.SyntaxError
+		if errors.As(err, &syntax) {
+			reason = rejectJSON
+		}
+		rejectPayload(w, requestID(ctx), reason, err, nil)
+		return AlertmanagerPayload{}, nil, false
+	}
+	tracef(ctx, "decode", "", "accepted %d alert(s) in %s mode", len(payload.Alerts), *decodeMode)
+	for _, warning := range warnings {
+		slog.Warn("lenient decode", "req_id", requestID(ctx), "warning", warning)
+		tracef(ctx, "decode", "", "warning: %s", warning)
+	}
+	return payload, body, true
+}
+
+// processAlerts runs decoded alerts through the pipeline, priority lane
+// first, and hands what is left to the sinks.
+func processAlerts(ctx context.Context, tenant string, alerts []Alert) {
+	ctx, span := startSpan(ctx, "pipeline", attribute.String("tenant", tenant), attribute.Int("alerts", len(alerts)))
+	defer span.End()
+	var processed []Alert
+	for _, alert := range byPriority(alerts) {
+		if skew, skewed := alertSkew(alert, clock.Now()); skewed {
+			host := safeHostname(alert.Labels)
+			clockSkewTotal.add(host, 1)
+			slog.Warn("clock skew", "req_id", requestID(ctx), "alertname", alert.Labels["alertname"],
+				"host", host, "ahead", skew.Round(time.Second))
+			tracef(ctx, "skew", alert.fingerprint(), "startsAt %s ahead of local time", skew.Round(time.Second))
+		}
+		tracker.observe(tenant, alert, clock.Now())
+		heartbeat.observe(ctx, tenant, alert)
+		tracef(ctx, "track", alert.fingerprint(), "%s %s", alert.Labels["alertname"], safeValue(alert.Status, "firing"))
+		if dedup.duplicate(tenant, alert, clock.Now()) {
+			tracef(ctx, "dedup", alert.fingerprint(), "duplicate within %s, dropped", *dedupWindow)
+			continue
+		}
+		fp := alert.fingerprint()
+		alert = transformAlert(ctx, alert)
+		if level := escalations.observe(tenant, fp, alert, clock.Now()); level > 0 {
+			alert = withEscalation(alert, fp, level)
+			tracef(ctx, "escalate", fp, "level %d", level)
+		}
+		processed = append(processed, alert)
+	}
+	if !*dryRun {
+		recordAlerts(ctx, tenant, processed)
+	}
+	recentAlerts.add(ctx, tenant, processed)
+	fanOut(ctx, processed)
+}
+
+// transformAlert rewrites one alert's labels and annotations for the
+// sinks: filtering, normalization, thresholds, units, enrichment and
+// redaction. It has no side effects beyond tracing.
+func transformAlert(ctx context.Context, alert Alert) Alert {
+	ctx, span := startSpan(ctx, "transform", attribute.String("fingerprint", alert.Fingerprint))
+	defer span.End()
+	alert, droppedLabels, droppedAnnotations := filterAlert(alert)
+	if len(droppedLabels)+len(droppedAnnotations) > 0 {
+		tracef(ctx, "filter", alert.Fingerprint, "dropped labels %v, annotations %v", droppedLabels, droppedAnnotations)
+	}
+	alert, normalized := normalizeAlert(alert)
+	if len(normalized) > 0 {
+		tracef(ctx, "normalize", alert.Fingerprint, "normalized %v", normalized)
+	}
+	alert, sanitized := sanitizeAlert(alert)
+	if len(sanitized) > 0 {
+		sort.Strings(sanitized)
+		tracef(ctx, "sanitize", alert.Fingerprint, "sanitized %v", sanitized)
+	}
+	alert, breached := addBreachMargin(alert)
+	if breached {
+		tracef(ctx, "threshold", alert.Fingerprint, "threshold %s, margin %s (%s%%)", alert.Annotations["threshold"],
+			alert.Annotations["breach_margin"], safeValue(alert.Annotations["breach_margin_pct"], "n/a"))
+	}
+	alert, converted := convertUnits(alert)
+	if converted {
+		tracef(ctx, "units", alert.Fingerprint, "current_value %s -> %s",
+			alert.Annotations["current_value_raw"], alert.Annotations["current_value"])
+	}
+	alert, enriched := enrichAlert(alert)
+	if enriched {
+		tracef(ctx, "enrich", alert.Fingerprint, "hostname=%q site=%q region=%q",
+			alert.Labels["hostname"], alert.Labels["site"], alert.Labels["region"])
+	}
+	alert, inventoried := addInventory(alert)
+	if len(inventoried) > 0 {
+		sort.Strings(inventoried)
+		tracef(ctx, "inventory", alert.Fingerprint, "added labels %v", inventoried)
+	}
+	alert, redacted := redactAlert(alert)
+	if redacted > 0 {
+		tracef(ctx, "redact", alert.Fingerprint, "%d value(s) redacted", redacted)
+	}
+	return alert
+}
+
 /*
 =============================
- JSON Log Writer (Open → Append → Close)
+ JSON Log Writer
 =============================
 */
 
-func writeJSONLog(alert Alert) {
-	now := time.Now()
+func writeJSONLog(ctx context.Context, alert Alert) error {
+	now := clock.Now()
 
-	// Day-wise file name
-	fileName := "/var/log/app_hivemq_" + now.Format("20060102") + "0001.log"
+	// Day-wise file name, by the entry's own ts so that history readers,
+	// which pick files by date, find backfilled entries.
+	ts := entryTimestamp(alert, now)
+	rt := routeOf(ctx)
+	dir := rt.fileDir(tenantOf(ctx))
+	if dir != tenantDir(tenantOf(ctx)) {
+		if err := makeDir(dir); err != nil {
+			return err
+		}
+	}
+	fileName := writer.current(rt.fileNamer(), dir, ts)
 
-	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	fp := alert.fingerprint()
+	if !ts.Equal(now) {
+		tracef(ctx, "timestamp", fp, "ts from startsAt %s, received %s", ts.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+	entry := buildEntry(ctx, alert, now)
+	entry.Priority = isPriority(alert)
+	if entry.AckBy != "" {
+		tracef(ctx, "ack", fp, "marked as acknowledged by %s", entry.AckBy)
+	}
+
+	switch {
+	case alert.Status == "resolved":
+		repeats.forget(entry.Tenant, fp)
+	case entry.Priority:
+		tracef(ctx, "lane", fp, "priority lane, not aggregated")
+	case repeats.absorb(entry.Tenant, fp, fileName, entry):
+		tracef(ctx, "aggregate", fp, "repeat folded into the next consolidated entry")
+		return nil
+	}
+	return commitEntry(ctx, fp, fileName, entry, now)
+}
+
+// commitEntry hands a finished entry to the file sink and, once written,
+// to the search index and live feed.
+func commitEntry(ctx context.Context, fp, fileName string, entry JSONLog, now time.Time) error {
+	err := injectDrop()
+	if err == nil {
+		lanes.enter(entry.Priority)
+		err = appendEntry(fileName, entry)
+		lanes.leave()
+		laneEntries.add(laneName(entry.Priority), 1)
+	}
+	deliveries.record(delivery{
+		Fingerprint: fp,
+		RequestID:   entry.RequestID,
+		Tenant:      entry.Tenant,
+		Sink:        "file",
+		Target:      fileName,
+		At:          now,
+	}, err)
+	if errors.Is(err, errOversize) {
+		if dlErr := writeDeadLetter(deadLetterDir(entry.Tenant), deadLetter{
+			At:          now,
+			Reason:      err.Error(),
+			RequestID:   entry.RequestID,
+			Fingerprint: fp,
+			Sink:        sinkFile,
+			Tenant:      entry.Tenant,
+			Entry:       &entry,
+		}); dlErr == nil {
+			tracef(ctx, "sink", fp, "oversized entry diverted to the dead-letter file")
+			return nil
+		}
+	}
 	if err != nil {
-		return // fail silently (alert flow must not break)
+		slog.Warn("sink write failed", "sink", sinkFile, "target", fileName, "req_id", entry.RequestID, "fingerprint", fp, "err", err)
+		tracef(ctx, "sink", fp, "file %s: %v", fileName, err)
+		return err // the alert flow must not break
 	}
-	defer file.Close()
+	tracef(ctx, "sink", fp, "file %s: written", fileName)
+	rollups.tally(fileName, entry.Severity)
+
+	index.add(entry)
+	feed.publish(entry)
+	return nil
+}
 
+func buildEntry(ctx context.Context, alert Alert, now time.Time) JSONLog {
 	hostname := safeHostname(alert.Labels)
 	ip := safeIP(alert.Labels)
 
 	entry := JSONLog{
-		Timestamp: now.Format("2006-01-02 15:04"),
-		IP:        ip,
-		Hostname:  hostname,
-		KPI:       safeValue(alert.Labels["alertname"], "unknown"),
-		Value:     "1",
-		Count:     safeValue(alert.Annotations["current_value"], "NA"),
-		Summary:   safeValue(alert.Annotations["summary"], "no summary"),
+		Timestamp:  entryTimestamp(alert, now).Format(tsLayout),
+		IP:         ip,
+		Hostname:   hostname,
+		KPI:        safeValue(alert.Labels["alertname"], "unknown"),
+		Value:      safeValue(severityOf(alert).Value, "1"),
+		Count:      safeValue(alert.Annotations["current_value"], "NA"),
+		Summary:    safeValue(alert.Annotations["summary"], "no summary"),
+		Threshold:  alert.Annotations["threshold"],
+		Margin:     alert.Annotations["breach_margin"],
+		MarginPct:  alert.Annotations["breach_margin_pct"],
+		Site:       alert.Labels["site"],
+		Region:     alert.Labels["region"],
+		Datacenter: alert.Labels["datacenter"],
+		Rack:       alert.Labels["rack"],
+		OwnerTeam:  alert.Labels["owner_team"],
+		Escalation: alert.Labels["escalation_contact"],
+		Tenant:     tenantOf(ctx),
+		RequestID:  requestID(ctx),
+		Severity:   alert.Labels["severity"],
+	}
+	entry.EscalationLevel = escalationOf(alert)
+	entry.annotations = annotationText(alert)
+	if a, ok := tracker.ackFor(tenantOf(ctx), alert.fingerprint()); ok {
+		entry.AckBy = a.By
+		entry.AckAt = a.At.Format(tsLayout)
+	}
+	if s, ok := silences.silencing(tenantOf(ctx), alert.Labels, now); ok {
+		entry.Silenced = s.ID
+	}
+	if skew, skewed := alertSkew(alert, now); skewed {
+		entry.Skew = skew.Round(time.Second).String()
+	}
+	entry.RawPayload = recordPayload(ctx)
+	applyResolved(&entry, alert)
+	applyMapping(&entry, alert)
+	applySchema(&entry, alert)
+	if s := routeOf(ctx).schema; s != nil {
+		entry.custom = s.render(ctx, entry, alert)
+	}
+	return entry
+}
+
+func appendEntry(fileName string, entry JSONLog) error {
+	if err := injectSinkFault(); err != nil {
+		return err
 	}
 
-	enc := json.NewEncoder(file)
-	enc.SetEscapeHTML(false)
-	_ = enc.Encode(entry)
+	line, err := encodeEntry(entry)
+	if err != nil {
+		return err
+	}
+	line, err = fitEntry(entry, line)
+	if err != nil {
+		return err
+	}
+	return appendLine(fileName, line)
+}
+
+// appendLine seals, encrypts and appends one finished JSON line.
+func appendLine(fileName string, line []byte) error {
+	// The chain covers the plaintext line; encryption wraps the result.
+	if *integrityMode != "off" {
+		chain.mu.Lock()
+		defer chain.mu.Unlock()
+		line = chain.seal(fileName, line)
+	}
+	stored := line
+	if *encryptMode == "line" {
+		var err error
+		if stored, err = encryptLine(line); err != nil {
+			return err
+		}
+	}
+	if err := writer.write(fileName, append(stored, '\n')); err != nil {
+		return err
+	}
+	if *integrityMode != "off" {
+		chain.commit(fileName, line)
+	}
+	return nil
 }
 
 /*
@@ -148,18 +920,37 @@ func safeHostname(labels map[string]string) string {
 	return "unknown"
 }
 
+// safeIP is the host of the first address label the alert carries
+// (-address-labels).
 func safeIP(labels map[string]string) string {
-	instance, ok := labels["instance"]
-	if !ok || instance == "" {
-		return "NA"
+	if hosts := alertHosts(labels); len(hosts) > 0 {
+		return hosts[0]
 	}
+	return "NA"
+}
 
-	host, _, err := net.SplitHostPort(instance)
-	if err == nil {
+// instanceHost extracts the host from the forms an instance label takes:
+// host, host:port, IPv4, bare or bracketed IPv6 (with or without port or
+// zone), URLs and host:port/path.
+func instanceHost(instance string) string {
+	instance = strings.TrimSpace(instance)
+	if u, err := url.Parse(instance); err == nil && u.Scheme != "" && u.Host != "" {
+		instance = u.Host
+	} else if i := strings.IndexByte(instance, '/'); i >= 0 {
+		instance = instance[:i]
+	}
+	if host, _, err := net.SplitHostPort(instance); err == nil {
 		return host
 	}
-
-	return strings.Split(instance, ":")[0]
+	if strings.HasPrefix(instance, "[") && strings.HasSuffix(instance, "]") {
+		return instance[1 : len(instance)-1]
+	}
+	// Several colons without brackets can only be a bare IPv6 address.
+	if strings.Count(instance, ":") > 1 {
+		return instance
+	}
+	host, _, _ := strings.Cut(instance, ":")
+	return host
 }
 
 func safeValue(v string, fallback string) string {
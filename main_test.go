@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestInstanceHost(t *testing.T) {
+	tests := []struct {
+		name, instance, want string
+	}{
+		{"host:port", "broker-1:9399", "broker-1"},
+		{"bare host", "broker-1", "broker-1"},
+		{"IPv4:port", "10.0.0.7:9399", "10.0.0.7"},
+		{"bare IPv4", "10.0.0.7", "10.0.0.7"},
+		{"[v6]:port", "[2001:db8::1]:9399", "2001:db8::1"},
+		{"[v6]", "[2001:db8::1]", "2001:db8::1"},
+		{"bare v6", "2001:db8::1", "2001:db8::1"},
+		{"v6 with zone", "[fe80::1%eth0]:9399", "fe80::1%eth0"},
+		{"URL", "https://broker-1:8443/metrics", "broker-1"},
+		{"host:port/path", "broker-1:9399/metrics", "broker-1"},
+		{"padded", "  broker-1:9399 ", "broker-1"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := instanceHost(tt.instance); got != tt.want {
+				t.Errorf("instanceHost(%q) = %q, want %q", tt.instance, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Record Field Mapping
+=============================
+*/
+
+var recordMappingFile = flag.String("record-mapping", "", "YAML file mapping output fields (ip, hname, kpi, value, cnt, ...) to Go templates over the alert; unmapped fields keep the built-in mapping")
+
+// mappableFields are the record fields a mapping may define. The others
+// (ts, req_id, ack and integrity fields) are not derived from the alert
+// alone.
+var mappableFields = map[string]func(*JSONLog) *string{
+	"ip":                 func(e *JSONLog) *string { return &e.IP },
+	"hname":              func(e *JSONLog) *string { return &e.Hostname },
+	"kpi":                func(e *JSONLog) *string { return &e.KPI },
+	"value":              func(e *JSONLog) *string { return &e.Value },
+	"cnt":                func(e *JSONLog) *string { return &e.Count },
+	"app_sub_name":       func(e *JSONLog) *string { return &e.Summary },
+	"threshold":          func(e *JSONLog) *string { return &e.Threshold },
+	"margin":             func(e *JSONLog) *string { return &e.Margin },
+	"margin_pct":         func(e *JSONLog) *string { return &e.MarginPct },
+	"site":               func(e *JSONLog) *string { return &e.Site },
+	"region":             func(e *JSONLog) *string { return &e.Region },
+	"datacenter":         func(e *JSONLog) *string { return &e.Datacenter },
+	"rack":               func(e *JSONLog) *string { return &e.Rack },
+	"owner_team":         func(e *JSONLog) *string { return &e.OwnerTeam },
+	"escalation_contact": func(e *JSONLog) *string { return &e.Escalation },
+}
+
+type fieldMapping struct {
+	field string
+	tmpl  *texttemplate.Template
+}
+
+var recordMapping []fieldMapping
+
+// mappingFuncs adds the built-in mapping's helpers to the template
+// functions, so a mapping can fall back to them.
+var mappingFuncs = func() texttemplate.FuncMap {
+	funcs := texttemplate.FuncMap{}
+	for k, v := range templateFuncs {
+		funcs[k] = v
+	}
+	funcs["instanceHost"] = instanceHost
+	funcs["hostname"] = safeHostname
+	funcs["ip"] = safeIP
+	return funcs
+}()
+
+func loadRecordMapping() error {
+	recordMapping = nil
+	if *recordMappingFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*recordMappingFile)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]string
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%s: %w", *recordMappingFile, err)
+	}
+
+	var mapping []fieldMapping
+	for field, src := range cfg {
+		if mappableFields[field] == nil {
+			names := make([]string, 0, len(mappableFields))
+			for name := range mappableFields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s: %q cannot be mapped (want one of %s)", *recordMappingFile, field, strings.Join(names, ", "))
+		}
+		t, err := texttemplate.New(field).Funcs(mappingFuncs).Option("missingkey=zero").Parse(src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *recordMappingFile, err)
+		}
+		mapping = append(mapping, fieldMapping{field, t})
+	}
+	sort.Slice(mapping, func(i, j int) bool { return mapping[i].field < mapping[j].field })
+	recordMapping = mapping
+	return nil
+}
+
+// applyMapping overrides the mapped fields. A template that fails leaves
+// the built-in value in place.
+func applyMapping(entry *JSONLog, alert Alert) {
+	for _, m := range recordMapping {
+		var buf bytes.Buffer
+		if err := m.tmpl.Execute(&buf, alert); err != nil {
+			slog.Warn("record mapping", "field", m.field, "err", err)
+			continue
+		}
+		*mappableFields[m.field](entry) = strings.TrimSpace(buf.String())
+	}
+}
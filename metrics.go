@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Metrics (Prometheus text format)
+=============================
+*/
+
+const metricsPrefix = "alertbridge_"
+
+// counterVec is a counter partitioned by a single label.
+type counterVec struct {
+	name, help, label string
+
+	mu     sync.Mutex
+	values map[string]uint64
+}
+
+// collector is anything that can render itself on /metrics.
+type collector interface {
+	writeTo(w http.ResponseWriter)
+}
+
+var (
+	metricsMu sync.Mutex
+	registry  []collector
+)
+
+func register(c collector) {
+	metricsMu.Lock()
+	registry = append(registry, c)
+	metricsMu.Unlock()
+}
+
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{name: metricsPrefix + name, help: help, label: label, values: make(map[string]uint64)}
+	register(c)
+	return c
+}
+
+func (c *counterVec) add(labelValue string, n int) {
+	if n <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.values[labelValue] += uint64(n)
+	c.mu.Unlock()
+}
+
+func (c *counterVec) writeTo(w http.ResponseWriter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %d\n", c.name, c.label, k, c.values[k])
+	}
+}
+
+// gaugeFunc is a gauge whose values are read at scrape time.
+type gaugeFunc struct {
+	name, help, label string
+	read              func() map[string]float64
+}
+
+func newGaugeFunc(name, help, label string, read func() map[string]float64) *gaugeFunc {
+	g := &gaugeFunc{name: metricsPrefix + name, help: help, label: label, read: read}
+	register(g)
+	return g
+}
+
+func (g *gaugeFunc) writeTo(w http.ResponseWriter) {
+	values := g.read()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", g.name, g.label, k, values[k])
+	}
+}
+
+// histogramVec is a histogram partitioned by a single label.
+type histogramVec struct {
+	name, help, label string
+	buckets           []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Latency buckets in seconds.
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5}
+
+func newHistogramVec(name, help, label string, buckets []float64) *histogramVec {
+	h := &histogramVec{name: metricsPrefix + name, help: help, label: label, buckets: buckets, series: make(map[string]*histogram)}
+	register(h)
+	return h
+}
+
+func (h *histogramVec) observe(labelValue string, v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.series[labelValue]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[labelValue] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *histogramVec) writeTo(w http.ResponseWriter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		var cum uint64
+		for i, le := range h.buckets {
+			cum += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket{%s=%q,le=%q} %d\n", h.name, h.label, k, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(w, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", h.name, h.label, k, s.count)
+		fmt.Fprintf(w, "%s_sum{%s=%q} %g\n", h.name, h.label, k, s.sum)
+		fmt.Fprintf(w, "%s_count{%s=%q} %d\n", h.name, h.label, k, s.count)
+	}
+}
+
+var (
+	alertsReceived  = newCounterVec("alerts_received_total", "Alerts accepted from webhook and remote-write requests.", "status")
+	deliveryFailed  = newCounterVec("delivery_failures_total", "Failed sink writes; the alert flow carries on without them.", "sink")
+	handlerDuration = newHistogramVec("http_request_duration_seconds", "Time spent handling HTTP requests.", "handler", latencyBuckets)
+	_               = newGaugeFunc("last_delivery_timestamp_seconds", "Unix time of the last successful write per sink.", "sink", deliveries.lastSuccess)
+)
+
+// countReceived tallies alerts by status as they enter the pipeline.
+func countReceived(alerts []Alert) {
+	for _, a := range alerts {
+		alertsReceived.add(safeValue(a.Status, "unknown"), 1)
+	}
+}
+
+// withMetrics times every request under the route pattern that serves it,
+// so path parameters do not multiply the series.
+func withMetrics(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tail" {
+			next.ServeHTTP(w, r) // streams until the client leaves
+			return
+		}
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		_, pattern := mux.Handler(r)
+		handlerDuration.observe(safeValue(pattern, "unmatched"), time.Since(start).Seconds())
+	})
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, c := range registry {
+		c.writeTo(w)
+	}
+}
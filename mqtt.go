@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+/*
+=============================
+ MQTT Republish
+=============================
+*/
+
+var (
+	mqttBroker       = flag.String("mqtt-broker", "", "broker to republish alerts to, e.g. tcp://localhost:1883 or ssl://hivemq:8883; empty disables MQTT")
+	mqttTopic        = flag.String("mqtt-topic", "alerts/{severity}/{alertname}", "topic per alert; {label} is replaced by the alert's label value")
+	mqttQoS          = flag.Int("mqtt-qos", 1, "publish QoS (0, 1 or 2)")
+	mqttRetain       = flag.Bool("mqtt-retain", false, "publish with the retained flag, so new subscribers get the latest alert per topic")
+	mqttClientID     = flag.String("mqtt-client-id", "alertbridge", "MQTT client identifier")
+	mqttUser         = flag.String("mqtt-user", "", "MQTT username; empty connects anonymously")
+	mqttPasswordFile = flag.String("mqtt-password-file", "", "file holding the MQTT password")
+	mqttCAFile       = flag.String("mqtt-ca-file", "", "PEM CA bundle for verifying an ssl:// broker; empty uses the system roots")
+	mqttCertFile     = flag.String("mqtt-cert-file", "", "PEM client certificate for ssl:// brokers that require one")
+	mqttKeyFile      = flag.String("mqtt-key-file", "", "PEM key of -mqtt-cert-file")
+	mqttTimeout      = flag.Duration("mqtt-timeout", 5*time.Second, "deadline for connecting and for one publish to be acknowledged")
+)
+
+const mqttSink = "mqtt"
+
+var (
+	mqttMu     sync.Mutex
+	mqttOpts   *mqtt.ClientOptions
+	mqttClient mqtt.Client
+)
+
+var topicPlaceholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// alertMessage is the JSON form of one processed alert for the MQTT and
+// webhook sinks: the alert after the pipeline, plus where it came from.
+type alertMessage struct {
+	Alert
+	Tenant    string `json:"tenant,omitempty"`
+	RequestID string `json:"req_id,omitempty"`
+}
+
+func mqttEnabled() bool {
+	return *mqttBroker != ""
+}
+
+// loadMQTT checks the MQTT settings. A reload drops the current connection;
+// the next publish connects with the new settings.
+func loadMQTT() error {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+		mqttClient = nil
+	}
+	mqttOpts = nil
+	if !mqttEnabled() {
+		return nil
+	}
+	if *mqttQoS < 0 || *mqttQoS > 2 {
+		return fmt.Errorf("invalid -mqtt-qos %d", *mqttQoS)
+	}
+	if strings.ContainsAny(*mqttTopic, "+#") {
+		return fmt.Errorf("-mqtt-topic %q must not contain wildcards", *mqttTopic)
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(*mqttBroker).
+		SetClientID(*mqttClientID).
+		SetConnectTimeout(*mqttTimeout).
+		SetWriteTimeout(*mqttTimeout).
+		SetAutoReconnect(true).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("mqtt connection lost", "broker", *mqttBroker, "err", err)
+		})
+	if *mqttUser != "" {
+		opts.SetUsername(*mqttUser)
+	}
+	if *mqttPasswordFile != "" {
+		data, err := os.ReadFile(*mqttPasswordFile)
+		if err != nil {
+			return err
+		}
+		opts.SetPassword(strings.TrimSpace(string(data)))
+	}
+	if *mqttCAFile != "" || *mqttCertFile != "" {
+		conf, err := mqttTLSConfig()
+		if err != nil {
+			return err
+		}
+		opts.SetTLSConfig(conf)
+	}
+	mqttOpts = opts
+	return nil
+}
+
+func mqttTLSConfig() (*tls.Config, error) {
+	conf := &tls.Config{MinVersion: tls.VersionTLS12}
+	if *mqttCAFile != "" {
+		pem, err := os.ReadFile(*mqttCAFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", *mqttCAFile)
+		}
+	}
+	if *mqttCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(*mqttCertFile, *mqttKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// mqttConnection connects on first use, so a broker that is down at
+// start-up does not keep the bridge from starting.
+func mqttConnection() (mqtt.Client, error) {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttOpts == nil {
+		return nil, errors.New("mqtt is not configured")
+	}
+	if mqttClient == nil {
+		mqttClient = mqtt.NewClient(mqttOpts)
+	}
+	if mqttClient.IsConnectionOpen() {
+		return mqttClient, nil
+	}
+	tok := mqttClient.Connect()
+	if !tok.WaitTimeout(*mqttTimeout) {
+		return nil, fmt.Errorf("connecting to %s: timed out", *mqttBroker)
+	}
+	if err := tok.Error(); err != nil {
+		return nil, fmt.Errorf("connecting to %s: %w", *mqttBroker, err)
+	}
+	return mqttClient, nil
+}
+
+// mqttTopicFor fills the topic template from the alert's labels, with
+// {severity} taken from -severity-map when it names a topic. Values cannot
+// add topic levels or wildcards.
+func mqttTopicFor(a Alert) string {
+	return topicPlaceholder.ReplaceAllStringFunc(*mqttTopic, func(m string) string {
+		name := m[1 : len(m)-1]
+		v := safeValue(a.Labels[name], "unknown")
+		if t := severityOf(a).Topic; name == "severity" && t != "" {
+			v = t
+		}
+		return strings.NewReplacer("/", "_", "+", "_", "#", "_").Replace(v)
+	})
+}
+
+// notifyMQTT publishes every processed alert, each to its own topic.
+func notifyMQTT(ctx context.Context, alerts []Alert) error {
+	if !mqttEnabled() {
+		return nil
+	}
+	var errs []error
+	for _, a := range alerts {
+		topic := mqttTopicFor(a)
+		err := publishMQTT(topic, alertMessage{Alert: a, Tenant: tenantOf(ctx), RequestID: requestID(ctx)})
+		errs = append(errs, recordDelivery(ctx, mqttSink, topic, a, err))
+	}
+	return errors.Join(errs...)
+}
+
+func publishMQTT(topic string, msg alertMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return breakerFor(mqttSink).call(func() error {
+		client, err := mqttConnection()
+		if err != nil {
+			return err
+		}
+		tok := client.Publish(topic, byte(*mqttQoS), *mqttRetain, payload)
+		if !tok.WaitTimeout(*mqttTimeout) {
+			return errors.New("publish timed out")
+		}
+		return tok.Error()
+	})
+}
+
+func closeMQTT() {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+		mqttClient = nil
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Field Normalization
+=============================
+*/
+
+var normalizeRulesFile = flag.String("normalize-rules", "", "YAML file of per-field normalization steps for label and annotation values")
+
+// normalizeConfig lists, per label or annotation name, the steps applied
+// in order. Aliases map a spelling (after the preceding steps) to its
+// canonical name and are used by the "alias" step.
+type normalizeConfig struct {
+	Labels      map[string][]string `yaml:"labels"`
+	Annotations map[string][]string `yaml:"annotations"`
+	Aliases     map[string]string   `yaml:"aliases"`
+}
+
+var normalizeSteps = map[string]func(string) string{
+	"trim":           strings.TrimSpace,
+	"lowercase":      strings.ToLower,
+	"uppercase":      strings.ToUpper,
+	"collapse_space": func(s string) string { return strings.Join(strings.Fields(s), " ") },
+	"strip_domain":   stripDomain,
+	"alias":          canonicalAlias,
+}
+
+var normalization normalizeConfig
+
+func loadNormalizeRules() error {
+	normalization = normalizeConfig{}
+	if *normalizeRulesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*normalizeRulesFile)
+	if err != nil {
+		return err
+	}
+	var cfg normalizeConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("%s: %w", *normalizeRulesFile, err)
+	}
+	for kind, fields := range map[string]map[string][]string{"labels": cfg.Labels, "annotations": cfg.Annotations} {
+		for field, steps := range fields {
+			for _, step := range steps {
+				if normalizeSteps[step] == nil {
+					return fmt.Errorf("%s: %s.%s: unknown step %q", *normalizeRulesFile, kind, field, step)
+				}
+			}
+		}
+	}
+	normalization = cfg
+	return nil
+}
+
+// stripDomain keeps the first DNS label of a hostname, leaving IPs and
+// host:port values untouched.
+func stripDomain(s string) string {
+	if net.ParseIP(s) != nil || strings.Contains(s, ":") {
+		return s
+	}
+	host, _, _ := strings.Cut(s, ".")
+	return host
+}
+
+func canonicalAlias(s string) string {
+	if c, ok := normalization.Aliases[s]; ok {
+		return c
+	}
+	return s
+}
+
+// normalizeAlert applies the configured steps and returns the names of the
+// fields whose value changed.
+func normalizeAlert(a Alert) (Alert, []string) {
+	if len(normalization.Labels) == 0 && len(normalization.Annotations) == 0 {
+		return a, nil
+	}
+	var changed []string
+	a.Labels = normalizeValues(a.Labels, normalization.Labels, "labels.", &changed)
+	a.Annotations = normalizeValues(a.Annotations, normalization.Annotations, "annotations.", &changed)
+	return a, changed
+}
+
+func normalizeValues(m map[string]string, rules map[string][]string, prefix string, changed *[]string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		orig := v
+		for _, step := range rules[k] {
+			v = normalizeSteps[step](v)
+		}
+		if v != orig {
+			*changed = append(*changed, prefix+k)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+/*
+=============================
+ OpenTelemetry Tracing
+=============================
+*/
+
+var (
+	otlpEndpoint    = flag.String("otlp-endpoint", "", "OTLP/HTTP collector receiving spans of the alert pipeline, e.g. http://otel-collector:4318 (path /v1/traces unless given); empty disables tracing")
+	otelServiceName = flag.String("otel-service-name", "hivemq-alert-logger", "service.name of the exported spans")
+	otelSampleRatio = flag.Float64("otel-sample-ratio", 1, "fraction (0..1) of requests traced when the caller's traceparent did not decide it")
+)
+
+var tracer = otel.Tracer("github.com/akmanon/hivemq-email-template")
+
+// tracerProvider is nil unless -otlp-endpoint is set; the global provider
+// then stays the no-op one.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing starts exporting spans. Trace context headers are taken
+// from requests and passed on to webhooks either way, so a trace that
+// runs through the bridge stays whole even when it is not recorded here.
+func setupTracing() error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if *otlpEndpoint == "" {
+		return nil
+	}
+	if *otelSampleRatio < 0 || *otelSampleRatio > 1 {
+		return fmt.Errorf("-otel-sample-ratio must be between 0 and 1")
+	}
+	endpoint := *otlpEndpoint
+	if u, err := url.Parse(endpoint); err == nil && strings.Trim(u.Path, "/") == "" {
+		endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return fmt.Errorf("-otlp-endpoint: %w", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", *otelServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(*otelSampleRatio))),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	return nil
+}
+
+// shutdownTracing sends the spans still buffered.
+func shutdownTracing(ctx context.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	return tracerProvider.Shutdown(ctx)
+}
+
+// withSpan runs a handler in a server span, continuing the caller's trace.
+func withSpan(name string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", r.RemoteAddr),
+				attribute.String("req_id", requestID(ctx)),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next(rec, r.WithContext(ctx))
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, strconv.Itoa(status))
+		}
+	}
+}
+
+// startSpan starts a span for one stage of the pipeline.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a stage, marking it failed if err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func spanRecording(ctx context.Context) bool {
+	return trace.SpanFromContext(ctx).IsRecording()
+}
+
+// spanAttrs annotates the current span.
+func spanAttrs(ctx context.Context, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).SetAttributes(attrs...)
+}
+
+// spanEvent adds a decision of the pipeline to the current span, so the
+// decision trace shows up in the tracing backend too.
+func spanEvent(ctx context.Context, stage, fingerprint, message string) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{attribute.String("message", message)}
+	if fingerprint != "" {
+		attrs = append(attrs, attribute.String("fingerprint", fingerprint))
+	}
+	span.AddEvent(stage, trace.WithAttributes(attrs...))
+}
+
+// injectTrace passes the trace on to an outgoing request.
+func injectTrace(ctx context.Context, req *http.Request) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
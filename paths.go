@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+/*
+=============================
+ Output Paths & Preflight
+=============================
+*/
+
+var (
+	logDirMode  = flag.String("log-dir-mode", "0755", "permissions, in octal, of the directories created under -log-dir and -dead-letter-dir")
+	logFileMode = flag.String("log-file-mode", "0644", "permissions, in octal, of the day, report and dead-letter files created; the umask does not apply")
+	logOwner    = flag.String("log-owner", "", "user[:group], by name or ID, the created directories and files are given; needs the right to chown, not on Windows")
+	preflight   = flag.String("preflight", "warn", "at start-up, create the output directories and check they take a file: warn logs those that do not, fail refuses to start, off skips the check")
+)
+
+// Set from -log-dir-mode, -log-file-mode and -log-owner by loadFileModes.
+var (
+	dirMode  fs.FileMode = 0755
+	fileMode fs.FileMode = 0644
+	ownerUID             = -1
+	ownerGID             = -1
+)
+
+// defaultLogDir is -log-dir's default: /var/log for root, as before, and
+// otherwise a directory the user may write, so the bridge runs without
+// privileges and on Windows out of the box.
+func defaultLogDir() string {
+	if runtime.GOOS == "windows" {
+		base := os.Getenv("ProgramData")
+		if base == "" {
+			base = `C:\ProgramData`
+		}
+		return filepath.Join(base, "alertbridge", "logs")
+	}
+	if os.Geteuid() == 0 {
+		return "/var/log"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "alertbridge")
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(home, "Library", "Logs", "alertbridge")
+	}
+	if state := os.Getenv("XDG_STATE_HOME"); filepath.IsAbs(state) {
+		return filepath.Join(state, "alertbridge")
+	}
+	return filepath.Join(home, ".local", "state", "alertbridge")
+}
+
+func loadFileModes() error {
+	dm, err := parseMode("-log-dir-mode", *logDirMode)
+	if err != nil {
+		return err
+	}
+	fm, err := parseMode("-log-file-mode", *logFileMode)
+	if err != nil {
+		return err
+	}
+	if dm&0700 != 0700 {
+		return fmt.Errorf("-log-dir-mode %s must leave the owner rwx", *logDirMode)
+	}
+	if fm&0600 != 0600 {
+		return fmt.Errorf("-log-file-mode %s must leave the owner rw", *logFileMode)
+	}
+	uid, gid, err := lookupOwner(*logOwner)
+	if err != nil {
+		return err
+	}
+	dirMode, fileMode, ownerUID, ownerGID = dm, fm, uid, gid
+	return nil
+}
+
+func parseMode(name, s string) (fs.FileMode, error) {
+	m, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || m > 0777 {
+		return 0, fmt.Errorf("invalid %s %q, want octal permissions like 0750", name, s)
+	}
+	return fs.FileMode(m), nil
+}
+
+// lookupOwner resolves -log-owner; -1 keeps the process's own user or
+// group, as os.Chown does.
+func lookupOwner(s string) (uid, gid int, err error) {
+	if s == "" {
+		return -1, -1, nil
+	}
+	if runtime.GOOS == "windows" {
+		return -1, -1, errors.New("-log-owner is not supported on Windows; set the directory's ACL instead")
+	}
+	name, group, hasGroup := strings.Cut(s, ":")
+	uid, gid = -1, -1
+	if name != "" {
+		if uid, err = strconv.Atoi(name); err != nil {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return -1, -1, fmt.Errorf("-log-owner: %w", err)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+			if !hasGroup {
+				gid, _ = strconv.Atoi(u.Gid)
+			}
+		}
+	}
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return -1, -1, fmt.Errorf("-log-owner: %w", err)
+			}
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid, nil
+}
+
+// makeDir creates dir and the parents it lacks with -log-dir-mode, giving
+// each to -log-owner. Directories that exist are left as they are.
+func makeDir(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := makeDir(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, dirMode); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Chmod(dir, dirMode); err != nil {
+		return err
+	}
+	return chownCreated(dir)
+}
+
+// openLogFile opens an output file, creating it with -log-file-mode and
+// -log-owner when flag has O_CREATE and it does not exist yet.
+func openLogFile(path string, flag int) (*os.File, error) {
+	_, statErr := os.Lstat(path)
+	f, err := os.OpenFile(path, flag, fileMode)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && errors.Is(statErr, fs.ErrNotExist) {
+		err = f.Chmod(fileMode)
+		if err == nil {
+			err = chownCreated(path)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func chownCreated(path string) error {
+	if ownerUID < 0 && ownerGID < 0 {
+		return nil
+	}
+	if err := os.Chown(path, ownerUID, ownerGID); err != nil {
+		return fmt.Errorf("-log-owner: %w", err)
+	}
+	return nil
+}
+
+// probeDir checks that dir takes a new file.
+func probeDir(dir string) error {
+	f, err := os.CreateTemp(dir, ".probe-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write([]byte("alertbridge\n"))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+func validPreflight() error {
+	switch *preflight {
+	case "warn", "fail", "off":
+		return nil
+	}
+	return fmt.Errorf("invalid -preflight %q, want warn, fail or off", *preflight)
+}
+
+// preflightDirs creates the directories output goes to and writes a file
+// to each, so a wrong -log-dir or missing rights show at start-up rather
+// than as failed alerts.
+func preflightDirs() error {
+	if *preflight == "off" {
+		return nil
+	}
+	dirs := []string{}
+	seen := map[string]bool{}
+	add := func(dir string) {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, tenant := range allTenants() {
+		add(tenantDir(tenant))
+		add(deadLetterDir(tenant))
+		if archiveEnabled() {
+			add(archiveTenantDir(tenant))
+		}
+	}
+	add(*spoolDir)
+
+	var errs []error
+	for _, dir := range dirs {
+		err := makeDir(dir)
+		if err == nil {
+			err = probeDir(dir)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+			slog.Error("preflight: output directory not writable", "dir", dir, "err", err,
+				"hint", "set -log-dir to a directory this user may write, or create it with the right owner")
+		}
+	}
+	if len(errs) == 0 {
+		slog.Info("preflight: output directories writable", "log_dir", logDir, "dirs", len(dirs))
+		return nil
+	}
+	if *preflight == "fail" {
+		return fmt.Errorf("preflight: %w", errors.Join(errs...))
+	}
+	return nil
+}
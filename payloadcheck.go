@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Payload Limits and Diagnostics
+=============================
+*/
+
+var maxBodyBytes = flag.Int64("max-body-bytes", 10<<20, "largest webhook request body accepted, as sent and once gunzipped, answered with 413 beyond it; 0 disables the limit")
+
+var payloadRejected = newCounterVec("payload_rejected_total", "Webhook requests rejected as too large or malformed, by reason.", "reason")
+
+const (
+	rejectTooLarge = "too_large"
+	rejectRead     = "read_error"
+	rejectEncoding = "unsupported_encoding"
+	rejectJSON     = "invalid_json"
+	rejectSchema   = "schema"
+)
+
+func validBodyLimit() error {
+	if *maxBodyBytes < 0 {
+		return fmt.Errorf("-max-body-bytes must not be negative")
+	}
+	return nil
+}
+
+// payloadProblem is one violation of the webhook schema, at a JSON path
+// such as alerts[2].labels.severity.
+type payloadProblem struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// payloadError is the JSON body of a rejected webhook request.
+type payloadError struct {
+	Error     string           `json:"error"`
+	Message   string           `json:"message"`
+	Problems  []payloadProblem `json:"problems,omitempty"`
+	RequestID string           `json:"request_id,omitempty"`
+}
+
+// readBody reads the request body up to -max-body-bytes, gunzipped.
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := bodyReader(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(body)
+}
+
+// encodingError is a Content-Encoding the bridge cannot decode.
+type encodingError string
+
+func (e encodingError) Error() string {
+	return fmt.Sprintf("unsupported Content-Encoding %q, want gzip or identity", string(e))
+}
+
+// bodyReader returns the request body, gunzipped when Alertmanager or a
+// proxy in front compressed it. Chunked bodies need nothing here, net/http
+// undoes the chunking. -max-body-bytes bounds the body both as sent and
+// gunzipped, so a small request cannot unpack into gigabytes.
+func bodyReader(w http.ResponseWriter, r *http.Request) (io.Reader, error) {
+	var body io.Reader = r.Body
+	if *maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, *maxBodyBytes)
+	}
+	switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); enc {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip body: %w", err)
+		}
+		if *maxBodyBytes > 0 {
+			return &gunzipLimit{r: gz, left: *maxBodyBytes}, nil
+		}
+		return gz, nil
+	default:
+		return nil, encodingError(enc)
+	}
+}
+
+// gunzipLimit fails like http.MaxBytesReader once more than -max-body-bytes
+// came out of the decompressor.
+type gunzipLimit struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *gunzipLimit) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		n, l.left = int(l.left), 0
+		return n, &http.MaxBytesError{Limit: *maxBodyBytes}
+	}
+	l.left -= int64(n)
+	return n, err
+}
+
+// readRejectReason is the rejection reason of a body that could not be
+// read.
+func readRejectReason(err error) string {
+	var tooLarge *http.MaxBytesError
+	var enc encodingError
+	switch {
+	case errors.As(err, &tooLarge):
+		return rejectTooLarge
+	case errors.As(err, &enc):
+		return rejectEncoding
+	}
+	return rejectRead
+}
+
+// rejectPayload answers a request whose body could not be used, saying
+// why in JSON.
+func rejectPayload(w http.ResponseWriter, reqID, reason string, err error, problems []payloadProblem) {
+	payloadRejected.add(reason, 1)
+	code := http.StatusBadRequest
+	msg := err.Error()
+	var tooLarge *http.MaxBytesError
+	var enc encodingError
+	var syntax *json.SyntaxError
+	switch {
+	case errors.As(err, &tooLarge):
+		code = http.StatusRequestEntityTooLarge
+		msg = fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit)
+	case errors.As(err, &enc):
+		code = http.StatusUnsupportedMediaType
+	case errors.As(err, &syntax):
+		msg = fmt.Sprintf("invalid JSON at offset %d: %v", syntax.Offset, err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payloadError{Error: reason, Message: msg, Problems: problems, RequestID: reqID})
+}
+
+// checkPayload validates the structure of an Alertmanager webhook body:
+// an object with an alerts array whose items carry string labels and
+// annotations, a known status and RFC 3339 times. Fields outside the
+// schema are left to -decode-mode.
+func checkPayload(data []byte) ([]payloadProblem, error) {
+	var doc any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var problems []payloadProblem
+	problem := func(path, format string, args ...any) {
+		problems = append(problems, payloadProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	root, ok := doc.(map[string]any)
+	if !ok {
+		problem("$", "expected an object, got %s", schemaKind(doc))
+		return problems, nil
+	}
+	checkGroupFields(root, problem)
+
+	alerts, ok := root["alerts"]
+	if !ok {
+		problem("alerts", "missing")
+		return problems, nil
+	}
+	items, ok := alerts.([]any)
+	if !ok {
+		problem("alerts", "expected an array, got %s", schemaKind(alerts))
+		return problems, nil
+	}
+	for i, item := range items {
+		checkAlertItem(fmt.Sprintf("alerts[%d]", i), item, problem)
+	}
+	return problems, nil
+}
+
+// checkGroupFields checks the fields of a payload besides alerts.
+func checkGroupFields(root map[string]any, problem func(string, string, ...any)) {
+	for _, field := range []string{"version", "groupKey", "status", "receiver", "externalURL"} {
+		if v, ok := root[field]; ok && !isString(v) {
+			problem(field, "expected a string, got %s", schemaKind(v))
+		}
+	}
+	if s, ok := root["status"].(string); ok && s != "firing" && s != "resolved" {
+		problem("status", "expected firing or resolved, got %q", s)
+	}
+	for _, field := range []string{"groupLabels", "commonLabels", "commonAnnotations"} {
+		if v, ok := root[field]; ok {
+			checkStringMap(v, field, problem)
+		}
+	}
+}
+
+// checkAlertItem checks one item of alerts, found at path.
+func checkAlertItem(path string, item any, problem func(string, string, ...any)) {
+	a, ok := item.(map[string]any)
+	if !ok {
+		problem(path, "expected an object, got %s", schemaKind(item))
+		return
+	}
+	if s, ok := a["status"]; ok && s != "firing" && s != "resolved" {
+		problem(path+".status", "expected firing or resolved, got %s", schemaValue(s))
+	}
+	for _, field := range []string{"labels", "annotations"} {
+		if v, ok := a[field]; ok {
+			checkStringMap(v, path+"."+field, problem)
+		}
+	}
+	for _, field := range []string{"startsAt", "endsAt"} {
+		v, ok := a[field]
+		if !ok {
+			continue
+		}
+		s, isStr := v.(string)
+		if !isStr {
+			problem(path+"."+field, "expected an RFC 3339 time, got %s", schemaKind(v))
+		} else if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+			problem(path+"."+field, "expected an RFC 3339 time, got %q", s)
+		}
+	}
+	for _, field := range []string{"generatorURL", "fingerprint"} {
+		if v, ok := a[field]; ok && !isString(v) {
+			problem(path+"."+field, "expected a string, got %s", schemaKind(v))
+		}
+	}
+}
+
+func checkStringMap(v any, path string, problem func(string, string, ...any)) {
+	if v == nil {
+		return
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		problem(path, "expected an object, got %s", schemaKind(v))
+		return
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !isString(m[k]) {
+			problem(path+"."+k, "expected a string, got %s", schemaKind(m[k]))
+		}
+	}
+}
+
+func isString(v any) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+func schemaKind(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	}
+	return jsonKind(v)
+}
+
+func schemaValue(v any) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return schemaKind(v)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    []string // paths of the problems
+		wantErr bool
+	}{
+		{"valid", `{"version":"4","status":"firing","alerts":[{"status":"firing","labels":{"alertname":"A"},"startsAt":"2026-10-15T10:00:00Z"}]}`, nil, false},
+		{"no alerts", `{"status":"firing","alerts":[]}`, nil, false},
+		{"not an object", `[]`, []string{"$"}, false},
+		{"alerts missing", `{"status":"firing"}`, []string{"alerts"}, false},
+		{"alerts not an array", `{"alerts":{}}`, []string{"alerts"}, false},
+		{"bad group status", `{"status":"pending","alerts":[]}`, []string{"status"}, false},
+		{"group label not a string", `{"commonLabels":{"a":1},"alerts":[]}`, []string{"commonLabels.a"}, false},
+		{"item not an object", `{"alerts":[1]}`, []string{"alerts[0]"}, false},
+		{"bad item status", `{"alerts":[{"status":"ok"}]}`, []string{"alerts[0].status"}, false},
+		{"label not a string", `{"alerts":[{"labels":{"b":true,"a":1}}]}`, []string{"alerts[0].labels.a", "alerts[0].labels.b"}, false},
+		{"bad startsAt", `{"alerts":[{"startsAt":"yesterday"}]}`, []string{"alerts[0].startsAt"}, false},
+		{"endsAt not a string", `{"alerts":[{"endsAt":0}]}`, []string{"alerts[0].endsAt"}, false},
+		{"group fields first", `{"alerts":[{"fingerprint":1}],"receiver":2}`, []string{"receiver", "alerts[0].fingerprint"}, false},
+		{"invalid JSON", `{"alerts":[`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems, err := checkPayload([]byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPayload error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := problemPaths(problems); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("problems at %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func problemPaths(problems []payloadProblem) []string {
+	var paths []string
+	for _, p := range problems {
+		paths = append(paths, p.Path)
+	}
+	return paths
+}
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestGunzipLimit(t *testing.T) {
+	defer func(v int64) { *maxBodyBytes = v }(*maxBodyBytes)
+	*maxBodyBytes = 1000
+
+	tests := []struct {
+		name     string
+		size     int
+		wantRead int
+		tooLarge bool
+	}{
+		{"under", 999, 999, false},
+		{"at", 1000, 1000, false},
+		{"over", 1001, 1000, true},
+		{"far over", 1 << 20, 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := gzipped(t, strings.Repeat("a", tt.size))
+			r := httptest.NewRequest("POST", "/alerts", bytes.NewReader(body))
+			r.Header.Set("Content-Encoding", "gzip")
+			in, err := bodyReader(httptest.NewRecorder(), r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := in.(*gunzipLimit); !ok {
+				t.Fatalf("bodyReader returned %T, want *gunzipLimit", in)
+			}
+			data, err := io.ReadAll(in)
+			if len(data) != tt.wantRead {
+				t.Errorf("read %d bytes, want %d", len(data), tt.wantRead)
+			}
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) != tt.tooLarge {
+				t.Errorf("error = %v, want too large %v", err, tt.tooLarge)
+			}
+			if tt.tooLarge && readRejectReason(err) != rejectTooLarge {
+				t.Errorf("reject reason %q, want %q", readRejectReason(err), rejectTooLarge)
+			}
+		})
+	}
+}
+
+func TestBodyReaderEncoding(t *testing.T) {
+	tests := []struct {
+		encoding string
+		reason   string // of a failing reader, empty when it works
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", ""},
+		{" X-GZIP ", ""},
+		{"br", rejectEncoding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			body := []byte(`{}`)
+			if strings.Contains(strings.ToLower(tt.encoding), "gzip") {
+				body = gzipped(t, `{}`)
+			}
+			r := httptest.NewRequest("POST", "/alerts", bytes.NewReader(body))
+			r.Header.Set("Content-Encoding", tt.encoding)
+			in, err := bodyReader(httptest.NewRecorder(), r)
+			if tt.reason != "" {
+				if err == nil || readRejectReason(err) != tt.reason {
+					t.Fatalf("error = %v, want reason %q", err, tt.reason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := io.ReadAll(in); err != nil || string(data) != `{}` {
+				t.Errorf("read %q, %v; want {}", data, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+=============================
+ Priority Lanes
+=============================
+*/
+
+var prioritySeverities = flag.String("priority-severities", "critical",
+	"comma-separated severities that bypass aggregation and are written ahead of other traffic; empty disables")
+
+var laneEntries = newCounterVec("lane_entries_total", "Sink writes attempted per priority lane.", "lane")
+
+func isPriority(a Alert) bool {
+	sev := strings.ToLower(strings.TrimSpace(a.Labels["severity"]))
+	if sev == "" {
+		return false
+	}
+	for _, s := range strings.Split(*prioritySeverities, ",") {
+		if strings.ToLower(strings.TrimSpace(s)) == sev {
+			return true
+		}
+	}
+	return false
+}
+
+// byPriority orders a batch so priority alerts are handled first; the
+// order within each lane is kept.
+func byPriority(alerts []Alert) []Alert {
+	out := slices.Clone(alerts)
+	slices.SortStableFunc(out, func(a, b Alert) int {
+		pa, pb := isPriority(a), isPriority(b)
+		switch {
+		case pa && !pb:
+			return -1
+		case pb && !pa:
+			return 1
+		}
+		return 0
+	})
+	return out
+}
+
+func laneName(priority bool) string {
+	if priority {
+		return "priority"
+	}
+	return "normal"
+}
+
+// laneGate serialises sink writes. When it is released, waiting priority
+// writers always go before normal ones, so a page is not stuck behind a
+// storm of lower-severity entries from other requests.
+type laneGate struct {
+	mu              sync.Mutex
+	cond            *sync.Cond
+	busy            bool
+	waitingPriority int
+}
+
+var lanes = newLaneGate()
+
+func newLaneGate() *laneGate {
+	g := &laneGate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+func (g *laneGate) enter(priority bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if priority {
+		g.waitingPriority++
+		defer func() { g.waitingPriority-- }()
+	}
+	for g.busy || (!priority && g.waitingPriority > 0) {
+		g.cond.Wait()
+	}
+	g.busy = true
+}
+
+func (g *laneGate) leave() {
+	g.mu.Lock()
+	g.busy = false
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
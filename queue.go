@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+=============================
+ Write Queue & Workers
+=============================
+*/
+
+var (
+	queueDepth   = flag.Int("queue-depth", 1000, "alert batches buffered per lane between the webhook and the writers")
+	queueWorkers = flag.Int("queue-workers", 4, "workers running the pipeline and sinks for queued batches")
+	queueFull    = flag.String("queue-full", "block", "when a lane is full: block (hold the request until there is room) or reject (503, Alertmanager retries)")
+	drainTimeout = flag.Duration("drain-timeout", 30*time.Second, "how long shutdown waits for queued batches to be written")
+)
+
+var errQueueFull = errors.New("write queue full")
+
+// writeJob is one batch of a request; its context keeps the request id,
+// tenant and trace but is detached from the request's lifetime.
+type writeJob struct {
+	ctx    context.Context
+	tenant string
+	alerts []Alert
+}
+
+// writeQueue has a lane per priority; workers always empty the priority
+// lane first. Batches of the same lane may complete out of order when
+// more than one worker runs.
+type writeQueue struct {
+	mu       sync.Mutex
+	closed   bool
+	priority chan writeJob
+	normal   chan writeJob
+	stop     chan struct{}
+	wg       sync.WaitGroup
+
+	// abort cancels the batches in flight once the drain deadline passed.
+	abort       context.Context
+	abortNow    context.CancelFunc
+	flushed     atomic.Int64
+	interrupted atomic.Int64
+}
+
+var queue *writeQueue
+
+var _ = newGaugeFunc("queue_depth", "Alert batches waiting in the write queue.", "lane", func() map[string]float64 {
+	if queue == nil {
+		return nil
+	}
+	return map[string]float64{
+		laneName(true):  float64(len(queue.priority)),
+		laneName(false): float64(len(queue.normal)),
+	}
+})
+
+func validQueueOptions() error {
+	if *queueDepth < 1 || *queueWorkers < 1 {
+		return fmt.Errorf("-queue-depth and -queue-workers must be at least 1")
+	}
+	if *queueFull != "block" && *queueFull != "reject" {
+		return fmt.Errorf("invalid -queue-full %q", *queueFull)
+	}
+	return nil
+}
+
+func startQueue() *writeQueue {
+	q := &writeQueue{
+		priority: make(chan writeJob, *queueDepth),
+		normal:   make(chan writeJob, *queueDepth),
+		stop:     make(chan struct{}),
+	}
+	q.abort, q.abortNow = context.WithCancel(context.Background())
+	for range *queueWorkers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// enqueue hands a request's alerts to the workers, split by lane. With
+// -queue-full=reject nothing is queued unless every part fits, so a retried
+// request is never written twice.
+func (q *writeQueue) enqueue(ctx context.Context, tenant string, alerts []Alert) error {
+	var jobs []writeJob
+	var lanes []chan writeJob
+	var urgent, rest []Alert
+	for _, a := range alerts {
+		if isPriority(a) {
+			urgent = append(urgent, a)
+		} else {
+			rest = append(rest, a)
+		}
+	}
+	detached := context.WithoutCancel(ctx)
+	if len(urgent) > 0 {
+		jobs, lanes = append(jobs, writeJob{detached, tenant, urgent}), append(lanes, q.priority)
+	}
+	if len(rest) > 0 {
+		jobs, lanes = append(jobs, writeJob{detached, tenant, rest}), append(lanes, q.normal)
+	}
+
+	if *queueFull == "reject" {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if q.closed {
+			return errQueueFull
+		}
+		for _, lane := range lanes {
+			if len(lane) == cap(lane) {
+				return errQueueFull
+			}
+		}
+		for i, job := range jobs {
+			lanes[i] <- job
+		}
+		return nil
+	}
+
+	// Workers take the settings lock, and a pending reload stops them from
+	// getting it while this request still holds it. A streamed request goes
+	// on decoding afterwards, under the lock again.
+	releaseConfig(ctx)
+	defer holdConfig(ctx)
+	for i, job := range jobs {
+		select {
+		case lanes[i] <- job:
+		case <-q.stop:
+			return errQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (q *writeQueue) work() {
+	defer q.wg.Done()
+	for {
+		job, ok := q.next()
+		if !ok {
+			return
+		}
+		ctx, cancel := context.WithCancel(job.ctx)
+		stop := context.AfterFunc(q.abort, cancel)
+		configMu.RLock()
+		processAlerts(ctx, job.tenant, job.alerts)
+		configMu.RUnlock()
+		stop()
+		cancel()
+		q.count(job)
+	}
+}
+
+// count notes a batch finished during a drain.
+func (q *writeQueue) count(job writeJob) {
+	select {
+	case <-q.stop:
+	default:
+		return
+	}
+	if q.abort.Err() != nil {
+		q.interrupted.Add(int64(len(job.alerts)))
+	} else {
+		q.flushed.Add(int64(len(job.alerts)))
+	}
+}
+
+// next prefers the priority lane. After drain has begun it only returns
+// what is already queued, and nothing once the drain was aborted.
+func (q *writeQueue) next() (writeJob, bool) {
+	if q.abort.Err() != nil {
+		return writeJob{}, false
+	}
+	select {
+	case job := <-q.priority:
+		return job, true
+	default:
+	}
+	select {
+	case job := <-q.priority:
+		return job, true
+	case job := <-q.normal:
+		return job, true
+	case <-q.stop:
+	}
+	select {
+	case job := <-q.priority:
+		return job, true
+	default:
+	}
+	select {
+	case job := <-q.normal:
+		return job, true
+	default:
+		return writeJob{}, false
+	}
+}
+
+// drain stops intake and waits for the queued batches, up to -drain-timeout
+// or the end of ctx. Then the batches in flight are cancelled, so their
+// retries give up and dead-letter, and what is still queued is abandoned.
+func (q *writeQueue) drain(ctx context.Context) drainStats {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+	q.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, *drainTimeout)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("shutdown: drain deadline passed, cancelling batches in flight", "queued", len(q.priority)+len(q.normal))
+		q.abortNow()
+		select {
+		case <-done:
+		case <-time.After(abortGrace):
+			slog.Warn("shutdown: workers still busy", "after", abortGrace)
+		}
+	}
+
+	stats := drainStats{flushed: int(q.flushed.Load()), interrupted: int(q.interrupted.Load())}
+	for _, lane := range []chan writeJob{q.priority, q.normal} {
+		for len(lane) > 0 {
+			select {
+			case job := <-lane:
+				stats.abandoned += len(job.alerts)
+				slog.Warn("shutdown: batch abandoned", "req_id", requestID(job.ctx), "tenant", job.tenant, "alerts", len(job.alerts))
+			default:
+			}
+		}
+	}
+	return stats
+}
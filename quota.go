@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Per-Source Quotas
+=============================
+*/
+
+var (
+	quotaPerHour = flag.Int("quota-per-hour", 0, "entries accepted per source per clock hour; 0 disables quotas")
+	quotaBy      = flag.String("quota-by", "tenant", "what a quota is counted against: ip, path or tenant")
+	quotaAction  = flag.String("quota-action", "reject", "over quota: reject (429) or summarize (one QuotaExceeded entry per source and hour)")
+)
+
+var (
+	quotaRejected   = newCounterVec("quota_rejected_requests_total", "Requests rejected with 429 for being over quota.", "source")
+	quotaSuppressed = newCounterVec("quota_suppressed_alerts_total", "Alerts folded into a QuotaExceeded summary.", "source")
+)
+
+func validQuotaOptions() error {
+	switch *quotaBy {
+	case "ip", "path", "tenant":
+	default:
+		return fmt.Errorf("invalid -quota-by %q", *quotaBy)
+	}
+	if *quotaAction != "reject" && *quotaAction != "summarize" {
+		return fmt.Errorf("invalid -quota-action %q", *quotaAction)
+	}
+	return nil
+}
+
+func quotaSource(r *http.Request, tenant string) string {
+	switch *quotaBy {
+	case "ip":
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			return host
+		}
+		return r.RemoteAddr
+	case "path":
+		return r.URL.Path
+	}
+	return safeValue(tenant, "default")
+}
+
+// quotaSlot is where suppressed alerts are summarised: a source can post
+// for several tenants, and each tenant gets its own summary entry.
+type quotaSlot struct {
+	source, tenant string
+}
+
+type quotaLedger struct {
+	mu         sync.Mutex
+	window     time.Time
+	used       map[string]int
+	suppressed map[quotaSlot]int
+}
+
+var quotas = &quotaLedger{used: make(map[string]int), suppressed: make(map[quotaSlot]int)}
+
+// admit splits a batch into the alerts within the source's quota and the
+// number over it. ok is false when the whole request should be refused.
+// Priority alerts neither count against nor are held back by a quota, and
+// a batch carrying one is summarised rather than refused.
+func (q *quotaLedger) admit(source, tenant string, alerts []Alert) (admitted []Alert, over int, ok bool) {
+	if *quotaPerHour <= 0 {
+		return alerts, 0, true
+	}
+	q.rollover(clock.Now())
+
+	normal, priority := 0, 0
+	for _, a := range alerts {
+		if isPriority(a) {
+			priority++
+		} else {
+			normal++
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	remaining := max(*quotaPerHour-q.used[source], 0)
+	if normal <= remaining {
+		q.used[source] += normal
+		return alerts, 0, true
+	}
+	if *quotaAction == "reject" && priority == 0 {
+		quotaRejected.add(source, 1)
+		return nil, 0, false
+	}
+
+	over = normal - remaining
+	q.used[source] = *quotaPerHour
+	q.suppressed[quotaSlot{source, tenant}] += over
+	quotaSuppressed.add(source, over)
+	for _, a := range alerts {
+		if isPriority(a) || remaining > 0 {
+			if !isPriority(a) {
+				remaining--
+			}
+			admitted = append(admitted, a)
+		}
+	}
+	return admitted, over, true
+}
+
+// retryAfter is the time left until the current quota window closes.
+func (q *quotaLedger) retryAfter() time.Duration {
+	now := clock.Now()
+	return now.Truncate(time.Hour).Add(time.Hour).Sub(now)
+}
+
+// rollover starts a new window once the clock hour changes and writes the
+// summaries for the one that closed.
+func (q *quotaLedger) rollover(now time.Time) {
+	window := now.Truncate(time.Hour)
+
+	q.mu.Lock()
+	if q.window.Equal(window) {
+		q.mu.Unlock()
+		return
+	}
+	closed, suppressed := q.window, q.suppressed
+	q.window = window
+	q.used = make(map[string]int)
+	q.suppressed = make(map[quotaSlot]int)
+	q.mu.Unlock()
+
+	for slot, n := range suppressed {
+		writeQuotaSummary(closed, slot, n)
+	}
+}
+
+// flush writes the summaries of the current window early, on shutdown.
+func (q *quotaLedger) flush() {
+	q.mu.Lock()
+	window, suppressed := q.window, q.suppressed
+	q.suppressed = make(map[quotaSlot]int)
+	q.mu.Unlock()
+
+	for slot, n := range suppressed {
+		writeQuotaSummary(window, slot, n)
+	}
+}
+
+// writeQuotaSummary records the suppressed alerts of one source as a single
+// synthetic alert, so it goes through the same writer as everything else.
+func writeQuotaSummary(window time.Time, slot quotaSlot, n int) {
+	alert := Alert{
+		Status: "firing",
+		Labels: map[string]string{
+			"alertname": "QuotaExceeded",
+			"severity":  "warning",
+			"quota_by":  *quotaBy,
+			"source":    slot.source,
+			"window":    window.Format(time.RFC3339),
+		},
+		Annotations: map[string]string{
+			"summary": fmt.Sprintf("%d alert(s) from %s %s over the quota of %d/h between %s and %s",
+				n, *quotaBy, slot.source, *quotaPerHour, window.Format("15:04"), window.Add(time.Hour).Format("15:04")),
+			"current_value": strconv.Itoa(n),
+		},
+		StartsAt: window,
+	}
+	slog.Info("quota: alerts summarised", "alerts", n, *quotaBy, slot.source, "window", window.Format(time.RFC3339))
+	writeJSONLog(withTenantValue(context.Background(), slot.tenant), alert)
+}
+
+func runQuotas(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			withSettings(func() { quotas.rollover(clock.Now()) })
+		}
+	}
+}
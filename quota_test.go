@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// quotaBatch makes a batch from a pattern of w (warning) and c (critical).
+func quotaBatch(pattern string) []Alert {
+	var alerts []Alert
+	for i, c := range pattern {
+		sev := "warning"
+		if c == 'c' {
+			sev = "critical"
+		}
+		alerts = append(alerts, Alert{Labels: map[string]string{"alertname": string(rune('A' + i)), "severity": sev}})
+	}
+	return alerts
+}
+
+func TestQuotaAdmit(t *testing.T) {
+	defer func(limit int, action string, c Clock) {
+		*quotaPerHour, *quotaAction, clock = limit, action, c
+	}(*quotaPerHour, *quotaAction, clock)
+	clock = fixedClock(time.Date(2026, 10, 15, 10, 30, 0, 0, time.UTC))
+
+	type step struct {
+		source, batch string
+		want          string // admitted alertnames, "refused" when not ok
+		wantOver      int
+	}
+	tests := []struct {
+		name   string
+		limit  int
+		action string
+		steps  []step
+	}{
+		{"off", 0, "reject", []step{{"a", "wwwww", "ABCDE", 0}}},
+		{"within", 3, "reject", []step{{"a", "ww", "AB", 0}, {"a", "w", "A", 0}}},
+		{"rejected whole", 3, "reject", []step{{"a", "ww", "AB", 0}, {"a", "ww", "refused", 0}, {"a", "w", "A", 0}, {"a", "w", "refused", 0}}},
+		{"sources apart", 2, "reject", []step{{"a", "ww", "AB", 0}, {"b", "ww", "AB", 0}, {"a", "w", "refused", 0}}},
+		{"summarized", 3, "summarize", []step{{"a", "ww", "AB", 0}, {"a", "www", "A", 2}, {"a", "w", "", 1}}},
+		{"priority not counted", 1, "reject", []step{{"a", "cccw", "ABCD", 0}, {"a", "c", "A", 0}}},
+		{"priority never held back", 1, "reject", []step{{"a", "w", "A", 0}, {"a", "c", "A", 0}}},
+		{"priority carries the batch", 1, "reject", []step{{"a", "wcw", "AB", 1}, {"a", "w", "refused", 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*quotaPerHour, *quotaAction = tt.limit, tt.action
+			q := &quotaLedger{used: make(map[string]int), suppressed: make(map[quotaSlot]int)}
+			for i, s := range tt.steps {
+				admitted, over, ok := q.admit(s.source, "", quotaBatch(s.batch))
+				got := "refused"
+				if ok {
+					var names []string
+					for _, a := range admitted {
+						names = append(names, a.Labels["alertname"])
+					}
+					got = strings.Join(names, "")
+				}
+				if got != s.want || over != s.wantOver {
+					t.Errorf("step %d: admitted %q, %d over; want %q, %d over", i+1, got, over, s.want, s.wantOver)
+				}
+			}
+		})
+	}
+}
+
+func TestQuotaSource(t *testing.T) {
+	defer func(by string) { *quotaBy = by }(*quotaBy)
+
+	tests := []struct {
+		by, tenant, want string
+	}{
+		{"ip", "", "192.0.2.7"},
+		{"path", "", "/alerts/team-a"},
+		{"tenant", "team-a", "team-a"},
+		{"tenant", "", "default"},
+	}
+	for _, tt := range tests {
+		*quotaBy = tt.by
+		r := httptest.NewRequest("POST", "/alerts/team-a", nil)
+		r.RemoteAddr = "192.0.2.7:51234"
+		if got := quotaSource(r, tt.tenant); got != tt.want {
+			t.Errorf("-quota-by=%s: source %q, want %q", tt.by, got, tt.want)
+		}
+	}
+}
+
+func TestQuotaRetryAfter(t *testing.T) {
+	defer func(c Clock) { clock = c }(clock)
+	clock = fixedClock(time.Date(2026, 10, 15, 10, 45, 0, 0, time.UTC))
+	if got := quotas.retryAfter(); got != 15*time.Minute {
+		t.Errorf("retryAfter = %s, want the rest of the hour", got)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Rate Limiting
+=============================
+*/
+
+var (
+	ratePerIP   = flag.Float64("rate-per-ip", 0, "webhook requests per second accepted from one client IP, answered with 429 beyond it; 0 disables the limit")
+	burstPerIP  = flag.Int("rate-per-ip-burst", 20, "requests one client IP may send at once before -rate-per-ip applies")
+	rateGlobal  = flag.Float64("rate-global", 0, "webhook requests per second accepted from all clients together; 0 disables the limit")
+	burstGlobal = flag.Int("rate-global-burst", 100, "requests all clients together may send at once before -rate-global applies")
+)
+
+var rateLimited = newCounterVec("rate_limited_requests_total", "Webhook requests answered with 429 by the rate limiter, by the limit they hit.", "limit")
+
+// rateIdleTime is how long a client's bucket is kept after its last request.
+const rateIdleTime = 10 * time.Minute
+
+func validRateOptions() error {
+	if *ratePerIP < 0 || *rateGlobal < 0 {
+		return fmt.Errorf("-rate-per-ip and -rate-global must not be negative")
+	}
+	if *ratePerIP > 0 && *burstPerIP < 1 {
+		return fmt.Errorf("-rate-per-ip-burst must be at least 1")
+	}
+	if *rateGlobal > 0 && *burstGlobal < 1 {
+		return fmt.Errorf("-rate-global-burst must be at least 1")
+	}
+	return nil
+}
+
+// tokenBucket holds up to burst tokens and gains rate of them a second.
+// A request takes one.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill brings the bucket up to now and reports whether it holds a token.
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if now.After(b.last) {
+		b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	return b.tokens >= 1
+}
+
+// wait is how long until the bucket holds a token again.
+func (b *tokenBucket) wait(rate float64) time.Duration {
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	global tokenBucket
+	perIP  map[string]*tokenBucket
+	pruned time.Time
+}
+
+var limiter = &rateLimiter{perIP: make(map[string]*tokenBucket)}
+
+// allow takes a token from the client's bucket and the global one, or from
+// neither when either is empty, in which case it says which limit was hit
+// and when to retry.
+func (l *rateLimiter) allow(ip string) (ok bool, limit string, retry time.Duration) {
+	if *ratePerIP <= 0 && *rateGlobal <= 0 {
+		return true, "", 0
+	}
+	now := clock.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	var client *tokenBucket
+	if *ratePerIP > 0 {
+		client = l.perIP[ip]
+		if client == nil {
+			client = &tokenBucket{}
+			l.perIP[ip] = client
+		}
+		if !client.refill(now, *ratePerIP, *burstPerIP) {
+			return false, "ip", client.wait(*ratePerIP)
+		}
+	}
+	if *rateGlobal > 0 && !l.global.refill(now, *rateGlobal, *burstGlobal) {
+		return false, "global", l.global.wait(*rateGlobal)
+	}
+	if client != nil {
+		client.tokens--
+	}
+	if *rateGlobal > 0 {
+		l.global.tokens--
+	}
+	return true, "", 0
+}
+
+// prune forgets the clients that have been quiet for a while; their
+// buckets would be full again anyway.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < time.Minute {
+		return
+	}
+	l.pruned = now
+	for ip, b := range l.perIP {
+		if now.Sub(b.last) > rateIdleTime {
+			delete(l.perIP, ip)
+		}
+	}
+}
+
+// rateLimit answers 429 with a Retry-After when the request is over a
+// limit, before the body is read.
+func rateLimit(w http.ResponseWriter, r *http.Request) bool {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+	ok, limit, retry := limiter.allow(ip)
+	if ok {
+		return true
+	}
+	rateLimited.add(limit, 1)
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retry.Seconds())), 1)))
+	http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	defer func(perIP, global float64, burstIP, burstAll int, c Clock) {
+		*ratePerIP, *rateGlobal, *burstPerIP, *burstGlobal, clock = perIP, global, burstIP, burstAll, c
+	}(*ratePerIP, *rateGlobal, *burstPerIP, *burstGlobal, clock)
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+
+	type req struct {
+		ip        string
+		at        time.Duration
+		wantLimit string // "" when allowed
+	}
+	tests := []struct {
+		name             string
+		perIP, global    float64
+		burstIP, burstGl int
+		reqs             []req
+	}{
+		{"off", 0, 0, 1, 1, []req{{"a", 0, ""}, {"a", 0, ""}, {"a", 0, ""}}},
+		{"burst then limited", 1, 0, 2, 0, []req{{"a", 0, ""}, {"a", 0, ""}, {"a", 0, "ip"}}},
+		{"refills at the rate", 2, 0, 1, 0, []req{{"a", 0, ""}, {"a", 0, "ip"}, {"a", 500 * time.Millisecond, ""}, {"a", 700 * time.Millisecond, "ip"}}},
+		{"refill capped at burst", 10, 0, 2, 0, []req{{"a", 0, ""}, {"a", time.Hour, ""}, {"a", time.Hour, ""}, {"a", time.Hour, "ip"}}},
+		{"clients apart", 1, 0, 1, 0, []req{{"a", 0, ""}, {"b", 0, ""}, {"a", 0, "ip"}}},
+		{"global", 0, 1, 0, 2, []req{{"a", 0, ""}, {"b", 0, ""}, {"c", 0, "global"}}},
+		{"refused takes no token", 1, 1, 1, 2, []req{{"a", 0, ""}, {"a", 0, "ip"}, {"b", 0, ""}, {"c", 0, "global"}}},
+		{"global refusal spares the client", 1, 1, 1, 1, []req{{"a", 0, ""}, {"b", 0, "global"}, {"b", time.Second, ""}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*ratePerIP, *rateGlobal, *burstPerIP, *burstGlobal = tt.perIP, tt.global, tt.burstIP, tt.burstGl
+			l := &rateLimiter{perIP: make(map[string]*tokenBucket)}
+			for i, r := range tt.reqs {
+				clock = fixedClock(start.Add(r.at))
+				ok, limit, retry := l.allow(r.ip)
+				if ok != (r.wantLimit == "") || limit != r.wantLimit {
+					t.Fatalf("request %d: allow = %v, %q; want limit %q", i+1, ok, limit, r.wantLimit)
+				}
+				if !ok && retry <= 0 {
+					t.Errorf("request %d: retry after %s", i+1, retry)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimitResponse(t *testing.T) {
+	defer func(perIP float64, burst int, l *rateLimiter, c Clock) {
+		*ratePerIP, *burstPerIP, limiter, clock = perIP, burst, l, c
+	}(*ratePerIP, *burstPerIP, limiter, clock)
+	*ratePerIP, *burstPerIP = 0.25, 1
+	limiter = &rateLimiter{perIP: make(map[string]*tokenBucket)}
+	clock = fixedClock(time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC))
+
+	tests := []struct {
+		addr      string
+		wantOK    bool
+		wantRetry string
+	}{
+		{"192.0.2.7:1000", true, ""},
+		{"192.0.2.7:1001", false, "4"}, // another port is the same client
+		{"192.0.2.8:1000", true, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/alerts", nil)
+		r.RemoteAddr = tt.addr
+		w := httptest.NewRecorder()
+		if ok := rateLimit(w, r); ok != tt.wantOK {
+			t.Fatalf("%s: rateLimit = %v, want %v", tt.addr, ok, tt.wantOK)
+		}
+		if got := w.Header().Get("Retry-After"); got != tt.wantRetry {
+			t.Errorf("%s: Retry-After %q, want %q", tt.addr, got, tt.wantRetry)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+)
+
+/*
+=============================
+ Raw Payload Copies
+=============================
+*/
+
+var rawPayloadMode = flag.String("raw-payload", "off", "keep the Alertmanager request with the alerts, redacted by -redact-rules: off, email (gzip attachment; not on digests), log (raw_payload field, dropped from records over -max-entry-bytes) or both")
+
+func validRawPayloadMode() error {
+	switch *rawPayloadMode {
+	case "off", "email", "log", "both":
+		return nil
+	}
+	return fmt.Errorf("invalid -raw-payload %q, want off, email, log or both", *rawPayloadMode)
+}
+
+func rawPayloadIn(sink string) bool {
+	return *rawPayloadMode == sink || *rawPayloadMode == "both"
+}
+
+// copied is the payload as records and emails carry it: redacted like the
+// alerts and compacted. It is worked out once per request.
+func (p *rawPayload) copied() []byte {
+	p.copyOnce.Do(func() {
+		p.copy = redactPayload(p.body)
+	})
+	return p.copy
+}
+
+// redactPayload applies the redaction rules to every string in the JSON
+// body. A body that is not JSON is kept as a JSON string.
+func redactPayload(body []byte) []byte {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		s, _ := json.Marshal(redactString(string(body)))
+		return s
+	}
+	out, err := json.Marshal(redactTree(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactTree(v any) any {
+	switch v := v.(type) {
+	case string:
+		return redactString(v)
+	case map[string]any:
+		for k, x := range v {
+			v[k] = redactTree(x)
+		}
+	case []any:
+		for i, x := range v {
+			v[i] = redactTree(x)
+		}
+	}
+	return v
+}
+
+// redactString rewrites s like redactValues, without counting: the alerts
+// themselves are counted already.
+func redactString(s string) string {
+	for _, r := range redactRules {
+		s = r.re.ReplaceAllString(s, r.Replacement)
+	}
+	return s
+}
+
+// recordPayload is the raw_payload of the request's records, if asked for.
+func recordPayload(ctx context.Context) json.RawMessage {
+	p := rawPayloadOf(ctx)
+	if p == nil || !rawPayloadIn("log") {
+		return nil
+	}
+	return p.copied()
+}
+
+// payloadAttachment is the request gzipped for an email, if asked for.
+func payloadAttachment(ctx context.Context) *emailAttachment {
+	p := rawPayloadOf(ctx)
+	if p == nil || !rawPayloadIn("email") {
+		return nil
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Name = "alertmanager-payload.json"
+	gz.Write(p.copied())
+	if gz.Close() != nil {
+		return nil
+	}
+	name := "alertmanager-payload.json.gz"
+	if id := requestID(ctx); id != "" {
+		name = "alertmanager-payload-" + id + ".json.gz"
+	}
+	return &emailAttachment{
+		Name:        name,
+		ContentType: "application/gzip",
+		Data:        buf.Bytes(),
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+=============================
+ Readiness
+=============================
+*/
+
+var (
+	readyCheckTTL = flag.Duration("ready-cache", 10*time.Second, "how long /readyz reuses the result of its sink checks")
+	shutdownDelay = flag.Duration("shutdown-delay", 0, "on shutdown, report not-ready for this long before closing the listener, so load balancers stop sending traffic first")
+)
+
+// draining is set once shutdown starts; from then on /readyz fails.
+var draining atomic.Bool
+
+// readyChecker is a sink that can tell whether it is able to deliver.
+type readyChecker interface {
+	ready(ctx context.Context) error
+}
+
+type readyReport struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+var readiness struct {
+	mu  sync.Mutex
+	at  time.Time
+	rep readyReport
+}
+
+// readyHandler answers 503 while draining or while any enabled sink fails
+// its check. Checks dial out, so their result is cached for -ready-cache.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	rep := readyReport{Status: "not ready", Checks: map[string]string{"shutdown": "draining"}}
+	if !draining.Load() {
+		rep = checkReadiness(r.Context())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if rep.Status != "ready" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(rep)
+}
+
+func checkReadiness(ctx context.Context) readyReport {
+	readiness.mu.Lock()
+	defer readiness.mu.Unlock()
+	if now := clock.Now(); now.Sub(readiness.at) < *readyCheckTTL {
+		return readiness.rep
+	}
+
+	sinksMu.RLock()
+	set := sinkSet
+	sinksMu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rep := readyReport{Status: "ready", Checks: map[string]string{}}
+	for _, s := range set {
+		c, ok := s.(readyChecker)
+		if !ok {
+			continue
+		}
+		rep.Checks[s.Name()] = "ok"
+		if err := c.ready(ctx); err != nil {
+			rep.Checks[s.Name()] = err.Error()
+			rep.Status = "not ready"
+		}
+	}
+	readiness.at, readiness.rep = clock.Now(), rep
+	return rep
+}
+
+// ready checks that every output directory takes a new file.
+func (fileSink) ready(context.Context) error {
+	for _, tenant := range allTenants() {
+		if err := probeDir(tenantDir(tenant)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (emailOutput) ready(ctx context.Context) error {
+	addr := net.JoinHostPort(*smtpHost, fmt.Sprint(*smtpPort))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (mqttOutput) ready(context.Context) error {
+	_, err := mqttConnection()
+	return err
+}
+
+// beginShutdown flips readiness and gives load balancers -shutdown-delay
+// to notice before the listener closes.
+func beginShutdown() {
+	draining.Store(true)
+	time.Sleep(*shutdownDelay)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+)
+
+/*
+=============================
+ Per-Alert Email Recipients
+=============================
+*/
+
+var (
+	emailToAnnotation   = flag.String("email-to-annotation", "email_to", "annotation whose addresses replace an alert's email recipients (comma-separated); needs -email-allowed-domains")
+	emailCcAnnotation   = flag.String("email-cc-annotation", "email_cc", "annotation whose addresses are copied on an alert's email; needs -email-allowed-domains")
+	emailAllowedDomains = flag.String("email-allowed-domains", "", "comma-separated domains (.example.com also allows subdomains) the recipients taken from annotations and templated route email_to/email_cc may be at; empty ignores those annotations")
+)
+
+var recipientsRejected = newCounterVec("email_recipients_rejected_total", "Recipients from annotations or route templates left out for their domain, by source.", "source")
+
+func recipientOverrides() bool {
+	return *emailAllowedDomains != ""
+}
+
+// allowedRecipient reports whether addr's domain is on the allow-list.
+func allowedRecipient(addr string) bool {
+	_, domain, ok := strings.Cut(strings.ToLower(addr), "@")
+	if !ok {
+		return false
+	}
+	for _, d := range splitList(strings.ToLower(*emailAllowedDomains)) {
+		if domain == d || strings.HasPrefix(d, ".") && strings.HasSuffix(domain, d) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileRecipients splits a route's email_to or email_cc into fixed
+// addresses and templates run per alert, like '{{ .Labels.team }}@example.com'.
+func compileRecipients(field string, list []string) ([]string, []*texttemplate.Template, error) {
+	var static []string
+	var tmpls []*texttemplate.Template
+	for _, entry := range list {
+		if !strings.Contains(entry, "{{") {
+			static = append(static, entry)
+			continue
+		}
+		if !recipientOverrides() {
+			return nil, nil, fmt.Errorf("templated %s %q needs -email-allowed-domains", field, entry)
+		}
+		t, err := texttemplate.New(field).Funcs(templateFuncs).Option("missingkey=zero").Parse(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s %q: %w", field, entry, err)
+		}
+		tmpls = append(tmpls, t)
+	}
+	if len(static) > 0 {
+		if _, err := mail.ParseAddressList(strings.Join(static, ", ")); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", field, err)
+		}
+	}
+	return static, tmpls, nil
+}
+
+// dynamicRecipients parses the addresses an annotation or template gave
+// and keeps those on the allow-list.
+func dynamicRecipients(ctx context.Context, a Alert, source, value string) []string {
+	value = strings.ReplaceAll(value, ";", ",")
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	addrs, err := mail.ParseAddressList(value)
+	if err != nil {
+		recipientsRejected.add(source, 1)
+		slog.Warn("unusable email recipients", "source", source, "value", value, "fingerprint", a.fingerprint(), "err", err)
+		return nil
+	}
+	var out []string
+	for _, addr := range addrs {
+		if !allowedRecipient(addr.Address) {
+			recipientsRejected.add(source, 1)
+			slog.Warn("email recipient not in -email-allowed-domains, left out", "source", source, "recipient", addr.Address, "fingerprint", a.fingerprint())
+			continue
+		}
+		out = append(out, addr.String())
+	}
+	if len(out) > 0 {
+		tracef(ctx, emailSink, a.fingerprint(), "%s recipients %s", source, strings.Join(out, ", "))
+	}
+	return out
+}
+
+func templatedRecipients(ctx context.Context, a Alert, field string, tmpls []*texttemplate.Template) []string {
+	var out []string
+	for _, t := range tmpls {
+		var buf bytes.Buffer
+		if err := t.Execute(&buf, a); err != nil {
+			slog.Warn("rendering route "+field, "fingerprint", a.fingerprint(), "err", err)
+			continue
+		}
+		out = append(out, dynamicRecipients(ctx, a, "route", buf.String())...)
+	}
+	return out
+}
+
+// alertRecipients works out an alert's To and Cc: the alert's email_to
+// annotation replaces the route's recipients, its email_cc adds to the
+// route's email_cc. Without a usable To the route, tenant or -email-to
+// recipients apply.
+func (r *route) alertRecipients(ctx context.Context, a Alert) (to, cc []string) {
+	if recipientOverrides() {
+		to = dynamicRecipients(ctx, a, "annotation", a.Annotations[*emailToAnnotation])
+	}
+	if len(to) == 0 {
+		to = append(slices.Clone(r.staticTo), templatedRecipients(ctx, a, "email_to", r.toT)...)
+	}
+	if len(to) == 0 {
+		to = []string{r.recipients(tenantOf(ctx))}
+	}
+	cc = append(slices.Clone(r.staticCc), templatedRecipients(ctx, a, "email_cc", r.ccT)...)
+	if recipientOverrides() {
+		cc = append(cc, dynamicRecipients(ctx, a, "annotation", a.Annotations[*emailCcAnnotation])...)
+	}
+	return compactRecipients(to), compactRecipients(cc)
+}
+
+func compactRecipients(list []string) []string {
+	var out []string
+	for _, s := range list {
+		if s = strings.TrimSpace(s); s != "" && !slices.Contains(out, s) {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// emailPart is the alerts of one email: those sharing their recipients.
+type emailPart struct {
+	to, cc string
+	alerts []Alert
+}
+
+// emailParts splits a notification by recipients, keeping the alerts'
+// order. Without per-alert recipients it is the one email Alertmanager's
+// grouping made.
+func (r *route) emailParts(ctx context.Context, alerts []Alert) []emailPart {
+	var parts []emailPart
+	index := map[string]int{}
+	for _, a := range alerts {
+		to, cc := r.alertRecipients(ctx, a)
+		p := emailPart{to: strings.Join(to, ", "), cc: strings.Join(cc, ", ")}
+		key := p.to + "\x00" + p.cc
+		i, ok := index[key]
+		if !ok {
+			i = len(parts)
+			index[key] = i
+			parts = append(parts, p)
+		}
+		parts[i].alerts = append(parts[i].alerts, a)
+	}
+	return parts
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Custom Record Schemas
+=============================
+*/
+
+var recordSchemaFile = flag.String("record-schema", "", "YAML file laying out the records in other field names and order, with static and templated fields, for ingestors that expect their own format; routes can set their own with record_schema; empty writes the built-in record")
+
+// customSchema lays out the records of a route. Each field is a built-in
+// record field under another name, a static value or a template.
+//
+//	fields:
+//	  - {name: "@timestamp", field: ts}
+//	  - {name: source, static: hivemq}
+//	  - {name: priority, template: '{{if eq .Labels.severity "critical"}}1{{else}}3{{end}}', type: number}
+type customSchema struct {
+	path   string
+	Fields []schemaField `yaml:"fields"`
+}
+
+type schemaField struct {
+	Name      string `yaml:"name"`
+	Field     string `yaml:"field"`
+	Static    any    `yaml:"static"`
+	Template  string `yaml:"template"`
+	Type      string `yaml:"type"`
+	OmitEmpty bool   `yaml:"omit_empty"`
+
+	tmpl *texttemplate.Template
+}
+
+// schemaData is what schema templates see: the alert, as in -record-mapping,
+// and the built-in record.
+type schemaData struct {
+	Alert
+	Record map[string]any
+	Tenant string
+	Route  string
+}
+
+// customRecord is an entry's layout with its templates already rendered,
+// as they depend on the alert; the built-in fields are filled in when the
+// line is encoded, after aggregation and size limits had their say.
+type customRecord struct {
+	schema *customSchema
+	values []any
+}
+
+func parseRecordSchema(path string) (*customSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &customSchema{path: path}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(s.Fields) == 0 {
+		return nil, fmt.Errorf("%s: no fields defined", path)
+	}
+	seen := map[string]bool{}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Name == "" || seen[f.Name] {
+			return nil, fmt.Errorf("%s: field %d: missing or repeated name %q", path, i+1, f.Name)
+		}
+		seen[f.Name] = true
+		sources := 0
+		for _, set := range []bool{f.Field != "", f.Static != nil, f.Template != ""} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return nil, fmt.Errorf("%s: field %s: set exactly one of field, static and template", path, f.Name)
+		}
+		if f.Field != "" && !isRecordField(f.Field) {
+			return nil, fmt.Errorf("%s: field %s: %q is not a record field", path, f.Name, f.Field)
+		}
+		switch f.Type {
+		case "", "string", "number", "bool":
+		default:
+			return nil, fmt.Errorf("%s: field %s: invalid type %q, want string, number or bool", path, f.Name, f.Type)
+		}
+		if f.Type != "" && f.Template == "" {
+			return nil, fmt.Errorf("%s: field %s: type only applies to templates", path, f.Name)
+		}
+		if f.Template != "" {
+			t, err := texttemplate.New(f.Name).Funcs(mappingFuncs).Option("missingkey=zero").Parse(f.Template)
+			if err != nil {
+				return nil, fmt.Errorf("%s: field %s: %w", path, f.Name, err)
+			}
+			f.tmpl = t
+		}
+	}
+	if !s.keepsTimestamp() {
+		slog.Warn("record schema has no ts field; history, search and validate-output will skip its records", "schema", path)
+	}
+	return s, nil
+}
+
+// keepsTimestamp reports whether the records carry ts under its own name,
+// which is how the readers of the output files find and sort them.
+func (s *customSchema) keepsTimestamp() bool {
+	for _, f := range s.Fields {
+		if f.Name == "ts" && f.Field == "ts" {
+			return true
+		}
+	}
+	return false
+}
+
+func isRecordField(name string) bool {
+	for _, f := range recordFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// render runs the templates of the schema for one entry. A template that
+// fails is written as null.
+func (s *customSchema) render(ctx context.Context, entry JSONLog, alert Alert) *customRecord {
+	data := schemaData{Alert: alert, Record: builtinFields(entry), Tenant: tenantOf(ctx), Route: routeOf(ctx).Name}
+	c := &customRecord{schema: s, values: make([]any, len(s.Fields))}
+	for i, f := range s.Fields {
+		if f.tmpl == nil {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			slog.Warn("record schema", "schema", s.path, "field", f.Name, "err", err)
+			continue
+		}
+		v, err := typedValue(strings.TrimSpace(buf.String()), f.Type)
+		if err != nil {
+			slog.Warn("record schema", "schema", s.path, "field", f.Name, "err", err)
+			continue
+		}
+		c.values[i] = v
+	}
+	return c
+}
+
+func typedValue(s, typ string) (any, error) {
+	switch typ {
+	case "number":
+		if s == "" {
+			return nil, nil
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", s)
+		}
+		return json.Number(s), nil
+	case "bool":
+		if s == "" {
+			return nil, nil
+		}
+		return strconv.ParseBool(s)
+	}
+	return s, nil
+}
+
+// builtinFields is the entry as the built-in record has it.
+func builtinFields(entry JSONLog) map[string]any {
+	entry.custom = nil
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if dec.Decode(&m) != nil {
+		return nil
+	}
+	return m
+}
+
+// encode writes the record in the schema's order.
+func (c *customRecord) encode(entry JSONLog) ([]byte, error) {
+	builtin := builtinFields(entry)
+	if builtin == nil {
+		return nil, errors.New("record schema: cannot read the built-in record")
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	buf.WriteByte('{')
+	first := true
+	for i, f := range c.schema.Fields {
+		v := c.values[i]
+		switch {
+		case f.Field != "":
+			v = builtin[f.Field]
+		case f.Static != nil:
+			v = f.Static
+		}
+		if f.OmitEmpty && (v == nil || v == "") {
+			continue
+		}
+		if !first {
+			buf.WriteByte(',')
+		}
+		first = false
+		if err := enc.Encode(f.Name); err != nil {
+			return nil, err
+		}
+		buf.Truncate(buf.Len() - 1)
+		buf.WriteByte(':')
+		if err := enc.Encode(v); err != nil {
+			return nil, fmt.Errorf("record schema: field %s: %w", f.Name, err)
+		}
+		buf.Truncate(buf.Len() - 1)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
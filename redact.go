@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Redaction
+=============================
+*/
+
+var redactRulesFile = flag.String("redact-rules", "", "YAML file of regexp redaction rules applied to label and annotation values")
+
+type redactRule struct {
+	Name        string `yaml:"name"`
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+
+	re *regexp.Regexp
+}
+
+var (
+	redactRules []redactRule
+
+	redactionsTotal = newCounterVec("redactions_total", "Values rewritten by redaction rules.", "rule")
+)
+
+func loadRedactRules() error {
+	redactRules = nil
+	if *redactRulesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*redactRulesFile)
+	if err != nil {
+		return err
+	}
+	var rules []redactRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", *redactRulesFile, err)
+	}
+	for i := range rules {
+		r := &rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		if r.Replacement == "" {
+			r.Replacement = "[REDACTED]"
+		}
+		if r.re, err = regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%s: rule %q: %w", *redactRulesFile, r.Name, err)
+		}
+	}
+	redactRules = rules
+	return nil
+}
+
+// redactAlert rewrites matching label and annotation values before the alert
+// is written or sent anywhere, and returns how many values were changed.
+func redactAlert(a Alert) (Alert, int) {
+	if len(redactRules) == 0 {
+		return a, 0
+	}
+	total := 0
+	a.Labels, total = redactValues(a.Labels)
+	var n int
+	a.Annotations, n = redactValues(a.Annotations)
+	return a, total + n
+}
+
+func redactValues(m map[string]string) (map[string]string, int) {
+	out := make(map[string]string, len(m))
+	changed := 0
+	for k, v := range m {
+		for _, r := range redactRules {
+			if !r.re.MatchString(v) {
+				continue
+			}
+			v = r.re.ReplaceAllString(v, r.Replacement)
+			redactionsTotal.add(r.Name, 1)
+			changed++
+		}
+		out[k] = v
+	}
+	return out, changed
+}
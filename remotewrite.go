@@ -0,0 +1,459 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Prometheus Remote-Write Ingestion
+=============================
+*/
+
+const remoteWritePath = "/api/v1/write"
+
+var remoteWriteRules = flag.String("remote-write-rules", "",
+	"YAML threshold rules evaluated on metrics pushed to "+remoteWritePath+" (Prometheus agent remote_write); empty disables the endpoint")
+
+var remoteWriteSamples = newCounterVec("remote_write_samples_total", "Remote-write samples received, by whether a rule uses them.", "result")
+
+// rwRule is a local threshold rule: metric (optionally divided by another
+// metric with the same labels, then scaled) compared against a threshold
+// for a minimum duration.
+type rwRule struct {
+	Alert       string            `yaml:"alert"`
+	Metric      string            `yaml:"metric"`
+	DivideBy    string            `yaml:"divide_by"`
+	Scale       float64           `yaml:"scale"`
+	Match       map[string]string `yaml:"match"`
+	Op          string            `yaml:"op"`
+	Threshold   float64           `yaml:"threshold"`
+	For         string            `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+
+	forDur      time.Duration
+	annotations map[string]*template.Template
+}
+
+var rwOps = map[string]func(v, t float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+var (
+	rwRules   []*rwRule
+	rwMetrics = map[string]bool{}
+)
+
+func loadRemoteWriteRules() error {
+	rwRules, rwMetrics = nil, map[string]bool{}
+	if *remoteWriteRules == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*remoteWriteRules)
+	if err != nil {
+		return err
+	}
+	var rules []*rwRule
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", *remoteWriteRules, err)
+	}
+	metrics := map[string]bool{}
+
+	for i, r := range rules {
+		where := fmt.Sprintf("%s: rule %d (%s)", *remoteWriteRules, i+1, r.Alert)
+		if r.Alert == "" || r.Metric == "" {
+			return fmt.Errorf("%s: alert and metric are required", where)
+		}
+		if rwOps[r.Op] == nil {
+			return fmt.Errorf("%s: unknown op %q", where, r.Op)
+		}
+		if r.For != "" {
+			if r.forDur, err = time.ParseDuration(r.For); err != nil {
+				return fmt.Errorf("%s: for: %w", where, err)
+			}
+		}
+		if r.Scale == 0 {
+			r.Scale = 1
+		}
+		r.annotations = make(map[string]*template.Template, len(r.Annotations))
+		for k, text := range r.Annotations {
+			// Same variables as Prometheus alerting rule templates.
+			t, err := template.New(k).Option("missingkey=zero").Parse("{{$labels := .Labels}}{{$value := .Value}}" + text)
+			if err != nil {
+				return fmt.Errorf("%s: annotation %s: %w", where, k, err)
+			}
+			r.annotations[k] = t
+		}
+		metrics[r.Metric] = true
+		if r.DivideBy != "" {
+			metrics[r.DivideBy] = true
+		}
+	}
+	rwRules, rwMetrics = rules, metrics
+	slog.Info("remote write rules loaded", "rules", len(rules), "path", remoteWritePath)
+	return nil
+}
+
+/*
+=============================
+ Remote-Write Decoding (prometheus.WriteRequest, protocol 1.0)
+=============================
+*/
+
+type rwSample struct {
+	value float64
+	ts    time.Time
+}
+
+type rwSeries struct {
+	labels  map[string]string
+	samples []rwSample
+}
+
+var errRemoteWrite = errors.New("malformed remote-write request")
+
+func decodeWriteRequest(b []byte) ([]rwSeries, error) {
+	var out []rwSeries
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		s, err := decodeTimeSeries(v)
+		out = append(out, s)
+		return err
+	})
+	return out, err
+}
+
+func decodeTimeSeries(b []byte) (rwSeries, error) {
+	s := rwSeries{labels: map[string]string{}}
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, v []byte) error {
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			var name, value string
+			err := eachField(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.BytesType:
+					name = string(v)
+				case num == 2 && typ == protowire.BytesType:
+					value = string(v)
+				}
+				return nil
+			})
+			s.labels[name] = value
+			return err
+		case num == 2 && typ == protowire.BytesType:
+			var sample rwSample
+			err := eachField(v, func(num protowire.Number, typ protowire.Type, v []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					x, _ := protowire.ConsumeFixed64(v)
+					sample.value = math.Float64frombits(x)
+				case num == 2 && typ == protowire.VarintType:
+					x, _ := protowire.ConsumeVarint(v)
+					sample.ts = time.UnixMilli(int64(x))
+				}
+				return nil
+			})
+			s.samples = append(s.samples, sample)
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+// eachField walks one protobuf message. For length-delimited fields fn gets
+// the payload, for scalars the raw encoded value.
+func eachField(b []byte, fn func(protowire.Number, protowire.Type, []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return errRemoteWrite
+		}
+		b = b[n:]
+		size := protowire.ConsumeFieldValue(num, typ, b)
+		if size < 0 {
+			return errRemoteWrite
+		}
+		v := b[:size]
+		if typ == protowire.BytesType {
+			v, _ = protowire.ConsumeBytes(v)
+		}
+		if err := fn(num, typ, v); err != nil {
+			return err
+		}
+		b = b[size:]
+	}
+	return nil
+}
+
+/*
+=============================
+ Remote-Write Handler & Rule Evaluation
+=============================
+*/
+
+// rwState keeps the newest sample of every selected series and the
+// pending/firing state of every rule instance, per tenant.
+type rwState struct {
+	mu      sync.Mutex
+	latest  map[string]rwSample // tenant, metric and labels
+	active  map[string]time.Time
+	firing  map[string]bool
+	labelOf map[string]map[string]string
+}
+
+var remoteWrite = &rwState{
+	latest:  map[string]rwSample{},
+	active:  map[string]time.Time{},
+	firing:  map[string]bool{},
+	labelOf: map[string]map[string]string{},
+}
+
+// remoteWriteHandler takes the same credentials, rate limits and quotas as
+// POST /alerts. -max-body-bytes bounds the body both as sent and as the
+// snappy block says it decodes to, before anything is decoded.
+func remoteWriteHandler(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	if len(rwRules) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if !rateLimit(w, r) {
+		return
+	}
+	if err := authorize(w, r); err != nil {
+		refuse(w, err)
+		return
+	}
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		http.Error(w, "only snappy-encoded remote-write 1.0 is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "proto=io.prometheus.write.v2") {
+		http.Error(w, "only remote-write 1.0 is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if *maxBodyBytes > 0 {
+		body = http.MaxBytesReader(w, r.Body, *maxBodyBytes)
+	}
+	compressed, err := io.ReadAll(body)
+	if readRejectReason(err) == rejectTooLarge {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := snappy.DecodedLen(compressed)
+	if err != nil {
+		http.Error(w, "snappy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if *maxBodyBytes > 0 && int64(n) > *maxBodyBytes {
+		http.Error(w, "request body too large once decoded", http.StatusRequestEntityTooLarge)
+		return
+	}
+	raw, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, "snappy: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	series, err := decodeWriteRequest(raw)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx := startTrace(r)
+	tenant := tenantOf(ctx)
+	alerts := remoteWrite.ingest(tenant, series)
+	if len(alerts) > 0 {
+		tracef(ctx, "remote-write", "", "%d rule transition(s) from %d series", len(alerts), len(series))
+		countReceived(alerts)
+		source := quotaSource(r, tenant)
+		admitted, over, ok := quotas.admit(source, tenant, alerts)
+		if !ok {
+			// As below, the rule state has already moved on.
+			slog.Warn("remote write: transitions over quota dropped", "transitions", len(alerts), "source", source)
+			w.Header().Set("Retry-After", strconv.Itoa(int(quotas.retryAfter().Seconds())+1))
+			http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+		if over > 0 {
+			tracef(ctx, "quota", "", "%d transition(s) over quota summarised", over)
+		}
+		alerts = admitted
+		if err := queue.enqueue(ctx, tenant, alerts); err != nil {
+			// The rule state has already moved on; a retry would not
+			// produce these transitions again.
+			slog.Error("remote write: transitions dropped", "transitions", len(alerts), "err", err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func seriesKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		if k != "__name__" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + "=" + strconv.Quote(labels[k]) + ",")
+	}
+	return b.String()
+}
+
+// ingest stores the newest sample of each selected series and returns the
+// alerts for rule instances that started firing or resolved.
+func (s *rwState) ingest(tenant string, series []rwSeries) []Alert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, ts := range series {
+		name := ts.labels["__name__"]
+		if !rwMetrics[name] {
+			remoteWriteSamples.add("dropped", len(ts.samples))
+			continue
+		}
+		remoteWriteSamples.add("kept", len(ts.samples))
+		key := seriesKey(ts.labels)
+		for _, sample := range ts.samples {
+			id := tenant + "\x00" + name + "\x00" + key
+			if prev, ok := s.latest[id]; !ok || !sample.ts.Before(prev.ts) {
+				s.latest[id] = sample
+				s.labelOf[id] = ts.labels
+			}
+		}
+	}
+
+	var alerts []Alert
+	prefix := tenant + "\x00"
+	for id, sample := range s.latest {
+		if !strings.HasPrefix(id, prefix) {
+			continue
+		}
+		labels := s.labelOf[id]
+		for i, rule := range rwRules {
+			if labels["__name__"] != rule.Metric || !matchLabels(labels, rule.Match) {
+				continue
+			}
+			value := sample.value
+			if rule.DivideBy != "" {
+				den, ok := s.latest[prefix+rule.DivideBy+"\x00"+seriesKey(labels)]
+				if !ok || den.value == 0 {
+					continue
+				}
+				value /= den.value
+			}
+			value *= rule.Scale
+
+			inst := fmt.Sprintf("%s%d\x00%s", prefix, i, seriesKey(labels))
+			if a, ok := s.step(inst, rule, labels, value, sample.ts); ok {
+				alerts = append(alerts, a)
+			}
+		}
+	}
+	return alerts
+}
+
+// step advances one rule instance. Staleness markers arrive as NaN and
+// resolve a firing instance, as in Prometheus.
+func (s *rwState) step(inst string, rule *rwRule, labels map[string]string, value float64, at time.Time) (Alert, bool) {
+	if !math.IsNaN(value) && rwOps[rule.Op](value, rule.Threshold) {
+		activeAt, pending := s.active[inst]
+		if !pending {
+			activeAt = at
+			s.active[inst] = at
+		}
+		if s.firing[inst] || at.Sub(activeAt) < rule.forDur {
+			return Alert{}, false
+		}
+		s.firing[inst] = true
+		return rule.alert(labels, value, "firing", activeAt, time.Time{}), true
+	}
+
+	activeAt := s.active[inst]
+	wasFiring := s.firing[inst]
+	delete(s.active, inst)
+	delete(s.firing, inst)
+	if !wasFiring {
+		return Alert{}, false
+	}
+	return rule.alert(labels, value, "resolved", activeAt, at), true
+}
+
+func matchLabels(labels, match map[string]string) bool {
+	for k, v := range match {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *rwRule) alert(series map[string]string, value float64, status string, startsAt, endsAt time.Time) Alert {
+	labels := make(map[string]string, len(series)+len(r.Labels)+1)
+	for k, v := range series {
+		if k != "__name__" {
+			labels[k] = v
+		}
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = r.Alert
+
+	annotations := make(map[string]string, len(r.annotations))
+	data := struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value}
+	for k, t := range r.annotations {
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			b.Reset()
+			b.WriteString(r.Annotations[k])
+		}
+		annotations[k] = b.String()
+	}
+
+	a := Alert{Status: status, StartsAt: startsAt, EndsAt: endsAt, Labels: labels, Annotations: annotations}
+	a.Fingerprint = a.fingerprint()
+	return a
+}
+
+func remoteWriteRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+remoteWritePath, remoteWriteHandler)
+}
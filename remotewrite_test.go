@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestRemoteWriteLimits(t *testing.T) {
+	defer func(rules []*rwRule, mode string, secret []byte, limit int64) {
+		rwRules, *authMode, authSecret, *maxBodyBytes = rules, mode, secret, limit
+	}(rwRules, *authMode, authSecret, *maxBodyBytes)
+	rwRules = []*rwRule{{Alert: "HighLoad", Metric: "load1"}}
+	*authMode, authSecret = "bearer", []byte("secret")
+	*maxBodyBytes = 1024
+
+	// A snappy block is its decoded length as a uvarint, then the data.
+	claims := binary.AppendUvarint(nil, 1<<30)
+
+	tests := []struct {
+		name  string
+		token string
+		body  []byte
+		want  int
+	}{
+		{"no token", "", snappy.Encode(nil, nil), http.StatusUnauthorized},
+		{"wrong token", "guess", snappy.Encode(nil, nil), http.StatusUnauthorized},
+		{"empty request", "secret", snappy.Encode(nil, nil), http.StatusNoContent},
+		{"body over the limit", "secret", bytes.Repeat([]byte{0}, 2048), http.StatusRequestEntityTooLarge},
+		{"decodes over the limit", "secret", claims, http.StatusRequestEntityTooLarge},
+		{"not snappy", "secret", []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", remoteWritePath, bytes.NewReader(tt.body))
+			r.Header.Set("Content-Encoding", "snappy")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			remoteWriteHandler(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+/*
+=============================
+ Template Test Endpoint (/render)
+=============================
+*/
+
+// renderResult is what the sinks would have been given for a payload.
+type renderResult struct {
+	Alerts []Alert         `json:"alerts"`
+	Routes []renderedRoute `json:"routes"`
+}
+
+type renderedRoute struct {
+	Route   string            `json:"route"`
+	Sinks   []string          `json:"sinks"`
+	Dir     string            `json:"dir"`
+	Records []json.RawMessage `json:"records"`
+	Email   *renderedEmail    `json:"email,omitempty"`
+	// MoreEmails are the further emails of alerts with recipients of their
+	// own (-email-to-annotation, templated email_to).
+	MoreEmails []*renderedEmail `json:"more_emails,omitempty"`
+	Errors     []string         `json:"errors,omitempty"`
+}
+
+type renderedEmail struct {
+	To         string `json:"to"`
+	Cc         string `json:"cc,omitempty"`
+	Subject    string `json:"subject"`
+	Importance string `json:"importance,omitempty"`
+	Text       string `json:"text"`
+	HTML       string `json:"html"`
+}
+
+// renderHandler runs a sample Alertmanager payload through decoding, the
+// alert transformations and routing, and answers with each route's record
+// lines and email as they would go out. Nothing is written, sent, tracked
+// or deduplicated, so templates and mapping rules can be tried against
+// the live configuration. The email is rendered even when SMTP is not set
+// up, with -email-templates.
+func renderHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := readBody(w, r)
+	if err != nil {
+		rejectPayload(w, requestID(ctx), readRejectReason(err), err, nil)
+		return
+	}
+	body, _ = repairUTF8(body)
+	if *decodeMode != "lenient" {
+		problems, err := checkPayload(body)
+		if err != nil {
+			rejectPayload(w, requestID(ctx), rejectJSON, err, nil)
+			return
+		}
+		if len(problems) > 0 {
+			rejectPayload(w, requestID(ctx), rejectSchema, errors.New("payload does not match the Alertmanager webhook schema"), problems)
+			return
+		}
+	}
+	payload, _, err := decodePayload(bytes.NewReader(body))
+	if err != nil {
+		rejectPayload(w, requestID(ctx), rejectSchema, err, nil)
+		return
+	}
+
+	res := renderResult{Alerts: []Alert{}, Routes: []renderedRoute{}}
+	for _, a := range byPriority(payload.Alerts) {
+		a.Fingerprint = a.fingerprint()
+		res.Alerts = append(res.Alerts, transformAlert(ctx, a))
+	}
+	for _, g := range routeAlerts(ctx, res.Alerts) {
+		res.Routes = append(res.Routes, renderRoute(withRoute(ctx, g.route), g.route, payload.group(), g.alerts, g.alerts))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(res)
+}
+
+// renderRoute renders the records of alerts and the email of notify, the
+// alerts the route's notifications would see.
+func renderRoute(ctx context.Context, rt *route, group alertGroup, alerts, notify []Alert) renderedRoute {
+	out := renderedRoute{
+		Route:   rt.Name,
+		Sinks:   rt.Sinks,
+		Dir:     rt.fileDir(tenantOf(ctx)),
+		Records: []json.RawMessage{},
+	}
+	for _, a := range alerts {
+		line, err := recordLine(ctx, a)
+		if err != nil {
+			out.Errors = append(out.Errors, a.Fingerprint+": "+err.Error())
+			continue
+		}
+		out.Records = append(out.Records, bytes.TrimSpace(line))
+	}
+
+	notify = rt.sinkAlerts(emailSink, notify)
+	if len(notify) == 0 {
+		return out
+	}
+	notify = append([]Alert(nil), notify...)
+	for i, a := range notify {
+		notify[i] = withAck(tenantOf(ctx), a)
+	}
+	set := rt.emailTemplates(tenantOf(ctx))
+	if set == nil {
+		var err error
+		if set, err = parseLocalizedTemplates(*emailTemplates, rt.emailLocaleOf()); err != nil {
+			out.Errors = append(out.Errors, "email templates: "+err.Error())
+			return out
+		}
+	}
+	for _, part := range rt.emailParts(ctx, notify) {
+		msg, err := set.render(group, part.alerts)
+		if err != nil {
+			out.Errors = append(out.Errors, "email: "+err.Error())
+			return out
+		}
+		email := &renderedEmail{
+			To:         part.to,
+			Cc:         part.cc,
+			Subject:    msg.Subject,
+			Importance: importanceOf(part.alerts),
+			Text:       msg.Text,
+			HTML:       msg.HTML,
+		}
+		if out.Email == nil {
+			out.Email = email
+		} else {
+			out.MoreEmails = append(out.MoreEmails, email)
+		}
+	}
+	return out
+}
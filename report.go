@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Daily Summary Report
+=============================
+*/
+
+var (
+	reportAt    = flag.String("report-at", "", "local time of day (HH:MM) to compile the daily summary report; empty disables it")
+	reportEmail = flag.Bool("report-email", false, "also email the daily summary report to -email-to")
+)
+
+const reportTopN = 10
+
+type nameCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type openCritical struct {
+	Fingerprint string    `json:"fingerprint"`
+	Alertname   string    `json:"alertname"`
+	Hostname    string    `json:"hostname"`
+	StartsAt    time.Time `json:"starts_at"`
+	AckBy       string    `json:"ack_by,omitempty"`
+}
+
+type dailyReport struct {
+	Tenant             string         `json:"tenant,omitempty"`
+	GeneratedAt        time.Time      `json:"generated_at"`
+	From               time.Time      `json:"from"`
+	To                 time.Time      `json:"to"`
+	TotalAlerts        int            `json:"total_alerts"`
+	TopAlertnames      []nameCount    `json:"top_alertnames"`
+	NoisiestHosts      []nameCount    `json:"noisiest_hosts"`
+	UnresolvedCritical []openCritical `json:"unresolved_critical"`
+}
+
+func runDailyReport(done <-chan struct{}) {
+	for {
+		var next, now time.Time
+		var err error
+		withSettings(func() {
+			now = clock.Now()
+			next, err = nextReportTime(*reportAt, now)
+		})
+		if err != nil {
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		withSettings(func() {
+			for _, tenant := range allTenants() {
+				report, err := buildDailyReport(tenant, next)
+				if err != nil {
+					continue
+				}
+				_ = writeDailyReport(report)
+				if *reportEmail {
+					if err := sendTextEmail(report.subject(), report.text()); err != nil {
+						slog.Error("emailing daily report", "err", err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func nextReportTime(at string, now time.Time) (time.Time, error) {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid report time %q: %w", at, err)
+	}
+	next := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next, nil
+}
+
+// buildDailyReport covers the tenant's 24 hours leading up to now.
+func buildDailyReport(tenant string, now time.Time) (dailyReport, error) {
+	from := now.Add(-24 * time.Hour)
+	report := dailyReport{Tenant: tenant, GeneratedAt: now, From: from, To: now}
+
+	byName, err := computeStats(tenant, from, now, statsKeys["alertname"], 0)
+	if err != nil {
+		return report, err
+	}
+	for _, g := range byName {
+		report.TotalAlerts += g.Count
+	}
+	report.TopAlertnames = topCounts(byName)
+
+	byHost, err := computeStats(tenant, from, now, statsKeys["hostname"], 0)
+	if err != nil {
+		return report, err
+	}
+	report.NoisiestHosts = topCounts(byHost)
+
+	report.UnresolvedCritical = unresolvedCriticals(tenant)
+	return report, nil
+}
+
+// unresolvedCriticals lists the tenant's open critical alerts, oldest first.
+func unresolvedCriticals(tenant string) []openCritical {
+	out := []openCritical{}
+	for fp, a := range tracker.snapshot(tenant, clock.Now()) {
+		if a.Labels["severity"] != "critical" {
+			continue
+		}
+		oc := openCritical{
+			Fingerprint: fp,
+			Alertname:   a.Labels["alertname"],
+			Hostname:    safeHostname(a.Labels),
+			StartsAt:    a.StartsAt,
+		}
+		if k, ok := tracker.ackFor(tenant, fp); ok {
+			oc.AckBy = k.By
+		}
+		out = append(out, oc)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].StartsAt.Before(out[j].StartsAt)
+	})
+	return out
+}
+
+func topCounts(groups []*statsGroup) []nameCount {
+	out := []nameCount{}
+	for _, g := range groups {
+		if len(out) == reportTopN {
+			break
+		}
+		out = append(out, nameCount{g.Key, g.Count})
+	}
+	return out
+}
+
+// writeDailyReport stores the report next to the tenant's alert files, under
+// a name the alert-file readers do not pick up.
+func writeDailyReport(report dailyReport) error {
+	fileName := filepath.Join(tenantDir(report.Tenant), logPrefix+"summary_"+report.To.Format("20060102")+".json")
+
+	file, err := openLogFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(report)
+}
+
+func (r dailyReport) subject() string {
+	s := fmt.Sprintf("HiveMQ daily report %s: %d alerts, %d unresolved critical",
+		r.To.Format("2006-01-02"), r.TotalAlerts, len(r.UnresolvedCritical))
+	if r.Tenant != "" {
+		s = "[" + r.Tenant + "] " + s
+	}
+	return s
+}
+
+func (r dailyReport) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "HiveMQ daily summary, %s to %s\n\n", r.From.Format("2006-01-02 15:04"), r.To.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&b, "Total alerts: %d\n\nTop alertnames:\n", r.TotalAlerts)
+	for _, c := range r.TopAlertnames {
+		fmt.Fprintf(&b, "  %6d  %s\n", c.Count, c.Name)
+	}
+	b.WriteString("\nNoisiest hosts:\n")
+	for _, c := range r.NoisiestHosts {
+		fmt.Fprintf(&b, "  %6d  %s\n", c.Count, c.Name)
+	}
+	fmt.Fprintf(&b, "\nUnresolved critical (%d):\n", len(r.UnresolvedCritical))
+	for _, c := range r.UnresolvedCritical {
+		fmt.Fprintf(&b, "  %s on %s since %s", c.Alertname, c.Hostname, c.StartsAt.Format(tsLayout))
+		if c.AckBy != "" {
+			fmt.Fprintf(&b, " (acknowledged by %s)", c.AckBy)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+/*
+=============================
+ Correlation IDs
+=============================
+*/
+
+const requestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID honours a caller-supplied X-Request-ID (so Alertmanager or a
+// proxy can set it) and otherwise generates one. It is echoed back on the
+// response and carried on the request context.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+=============================
+ Request Lookup Handler
+=============================
+*/
+
+func requestLookupHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	entries := index.byRequestID(tenantOf(r.Context()), id)
+	if len(entries) == 0 {
+		http.Error(w, "no entries for that request id", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		RequestID string    `json:"request_id"`
+		Entries   []JSONLog `json:"entries"`
+	}{id, entries})
+}
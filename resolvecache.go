@@ -0,0 +1,126 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"flag"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Resolution Cache
+=============================
+*/
+
+var (
+	resolveTTL     = flag.Duration("resolve-cache-ttl", 10*time.Minute, "how long enrichment lookups (reverse DNS, GeoIP, discovery) are served fresh")
+	resolveStale   = flag.Duration("resolve-cache-stale", time.Hour, "how long past the TTL a stale value is still served while it is refreshed in the background")
+	resolveSize    = flag.Int("resolve-cache-size", 10000, "maximum number of cached lookups")
+	resolveTimeout = flag.Duration("resolve-timeout", 250*time.Millisecond, "upper bound for a lookup on a cache miss")
+	reverseDNS     = flag.Bool("reverse-dns", false, "fill a missing hostname label by reverse DNS lookup of the alert's IP address, the first of -address-labels holding one")
+)
+
+type cacheEntry struct {
+	key        string
+	value      any
+	err        error
+	fetched    time.Time
+	refreshing bool
+}
+
+// resolveCache is shared by every enrichment lookup; callers namespace
+// their keys ("rdns:", "geo:", ...). Failures are cached as well so an
+// unresolvable address is not retried on every alert.
+type resolveCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+var (
+	lookups = &resolveCache{entries: make(map[string]*list.Element), lru: list.New()}
+
+	resolveLookupsTotal = newCounterVec("resolve_cache_lookups_total", "Enrichment cache lookups by outcome.", "result")
+)
+
+// get returns the cached value for key. Fresh values are returned as is;
+// stale ones are returned immediately while a single background refresh
+// runs; misses are fetched inline within -resolve-timeout.
+func (c *resolveCache) get(key string, fetch func(context.Context) (any, error)) (any, error) {
+	now := clock.Now()
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*cacheEntry)
+		age := now.Sub(e.fetched)
+		if age < *resolveTTL+*resolveStale {
+			c.lru.MoveToFront(el)
+			value, err := e.value, e.err
+			if age < *resolveTTL {
+				c.mu.Unlock()
+				resolveLookupsTotal.add("hit", 1)
+				return value, err
+			}
+			if !e.refreshing {
+				e.refreshing = true
+				go withSettings(func() { c.refresh(key, fetch) })
+			}
+			c.mu.Unlock()
+			resolveLookupsTotal.add("stale", 1)
+			return value, err
+		}
+	}
+	c.mu.Unlock()
+
+	resolveLookupsTotal.add("miss", 1)
+	return c.refresh(key, fetch)
+}
+
+func (c *resolveCache) refresh(key string, fetch func(context.Context) (any, error)) (any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), *resolveTimeout)
+	defer cancel()
+	value, err := fetch(ctx)
+	c.put(&cacheEntry{key: key, value: value, err: err, fetched: clock.Now()})
+	return value, err
+}
+
+func (c *resolveCache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[e.key] = c.lru.PushFront(e)
+	}
+	for c.lru.Len() > max(*resolveSize, 1) {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+/*
+=============================
+ Reverse DNS
+=============================
+*/
+
+func lookupHostname(addr netip.Addr) string {
+	v, err := lookups.get("rdns:"+addr.String(), func(ctx context.Context) (any, error) {
+		names, err := net.DefaultResolver.LookupAddr(ctx, addr.String())
+		if err != nil || len(names) == 0 {
+			return "", err
+		}
+		return strings.TrimSuffix(names[0], "."), nil
+	})
+	if err != nil {
+		return ""
+	}
+	return v.(string)
+}
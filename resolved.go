@@ -0,0 +1,29 @@
+package main
+
+import "flag"
+
+/*
+=============================
+ Resolved Alerts
+=============================
+*/
+
+var (
+	resolvedValue  = flag.String("resolved-value", "1", `"value" field of entries for resolved alerts`)
+	resolvedCount  = flag.String("resolved-count", "", `"cnt" field of entries for resolved alerts; empty keeps current_value as for firing ones`)
+	notifyResolved = flag.Bool("notify-resolved", true, "pass resolved alerts to the notification sinks (email, mqtt, webhook); the file and record sinks always get them. Routes can override it")
+)
+
+// notificationSinks tell people or systems about an alert; the others
+// record it.
+var notificationSinks = map[string]bool{emailSink: true, mqttSink: true, sinkWebhook: true, slackSink: true, teamsSink: true, snmpSink: true}
+
+func applyResolved(entry *JSONLog, alert Alert) {
+	if alert.Status != "resolved" {
+		return
+	}
+	entry.Value = *resolvedValue
+	if *resolvedCount != "" {
+		entry.Count = *resolvedCount
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+/*
+=============================
+ Delivery Retries
+=============================
+*/
+
+var (
+	retryAttempts = flag.Int("retry-attempts", 3, "tries of a failed sink write before the alert goes to the dead-letter file (1 disables retries)")
+	retryInitial  = flag.Duration("retry-initial", 500*time.Millisecond, "wait before the first retry; it doubles with every further try")
+	retryMax      = flag.Duration("retry-max", 30*time.Second, "longest wait between two tries")
+)
+
+var (
+	deliveryRetries = newCounterVec("delivery_retries_total", "Sink writes retried after a failure.", "sink")
+	deadLettered    = newCounterVec("dead_letters_total", "Alerts written to the dead-letter file after their sink gave up.", "sink")
+)
+
+func validRetryOptions() error {
+	if *retryAttempts < 1 {
+		return fmt.Errorf("-retry-attempts must be at least 1")
+	}
+	if *retryInitial <= 0 || *retryMax < *retryInitial {
+		return fmt.Errorf("-retry-initial must be positive and not above -retry-max")
+	}
+	return nil
+}
+
+// deliver hands alerts to one sink. Failed writes are retried with
+// exponential backoff; what still fails goes to the spool, or the
+// dead-letter file for a later replay. While the sink has spooled alerts,
+// new ones queue behind them. An open breaker is not waited out, and an
+// oversized entry does not get any smaller. It returns how many alerts
+// failed.
+func deliver(ctx context.Context, s Sink, alerts []Alert) (failed int) {
+	ctx, span := startSpan(ctx, "sink "+s.Name(),
+		attribute.String("sink", s.Name()), attribute.String("route", routeOf(ctx).Name), attribute.Int("alerts", len(alerts)))
+	defer func() {
+		span.SetAttributes(attribute.Int("alerts.failed", failed))
+		if failed > 0 {
+			span.SetStatus(codes.Error, fmt.Sprintf("%d alert(s) failed", failed))
+		}
+		span.End()
+	}()
+
+	if spool.holds(s.Name()) {
+		for _, a := range alerts {
+			deadLetterAlert(ctx, s.Name(), "", a, errSpooledBehind)
+		}
+		return len(alerts)
+	}
+	if b, ok := s.(batchSink); ok {
+		err := withRetries(ctx, s.Name(), func() error { return b.WriteBatch(ctx, alerts) })
+		return deadLetterFailed(ctx, s.Name(), alerts, err)
+	}
+	for _, a := range alerts {
+		err := withRetries(ctx, s.Name(), func() error { return s.Write(ctx, a) })
+		failed += deadLetterFailed(ctx, s.Name(), []Alert{a}, err)
+	}
+	return failed
+}
+
+// errGaveUp marks an error of a sink that already retried on its own.
+var errGaveUp = errors.New("retries exhausted")
+
+// targetError is the failure of one target of a sink with several, for
+// one alert or, without a fingerprint, for all alerts of the write.
+type targetError struct {
+	target      string
+	fingerprint string
+	err         error
+}
+
+func (e targetError) Error() string { return e.target + ": " + e.err.Error() }
+func (e targetError) Unwrap() error { return e.err }
+
+// deadLetterFailed dead-letters the alerts err says failed, once per
+// failed target, and returns how many alerts failed anywhere.
+func deadLetterFailed(ctx context.Context, sink string, alerts []Alert, err error) int {
+	if err == nil {
+		return 0
+	}
+	var failures []targetError
+	var collect func(error)
+	collect = func(err error) {
+		var te targetError
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			for _, e := range joined.Unwrap() {
+				collect(e)
+			}
+		} else if errors.As(err, &te) {
+			failures = append(failures, te)
+		} else {
+			failures = append(failures, targetError{err: err})
+		}
+	}
+	collect(err)
+
+	failed := 0
+	for _, a := range alerts {
+		hit := false
+		for _, f := range failures {
+			if f.fingerprint == "" || f.fingerprint == a.Fingerprint {
+				deadLetterAlert(ctx, sink, f.target, a, f.err)
+				hit = true
+			}
+		}
+		if hit {
+			failed++
+		}
+	}
+	return failed
+}
+
+func withRetries(ctx context.Context, sink string, write func() error) error {
+	err := write()
+	for try := 1; err != nil && try < *retryAttempts && retryable(err); try++ {
+		wait := backoff(try)
+		tracef(ctx, "retry", "", "%s: try %d failed (%v), next in %s", sink, try, err, wait.Round(time.Millisecond))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		deliveryRetries.add(sink, 1)
+		err = write()
+	}
+	return err
+}
+
+func retryable(err error) bool {
+	return !errors.Is(err, errBreakerOpen) && !errors.Is(err, errOversize) && !errors.Is(err, errGaveUp)
+}
+
+// backoff is the wait before retry n (from 1): -retry-initial doubled
+// n-1 times, capped at -retry-max, of which a random half is taken off so
+// that sinks recovering from an outage are not hit by every retry at once.
+func backoff(n int) time.Duration {
+	return backoffWith(n, *retryInitial, *retryMax)
+}
+
+func backoffWith(n int, initial, limit time.Duration) time.Duration {
+	d := initial
+	for i := 1; i < n && d < limit; i++ {
+		d *= 2
+	}
+	d = min(d, limit)
+	return d/2 + rand.N(d/2+1)
+}
+
+func deadLetterAlert(ctx context.Context, sink, target string, a Alert, cause error) {
+	if spoolAlert(ctx, sink, target, a, cause) {
+		return
+	}
+	tenant := tenantOf(ctx)
+	err := writeDeadLetter(deadLetterDir(tenant), deadLetter{
+		At:          clock.Now(),
+		Reason:      cause.Error(),
+		RequestID:   requestID(ctx),
+		Fingerprint: a.fingerprint(),
+		Sink:        sink,
+		Target:      target,
+		Tenant:      tenant,
+		Route:       routeOf(ctx).Name,
+		Alert:       &a,
+	})
+	if err != nil {
+		slog.Error("dead-lettering failed, alert lost", "sink", sink, "req_id", requestID(ctx), "fingerprint", a.fingerprint(), "err", err)
+		return
+	}
+	deadLettered.add(sink, 1)
+	tracef(ctx, sink, a.fingerprint(), "gave up (%v), written to the dead-letter file", cause)
+}
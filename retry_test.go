@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{4, 250 * time.Millisecond, 500 * time.Millisecond}, // capped
+		{40, 250 * time.Millisecond, 500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		for range 50 {
+			if d := backoffWith(tt.n, 100*time.Millisecond, 500*time.Millisecond); d < tt.min || d > tt.max {
+				t.Fatalf("retry %d: waited %s, want %s to %s", tt.n, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	defer func(attempts int, initial, limit time.Duration) {
+		*retryAttempts, *retryInitial, *retryMax = attempts, initial, limit
+	}(*retryAttempts, *retryInitial, *retryMax)
+	*retryInitial, *retryMax = time.Millisecond, time.Millisecond
+	down := errors.New("down")
+
+	tests := []struct {
+		name      string
+		attempts  int
+		errs      []error // returned by each try; nil after they run out
+		wantTries int
+		wantErr   error
+	}{
+		{"first try", 3, nil, 1, nil},
+		{"succeeds on retry", 3, []error{down, down}, 3, nil},
+		{"gives up", 3, []error{down, down, down, down}, 3, down},
+		{"retries disabled", 1, []error{down}, 1, down},
+		{"breaker open", 3, []error{errBreakerOpen}, 1, errBreakerOpen},
+		{"oversized", 3, []error{fmt.Errorf("webhook: %w", errOversize)}, 1, errOversize},
+		{"sink retried already", 3, []error{fmt.Errorf("smtp: %w", errGaveUp)}, 1, errGaveUp},
+		{"breaker opens while retrying", 5, []error{down, errBreakerOpen}, 2, errBreakerOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*retryAttempts = tt.attempts
+			tries := 0
+			err := withRetries(context.Background(), "test", func() error {
+				tries++
+				if tries <= len(tt.errs) {
+					return tt.errs[tries-1]
+				}
+				return nil
+			})
+			if tries != tt.wantTries || !errors.Is(err, tt.wantErr) {
+				t.Errorf("%d tries, err %v; want %d tries, err %v", tries, err, tt.wantTries, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithRetriesStopsOnCancel(t *testing.T) {
+	defer func(attempts int, initial, limit time.Duration) {
+		*retryAttempts, *retryInitial, *retryMax = attempts, initial, limit
+	}(*retryAttempts, *retryInitial, *retryMax)
+	*retryAttempts, *retryInitial, *retryMax = 5, time.Hour, time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tries := 0
+	err := withRetries(ctx, "test", func() error { tries++; return errors.New("down") })
+	if tries != 1 || err == nil {
+		t.Errorf("%d tries, err %v; want one try and its error", tries, err)
+	}
+}
+
+func TestDeadLetterFailed(t *testing.T) {
+	defer func(dir, spoolAt string) { *deadLetterDirFlag, *spoolDir = dir, spoolAt }(*deadLetterDirFlag, *spoolDir)
+	*spoolDir = ""
+	down := errors.New("down")
+	alerts := []Alert{{Fingerprint: "a1"}, {Fingerprint: "b2"}, {Fingerprint: "c3"}}
+
+	tests := []struct {
+		name       string
+		err        error
+		wantFailed int
+		want       []string // fingerprint@target of each dead letter
+	}{
+		{"no error", nil, 0, nil},
+		{"whole write", down, 3, []string{"a1@", "b2@", "c3@"}},
+		{"one alert", targetError{target: "t1", fingerprint: "b2", err: down}, 1, []string{"b2@t1"}},
+		{"one target", targetError{target: "t1", err: down}, 3, []string{"a1@t1", "b2@t1", "c3@t1"}},
+		{"two targets, one alert each", errors.Join(
+			targetError{target: "t1", fingerprint: "a1", err: down},
+			targetError{target: "t2", fingerprint: "c3", err: down}), 2, []string{"a1@t1", "c3@t2"}},
+		{"same alert on two targets", errors.Join(
+			targetError{target: "t1", fingerprint: "a1", err: down},
+			targetError{target: "t2", fingerprint: "a1", err: down}), 1, []string{"a1@t1", "a1@t2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*deadLetterDirFlag = t.TempDir()
+			if got := deadLetterFailed(context.Background(), "test", alerts, tt.err); got != tt.wantFailed {
+				t.Errorf("%d failed, want %d", got, tt.wantFailed)
+			}
+			var got []string
+			if f, err := os.Open(deadLetterPath(*deadLetterDirFlag, clock.Now())); err == nil {
+				sc := bufio.NewScanner(f)
+				for sc.Scan() {
+					var d deadLetter
+					if err := json.Unmarshal(sc.Bytes(), &d); err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, d.Fingerprint+"@"+d.Target)
+				}
+				f.Close()
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("dead letters %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+/*
+=============================
+ Per-Route Rewrite Rules
+=============================
+*/
+
+// rewriteRule is one step of a route's rewrite list. Rules run in order
+// on the alerts a route was given, before its sinks map them to records.
+//
+//	rewrite:
+//	  - {action: rename, name: 'kubernetes_(.+)', replacement: '$1'}
+//	  - {action: hostname, name: hostname, form: short}
+//	  - {action: replace, on: annotations, regex: '(token|password)=\S+', replacement: '$1=[REDACTED]'}
+//	  - {action: drop, name: 'pod_template_hash|__.*'}
+type rewriteRule struct {
+	Action      string `yaml:"action"`
+	On          string `yaml:"on"`
+	Name        string `yaml:"name"`
+	Regex       string `yaml:"regex"`
+	Replacement string `yaml:"replacement"`
+	Form        string `yaml:"form"`
+	Domain      string `yaml:"domain"`
+
+	nameRE  *regexp.Regexp
+	valueRE *regexp.Regexp
+}
+
+var rewritesTotal = newCounterVec("rewrites_total", "Labels and annotations renamed, rewritten or dropped by route rewrite rules, by route.", "route")
+
+// compileRewrite checks the rules of a route and prepares their regular
+// expressions. name is anchored like match_re; regex is not, so it can
+// replace part of a value.
+func compileRewrite(rules []rewriteRule) error {
+	for i := range rules {
+		rule := &rules[i]
+		where := fmt.Sprintf("rewrite %d (%s)", i+1, rule.Action)
+		switch rule.On {
+		case "":
+			rule.On = "labels"
+		case "labels", "annotations", "both":
+		default:
+			return fmt.Errorf("%s: invalid on %q, want labels, annotations or both", where, rule.On)
+		}
+		switch rule.Action {
+		case "rename", "drop":
+			if rule.Name == "" {
+				return fmt.Errorf("%s: needs name", where)
+			}
+			if rule.Action == "rename" && rule.Replacement == "" {
+				return fmt.Errorf("%s: needs replacement, the new name", where)
+			}
+		case "replace":
+			if rule.Regex == "" {
+				return fmt.Errorf("%s: needs regex", where)
+			}
+			re, err := regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("%s: regex: %w", where, err)
+			}
+			rule.valueRE = re
+		case "hostname":
+			if rule.Name == "" {
+				rule.Name = "hostname"
+			}
+			switch {
+			case rule.Form == "short":
+			case rule.Form == "fqdn" && rule.Domain != "":
+				rule.Domain = strings.Trim(rule.Domain, ".")
+			default:
+				return fmt.Errorf("%s: form must be short, or fqdn with a domain", where)
+			}
+		default:
+			return fmt.Errorf("%s: invalid action, want rename, replace, hostname or drop", where)
+		}
+		if rule.Name != "" {
+			re, err := regexp.Compile("^(?:" + rule.Name + ")$")
+			if err != nil {
+				return fmt.Errorf("%s: name: %w", where, err)
+			}
+			rule.nameRE = re
+		}
+	}
+	return nil
+}
+
+// rewriteAlert runs the route's rules over a copy of the alert. The
+// fingerprint is fixed first, so the alert is still tracked, acknowledged
+// and silenced as the one that came in.
+func (r *route) rewriteAlert(ctx context.Context, a Alert) Alert {
+	if len(r.Rewrite) == 0 {
+		return a
+	}
+	a.Fingerprint = a.fingerprint()
+	a.Labels, a.Annotations = maps.Clone(a.Labels), maps.Clone(a.Annotations)
+	if a.Labels == nil {
+		a.Labels = map[string]string{}
+	}
+	if a.Annotations == nil {
+		a.Annotations = map[string]string{}
+	}
+	var changed []string
+	for _, rule := range r.Rewrite {
+		if rule.On != "annotations" {
+			changed = append(changed, rule.apply(a.Labels, "labels.")...)
+		}
+		if rule.On != "labels" {
+			changed = append(changed, rule.apply(a.Annotations, "annotations.")...)
+		}
+	}
+	if len(changed) > 0 {
+		rewritesTotal.add(r.Name, len(changed))
+		tracef(ctx, "rewrite", a.Fingerprint, "route %s: %v", r.Name, changed)
+	}
+	return a
+}
+
+// apply rewrites m in place and describes what it changed.
+func (rule rewriteRule) apply(m map[string]string, prefix string) []string {
+	var changed []string
+	keys := slices.Sorted(maps.Keys(m))
+	for _, k := range keys {
+		if rule.nameRE != nil && !rule.nameRE.MatchString(k) {
+			continue
+		}
+		v := m[k]
+		switch rule.Action {
+		case "rename":
+			to := rule.nameRE.ReplaceAllString(k, rule.Replacement)
+			if _, taken := m[to]; taken || to == k || !labelName.MatchString(to) {
+				continue
+			}
+			delete(m, k)
+			m[to] = v
+			changed = append(changed, prefix+k+"->"+to)
+		case "drop":
+			delete(m, k)
+			changed = append(changed, prefix+k+" dropped")
+		case "replace", "hostname":
+			var nv string
+			if rule.Action == "replace" {
+				nv = rule.valueRE.ReplaceAllString(v, rule.Replacement)
+			} else {
+				nv = rule.hostname(v)
+			}
+			if nv != v {
+				m[k] = nv
+				changed = append(changed, prefix+k)
+			}
+		}
+	}
+	return changed
+}
+
+// hostname shortens a host name to its first DNS label or completes a
+// short one with the rule's domain. IPs and host:port values are kept.
+func (rule rewriteRule) hostname(v string) string {
+	if rule.Form == "short" {
+		return stripDomain(v)
+	}
+	if v == "" || net.ParseIP(v) != nil || strings.ContainsAny(v, ".:") {
+		return v
+	}
+	return v + "." + rule.Domain
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ End-of-Day Rollup
+=============================
+*/
+
+var (
+	rollupEnabled = flag.Bool("rollup", false, "append a rollup record (totals per alertname and severity, open criticals) to each day file when the day closes")
+	rollupEmail   = flag.Bool("rollup-email", false, "also email each rollup to -email-to")
+)
+
+// rollupRecord is the last line of a closed day file. It has no "ts", so
+// readers of alert entries skip it.
+type rollupRecord struct {
+	Rollup             string         `json:"rollup"`
+	GeneratedAt        string         `json:"generated_at"`
+	Total              int            `json:"total"`
+	ByAlertname        map[string]int `json:"by_alertname"`
+	BySeverity         map[string]int `json:"by_severity"`
+	UnresolvedCritical []openCritical `json:"unresolved_critical"`
+}
+
+// rollupTally counts severities per day, over all of its parts, as entries
+// are written; the file itself does not record severity. Entries written before a restart
+// are not in the tally and show up as "unknown".
+type rollupTally struct {
+	mu     sync.Mutex
+	counts map[string]map[string]int
+	closed map[string]bool
+}
+
+var rollups = &rollupTally{counts: make(map[string]map[string]int), closed: make(map[string]bool)}
+
+func (t *rollupTally) tally(fileName, severity string) {
+	if !*rollupEnabled {
+		return
+	}
+	key := dayKey(fileName)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.counts[key] == nil {
+		t.counts[key] = make(map[string]int)
+	}
+	t.counts[key][safeValue(severity, "unknown")]++
+}
+
+func (t *rollupTally) take(fileName string) map[string]int {
+	key := dayKey(fileName)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := t.counts[key]
+	delete(t.counts, key)
+	return counts
+}
+
+func (t *rollupTally) markClosed(fileName string) {
+	t.mu.Lock()
+	t.closed[dayKey(fileName)] = true
+	t.mu.Unlock()
+}
+
+// dayFile is the part of the day that takes the rollup: the last one.
+func dayFile(tenant string, day time.Time) string {
+	return writer.current(currentRoute().fileNamer(), tenantDir(tenant), day)
+}
+
+// runRollup closes each day just after midnight. Yesterday is rolled up at
+// start-up too, in case the boundary was missed while we were down.
+func runRollup(done <-chan struct{}) {
+	withSettings(func() {
+		now := clock.Now()
+		closeDay(time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location()))
+	})
+	for {
+		var now time.Time
+		withSettings(func() { now = clock.Now() })
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		withSettings(func() { closeDay(next.AddDate(0, 0, -1)) })
+	}
+}
+
+func closeDay(day time.Time) {
+	for _, tenant := range allTenants() {
+		if err := writeRollup(tenant, day); err != nil {
+			slog.Error("rollup", "day", day.Format("2006-01-02"), "tenant", tenant, "err", err)
+		}
+	}
+}
+
+var errRolledUp = errors.New("day already rolled up")
+
+// writeRollup counts the day's parts as they are on disk, so the totals
+// match their lines exactly, and appends the rollup through the normal
+// sink path.
+// It is a no-op for a day without a file or one that already has a rollup;
+// entries backfilled into a day after it closed are not counted.
+func writeRollup(tenant string, day time.Time) error {
+	fileName := dayFile(tenant, day)
+	rec := rollupRecord{
+		Rollup:      day.Format("2006-01-02"),
+		GeneratedAt: clock.Now().Format(time.RFC3339),
+		ByAlertname: map[string]int{},
+		BySeverity:  rollups.take(fileName),
+	}
+
+	var parts []string
+	files, err := listLogFiles(tenant)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.Date.Equal(day) {
+			parts = append(parts, f.Path)
+		}
+	}
+	if len(parts) == 0 {
+		return nil // nothing was written that day
+	}
+
+	count := func(line []byte) error {
+		var probe struct {
+			Timestamp string `json:"ts"`
+			KPI       string `json:"kpi"`
+			Rollup    string `json:"rollup"`
+		}
+		if json.Unmarshal(line, &probe) != nil {
+			return nil
+		}
+		if probe.Rollup != "" {
+			return errRolledUp
+		}
+		if probe.Timestamp != "" {
+			rec.Total++
+			rec.ByAlertname[probe.KPI]++
+		}
+		return nil
+	}
+	for _, part := range parts {
+		err := readLogLines(part, count)
+		if errors.Is(err, errRolledUp) {
+			rollups.markClosed(fileName)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if rec.BySeverity == nil {
+		rec.BySeverity = map[string]int{}
+	}
+	tallied := 0
+	for _, n := range rec.BySeverity {
+		tallied += n
+	}
+	if missing := rec.Total - tallied; missing > 0 {
+		rec.BySeverity["unknown"] += missing
+	}
+	rec.UnresolvedCritical = unresolvedCriticals(tenant)
+
+	line, err := encodeRollup(rec)
+	if err != nil {
+		return err
+	}
+	lanes.enter(false)
+	err = appendLine(fileName, line)
+	lanes.leave()
+	if err != nil {
+		return err
+	}
+	rollups.markClosed(fileName)
+	if *rollupEmail {
+		return sendTextEmail(rec.subject(tenant), rec.text())
+	}
+	return nil
+}
+
+func (r rollupRecord) text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rollup of %s: %d entries\n\nBy alertname:\n", r.Rollup, r.Total)
+	for _, name := range slices.Sorted(maps.Keys(r.ByAlertname)) {
+		fmt.Fprintf(&b, "  %6d  %s\n", r.ByAlertname[name], name)
+	}
+	b.WriteString("\nBy severity:\n")
+	for _, sev := range slices.Sorted(maps.Keys(r.BySeverity)) {
+		fmt.Fprintf(&b, "  %6d  %s\n", r.BySeverity[sev], sev)
+	}
+	fmt.Fprintf(&b, "\nUnresolved critical: %d\n", len(r.UnresolvedCritical))
+	for _, c := range r.UnresolvedCritical {
+		fmt.Fprintf(&b, "  %s on %s since %s\n", c.Alertname, c.Hostname, c.StartsAt.Format(tsLayout))
+	}
+	return b.String()
+}
+
+func (r rollupRecord) subject(tenant string) string {
+	s := fmt.Sprintf("HiveMQ rollup %s: %d entries, %d unresolved critical", r.Rollup, r.Total, len(r.UnresolvedCritical))
+	if tenant != "" {
+		s = "[" + tenant + "] " + s
+	}
+	return s
+}
+
+func encodeRollup(rec rollupRecord) ([]byte, error) {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if *nonASCII == "escape" {
+		line = escapeNonASCII(line)
+	}
+	return line, nil
+}
+
+// rollupPending reports whether a closed day file still waits for its
+// rollup and must not be encrypted or compressed yet.
+func rollupPending(f logFile, now time.Time) bool {
+	if !*rollupEnabled {
+		return false
+	}
+	yesterday := time.Date(now.Year(), now.Month(), now.Day()-1, 0, 0, 0, 0, now.Location())
+	if !f.Date.Equal(yesterday) {
+		return false
+	}
+	rollups.mu.Lock()
+	defer rollups.mu.Unlock()
+	return !rollups.closed[dayKey(f.Path)]
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Rotating File Writer
+=============================
+*/
+
+var (
+	rotateSizeMB    = flag.Int("rotate-size-mb", 0, "also start a new part of the day file ({{.Seq}} of -log-file-name counts up) once the current one reaches this size; 0 rotates at midnight only")
+	rotateLines     = flag.Int("rotate-lines", 0, "also start a new part of the day file once the current one holds this many records; 0 does not count them")
+	compressRotated = flag.Bool("compress-rotated", false, "gzip day files once they are rotated out and rolled up")
+
+	writeBufferRecords = flag.Int("write-buffer-records", 0, "hold up to this many records per day file in memory and write them out together; 0 writes every record at once")
+	writeFlushInterval = flag.Duration("write-flush-interval", time.Second, "write buffered records out (and, with -fsync=interval, sync the files) at least this often")
+	fsyncPolicy        = flag.String("fsync", "never", "when day files are synced to disk: never (on rotation and shutdown only), interval (every -write-flush-interval) or every-write")
+)
+
+var (
+	fileFlushes      = newCounterVec("file_flushes_total", "Buffered records written out to the day files, by what triggered it.", "reason")
+	fileFlushedBytes = newCounterVec("file_flushed_bytes_total", "Bytes written out of the record buffers, by what triggered it.", "reason")
+	fileSyncDuration = newHistogramVec("file_fsync_duration_seconds", "Time spent syncing day files to disk, by what triggered it.", "reason", latencyBuckets)
+	fileRotations    = newCounterVec("file_rotations_total", "Day files closed for a new part, by what triggered it.", "reason")
+
+	_ = newGaugeFunc("day_file_sequence", "Part number of the day file each directory is appending to, by file.", "file", func() map[string]float64 { return writer.stats(partSeq) })
+	_ = newGaugeFunc("day_file_bytes", "Size of the day file each directory is appending to, by file.", "file", func() map[string]float64 { return writer.stats(partBytes) })
+	_ = newGaugeFunc("day_file_lines", "Records in the day file each directory is appending to, by file; without -rotate-lines only those written since it was opened.", "file", func() map[string]float64 { return writer.stats(partLines) })
+)
+
+// openPart is the file a tenant directory is currently appending to. The
+// handle is opened lazily, so a size rotation can name the next part
+// before anything is written to it.
+type openPart struct {
+	path  string
+	day   string
+	seq   int
+	namer *fileNamer
+	file  *os.File
+	size  int64
+	lines int
+
+	// buf holds the records not written out yet; their bytes already
+	// count towards size.
+	buf      []byte
+	records  int
+	unsynced bool
+}
+
+// partKey tells apart the files of routes that share a directory but name
+// their files differently.
+type partKey struct {
+	dir, pattern string
+}
+
+// logWriter keeps one open handle per tenant directory and file name
+// instead of opening the day file for every line. Requests write
+// concurrently; each record goes out in a single write under mu, so
+// lines never interleave.
+type logWriter struct {
+	mu    sync.Mutex
+	parts map[partKey]*openPart
+}
+
+var writer = &logWriter{parts: make(map[partKey]*openPart)}
+
+func validRotateOptions() error {
+	if *rotateSizeMB < 0 || *rotateLines < 0 {
+		return fmt.Errorf("-rotate-size-mb and -rotate-lines must not be negative")
+	}
+	if *compressRotated && *encryptMode == "file" {
+		return errors.New("-compress-rotated cannot be combined with -encrypt=file")
+	}
+	if *writeBufferRecords < 0 {
+		return errors.New("-write-buffer-records must not be negative")
+	}
+	if *writeFlushInterval <= 0 {
+		return errors.New("-write-flush-interval must be positive")
+	}
+	switch *fsyncPolicy {
+	case "never", "interval", "every-write":
+	default:
+		return fmt.Errorf("invalid -fsync %q, want never, interval or every-write", *fsyncPolicy)
+	}
+	return nil
+}
+
+// splitPart takes a day file name apart into its day and part number, by
+// whichever file name it was given.
+func splitPart(path string) (day string, seq int, n *fileNamer, ok bool) {
+	for _, n := range fileNamers() {
+		if day, seq, ok := n.split(path); ok {
+			return day, seq, n, true
+		}
+	}
+	return "", 0, nil, false
+}
+
+// dayKey identifies a day across all of its parts.
+func dayKey(path string) string {
+	day, _, n, ok := splitPart(path)
+	if !ok {
+		return path
+	}
+	return n.path(filepath.Dir(path), day, 0)
+}
+
+// current names the part that entries for day go to: the open part, or the
+// last plain part on disk. A day whose last part is already compressed or
+// encrypted gets a new one.
+func (w *logWriter) current(n *fileNamer, dir string, day time.Time) string {
+	d := day.Format("20060102")
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if p := w.parts[partKey{dir, n.pattern}]; p != nil && p.day == d {
+		return p.path
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.log*"))
+	last, plain := 0, false
+	for _, m := range matches {
+		mday, seq, ok := n.split(m)
+		if !ok || mday != d || seq < last {
+			continue
+		}
+		if seq > last {
+			last, plain = seq, false
+		}
+		plain = plain || strings.HasSuffix(m, ".log")
+	}
+	switch {
+	case last == 0:
+		return n.path(dir, d, 1)
+	case plain:
+		return n.path(dir, d, last)
+	}
+	return n.path(dir, d, last+1)
+}
+
+// write appends data to path. A path for a later day than the open part
+// rotates the handle; the closed day is compressed later, once its rollup
+// is in. If the old part cannot be closed, say its buffer cannot be
+// written out, it stays open and data is refused, so neither is lost.
+// Past days (backfill, rollups) are written without keeping a handle.
+func (w *logWriter) write(path string, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	day, seq, namer, ok := splitPart(path)
+	if !ok {
+		return appendOnce(path, data)
+	}
+	key := partKey{filepath.Dir(path), namer.pattern}
+	p := w.parts[key]
+	if p == nil || p.path != path {
+		past := day < clock.Now().Format("20060102")
+		if past || p != nil && (day < p.day || (day == p.day && seq < p.seq)) {
+			return appendOnce(path, data)
+		}
+		if p != nil {
+			if err := p.close(); err != nil {
+				return fmt.Errorf("closing %s: %w", p.path, err)
+			}
+		}
+		p = &openPart{path: path, day: day, seq: seq, namer: namer}
+		w.parts[key] = p
+	}
+	if p.file == nil {
+		file, err := openLogFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+		if err != nil {
+			return err
+		}
+		st, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return err
+		}
+		p.file, p.size = file, st.Size()
+		if *rotateLines > 0 && p.size > 0 {
+			if p.lines, err = countLines(path); err != nil {
+				file.Close()
+				p.file = nil
+				return err
+			}
+		}
+	}
+
+	if *writeBufferRecords > 0 {
+		p.buf = append(p.buf, data...)
+		p.records++
+		p.size += int64(len(data))
+		p.lines += bytes.Count(data, []byte("\n"))
+		if p.records >= *writeBufferRecords {
+			if err := p.flush("records"); err != nil {
+				return err
+			}
+		}
+	} else {
+		if err := writeWhole(p.file, p.size, data); err != nil {
+			return err
+		}
+		p.size += int64(len(data))
+		p.lines += bytes.Count(data, []byte("\n"))
+		p.unsynced = true
+		if *fsyncPolicy == "every-write" {
+			if err := p.sync("every-write"); err != nil {
+				return err
+			}
+		}
+	}
+	if reason := p.full(); reason != "" {
+		// data is in; a part that fails to close keeps taking records and
+		// is rotated by the next write that gets it closed.
+		if err := p.close(); err != nil {
+			slog.Error("rotating day file", "file", p.path, "reason", reason, "err", err)
+			return nil
+		}
+		w.parts[key] = &openPart{path: p.namer.path(key.dir, p.day, p.seq+1), day: p.day, seq: p.seq + 1, namer: p.namer}
+		fileRotations.add(reason, 1)
+		slog.Info("rotated by "+reason, "file", p.path, "size", p.size, "lines", p.lines, "next", w.parts[key].path)
+		if *compressRotated {
+			path := p.path
+			go withSettings(func() { compressPart(path) })
+		}
+	}
+	return nil
+}
+
+// full says why the part has to make way for the next one, if it does:
+// it reached -rotate-size-mb or -rotate-lines.
+func (p *openPart) full() string {
+	switch {
+	case *rotateSizeMB > 0 && p.size >= int64(*rotateSizeMB)<<20:
+		return "size"
+	case *rotateLines > 0 && p.lines >= *rotateLines:
+		return "lines"
+	}
+	return ""
+}
+
+// countLines counts the records of a part written before a restart, so
+// -rotate-lines carries on where it was.
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	n := 0
+	buf := make([]byte, 64<<10)
+	for {
+		m, err := f.Read(buf)
+		n += bytes.Count(buf[:m], []byte("\n"))
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+const (
+	partSeq = iota
+	partBytes
+	partLines
+)
+
+// stats reads one figure of each open part for the day file gauges.
+func (w *logWriter) stats(what int) map[string]float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	out := map[string]float64{}
+	for _, p := range w.parts {
+		switch what {
+		case partSeq:
+			out[p.path] = float64(p.seq)
+		case partBytes:
+			out[p.path] = float64(p.size)
+		case partLines:
+			out[p.path] = float64(p.lines)
+		}
+	}
+	return out
+}
+
+// flush writes the buffered records out. If that fails they stay
+// buffered for the next try.
+func (p *openPart) flush(reason string) error {
+	if len(p.buf) == 0 || p.file == nil {
+		return nil
+	}
+	if err := writeWhole(p.file, p.size-int64(len(p.buf)), p.buf); err != nil {
+		return err
+	}
+	fileFlushes.add(reason, 1)
+	fileFlushedBytes.add(reason, len(p.buf))
+	p.buf, p.records, p.unsynced = nil, 0, true
+	if *fsyncPolicy == "every-write" {
+		return p.sync("every-write")
+	}
+	return nil
+}
+
+func (p *openPart) sync(reason string) error {
+	if p.file == nil || !p.unsynced {
+		return nil
+	}
+	start := time.Now()
+	err := p.file.Sync()
+	fileSyncDuration.observe(reason, time.Since(start).Seconds())
+	if err == nil {
+		p.unsynced = false
+	}
+	return err
+}
+
+// close writes out and syncs the part before closing it, so a part that
+// was rotated out or left at shutdown is complete. A buffer that cannot be
+// written out keeps the part open for the next try.
+func (p *openPart) close() error {
+	if p.file == nil {
+		return nil
+	}
+	if err := p.flush("close"); err != nil {
+		return err
+	}
+	err := p.sync("close")
+	if cerr := p.file.Close(); err == nil {
+		err = cerr
+	}
+	p.file = nil
+	return err
+}
+
+func appendOnce(path string, data []byte) error {
+	file, err := openLogFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return writeWhole(file, st.Size(), data)
+}
+
+// writeWhole appends data to file, which ends at size. A write that
+// fails halfway, say on a full disk, is cut off again: a torn record
+// would run into the next one and spoil both lines.
+func writeWhole(file *os.File, size int64, data []byte) error {
+	n, err := file.Write(data)
+	if err != nil && n > 0 {
+		if terr := file.Truncate(size); terr != nil {
+			slog.Error("cutting off a torn write", "file", file.Name(), "err", terr)
+		}
+	}
+	return err
+}
+
+// holds reports whether path is a part some directory is appending to.
+func (w *logWriter) holds(path string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range w.parts {
+		if p.path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// closeBefore drops the handles of days before day, so closed days are
+// free for compression and encryption even when nothing is written after
+// midnight. A part that fails to close is kept, for flushAll and the next
+// write to retry.
+func (w *logWriter) closeBefore(day string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for key, p := range w.parts {
+		if p.day >= day {
+			continue
+		}
+		if err := p.close(); err != nil {
+			slog.Error("closing day file", "file", p.path, "err", err)
+			continue
+		}
+		delete(w.parts, key)
+	}
+}
+
+// closeAll syncs and closes every open part and reports how many.
+func (w *logWriter) closeAll() (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var errs []error
+	n := 0
+	for key, p := range w.parts {
+		if p.file != nil {
+			n++
+		}
+		if err := p.close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.path, err))
+		}
+		delete(w.parts, key)
+	}
+	return n, errors.Join(errs...)
+}
+
+// flushAll writes out what the parts have buffered and, with
+// -fsync=interval, syncs them. Callers hold the settings (withSettings).
+func (w *logWriter) flushAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, p := range w.parts {
+		err := p.flush("interval")
+		if err == nil && *fsyncPolicy == "interval" {
+			err = p.sync("interval")
+		}
+		if err != nil {
+			slog.Error("flushing day file", "file", p.path, "err", err)
+		}
+	}
+}
+
+// runFlush flushes every -write-flush-interval, picking up a new interval
+// from a reload at the next tick.
+func runFlush(done <-chan struct{}) {
+	var every time.Duration
+	withSettings(func() { every = *writeFlushInterval })
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		withSettings(func() {
+			writer.flushAll()
+			if d := *writeFlushInterval; d != every {
+				every = d
+				ticker.Reset(every)
+			}
+		})
+	}
+}
+
+func runRotation(done <-chan struct{}) {
+	for {
+		var now time.Time
+		withSettings(func() { now = clock.Now() })
+		next := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		withSettings(func() { writer.closeBefore(next.Format("20060102")) })
+		slog.Info("rotated at midnight", "day", next.Format("2006-01-02"))
+	}
+}
+
+/*
+=============================
+ Compression
+=============================
+*/
+
+func runCompression(done <-chan struct{}) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		withSettings(func() { compressClosedFiles(clock.Now()) })
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// compressClosedFiles gzips the plain files of past days that are rolled up
+// (when rollups are on) and no longer written to.
+func compressClosedFiles(now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	for _, tenant := range allTenants() {
+		files, err := listLogFiles(tenant)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f.Compressed || f.Encrypted || !f.Date.Before(today) || rollupPending(f, now) || writer.holds(f.Path) {
+				continue
+			}
+			compressPart(f.Path)
+		}
+	}
+}
+
+func compressPart(path string) {
+	if err := compressFile(path); err != nil {
+		slog.Error("compressing file", "path", path, "err", err)
+	}
+}
+
+// compressFile writes path.gz next to path and removes path. A backfilled
+// line that lands while compressing leaves the plain file for the next
+// pass instead of being lost.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	st, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	target := path + ".gz"
+	if _, err := os.Stat(target); err == nil {
+		return fmt.Errorf("%s already exists", target)
+	}
+	tmp := target + ".tmp"
+	out, err := openLogFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(out)
+	gz := gzip.NewWriter(bw)
+	gz.Name = filepath.Base(path)
+	gz.ModTime = st.ModTime()
+	_, err = io.Copy(gz, io.LimitReader(in, st.Size()))
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+	if now, err := os.Stat(path); err != nil || now.Size() != st.Size() {
+		os.Remove(tmp)
+		return nil
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
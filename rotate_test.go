@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitPart(t *testing.T) {
+	tests := []struct {
+		name, path string
+		wantDay    string
+		wantSeq    int
+		wantOK     bool
+	}{
+		{"plain", "/logs/app_hivemq_202610150001.log", "20261015", 1, true},
+		{"long seq", "/logs/app_hivemq_2026101512345.log", "20261015", 12345, true},
+		{"compressed", "/logs/app_hivemq_202610150002.log.gz", "20261015", 2, true},
+		{"encrypted", "/logs/app_hivemq_202610150003.log.age", "20261015", 3, true},
+		{"short seq", "/logs/app_hivemq_20261015001.log", "", 0, false},
+		{"other prefix", "/logs/other_202610150001.log", "", 0, false},
+		{"no date", "/logs/app_hivemq_x.log", "", 0, false},
+		{"invalid date", "/logs/app_hivemq_202613400001.log", "", 0, false},
+		{"trailing junk", "/logs/app_hivemq_202610150001.logx", "", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			day, seq, n, ok := splitPart(tt.path)
+			if ok != tt.wantOK || day != tt.wantDay || seq != tt.wantSeq {
+				t.Fatalf("splitPart(%q) = %q, %d, %v; want %q, %d, %v", tt.path, day, seq, ok, tt.wantDay, tt.wantSeq, tt.wantOK)
+			}
+			if ok && n != defaultFileNamer {
+				t.Errorf("splitPart(%q) used %q, want the default name", tt.path, n.pattern)
+			}
+		})
+	}
+}
+
+// A part whose buffer cannot be written out must stay open with its
+// records, whichever way it is being closed.
+func TestFailedCloseKeepsBuffer(t *testing.T) {
+	defer func(n, mb int) { *writeBufferRecords, *rotateSizeMB = n, mb }(*writeBufferRecords, *rotateSizeMB)
+	*writeBufferRecords = 10
+
+	now := clock.Now()
+	today, tomorrow := now.Format("20060102"), now.AddDate(0, 0, 1).Format("20060102")
+	tests := []struct {
+		name    string
+		close   func(w *logWriter, dir string) error
+		wantErr bool
+	}{
+		{"next day", func(w *logWriter, dir string) error {
+			return w.write(defaultFileNamer.path(dir, tomorrow, 1), []byte("{}\n"))
+		}, true},
+		{"size rotation", func(w *logWriter, dir string) error {
+			*rotateSizeMB = 1
+			defer func() { *rotateSizeMB = 0 }()
+			return w.write(defaultFileNamer.path(dir, today, 1), bytes.Repeat([]byte("x"), 1<<20))
+		}, false},
+		{"midnight", func(w *logWriter, dir string) error {
+			w.closeBefore(tomorrow)
+			return nil
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := defaultFileNamer.path(dir, today, 1)
+			if err := os.WriteFile(path, nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			ro, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := &openPart{path: path, day: today, seq: 1, namer: defaultFileNamer, file: ro,
+				buf: []byte("{\"a\":1}\n"), records: 1, size: 8, lines: 1}
+			key := partKey{dir, defaultFileNamer.pattern}
+			w := &logWriter{parts: map[partKey]*openPart{key: p}}
+
+			if err := tt.close(w, dir); (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if w.parts[key] != p || p.file == nil || !bytes.HasPrefix(p.buf, []byte("{\"a\":1}\n")) {
+				t.Fatalf("part not kept open with its buffer: %+v", w.parts[key])
+			}
+
+			// Once the file takes writes again, the buffer goes out.
+			ro.Close()
+			if p.file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.close(); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if !bytes.HasPrefix(data, []byte("{\"a\":1}\n")) {
+				t.Errorf("file holds %.20q, want the buffered record", data)
+			}
+		})
+	}
+}
+
+func TestRotation(t *testing.T) {
+	defer func(mb, lines, n int) {
+		*rotateSizeMB, *rotateLines, *writeBufferRecords = mb, lines, n
+	}(*rotateSizeMB, *rotateLines, *writeBufferRecords)
+	record := append(bytes.Repeat([]byte("x"), 300<<10), '\n')
+
+	tests := []struct {
+		name       string
+		sizeMB     int
+		lines      int
+		buffer     int
+		records    int
+		wantCounts []int // records in each part
+	}{
+		{"midnight only", 0, 0, 0, 5, []int{5}},
+		{"by lines", 0, 2, 0, 5, []int{2, 2, 1}},
+		{"by size", 1, 0, 0, 5, []int{4, 1}},
+		{"first limit reached wins", 1, 3, 0, 5, []int{3, 2}},
+		{"buffered records count", 0, 2, 10, 5, []int{2, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*rotateSizeMB, *rotateLines, *writeBufferRecords = tt.sizeMB, tt.lines, tt.buffer
+			dir := t.TempDir()
+			w := &logWriter{parts: make(map[partKey]*openPart)}
+			for range tt.records {
+				if err := w.write(w.current(defaultFileNamer, dir, clock.Now()), record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := w.closeAll(); err != nil {
+				t.Fatal(err)
+			}
+
+			day := clock.Now().Format("20060102")
+			for i, want := range tt.wantCounts {
+				data, err := os.ReadFile(defaultFileNamer.path(dir, day, i+1))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := bytes.Count(data, []byte("\n")); got != want {
+					t.Errorf("part %d holds %d record(s), want %d", i+1, got, want)
+				}
+			}
+			if _, err := os.Stat(defaultFileNamer.path(dir, day, len(tt.wantCounts)+1)); err == nil {
+				t.Errorf("more than %d part(s)", len(tt.wantCounts))
+			}
+		})
+	}
+}
+
+// After a restart -rotate-lines carries on with the records already in
+// the part instead of starting over.
+func TestRotationCountsExistingLines(t *testing.T) {
+	defer func(lines int) { *rotateLines = lines }(*rotateLines)
+	*rotateLines = 3
+	dir := t.TempDir()
+	day := clock.Now().Format("20060102")
+	if err := os.WriteFile(defaultFileNamer.path(dir, day, 1), []byte("{}\n{}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	w := &logWriter{parts: make(map[partKey]*openPart)}
+	for range 2 {
+		if err := w.write(w.current(defaultFileNamer, dir, clock.Now()), []byte("{}\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.closeAll()
+	data, _ := os.ReadFile(defaultFileNamer.path(dir, day, 2))
+	if string(data) != "{}\n" {
+		t.Errorf("second part holds %q, want the one record past the limit", data)
+	}
+}
+
+func TestCurrentPart(t *testing.T) {
+	now := clock.Now()
+	day := now.Format("20060102")
+	yesterday := now.AddDate(0, 0, -1).Format("20060102")
+
+	tests := []struct {
+		name    string
+		files   []string
+		wantSeq int
+	}{
+		{"none yet", nil, 1},
+		{"last plain part", []string{day + "0001.log", day + "0002.log"}, 2},
+		{"last compressed", []string{day + "0001.log.gz", day + "0002.log.gz"}, 3},
+		{"last encrypted", []string{day + "0001.log", day + "0002.log.age"}, 3},
+		{"plain beside its compressed copy", []string{day + "0003.log", day + "0003.log.gz"}, 3},
+		{"other days ignored", []string{yesterday + "0007.log"}, 1},
+		{"other files ignored", []string{"notes.log", day + "0002.log"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.files {
+				name := f
+				if f != "notes.log" {
+					name = logPrefix + f
+				}
+				if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			w := &logWriter{parts: make(map[partKey]*openPart)}
+			if got, want := w.current(defaultFileNamer, dir, now), defaultFileNamer.path(dir, day, tt.wantSeq); got != want {
+				t.Errorf("current = %s, want %s", filepath.Base(got), filepath.Base(want))
+			}
+		})
+	}
+
+	// The open part wins over what is on disk.
+	dir := t.TempDir()
+	open := defaultFileNamer.path(dir, day, 4)
+	w := &logWriter{parts: map[partKey]*openPart{{dir, defaultFileNamer.pattern}: {path: open, day: day, seq: 4}}}
+	if got := w.current(defaultFileNamer, dir, now); got != open {
+		t.Errorf("current = %s, want the open part", filepath.Base(got))
+	}
+}
+
+func TestWriteBuffer(t *testing.T) {
+	defer func(n int, policy string) { *writeBufferRecords, *fsyncPolicy = n, policy }(*writeBufferRecords, *fsyncPolicy)
+	*fsyncPolicy = "never"
+
+	// Each step writes a record ("w"), flushes all parts ("f") or closes
+	// them ("c"); wantOnDisk is the number of records in the file after it.
+	tests := []struct {
+		name       string
+		buffer     int
+		steps      string
+		wantOnDisk []int
+	}{
+		{"unbuffered", 0, "www", []int{1, 2, 3}},
+		{"written out when full", 3, "wwwww", []int{0, 0, 3, 3, 3}},
+		{"interval flush", 3, "wwfw", []int{0, 0, 2, 2}},
+		{"flush of an empty buffer", 3, "fwff", []int{0, 0, 1, 1}},
+		{"close writes out", 5, "wwc", []int{0, 0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*writeBufferRecords = tt.buffer
+			dir := t.TempDir()
+			path := defaultFileNamer.path(dir, clock.Now().Format("20060102"), 1)
+			w := &logWriter{parts: make(map[partKey]*openPart)}
+			for i, step := range tt.steps {
+				switch step {
+				case 'w':
+					if err := w.write(path, []byte("{}\n")); err != nil {
+						t.Fatal(err)
+					}
+				case 'f':
+					w.flushAll()
+				case 'c':
+					if _, err := w.closeAll(); err != nil {
+						t.Fatal(err)
+					}
+				}
+				data, _ := os.ReadFile(path)
+				if got := bytes.Count(data, []byte("\n")); got != tt.wantOnDisk[i] {
+					t.Errorf("step %d (%c): %d record(s) on disk, want %d", i+1, step, got, tt.wantOnDisk[i])
+				}
+			}
+			w.closeAll()
+		})
+	}
+}
+
+// Buffered records already count towards the part's size and lines, so
+// rotation sees them before they are written out.
+func TestWriteBufferSize(t *testing.T) {
+	defer func(n, mb int) { *writeBufferRecords, *rotateSizeMB = n, mb }(*writeBufferRecords, *rotateSizeMB)
+	*writeBufferRecords = 100
+
+	dir := t.TempDir()
+	day := clock.Now().Format("20060102")
+	path := defaultFileNamer.path(dir, day, 1)
+	w := &logWriter{parts: make(map[partKey]*openPart)}
+	for _, data := range []string{"{\"a\":1}\n", "{\"b\":2}\n"} {
+		if err := w.write(path, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := w.parts[partKey{dir, defaultFileNamer.pattern}]
+	if p.size != 16 || p.lines != 2 || p.records != 2 {
+		t.Errorf("part at %d bytes, %d lines, %d buffered; want 16, 2, 2", p.size, p.lines, p.records)
+	}
+	w.closeAll()
+	if data, _ := os.ReadFile(path); string(data) != "{\"a\":1}\n{\"b\":2}\n" {
+		t.Errorf("file holds %q, want both records in order", data)
+	}
+}
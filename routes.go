@@ -0,0 +1,478 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Routing Tree
+=============================
+*/
+
+var routesFile = flag.String("routes", "", "YAML routing tree choosing sinks, email recipients, templates and files by label matchers; empty sends everything to every enabled sink")
+
+// route is one node of the tree. As in Alertmanager, an alert goes to the
+// deepest matching node; a node with continue lets its later siblings be
+// tried as well. Unset settings are inherited from the parent.
+type route struct {
+	Name           string            `yaml:"name"`
+	Match          map[string]string `yaml:"match"`
+	MatchRE        map[string]string `yaml:"match_re"`
+	Sinks          []string          `yaml:"sinks"`
+	EmailTo        []string          `yaml:"email_to"`
+	EmailCc        []string          `yaml:"email_cc"`
+	EmailTemplates string            `yaml:"email_templates"`
+	EmailLocale    string            `yaml:"email_locale"`
+	EmailTimezone  string            `yaml:"email_timezone"`
+	SlackURL       string            `yaml:"slack_webhook_url"`
+	TeamsURL       string            `yaml:"teams_webhook_url"`
+	File           string            `yaml:"file"`
+	FileName       string            `yaml:"file_name"`
+	RecordSchema   string            `yaml:"record_schema"`
+	NotifyResolved *bool             `yaml:"notify_resolved"`
+	LogOnly        *bool             `yaml:"log_only"`
+	Rewrite        []rewriteRule     `yaml:"rewrite"`
+	Continue       bool              `yaml:"continue"`
+	Routes         []*route          `yaml:"routes"`
+
+	matchRE  map[string]*regexp.Regexp
+	staticTo []string // email_to without its templates, toT
+	staticCc []string
+	toT, ccT []*texttemplate.Template
+	emailT   *emailTemplateSet
+	locale   *emailLocale // of email_locale and email_timezone; nil is -email-locale's
+	namer    *fileNamer
+	schema   *customSchema
+}
+
+type routesConfig struct {
+	Route *route `yaml:"route"`
+}
+
+var (
+	routesMu  sync.RWMutex
+	rootRoute = &route{Name: "root"}
+)
+
+// loadRoutes reads the tree. It runs after the sinks and email settings
+// are loaded, so routes can only name sinks that are enabled.
+func loadRoutes() error {
+	enabled := enabledSinkNames()
+	root := &route{Name: "root", Sinks: enabled}
+	if *routesFile != "" {
+		data, err := os.ReadFile(*routesFile)
+		if err != nil {
+			return err
+		}
+		var cfg routesConfig
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s: %w", *routesFile, err)
+		}
+		if cfg.Route == nil {
+			return fmt.Errorf("%s: no route defined", *routesFile)
+		}
+		root = cfg.Route
+		if root.Name == "" {
+			root.Name = "root"
+		}
+		if len(root.Match)+len(root.MatchRE) > 0 {
+			return fmt.Errorf("%s: the root route matches every alert and takes no matchers", *routesFile)
+		}
+		if root.Sinks == nil {
+			root.Sinks = enabled
+		}
+	}
+	if root.NotifyResolved == nil {
+		root.NotifyResolved = notifyResolved
+	}
+	if root.RecordSchema == "" {
+		root.RecordSchema = *recordSchemaFile
+	}
+	if err := root.compile(nil, enabled); err != nil {
+		if *routesFile != "" {
+			return fmt.Errorf("%s: %w", *routesFile, err)
+		}
+		return err
+	}
+
+	routesMu.Lock()
+	rootRoute = root
+	routesMu.Unlock()
+	return nil
+}
+
+// compile fills in inherited settings, checks them and prepares the
+// matchers and templates of r and its children.
+func (r *route) compile(parent *route, enabled []string) error {
+	ownRewrite := r.Rewrite != nil
+	if parent != nil {
+		if r.Sinks == nil {
+			r.Sinks = parent.Sinks
+		}
+		if r.EmailTo == nil {
+			r.EmailTo = parent.EmailTo
+		}
+		if r.EmailCc == nil {
+			r.EmailCc = parent.EmailCc
+		}
+		if r.EmailTemplates == "" {
+			r.EmailTemplates, r.emailT = parent.EmailTemplates, parent.emailT
+		}
+		if r.EmailLocale == "" {
+			r.EmailLocale = parent.EmailLocale
+		}
+		if r.EmailTimezone == "" {
+			r.EmailTimezone = parent.EmailTimezone
+		}
+		if r.File == "" {
+			r.File = parent.File
+		}
+		if r.FileName == "" {
+			r.FileName, r.namer = parent.FileName, parent.namer
+		}
+		if r.RecordSchema == "" {
+			r.RecordSchema, r.schema = parent.RecordSchema, parent.schema
+		}
+		if r.SlackURL == "" {
+			r.SlackURL = parent.SlackURL
+		}
+		if r.TeamsURL == "" {
+			r.TeamsURL = parent.TeamsURL
+		}
+		if r.NotifyResolved == nil {
+			r.NotifyResolved = parent.NotifyResolved
+		}
+		if r.LogOnly == nil {
+			r.LogOnly = parent.LogOnly
+		}
+		if !ownRewrite {
+			r.Rewrite = parent.Rewrite
+		}
+	}
+
+	for _, name := range r.Sinks {
+		if !slices.Contains(enabled, name) {
+			return fmt.Errorf("route %s: sink %q is not enabled (enabled: %s)", r.Name, name, strings.Join(enabled, ", "))
+		}
+	}
+	var err error
+	if r.staticTo, r.toT, err = compileRecipients("email_to", r.EmailTo); err != nil {
+		return fmt.Errorf("route %s: %w", r.Name, err)
+	}
+	if r.staticCc, r.ccT, err = compileRecipients("email_cc", r.EmailCc); err != nil {
+		return fmt.Errorf("route %s: %w", r.Name, err)
+	}
+	if r.EmailTemplates != "" && r.emailT == nil {
+		set, err := parseEmailTemplates(r.EmailTemplates)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", r.Name, err)
+		}
+		r.emailT = set
+	}
+	if err := r.compileLocale(); err != nil {
+		return fmt.Errorf("route %s: %w", r.Name, err)
+	}
+	for _, u := range []string{r.SlackURL, r.TeamsURL} {
+		if u == "" {
+			continue
+		}
+		if err := validChatURL(u); err != nil {
+			return fmt.Errorf("route %s: %w", r.Name, err)
+		}
+	}
+	if r.File != "" && (filepath.Base(r.File) != r.File || r.File == "." || r.File == "..") {
+		return fmt.Errorf("route %s: file %q must be a plain directory name", r.Name, r.File)
+	}
+	if r.FileName != "" && r.namer == nil {
+		n, err := parseFileName(r.FileName)
+		if err != nil {
+			return fmt.Errorf("route %s: file_name: %w", r.Name, err)
+		}
+		r.namer = n
+	}
+	if r.RecordSchema != "" && r.schema == nil {
+		s, err := parseRecordSchema(r.RecordSchema)
+		if err != nil {
+			return fmt.Errorf("route %s: record_schema: %w", r.Name, err)
+		}
+		r.schema = s
+	}
+
+	if ownRewrite {
+		if err := compileRewrite(r.Rewrite); err != nil {
+			return fmt.Errorf("route %s: %w", r.Name, err)
+		}
+	}
+
+	r.matchRE = make(map[string]*regexp.Regexp, len(r.MatchRE))
+	for label, expr := range r.MatchRE {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("route %s: match_re %s: %w", r.Name, label, err)
+		}
+		r.matchRE[label] = re
+	}
+
+	for i, child := range r.Routes {
+		if child.Name == "" {
+			child.Name = fmt.Sprintf("%s.%d", r.Name, i)
+		}
+		if err := child.compile(r, enabled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *route) matches(labels map[string]string) bool {
+	for label, v := range r.Match {
+		if labels[label] != v {
+			return false
+		}
+	}
+	for label, re := range r.matchRE {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// routesFor returns the nodes that handle an alert; r itself is assumed
+// to match.
+func (r *route) routesFor(labels map[string]string) []*route {
+	var out []*route
+	for _, child := range r.Routes {
+		if !child.matches(labels) {
+			continue
+		}
+		out = append(out, child.routesFor(labels)...)
+		if !child.Continue {
+			break
+		}
+	}
+	if len(out) == 0 {
+		out = []*route{r}
+	}
+	return out
+}
+
+func currentRoute() *route {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	return rootRoute
+}
+
+type routeKey struct{}
+
+func withRoute(ctx context.Context, r *route) context.Context {
+	return context.WithValue(ctx, routeKey{}, r)
+}
+
+// routeOf is the route a sink is delivering for; outside of routing it is
+// the root.
+func routeOf(ctx context.Context) *route {
+	if r, ok := ctx.Value(routeKey{}).(*route); ok {
+		return r
+	}
+	return currentRoute()
+}
+
+// routeGroup is the alerts of one request that ended up at one route.
+type routeGroup struct {
+	route  *route
+	alerts []Alert
+}
+
+// routeAlerts groups alerts by route, keeping their order within a group,
+// and applies each route's rewrite rules to its copy. Escalated alerts go
+// to their escalation route as well.
+func routeAlerts(ctx context.Context, alerts []Alert) []routeGroup {
+	ctx, span := startSpan(ctx, "route", attribute.Int("alerts", len(alerts)))
+	defer span.End()
+	root := currentRoute()
+	var groups []routeGroup
+	index := map[*route]int{}
+	for _, a := range alerts {
+		targets := root.routesFor(a.Labels)
+		if rt := escalationRoute(root, escalationOf(a)); rt != nil && !slices.Contains(targets, rt) {
+			targets = append(targets, rt)
+		}
+		for _, r := range targets {
+			i, ok := index[r]
+			if !ok {
+				i = len(groups)
+				index[r] = i
+				groups = append(groups, routeGroup{route: r})
+			}
+			groups[i].alerts = append(groups[i].alerts, r.rewriteAlert(ctx, a))
+			tracef(ctx, "route", a.Fingerprint, "route %s: %s", r.Name, strings.Join(r.Sinks, ", "))
+		}
+	}
+	return groups
+}
+
+// sinkAlerts is the part of a route's alerts a sink is given.
+func (r *route) sinkAlerts(sink string, alerts []Alert) []Alert {
+	if *r.NotifyResolved || !notificationSinks[sink] {
+		return alerts
+	}
+	var out []Alert
+	for _, a := range alerts {
+		if a.Status != "resolved" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// emailTemplates is the route's template set, else the tenant's, else the
+// global one, in the route's locale.
+func (r *route) emailTemplates(tenant string) *emailTemplateSet {
+	set := emailTmpl
+	if r.emailT != nil {
+		set = r.emailT
+	} else if tc := tenantSettings(tenant); tc != nil && tc.emailT != nil {
+		set = tc.emailT
+	}
+	return set.localizedOrDefault(r.emailLocaleOf())
+}
+
+// recipients is the route's fixed email_to, else the tenant's, else
+// -email-to.
+func (r *route) recipients(tenant string) string {
+	if len(r.staticTo) > 0 {
+		return strings.Join(r.staticTo, ", ")
+	}
+	if tc := tenantSettings(tenant); tc != nil && len(tc.EmailTo) > 0 {
+		return strings.Join(tc.EmailTo, ", ")
+	}
+	return *emailTo
+}
+
+// fileNamer names the route's day files: its file_name or -log-file-name.
+func (r *route) fileNamer() *fileNamer {
+	if r.namer != nil {
+		return r.namer
+	}
+	return defaultFileNamer
+}
+
+// fileDir is where the file sink writes for this route.
+func (r *route) fileDir(tenant string) string {
+	if r.File == "" {
+		return tenantDir(tenant)
+	}
+	return filepath.Join(tenantDir(tenant), r.File)
+}
+
+/*
+=============================
+ Route Lint
+=============================
+*/
+
+// lintRoutes reports routes no alert can reach: those whose matchers
+// contradict an ancestor's, and those shadowed by an earlier sibling
+// without continue that matches everything they match.
+func lintRoutes(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg routesConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Route == nil {
+		return []string{path + ": no route defined"}, nil
+	}
+	if cfg.Route.Name == "" {
+		cfg.Route.Name = "root"
+	}
+	var issues []string
+	var walk func(r *route, eq map[string]string, re map[string]string)
+	walk = func(r *route, eq map[string]string, re map[string]string) {
+		for i, child := range r.Routes {
+			if child.Name == "" {
+				child.Name = fmt.Sprintf("%s.%d", r.Name, i)
+			}
+			if why := contradiction(child, eq, re); why != "" {
+				issues = append(issues, fmt.Sprintf("%s: route %s is unreachable: %s", path, child.Name, why))
+				continue
+			}
+			for _, earlier := range r.Routes[:i] {
+				if !earlier.Continue && subsumes(earlier, child) {
+					issues = append(issues, fmt.Sprintf("%s: route %s is unreachable: route %s before it matches the same alerts and has no continue", path, child.Name, earlier.Name))
+					break
+				}
+			}
+			childEq, childRE := maps.Clone(eq), maps.Clone(re)
+			maps.Copy(childEq, child.Match)
+			maps.Copy(childRE, child.MatchRE)
+			walk(child, childEq, childRE)
+		}
+	}
+	walk(cfg.Route, map[string]string{}, map[string]string{})
+	sort.Strings(issues)
+	return issues, nil
+}
+
+func contradiction(r *route, eq, re map[string]string) string {
+	for label, v := range r.Match {
+		if want, ok := eq[label]; ok && want != v {
+			return fmt.Sprintf("%s=%q but a parent requires %q", label, v, want)
+		}
+		if expr, ok := re[label]; ok {
+			if m, err := regexp.MatchString("^(?:"+expr+")$", v); err == nil && !m {
+				return fmt.Sprintf("%s=%q but a parent requires it to match %q", label, v, expr)
+			}
+		}
+	}
+	for label, expr := range r.MatchRE {
+		if want, ok := eq[label]; ok {
+			if m, err := regexp.MatchString("^(?:"+expr+")$", want); err == nil && !m {
+				return fmt.Sprintf("%s must match %q but a parent requires %q", label, expr, want)
+			}
+		}
+	}
+	return ""
+}
+
+// subsumes reports whether every alert b matches is matched by a as well,
+// judged by a's matchers all appearing in b.
+func subsumes(a, b *route) bool {
+	for label, v := range a.Match {
+		if b.Match[label] != v {
+			return false
+		}
+	}
+	for label, expr := range a.MatchRE {
+		if b.MatchRE[label] == expr {
+			continue
+		}
+		v, ok := b.Match[label]
+		if !ok {
+			return false
+		}
+		if m, err := regexp.MatchString("^(?:"+expr+")$", v); err != nil || !m {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+/*
+=============================
+ Annotation Sanitization
+=============================
+*/
+
+var (
+	sanitizeSteps   = flag.String("sanitize", "", "comma-separated sanitization of annotation text: newlines, control, whitespace, html")
+	sanitizeNewline = flag.String("sanitize-newline", " ", "replacement for line breaks when -sanitize includes newlines")
+)
+
+var (
+	sanitizers []func(string) string
+
+	htmlTag = regexp.MustCompile(`(?s)<[^>]*>`)
+)
+
+func loadSanitizers() error {
+	sanitizers = nil
+	if *sanitizeSteps == "" {
+		return nil
+	}
+	for _, step := range strings.Split(*sanitizeSteps, ",") {
+		switch strings.TrimSpace(step) {
+		case "newlines":
+			r := strings.NewReplacer("\r\n", *sanitizeNewline, "\n", *sanitizeNewline, "\r", *sanitizeNewline)
+			sanitizers = append(sanitizers, r.Replace)
+		case "control":
+			sanitizers = append(sanitizers, stripControl)
+		case "whitespace":
+			sanitizers = append(sanitizers, func(s string) string { return strings.Join(strings.Fields(s), " ") })
+		case "html":
+			sanitizers = append(sanitizers, func(s string) string { return html.UnescapeString(htmlTag.ReplaceAllString(s, "")) })
+		default:
+			return fmt.Errorf("invalid -sanitize step %q", step)
+		}
+	}
+	return nil
+}
+
+// stripControl drops control characters other than line breaks and tabs,
+// which the newlines and whitespace steps deal with.
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// sanitizeAlert applies the configured steps, in order, to every
+// annotation and returns the names of those that changed.
+func sanitizeAlert(a Alert) (Alert, []string) {
+	if len(sanitizers) == 0 {
+		return a, nil
+	}
+	var changed []string
+	annotations := make(map[string]string, len(a.Annotations))
+	for k, v := range a.Annotations {
+		orig := v
+		for _, fn := range sanitizers {
+			v = fn(v)
+		}
+		if v != orig {
+			changed = append(changed, k)
+		}
+		annotations[k] = v
+	}
+	a.Annotations = annotations
+	return a, changed
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+/*
+=============================
+ Output Schema Versions
+=============================
+*/
+
+// Version 1 is the original flat record. Version 2 adds the alert's own
+// lifecycle: starts_at, ends_at and duration_sec for resolved alerts.
+const latestSchema = 2
+
+var schemaVersion = flag.Int("schema-version", 1, "output record schema version (1 or 2)")
+
+func validSchemaVersion(v int) error {
+	if v < 1 || v > latestSchema {
+		return fmt.Errorf("invalid -schema-version %d, supported 1..%d", v, latestSchema)
+	}
+	return nil
+}
+
+func applySchema(entry *JSONLog, alert Alert) {
+	if *schemaVersion < 2 {
+		return
+	}
+	entry.Schema = *schemaVersion
+	if !alert.StartsAt.IsZero() {
+		entry.StartsAt = alert.StartsAt.Format(time.RFC3339)
+	}
+	// A firing alert's endsAt is only Alertmanager's resolve-timeout guess.
+	if alert.Status == "resolved" && !alert.EndsAt.IsZero() {
+		entry.EndsAt = alert.EndsAt.Format(time.RFC3339)
+		if !alert.StartsAt.IsZero() && !alert.EndsAt.Before(alert.StartsAt) {
+			secs := int64(alert.EndsAt.Sub(alert.StartsAt) / time.Second)
+			entry.DurationSec = &secs
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+/*
+=============================
+ Full-Text Search Index
+=============================
+*/
+
+var searchMaxEntries = flag.Int("search-max-entries", 200000, "entries the search index keeps, dropping the oldest beyond that; 0 bounds it only by -retention-days")
+
+type searchIndex struct {
+	mu        sync.RWMutex
+	docs      []JSONLog
+	postings  map[string][]int
+	byRequest map[string][]int
+}
+
+var index = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{postings: make(map[string][]int), byRequest: make(map[string][]int)}
+}
+
+// add indexes a written entry. Past -search-max-entries by a quarter, the
+// index is rebuilt from the newest -search-max-entries, so that the cost
+// of the rebuild is spread over the entries added since the last one.
+func (ix *searchIndex) add(e JSONLog) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.addLocked(e)
+	if max := *searchMaxEntries; max > 0 && len(ix.docs) > max+max/4 {
+		ix.rebuildLocked(ix.docs[len(ix.docs)-max:])
+	}
+}
+
+func (ix *searchIndex) addLocked(e JSONLog) {
+	id := len(ix.docs)
+	ix.docs = append(ix.docs, e)
+	if e.RequestID != "" {
+		ix.byRequest[e.RequestID] = append(ix.byRequest[e.RequestID], id)
+	}
+
+	seen := make(map[string]bool)
+	for _, tok := range tokenize(searchText(e) + " " + e.KPI + " " + e.Hostname) {
+		if seen[tok] {
+			continue
+		}
+		seen[tok] = true
+		ix.postings[tok] = append(ix.postings[tok], id)
+	}
+}
+
+// rebuildLocked replaces the index by one over docs.
+func (ix *searchIndex) rebuildLocked(docs []JSONLog) {
+	ix.docs = nil
+	ix.postings = make(map[string][]int)
+	ix.byRequest = make(map[string][]int)
+	for _, e := range docs {
+		ix.addLocked(e)
+	}
+}
+
+// searchText is the summary of an entry and, for entries written since
+// startup, the other annotations of its alert. The record keeps only the
+// summary, so entries loaded from disk are found by that alone.
+func searchText(e JSONLog) string {
+	return strings.TrimSpace(e.Summary + " " + e.annotations)
+}
+
+// annotationText joins the alert's annotations other than the summary,
+// by name.
+func annotationText(alert Alert) string {
+	names := make([]string, 0, len(alert.Annotations))
+	for k := range alert.Annotations {
+		if k != "summary" {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, k := range names {
+		parts[i] = alert.Annotations[k]
+	}
+	return strings.Join(parts, " ")
+}
+
+// load indexes the retained history on disk, for every tenant. It runs
+// before the queue starts, so that no entry is indexed twice.
+func (ix *searchIndex) load() error {
+	for _, tenant := range allTenants() {
+		err := scanHistory(tenant, time.Time{}, time.Time{}, func(e JSONLog) error {
+			ix.add(e)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prune drops entries older than cutoff, after retention removed their
+// files. Entries whose ts does not parse go with them.
+func (ix *searchIndex) prune(cutoff time.Time) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	var keep []JSONLog
+	for _, e := range ix.docs {
+		if ts, err := entryTime(e); err == nil && !ts.Before(cutoff) {
+			keep = append(keep, e)
+		}
+	}
+	ix.rebuildLocked(keep)
+}
+
+func (ix *searchIndex) byRequestID(tenant, id string) []JSONLog {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	var out []JSONLog
+	for _, i := range ix.byRequest[id] {
+		if ix.docs[i].Tenant == tenant {
+			out = append(out, ix.docs[i])
+		}
+	}
+	return out
+}
+
+// search returns the tenant's entries containing every query term, newest
+// first. Quoted parts of the query must additionally match as a phrase.
+func (ix *searchIndex) search(tenant, query string, from, to time.Time, limit int) (int, []JSONLog) {
+	terms := tokenize(query)
+	if len(terms) == 0 {
+		return 0, nil
+	}
+	phrases := quotedPhrases(query)
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	ids := ix.postings[terms[0]]
+	for _, t := range terms[1:] {
+		ids = intersect(ids, ix.postings[t])
+	}
+
+	var hits []JSONLog
+	for _, id := range ids {
+		e := ix.docs[id]
+		if e.Tenant != tenant || !matchesPhrases(searchText(e), phrases) || !inRange(e, from, to) {
+			continue
+		}
+		hits = append(hits, e)
+	}
+
+	sortNewestFirst(hits)
+	total := len(hits)
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return total, hits
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func quotedPhrases(q string) []string {
+	parts := strings.Split(q, `"`)
+	var phrases []string
+	for i := 1; i < len(parts); i += 2 {
+		if p := strings.Join(tokenize(parts[i]), " "); p != "" {
+			phrases = append(phrases, p)
+		}
+	}
+	return phrases
+}
+
+func matchesPhrases(text string, phrases []string) bool {
+	norm := " " + strings.Join(tokenize(text), " ") + " "
+	for _, p := range phrases {
+		if !strings.Contains(norm, " "+p+" ") {
+			return false
+		}
+	}
+	return true
+}
+
+func intersect(a, b []int) []int {
+	var out []int
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+/*
+=============================
+ Search Handler
+=============================
+*/
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		http.Error(w, "missing q", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTimeParam(q.Get("from"), false)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), true)
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+
+	total, hits := index.search(tenantOf(r.Context()), query, from, to, limit)
+	if hits == nil {
+		hits = []JSONLog{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Query   string    `json:"query"`
+		Total   int       `json:"total"`
+		Results []JSONLog `json:"results"`
+	}{query, total, hits})
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ End-to-End Self-Test (selftest)
+=============================
+*/
+
+// selftestAlertname marks the synthetic alerts, so searches and reports
+// can leave them out.
+const selftestAlertname = "AlertbridgeSelftest"
+
+// selftestWrites are the sinks the synthetic pair is written to. Email is
+// only connected to unless a test address is given; the rest would notify
+// real targets and are checked for reachability where they can be.
+var selftestWrites = []string{sinkFile, sinkStdout, sinkSyslog, mqttSink}
+
+type selftestResult struct {
+	Check      string `json:"check"`
+	Status     string `json:"status"` // ok, failed or skipped
+	Detail     string `json:"detail,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type selftestReport struct {
+	ID      string           `json:"id"`
+	Tenant  string           `json:"tenant,omitempty"`
+	OK      bool             `json:"ok"`
+	Results []selftestResult `json:"results"`
+}
+
+func (rep *selftestReport) add(check string, start time.Time, detail string, err error) {
+	res := selftestResult{Check: check, Status: "ok", Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+	switch {
+	case errors.Is(err, errSelftestSkipped):
+		res.Status = "skipped"
+	case err != nil:
+		res.Status, res.Detail = "failed", err.Error()
+		rep.OK = false
+	}
+	rep.Results = append(rep.Results, res)
+}
+
+var errSelftestSkipped = errors.New("skipped")
+
+// selftestPair is a firing alert and its resolution, labelled with the
+// run's ID.
+func selftestPair(id string, now time.Time) []Alert {
+	labels := map[string]string{"alertname": selftestAlertname, "severity": "info", "instance": "127.0.0.1:0", "selftest": id}
+	annotations := map[string]string{"summary": "alertbridge self-test", "description": "Synthetic alert of a self-test run; no action needed."}
+	firing := Alert{Status: "firing", Labels: labels, Annotations: annotations, StartsAt: now}
+	resolved := firing
+	resolved.Status, resolved.EndsAt = "resolved", now
+	firing.Fingerprint, resolved.Fingerprint = firing.fingerprint(), resolved.fingerprint()
+	return []Alert{firing, resolved}
+}
+
+// runSelftest pushes a synthetic pair through routing, the templates and
+// the sinks and reports on each. emailTo, when set, also gets the email;
+// otherwise the SMTP server is only connected to.
+func runSelftest(ctx context.Context, tenant, emailTo string) selftestReport {
+	rep := selftestReport{ID: "selftest-" + newRequestID(), Tenant: tenant, OK: true}
+	ctx = context.WithValue(withTenantValue(ctx, tenant), requestIDKey{}, rep.ID)
+	group := alertGroup{Receiver: "alertbridge-selftest", GroupLabels: map[string]string{"alertname": selftestAlertname}}
+	ctx = withAlertGroup(ctx, group)
+	pair := selftestPair(rep.ID, clock.Now())
+
+	start := time.Now()
+	groups := routeAlerts(ctx, pair)
+	routeOfSink := map[string]routeGroup{}
+	var names []string
+	for _, g := range groups {
+		names = append(names, fmt.Sprintf("%s%v", g.route.Name, g.route.Sinks))
+		for _, s := range g.route.Sinks {
+			if _, ok := routeOfSink[s]; !ok {
+				routeOfSink[s] = g
+			}
+		}
+	}
+	var err error
+	if len(groups) == 0 {
+		err = errors.New("no route takes the alerts")
+	}
+	rep.add("routing", start, strings.Join(names, " "), err)
+
+	for _, g := range groups {
+		start := time.Now()
+		rctx := withRoute(ctx, g.route)
+		var errs []error
+		for _, a := range g.alerts {
+			if _, err := recordLine(rctx, a); err != nil {
+				errs = append(errs, fmt.Errorf("record: %w", err))
+			}
+		}
+		if set := g.route.emailTemplates(tenant); set != nil {
+			if _, err := set.render(group, g.alerts); err != nil {
+				errs = append(errs, fmt.Errorf("email: %w", err))
+			}
+		}
+		rep.add("templates:"+g.route.Name, start, "", errors.Join(errs...))
+	}
+
+	sinksMu.RLock()
+	sinks := slices.Clone(sinkSet)
+	sinksMu.RUnlock()
+	for _, s := range sinks {
+		start := time.Now()
+		name := s.Name()
+		g, routed := routeOfSink[name]
+		if !routed {
+			rep.add(name, start, "no route sends to it", errSelftestSkipped)
+			continue
+		}
+		sctx := withRoute(ctx, g.route)
+		switch {
+		case name == emailSink:
+			detail, err := selftestEmail(sctx, g, emailTo)
+			rep.add(name, start, detail, err)
+		case slices.Contains(selftestWrites, name):
+			var errs []error
+			for _, a := range g.alerts {
+				errs = append(errs, s.Write(sctx, a))
+			}
+			err := errors.Join(errs...)
+			detail := fmt.Sprintf("wrote %d alerts", len(g.alerts))
+			if err == nil && name == sinkFile {
+				detail, err = selftestFile(tenant, rep.ID, g.route.fileDir(tenant))
+			}
+			rep.add(name, start, detail, err)
+		default:
+			if rc, ok := s.(readyChecker); ok {
+				rep.add(name, start, "reachable, not sent to", rc.ready(sctx))
+			} else {
+				rep.add(name, start, "not sent to, it would notify its targets", errSelftestSkipped)
+			}
+		}
+	}
+	return rep
+}
+
+// selftestFile looks for the pair in the day files. Those of a route's
+// file subdirectory are not searched.
+func selftestFile(tenant, id, dir string) (string, error) {
+	if dir != tenantDir(tenant) {
+		return "written to " + dir, nil
+	}
+	writer.flushAll()
+	found := 0
+	err := scanHistory(tenant, clock.Now().AddDate(0, 0, -1), time.Time{}, func(e JSONLog) error {
+		if e.RequestID == id {
+			found++
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if found == 0 {
+		return "", fmt.Errorf("written, but not found in the day files of %s", dir)
+	}
+	return fmt.Sprintf("%d records in %s", found, dir), nil
+}
+
+func selftestEmail(ctx context.Context, g routeGroup, to string) (string, error) {
+	if err := (emailOutput{}).ready(ctx); err != nil {
+		return "", err
+	}
+	server := fmt.Sprintf("%s:%d", *smtpHost, *smtpPort)
+	if to == "" {
+		return "connected to " + server + ", not sent", nil
+	}
+	msg, err := g.route.emailTemplates(tenantOf(ctx)).render(alertGroupOf(ctx), g.alerts[:1])
+	if err != nil {
+		return "", err
+	}
+	msg.To, msg.RequestID = to, requestID(ctx)
+	msg.Subject = "[self-test] " + msg.Subject
+	if err := sendEmail(msg); err != nil {
+		return "", err
+	}
+	return "sent to " + to + " via " + server, nil
+}
+
+// selftestHandler runs a self-test on POST /selftest and answers 503 if a
+// check failed, so monitoring can probe it. ?email_to= sends the email to
+// a test address, ?tenant= tests a tenant's settings.
+func selftestHandler(w http.ResponseWriter, r *http.Request) {
+	tenant := r.URL.Query().Get("tenant")
+	if tenant != "" && !knownTenant(tenant) {
+		http.Error(w, fmt.Sprintf("unknown tenant %q", tenant), http.StatusNotFound)
+		return
+	}
+	to := r.URL.Query().Get("email_to")
+	if to != "" {
+		if _, err := mail.ParseAddressList(to); err != nil {
+			http.Error(w, "invalid email_to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !emailEnabled() {
+			http.Error(w, "email_to given but email is not set up", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The SMTP and broker checks may outlast -listen's write timeout.
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	rep := runSelftest(r.Context(), tenant, to)
+	w.Header().Set("Content-Type", "application/json")
+	if !rep.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(rep)
+}
+
+// runSelftestCommand asks a running instance for a self-test and prints
+// its report; it fails when a check did.
+func runSelftestCommand(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the instance's admin endpoints (-admin-listen, else -listen)")
+	tokenFile := fs.String("token-file", "", "file holding the admin token")
+	emailTo := fs.String("email-to", "", "also send the test email to this address")
+	tenant := fs.String("tenant", "", "test this tenant's directories, templates and recipients")
+	timeout := fs.Duration("timeout", time.Minute, "how long to wait for the report")
+	fs.Parse(args)
+	if *tokenFile == "" {
+		return errors.New("usage: selftest -token-file <file> [-target URL] [-email-to addr] [-tenant name]")
+	}
+	token, err := readSecret(*tokenFile)
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	if *emailTo != "" {
+		q.Set("email_to", *emailTo)
+	}
+	if *tenant != "" {
+		q.Set("tenant", *tenant)
+	}
+	u := strings.TrimRight(*target, "/") + "/selftest"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	resp, err := (&http.Client{Timeout: *timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	var rep selftestReport
+	if err := json.Unmarshal(body, &rep); err != nil {
+		return fmt.Errorf("selftest: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	for _, res := range rep.Results {
+		fmt.Printf("%-8s %-20s %5dms  %s\n", strings.ToUpper(res.Status), res.Check, res.DurationMS, res.Detail)
+	}
+	if !rep.OK {
+		return fmt.Errorf("selftest %s failed", rep.ID)
+	}
+	fmt.Printf("selftest %s ok\n", rep.ID)
+	return nil
+}
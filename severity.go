@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Severity Mapping
+=============================
+*/
+
+var severityMapFile = flag.String("severity-map", "", `YAML file mapping severity label values to the record "value", email importance, syslog severity, MQTT topic segment and chat color`)
+
+// severityLevel is what one severity label value turns into on the
+// outputs. Empty fields keep the output's own default.
+type severityLevel struct {
+	Value      string `yaml:"value"`
+	Importance string `yaml:"importance"`
+	Syslog     string `yaml:"syslog"`
+	Topic      string `yaml:"topic"`
+	Color      string `yaml:"color"`
+}
+
+// severityDefault is the key for alerts whose severity is not mapped.
+const severityDefault = "default"
+
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+var (
+	severityLevels map[string]severityLevel
+
+	// emailImportance gives the Importance and X-Priority headers.
+	emailImportance = map[string]string{"high": "1 (Highest)", "normal": "3 (Normal)", "low": "5 (Lowest)"}
+)
+
+func loadSeverityMap() error {
+	severityLevels = nil
+	if *severityMapFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*severityMapFile)
+	if err != nil {
+		return err
+	}
+	var levels map[string]severityLevel
+	if err := yaml.Unmarshal(data, &levels); err != nil {
+		return fmt.Errorf("%s: %w", *severityMapFile, err)
+	}
+	for severity, l := range levels {
+		if _, ok := emailImportance[l.Importance]; l.Importance != "" && !ok {
+			return fmt.Errorf("%s: %s: invalid importance %q, want high, normal or low", *severityMapFile, severity, l.Importance)
+		}
+		if _, ok := syslogSeverities[l.Syslog]; l.Syslog != "" && !ok {
+			return fmt.Errorf("%s: %s: unknown syslog severity %q", *severityMapFile, severity, l.Syslog)
+		}
+		if l.Color != "" && !hexColor.MatchString(l.Color) {
+			return fmt.Errorf("%s: %s: invalid color %q, want #rrggbb", *severityMapFile, severity, l.Color)
+		}
+	}
+	severityLevels = levels
+	return nil
+}
+
+// severityOf maps the alert's severity label, field by field falling back
+// to the default entry.
+func severityOf(a Alert) severityLevel {
+	l := severityLevels[a.Labels["severity"]]
+	def := severityLevels[severityDefault]
+	if l.Value == "" {
+		l.Value = def.Value
+	}
+	if l.Importance == "" {
+		l.Importance = def.Importance
+	}
+	if l.Syslog == "" {
+		l.Syslog = def.Syslog
+	}
+	if l.Topic == "" {
+		l.Topic = def.Topic
+	}
+	if l.Color == "" {
+		l.Color = def.Color
+	}
+	return l
+}
+
+// importanceOf is the highest importance of the firing alerts of an email,
+// or "" when nothing maps one.
+func importanceOf(alerts []Alert) string {
+	rank := map[string]int{"low": 1, "normal": 2, "high": 3}
+	best := ""
+	for _, a := range alerts {
+		if a.Status == "resolved" {
+			continue
+		}
+		if i := severityOf(a).Importance; rank[i] > rank[best] {
+			best = i
+		}
+	}
+	return best
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Silences (Maintenance Windows)
+=============================
+*/
+
+var (
+	silencesFile = flag.String("silences", "", "YAML list of silences (match, match_re, starts_at, ends_at, comment) holding back notifications of matching alerts, e.g. during planned upgrades")
+	silenceState = flag.String("silence-state", "", "JSON file keeping the silences created through /api/silences across restarts; empty keeps them in memory")
+)
+
+var silencedAlerts = newCounterVec("silenced_alerts_total", "Alerts recorded but not notified because a silence matched them, by silence.", "silence")
+
+// silenceKeep is how long an expired silence stays listed.
+const silenceKeep = 24 * time.Hour
+
+// silence holds back email, MQTT and webhook notifications of the alerts
+// its matchers select while it is in force; the record sinks still write
+// them, marked with silenced_by. A silence from -silences applies to every
+// tenant unless it names one; one made through the API to its creator's.
+type silence struct {
+	ID        string            `json:"id" yaml:"id"`
+	Tenant    string            `json:"tenant,omitempty" yaml:"tenant"`
+	Match     map[string]string `json:"match,omitempty" yaml:"match"`
+	MatchRE   map[string]string `json:"match_re,omitempty" yaml:"match_re"`
+	StartsAt  time.Time         `json:"starts_at" yaml:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at" yaml:"ends_at"`
+	CreatedBy string            `json:"created_by,omitempty" yaml:"created_by"`
+	Comment   string            `json:"comment,omitempty" yaml:"comment"`
+	Source    string            `json:"source" yaml:"-"`
+
+	anyTenant bool
+	matchRE   map[string]*regexp.Regexp
+}
+
+const (
+	silenceFromConfig = "config"
+	silenceFromAPI    = "api"
+)
+
+func (s *silence) compile() error {
+	if len(s.Match)+len(s.MatchRE) == 0 {
+		return errors.New("a silence needs at least one matcher")
+	}
+	if s.EndsAt.IsZero() || !s.EndsAt.After(s.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	for label := range s.Match {
+		if !labelName.MatchString(label) {
+			return fmt.Errorf("match: %q is not a valid label name", label)
+		}
+	}
+	s.matchRE = make(map[string]*regexp.Regexp, len(s.MatchRE))
+	for label, expr := range s.MatchRE {
+		if !labelName.MatchString(label) {
+			return fmt.Errorf("match_re: %q is not a valid label name", label)
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("match_re %s: %w", label, err)
+		}
+		s.matchRE[label] = re
+	}
+	return nil
+}
+
+func (s *silence) state(now time.Time) string {
+	switch {
+	case now.Before(s.StartsAt):
+		return "pending"
+	case now.Before(s.EndsAt):
+		return "active"
+	}
+	return "expired"
+}
+
+func (s *silence) appliesTo(tenant string) bool {
+	return s.anyTenant || s.Tenant == tenant
+}
+
+func (s *silence) matches(labels map[string]string) bool {
+	for label, v := range s.Match {
+		if labels[label] != v {
+			return false
+		}
+	}
+	for label, re := range s.matchRE {
+		if !re.MatchString(labels[label]) {
+			return false
+		}
+	}
+	return true
+}
+
+// silenceView is a silence as the API shows it.
+type silenceView struct {
+	*silence
+	State string `json:"state"`
+}
+
+type silenceStore struct {
+	mu     sync.RWMutex
+	config []*silence
+	api    map[string]*silence
+}
+
+var silences = &silenceStore{api: make(map[string]*silence)}
+
+// loadSilences reads -silences. Entries without an id get one from their
+// position, so they keep it across reloads of an unchanged file.
+func loadSilences() error {
+	var list []*silence
+	if *silencesFile != "" {
+		data, err := os.ReadFile(*silencesFile)
+		if err != nil {
+			return err
+		}
+		if err := yaml.Unmarshal(data, &list); err != nil {
+			return fmt.Errorf("%s: %w", *silencesFile, err)
+		}
+	}
+	seen := map[string]bool{}
+	for i, s := range list {
+		if s.ID == "" {
+			s.ID = fmt.Sprintf("config-%d", i+1)
+		}
+		if seen[s.ID] {
+			return fmt.Errorf("%s: duplicate silence id %q", *silencesFile, s.ID)
+		}
+		seen[s.ID] = true
+		s.Source, s.anyTenant = silenceFromConfig, s.Tenant == ""
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("%s: silence %s: %w", *silencesFile, s.ID, err)
+		}
+	}
+
+	silences.mu.Lock()
+	silences.config = list
+	silences.mu.Unlock()
+	return nil
+}
+
+// loadSilenceState restores the API silences saved in -silence-state.
+func loadSilenceState() error {
+	if *silenceState == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*silenceState)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*silence
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", *silenceState, err)
+	}
+	silences.mu.Lock()
+	defer silences.mu.Unlock()
+	for _, s := range list {
+		s.Source = silenceFromAPI
+		if err := s.compile(); err != nil {
+			return fmt.Errorf("%s: silence %s: %w", *silenceState, s.ID, err)
+		}
+		silences.api[s.ID] = s
+	}
+	return nil
+}
+
+// saveLocked writes the API silences to -silence-state, replacing the
+// file in one step. The caller holds mu.
+func (st *silenceStore) saveLocked() error {
+	if *silenceState == "" {
+		return nil
+	}
+	list := make([]*silence, 0, len(st.api))
+	for _, s := range st.api {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(*silenceState), "."+filepath.Base(*silenceState)+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, *silenceState)
+}
+
+// pruneLocked forgets API silences that expired more than silenceKeep
+// ago. The caller holds mu for writing.
+func (st *silenceStore) pruneLocked(now time.Time) {
+	for id, s := range st.api {
+		if now.Sub(s.EndsAt) > silenceKeep {
+			delete(st.api, id)
+		}
+	}
+}
+
+// silencing returns the active silence of the tenant's alert, if any.
+func (st *silenceStore) silencing(tenant string, labels map[string]string, now time.Time) (*silence, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	for _, s := range st.config {
+		if s.appliesTo(tenant) && s.state(now) == "active" && s.matches(labels) {
+			return s, true
+		}
+	}
+	for _, s := range st.api {
+		if s.appliesTo(tenant) && s.state(now) == "active" && s.matches(labels) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// unsilenced drops the alerts held back by a silence, for the
+// notification sinks.
+func unsilenced(ctx context.Context, alerts []Alert) []Alert {
+	now := clock.Now()
+	var out []Alert
+	for _, a := range alerts {
+		s, ok := silences.silencing(tenantOf(ctx), a.Labels, now)
+		if !ok {
+			out = append(out, a)
+			continue
+		}
+		silencedAlerts.add(s.ID, 1)
+		slog.Info("alert silenced", "req_id", requestID(ctx), "fingerprint", a.Fingerprint,
+			"alertname", a.Labels["alertname"], "silence", s.ID, "until", s.EndsAt.Format(time.RFC3339))
+		tracef(ctx, "silence", a.Fingerprint, "silenced by %s until %s, not notified", s.ID, s.EndsAt.Format(time.RFC3339))
+	}
+	return out
+}
+
+// list returns the silences the tenant sees, by start time.
+func (st *silenceStore) list(tenant string, now time.Time) []silenceView {
+	st.mu.Lock()
+	st.pruneLocked(now)
+	out := []silenceView{}
+	for _, s := range st.config {
+		if s.appliesTo(tenant) {
+			out = append(out, silenceView{s, s.state(now)})
+		}
+	}
+	for _, s := range st.api {
+		if s.appliesTo(tenant) {
+			out = append(out, silenceView{s, s.state(now)})
+		}
+	}
+	st.mu.Unlock()
+	sort.SliceStable(out, func(i, j int) bool { return out[i].StartsAt.Before(out[j].StartsAt) })
+	return out
+}
+
+func (st *silenceStore) lookup(tenant, id string) (*silence, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if s, ok := st.api[id]; ok && s.appliesTo(tenant) {
+		return s, true
+	}
+	for _, s := range st.config {
+		if s.ID == id && s.appliesTo(tenant) {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+/*
+=============================
+ Silence Handlers
+=============================
+*/
+
+// silenceRequest is the body of POST and PUT /api/silences. Without
+// starts_at a silence starts now; duration (e.g. "2h") may stand in for
+// ends_at.
+type silenceRequest struct {
+	Match     map[string]string `json:"match"`
+	MatchRE   map[string]string `json:"match_re"`
+	StartsAt  time.Time         `json:"starts_at"`
+	EndsAt    time.Time         `json:"ends_at"`
+	Duration  string            `json:"duration"`
+	CreatedBy string            `json:"created_by"`
+	Comment   string            `json:"comment"`
+}
+
+func decodeSilence(w http.ResponseWriter, r *http.Request, tenant string) (*silence, error) {
+	var req silenceRequest
+	body, err := bodyReader(w, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		return nil, fmt.Errorf("body must be a JSON silence: %w", err)
+	}
+	if req.CreatedBy == "" {
+		return nil, errors.New(`a silence needs a non-empty "created_by"`)
+	}
+	s := &silence{
+		Tenant:    tenant,
+		Match:     req.Match,
+		MatchRE:   req.MatchRE,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		CreatedBy: req.CreatedBy,
+		Comment:   req.Comment,
+		Source:    silenceFromAPI,
+	}
+	if s.StartsAt.IsZero() {
+		s.StartsAt = clock.Now().UTC().Truncate(time.Second)
+	}
+	if req.Duration != "" {
+		if !s.EndsAt.IsZero() {
+			return nil, errors.New("give ends_at or duration, not both")
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid duration: %w", err)
+		}
+		s.EndsAt = s.StartsAt.Add(d)
+	}
+	if err := s.compile(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func writeSilence(w http.ResponseWriter, code int, s *silence) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(silenceView{s, s.state(clock.Now())})
+}
+
+func silenceListHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(silences.list(tenantOf(r.Context()), clock.Now()))
+}
+
+func silenceGetHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := silences.lookup(tenantOf(r.Context()), r.PathValue("id"))
+	if !ok {
+		http.Error(w, "no silence with that id", http.StatusNotFound)
+		return
+	}
+	writeSilence(w, http.StatusOK, s)
+}
+
+// silenceCreateHandler adds an API silence. Creating, changing and deleting
+// silences is mounted behind the admin token: a silence mutes alerts, so
+// it is not left to whoever can reach the webhook.
+func silenceCreateHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := decodeSilence(w, r, tenantOf(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.ID = newRequestID()[:16]
+
+	silences.mu.Lock()
+	silences.pruneLocked(clock.Now())
+	silences.api[s.ID] = s
+	err = silences.saveLocked()
+	silences.mu.Unlock()
+	if err != nil {
+		slog.Error("saving silences", "err", err)
+	}
+	slog.Info("silence created", "silence", s.ID, "by", s.CreatedBy, "starts_at", s.StartsAt, "ends_at", s.EndsAt, "comment", s.Comment)
+	writeSilence(w, http.StatusCreated, s)
+}
+
+// silenceUpdateHandler replaces an API silence. Those from -silences are
+// changed in the file.
+func silenceUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	tenant, id := tenantOf(r.Context()), r.PathValue("id")
+	old, ok := silences.lookup(tenant, id)
+	if !ok {
+		http.Error(w, "no silence with that id", http.StatusNotFound)
+		return
+	}
+	if old.Source == silenceFromConfig {
+		http.Error(w, "silence comes from -silences; change it there", http.StatusConflict)
+		return
+	}
+	s, err := decodeSilence(w, r, tenant)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.ID = id
+
+	silences.mu.Lock()
+	silences.api[id] = s
+	err = silences.saveLocked()
+	silences.mu.Unlock()
+	if err != nil {
+		slog.Error("saving silences", "err", err)
+	}
+	slog.Info("silence updated", "silence", id, "by", s.CreatedBy, "starts_at", s.StartsAt, "ends_at", s.EndsAt)
+	writeSilence(w, http.StatusOK, s)
+}
+
+func silenceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s, ok := silences.lookup(tenantOf(r.Context()), id)
+	if !ok {
+		http.Error(w, "no silence with that id", http.StatusNotFound)
+		return
+	}
+	if s.Source == silenceFromConfig {
+		http.Error(w, "silence comes from -silences; remove it there", http.StatusConflict)
+		return
+	}
+
+	silences.mu.Lock()
+	delete(silences.api, id)
+	err := silences.saveLocked()
+	silences.mu.Unlock()
+	if err != nil {
+		slog.Error("saving silences", "err", err)
+	}
+	slog.Info("silence deleted", "silence", id)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSilenceMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		match   map[string]string
+		matchRE map[string]string
+		labels  map[string]string
+		want    bool
+	}{
+		{"equal", map[string]string{"alertname": "Disk"}, nil, map[string]string{"alertname": "Disk", "host": "a"}, true},
+		{"differs", map[string]string{"alertname": "Disk"}, nil, map[string]string{"alertname": "CPU"}, false},
+		{"label missing", map[string]string{"host": "a"}, nil, map[string]string{"alertname": "Disk"}, false},
+		{"empty value matches missing", map[string]string{"host": ""}, nil, map[string]string{"alertname": "Disk"}, true},
+		{"all matchers", map[string]string{"alertname": "Disk", "host": "a"}, nil, map[string]string{"alertname": "Disk", "host": "b"}, false},
+		{"regexp", nil, map[string]string{"host": "broker-[0-9]+"}, map[string]string{"host": "broker-12"}, true},
+		{"regexp anchored", nil, map[string]string{"host": "broker-[0-9]+"}, map[string]string{"host": "old-broker-12"}, false},
+		{"regexp alternation anchored", nil, map[string]string{"host": "a|b"}, map[string]string{"host": "ab"}, false},
+		{"both kinds", map[string]string{"alertname": "Disk"}, map[string]string{"host": "a.*"}, map[string]string{"alertname": "Disk", "host": "ab"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &silence{Match: tt.match, MatchRE: tt.matchRE, EndsAt: time.Unix(1, 0)}
+			if err := s.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := s.matches(tt.labels); got != tt.want {
+				t.Errorf("matches(%v) = %v, want %v", tt.labels, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSilenceCompile(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		s    silence
+	}{
+		{"no matchers", silence{StartsAt: start, EndsAt: start.Add(time.Hour)}},
+		{"no end", silence{Match: map[string]string{"a": "b"}, StartsAt: start}},
+		{"ends before start", silence{Match: map[string]string{"a": "b"}, StartsAt: start, EndsAt: start.Add(-time.Hour)}},
+		{"bad label", silence{Match: map[string]string{"a-b": "c"}, StartsAt: start, EndsAt: start.Add(time.Hour)}},
+		{"bad regexp", silence{MatchRE: map[string]string{"a": "("}, StartsAt: start, EndsAt: start.Add(time.Hour)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.compile(); err == nil {
+				t.Error("compile succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestSilencing(t *testing.T) {
+	start := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	mk := func(id, tenant string, anyTenant bool) *silence {
+		s := &silence{ID: id, Tenant: tenant, anyTenant: anyTenant, Match: map[string]string{"alertname": "Disk"}, StartsAt: start, EndsAt: start.Add(time.Hour)}
+		if err := s.compile(); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	st := &silenceStore{
+		config: []*silence{mk("cfg", "", true)},
+		api:    map[string]*silence{"api": mk("api", "team-a", false)},
+	}
+	st.config[0].Match["host"] = "a"
+
+	disk := map[string]string{"alertname": "Disk", "host": "b"}
+	tests := []struct {
+		name   string
+		tenant string
+		labels map[string]string
+		at     time.Time
+		want   string // ID of the silence, empty for none
+	}{
+		{"own tenant", "team-a", disk, start.Add(time.Minute), "api"},
+		{"other tenant", "team-b", disk, start.Add(time.Minute), ""},
+		{"any tenant", "team-b", map[string]string{"alertname": "Disk", "host": "a"}, start.Add(time.Minute), "cfg"},
+		{"at start", "team-a", disk, start, "api"},
+		{"pending", "team-a", disk, start.Add(-time.Second), ""},
+		{"at end", "team-a", disk, start.Add(time.Hour), ""},
+		{"no match", "team-a", map[string]string{"alertname": "CPU"}, start.Add(time.Minute), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := st.silencing(tt.tenant, tt.labels, tt.at)
+			got := ""
+			if ok {
+				got = s.ID
+			}
+			if got != tt.want {
+				t.Errorf("silencing = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
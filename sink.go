@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+=============================
+ Output Sinks
+=============================
+*/
+
+var (
+	sinksFlag = flag.String("sinks", "", "comma-separated outputs: file, stdout, syslog, email, mqtt, webhook, slack, teams, snmp, kafka; empty means file plus every configured one")
+)
+
+// Sink is one destination for processed alerts. Write is called once per
+// alert, in lane order.
+type Sink interface {
+	Name() string
+	Write(ctx context.Context, a Alert) error
+}
+
+// batchSink is a sink that wants all alerts of a request at once, such as
+// email, where Alertmanager's grouping makes one message.
+type batchSink interface {
+	Sink
+	WriteBatch(ctx context.Context, alerts []Alert) error
+}
+
+const (
+	sinkFile    = "file"
+	sinkStdout  = "stdout"
+	sinkSyslog  = "syslog"
+	sinkWebhook = "webhook"
+)
+
+var sinkNames = []string{sinkFile, sinkStdout, sinkSyslog, emailSink, mqttSink, sinkWebhook, slackSink, teamsSink, snmpSink, kafkaSink}
+
+var (
+	sinksMu sync.RWMutex
+	sinkSet []Sink          // enabled, in -sinks order
+	sinkBy  map[string]Sink // the same, by name
+)
+
+// loadSinks builds the enabled sinks. Their own settings are checked by
+// their loaders; naming an unconfigured sink here is an error.
+func loadSinks() error {
+	names := splitList(*sinksFlag)
+	if len(names) == 0 {
+		names = []string{sinkFile}
+		if emailEnabled() {
+			names = append(names, emailSink)
+		}
+		if mqttEnabled() {
+			names = append(names, mqttSink)
+		}
+		if webhookEnabled() {
+			names = append(names, sinkWebhook)
+		}
+		if slackEnabled() {
+			names = append(names, slackSink)
+		}
+		if teamsEnabled() {
+			names = append(names, teamsSink)
+		}
+		if snmpEnabled() {
+			names = append(names, snmpSink)
+		}
+		if kafkaEnabled() {
+			names = append(names, kafkaSink)
+		}
+	}
+
+	built := make(map[string]Sink, len(names))
+	var set []Sink
+	for _, name := range names {
+		if _, dup := built[name]; dup {
+			continue
+		}
+		s, err := newSink(name)
+		if err != nil {
+			for _, s := range set {
+				closeSink(s)
+			}
+			return err
+		}
+		built[name] = s
+		set = append(set, s)
+	}
+
+	sinksMu.Lock()
+	old := sinkSet
+	sinkSet, sinkBy = set, built
+	sinksMu.Unlock()
+	for _, s := range old {
+		closeSink(s)
+	}
+	return nil
+}
+
+func newSink(name string) (Sink, error) {
+	switch name {
+	case sinkFile:
+		return fileSink{}, nil
+	case sinkStdout:
+		return &stdoutSink{}, nil
+	case sinkSyslog:
+		return newSyslogSink()
+	case emailSink:
+		if !emailEnabled() {
+			return nil, errors.New("email sink needs -smtp-host")
+		}
+		return emailOutput{}, nil
+	case mqttSink:
+		if !mqttEnabled() {
+			return nil, errors.New("mqtt sink needs -mqtt-broker")
+		}
+		return mqttOutput{}, nil
+	case sinkWebhook:
+		if !webhookEnabled() {
+			return nil, errors.New("webhook sink needs -webhook-url or -webhook-endpoints")
+		}
+		return &webhookSink{endpoints: webhookTargets}, nil
+	case slackSink:
+		if !slackEnabled() {
+			return nil, errors.New("slack sink needs -slack-webhook-url")
+		}
+		return newChatSink(slackSink), nil
+	case teamsSink:
+		if !teamsEnabled() {
+			return nil, errors.New("teams sink needs -teams-webhook-url")
+		}
+		return newChatSink(teamsSink), nil
+	case snmpSink:
+		if !snmpEnabled() {
+			return nil, errors.New("snmp sink needs -snmp-receivers")
+		}
+		return newSNMPSink()
+	case kafkaSink:
+		if !kafkaEnabled() {
+			return nil, errors.New("kafka sink needs -kafka-brokers")
+		}
+		return newKafkaSink()
+	}
+	return nil, fmt.Errorf("unknown sink %q (want one of %s)", name, strings.Join(sinkNames, ", "))
+}
+
+func closeSink(s Sink) {
+	if c, ok := s.(interface{ close() }); ok {
+		c.close()
+	}
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+func enabledSinkNames() []string {
+	sinksMu.RLock()
+	defer sinksMu.RUnlock()
+	names := make([]string, len(sinkSet))
+	for i, s := range sinkSet {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// fanOut routes the processed alerts and hands each route's share to the
+// route's sinks. Deliveries run concurrently; each sees its alerts in
+// order, so the priority lane still goes first within a sink. Routes that
+// are log-only print what the sinks would have been given instead.
+func fanOut(ctx context.Context, alerts []Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+	sinksMu.RLock()
+	byName := sinkBy
+	sinksMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, g := range routeAlerts(ctx, alerts) {
+		deliverGroup(withRoute(ctx, g.route), g, byName, &wg)
+	}
+	wg.Wait()
+}
+
+// deliverRoute hands alerts to one route's sinks, bypassing the routing
+// tree, and waits for them.
+func deliverRoute(ctx context.Context, rt *route, alerts []Alert) {
+	sinksMu.RLock()
+	byName := sinkBy
+	sinksMu.RUnlock()
+
+	var wg sync.WaitGroup
+	deliverGroup(withRoute(ctx, rt), routeGroup{route: rt, alerts: alerts}, byName, &wg)
+	wg.Wait()
+}
+
+// deliverGroup starts the deliveries of a route's share, or prints them
+// for log_only routes.
+func deliverGroup(ctx context.Context, g routeGroup, byName map[string]Sink, wg *sync.WaitGroup) {
+	audible := g.alerts
+	if slices.ContainsFunc(g.route.Sinks, func(name string) bool { return notificationSinks[name] }) {
+		audible = unacked(ctx, unsilenced(ctx, g.alerts))
+	}
+	var dry map[string][]Alert
+	if g.route.logOnly() {
+		dry = map[string][]Alert{}
+	}
+	for _, name := range g.route.Sinks {
+		s := byName[name]
+		alerts := g.alerts
+		if notificationSinks[name] {
+			alerts = audible
+		}
+		alerts = g.route.sinkAlerts(name, alerts)
+		if s == nil || len(alerts) == 0 {
+			continue
+		}
+		if dry != nil {
+			dry[name] = alerts
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliver(ctx, s, alerts)
+		}()
+	}
+	if dry != nil {
+		printDryRun(ctx, g.route, g.alerts, audible, dry)
+	}
+}
+
+// recordDelivery notes one attempt of a sink and logs a failure.
+func recordDelivery(ctx context.Context, sink, target string, a Alert, err error) error {
+	deliveries.record(delivery{
+		Fingerprint: a.Fingerprint,
+		RequestID:   requestID(ctx),
+		Tenant:      tenantOf(ctx),
+		Sink:        sink,
+		Target:      target,
+		At:          clock.Now(),
+	}, err)
+	if err != nil {
+		slog.Warn("sink write failed", "sink", sink, "target", target, "req_id", requestID(ctx), "fingerprint", a.Fingerprint, "err", err)
+		tracef(ctx, sink, a.Fingerprint, "%s: %v", target, err)
+		return err
+	}
+	tracef(ctx, sink, a.Fingerprint, "%s: written", target)
+	return nil
+}
+
+// recordLine is the output record of an alert as the file sink writes it,
+// without aggregation or integrity fields.
+func recordLine(ctx context.Context, a Alert) ([]byte, error) {
+	entry := buildEntry(ctx, a, clock.Now())
+	line, err := encodeEntry(entry)
+	if err != nil {
+		return nil, err
+	}
+	return fitEntry(entry, line)
+}
+
+type fileSink struct{}
+
+func (fileSink) Name() string { return sinkFile }
+
+func (fileSink) Write(ctx context.Context, a Alert) error {
+	return writeJSONLog(ctx, a)
+}
+
+// stdoutSink prints one record per line, for containers that ship stdout.
+type stdoutSink struct {
+	mu sync.Mutex
+}
+
+func (*stdoutSink) Name() string { return sinkStdout }
+
+func (s *stdoutSink) Write(ctx context.Context, a Alert) error {
+	line, err := recordLine(ctx, a)
+	if err == nil {
+		s.mu.Lock()
+		_, err = os.Stdout.Write(append(line, '\n'))
+		s.mu.Unlock()
+	}
+	return recordDelivery(ctx, sinkStdout, "stdout", a, err)
+}
+
+type emailOutput struct{}
+
+func (emailOutput) Name() string { return emailSink }
+
+func (emailOutput) Write(ctx context.Context, a Alert) error {
+	return notifyEmail(ctx, []Alert{a})
+}
+
+func (emailOutput) WriteBatch(ctx context.Context, alerts []Alert) error {
+	return notifyEmail(ctx, alerts)
+}
+
+type mqttOutput struct{}
+
+func (mqttOutput) Name() string { return mqttSink }
+
+func (mqttOutput) Write(ctx context.Context, a Alert) error {
+	return notifyMQTT(ctx, []Alert{a})
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+/*
+=============================
+ Clock Skew Detection
+=============================
+*/
+
+var skewThreshold = flag.Duration("clock-skew-threshold", 2*time.Minute, "flag alerts whose startsAt lies further than this in the future; 0 disables")
+
+var clockSkewTotal = newCounterVec("clock_skew_alerts_total", "Alerts whose startsAt lay in the future beyond -clock-skew-threshold.", "hostname")
+
+// alertSkew reports how far an alert's startsAt lies ahead of local time.
+// Only future starts are conclusive; a past startsAt is normal.
+func alertSkew(alert Alert, now time.Time) (time.Duration, bool) {
+	if *skewThreshold <= 0 || alert.StartsAt.IsZero() {
+		return 0, false
+	}
+	skew := alert.StartsAt.Sub(now)
+	return skew, skew > *skewThreshold
+}
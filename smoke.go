@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+/*
+=============================
+ Self Smoke Test (smoke)
+=============================
+*/
+
+const smokeFixture = "testdata/fixtures/node-down.json"
+
+// runSmoke boots a private instance on an ephemeral port with a temporary
+// output directory, posts a canned payload to it and waits for the entry
+// to land on disk.
+func runSmoke(args []string) error {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 5*time.Second, "how long to wait for the entry to be written")
+	keep := fs.Bool("keep", false, "keep the temporary output directory")
+	fs.Parse(args)
+
+	dir, err := os.MkdirTemp("", "hivemq-smoke-")
+	if err != nil {
+		return err
+	}
+	if !*keep {
+		defer os.RemoveAll(dir)
+	}
+	pinFlag("log-dir", dir)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, ln, nil) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	payload, err := exampleFS.ReadFile(smokeFixture)
+	if err != nil {
+		return err
+	}
+	id := "smoke-" + newRequestID()
+	url := "http://" + ln.Addr().String() + alertsPath
+
+	req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, id)
+	signRequest(req, payload)
+	resp, err := (&http.Client{Timeout: *timeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("smoke: post failed: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("smoke: %s answered %s", alertsPath, resp.Status)
+	}
+
+	deadline := time.Now().Add(*timeout)
+	for {
+		entry, found, err := findSmokeEntry(id)
+		if err != nil {
+			return fmt.Errorf("smoke: reading output: %w", err)
+		}
+		if found {
+			if entry.KPI != "HiveMQNodeDown" {
+				return fmt.Errorf("smoke: entry has kpi %q, want HiveMQNodeDown", entry.KPI)
+			}
+			fmt.Printf("smoke ok: entry %s written to %s\n", id, dir)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("smoke: entry did not appear in the output file in time")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func findSmokeEntry(id string) (JSONLog, bool, error) {
+	var found JSONLog
+	errFound := errors.New("found")
+	err := scanHistory("", time.Time{}, time.Time{}, func(e JSONLog) error {
+		if e.RequestID == id {
+			found = e
+			return errFound
+		}
+		return nil
+	})
+	if err == errFound {
+		return found, true, nil
+	}
+	return JSONLog{}, false, err
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+/*
+=============================
+ SNMP Trap Sink
+=============================
+*/
+
+var (
+	snmpReceivers     = flag.String("snmp-receivers", "", "comma-separated trap receivers, host or host:port (default port 162); empty disables the snmp sink")
+	snmpVersion       = flag.String("snmp-version", "v2c", "trap version: v2c or v3")
+	snmpCommunity     = flag.String("snmp-community", "public", "v2c community")
+	snmpEnterpriseOID = flag.String("snmp-enterprise-oid", "1.3.6.1.4.1.32473.1", "OID under which traps are sent: snmpTrapOID is <oid>.0.1 for firing and <oid>.0.2 for resolved alerts, varbinds are <oid>.1.<n>")
+	snmpVarbinds      = flag.String("snmp-varbinds", "hostname=1,alertname=2,severity=3,summary=4,status=5,fingerprint=6",
+		"comma-separated field=OID of the varbinds: a label or annotation name, or status, fingerprint, starts_at, tenant; a number is <enterprise-oid>.1.<n>")
+	snmpUser             = flag.String("snmp-user", "", "v3 user name")
+	snmpAuthProtocol     = flag.String("snmp-auth-protocol", "SHA", "v3 authentication: none, MD5, SHA, SHA224, SHA256, SHA384 or SHA512")
+	snmpAuthPasswordFile = flag.String("snmp-auth-password-file", "", "file holding the v3 authentication passphrase")
+	snmpPrivProtocol     = flag.String("snmp-priv-protocol", "AES", "v3 privacy: none, DES, AES, AES192 or AES256")
+	snmpPrivPasswordFile = flag.String("snmp-priv-password-file", "", "file holding the v3 privacy passphrase")
+	snmpEngineID         = flag.String("snmp-engine-id", "", "v3 engine ID of the bridge in hex, as the receivers know it; empty derives one from the host name")
+	snmpTimeout          = flag.Duration("snmp-timeout", 5*time.Second, "deadline for sending one trap")
+)
+
+const snmpSink = "snmp"
+
+// sysUpTime.0 and snmpTrapOID.0 start every SNMPv2 trap.
+const (
+	oidSysUpTime   = "1.3.6.1.2.1.1.3.0"
+	oidSnmpTrapOID = "1.3.6.1.6.3.1.1.4.1.0"
+)
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"none": gosnmp.NoAuth, "MD5": gosnmp.MD5, "SHA": gosnmp.SHA, "SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256, "SHA384": gosnmp.SHA384, "SHA512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"none": gosnmp.NoPriv, "DES": gosnmp.DES, "AES": gosnmp.AES, "AES192": gosnmp.AES192, "AES256": gosnmp.AES256,
+}
+
+var oidPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)+$`)
+
+func snmpEnabled() bool {
+	return *snmpReceivers != ""
+}
+
+// snmpVarbind maps one field of an alert to an OID.
+type snmpVarbind struct {
+	field, oid string
+}
+
+// snmpTrapSink sends one trap per alert to every receiver.
+type snmpTrapSink struct {
+	enterprise string
+	varbinds   []snmpVarbind
+	started    time.Time
+
+	receivers []*snmpReceiver
+}
+
+type snmpReceiver struct {
+	addr    string
+	started time.Time
+
+	mu     sync.Mutex
+	client *gosnmp.GoSNMP
+	conn   bool
+}
+
+func newSNMPSink() (Sink, error) {
+	enterprise := strings.Trim(*snmpEnterpriseOID, ".")
+	if !oidPattern.MatchString(enterprise) {
+		return nil, fmt.Errorf("snmp sink: invalid -snmp-enterprise-oid %q", *snmpEnterpriseOID)
+	}
+	s := &snmpTrapSink{enterprise: enterprise, started: time.Now()}
+	for _, pair := range splitList(*snmpVarbinds) {
+		field, oid, _ := strings.Cut(pair, "=")
+		oid = strings.Trim(oid, ".")
+		if _, err := strconv.ParseUint(oid, 10, 32); err == nil {
+			oid = enterprise + ".1." + oid
+		}
+		if field == "" || !oidPattern.MatchString(oid) {
+			return nil, fmt.Errorf("snmp sink: invalid -snmp-varbinds entry %q", pair)
+		}
+		s.varbinds = append(s.varbinds, snmpVarbind{field: field, oid: oid})
+	}
+
+	for _, addr := range splitList(*snmpReceivers) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			host, port = addr, "162"
+		}
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil || host == "" {
+			return nil, fmt.Errorf("snmp sink: invalid receiver %q", addr)
+		}
+		client, err := snmpClient()
+		if err != nil {
+			return nil, fmt.Errorf("snmp sink: %w", err)
+		}
+		client.Target, client.Port = host, uint16(p)
+		s.receivers = append(s.receivers, &snmpReceiver{addr: net.JoinHostPort(host, port), started: s.started, client: client})
+	}
+	return s, nil
+}
+
+// snmpClient is a client with the version and security settings; the
+// caller sets the receiver.
+func snmpClient() (*gosnmp.GoSNMP, error) {
+	c := &gosnmp.GoSNMP{Transport: "udp", Timeout: *snmpTimeout, Retries: 0}
+	switch *snmpVersion {
+	case "v2c":
+		c.Version, c.Community = gosnmp.Version2c, *snmpCommunity
+		return c, nil
+	case "v3":
+	default:
+		return nil, fmt.Errorf("invalid -snmp-version %q, want v2c or v3", *snmpVersion)
+	}
+	if *snmpUser == "" {
+		return nil, errors.New("v3 needs -snmp-user")
+	}
+	auth, ok := snmpAuthProtocols[*snmpAuthProtocol]
+	if !ok {
+		return nil, fmt.Errorf("invalid -snmp-auth-protocol %q", *snmpAuthProtocol)
+	}
+	priv, ok := snmpPrivProtocols[*snmpPrivProtocol]
+	if !ok {
+		return nil, fmt.Errorf("invalid -snmp-priv-protocol %q", *snmpPrivProtocol)
+	}
+	usm := &gosnmp.UsmSecurityParameters{
+		UserName:                 *snmpUser,
+		AuthenticationProtocol:   auth,
+		PrivacyProtocol:          priv,
+		AuthoritativeEngineBoots: 1,
+	}
+	c.MsgFlags = gosnmp.NoAuthNoPriv
+	if auth != gosnmp.NoAuth {
+		pass, err := snmpPassphrase(*snmpAuthPasswordFile, "-snmp-auth-password-file")
+		if err != nil {
+			return nil, err
+		}
+		usm.AuthenticationPassphrase = pass
+		c.MsgFlags = gosnmp.AuthNoPriv
+	}
+	if priv != gosnmp.NoPriv {
+		if auth == gosnmp.NoAuth {
+			return nil, errors.New("v3 privacy needs authentication")
+		}
+		pass, err := snmpPassphrase(*snmpPrivPasswordFile, "-snmp-priv-password-file")
+		if err != nil {
+			return nil, err
+		}
+		usm.PrivacyPassphrase = pass
+		c.MsgFlags = gosnmp.AuthPriv
+	}
+	engine, err := snmpEngine()
+	if err != nil {
+		return nil, err
+	}
+	usm.AuthoritativeEngineID = engine
+	c.Version, c.SecurityModel, c.SecurityParameters = gosnmp.Version3, gosnmp.UserSecurityModel, usm
+	return c, nil
+}
+
+// snmpEngine is -snmp-engine-id, or an RFC 3411 text engine ID of the host
+// name under the documentation enterprise number 32473.
+func snmpEngine() (string, error) {
+	if *snmpEngineID != "" {
+		id, err := hex.DecodeString(strings.TrimPrefix(*snmpEngineID, "0x"))
+		if err != nil || len(id) < 5 || len(id) > 32 {
+			return "", fmt.Errorf("invalid -snmp-engine-id %q, want 5 to 32 bytes in hex", *snmpEngineID)
+		}
+		return string(id), nil
+	}
+	host, _ := os.Hostname()
+	if len(host) > 27 {
+		host = host[:27]
+	}
+	return string([]byte{0x80, 0x00, 0x7e, 0xd9, 0x04}) + host, nil
+}
+
+// snmpPassphrase reads a v3 passphrase file.
+func snmpPassphrase(file, name string) (string, error) {
+	if file == "" {
+		return "", fmt.Errorf("v3 needs %s", name)
+	}
+	secret, err := readSecret(file)
+	return string(secret), err
+}
+
+func (*snmpTrapSink) Name() string { return snmpSink }
+
+// Write retries each receiver on its own, so a failing one does not get the
+// trap sent again to those that took it.
+func (s *snmpTrapSink) Write(ctx context.Context, a Alert) error {
+	only := replayTarget(ctx)
+	trap := s.trap(ctx, a)
+	var errs []error
+	for _, r := range s.receivers {
+		if only != "" && r.addr != only {
+			continue
+		}
+		err := withRetries(ctx, snmpSink, func() error {
+			return breakerFor(snmpSink + ":" + r.addr).call(func() error { return r.send(trap) })
+		})
+		if recordDelivery(ctx, snmpSink, r.addr, a, err) != nil {
+			errs = append(errs, targetError{target: r.addr, fingerprint: a.Fingerprint, err: fmt.Errorf("%w: %w", errGaveUp, err)})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// trap builds the varbinds of an alert; fields the alert lacks are sent
+// empty, so receivers can rely on every OID being there.
+func (s *snmpTrapSink) trap(ctx context.Context, a Alert) gosnmp.SnmpTrap {
+	kind := ".0.1"
+	if a.Status == "resolved" {
+		kind = ".0.2"
+	}
+	vars := []gosnmp.SnmpPDU{
+		{Name: oidSysUpTime, Type: gosnmp.TimeTicks, Value: uint32(time.Since(s.started) / (10 * time.Millisecond))},
+		{Name: oidSnmpTrapOID, Type: gosnmp.ObjectIdentifier, Value: s.enterprise + kind},
+	}
+	for _, v := range s.varbinds {
+		vars = append(vars, gosnmp.SnmpPDU{Name: v.oid, Type: gosnmp.OctetString, Value: snmpField(ctx, a, v.field)})
+	}
+	return gosnmp.SnmpTrap{Variables: vars}
+}
+
+func snmpField(ctx context.Context, a Alert, field string) string {
+	switch field {
+	case "status":
+		return safeValue(a.Status, "firing")
+	case "fingerprint":
+		return a.Fingerprint
+	case "starts_at":
+		return a.StartsAt.Format(time.RFC3339)
+	case "tenant":
+		return tenantOf(ctx)
+	case "hostname":
+		return safeHostname(a.Labels)
+	}
+	if v, ok := a.Labels[field]; ok {
+		return v
+	}
+	return a.Annotations[field]
+}
+
+// send connects on first use; a UDP socket only fails to open when the
+// receiver's name does not resolve.
+func (r *snmpReceiver) send(trap gosnmp.SnmpTrap) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.conn {
+		if err := r.client.Connect(); err != nil {
+			return err
+		}
+		r.conn = true
+	}
+	if usm, ok := r.client.SecurityParameters.(*gosnmp.UsmSecurityParameters); ok {
+		usm.AuthoritativeEngineTime = uint32(time.Since(r.started).Seconds())
+	}
+	_, err := r.client.SendTrap(trap)
+	return err
+}
+
+func (s *snmpTrapSink) close() {
+	for _, r := range s.receivers {
+		r.mu.Lock()
+		if r.conn {
+			r.client.Conn.Close()
+			r.conn = false
+		}
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Delivery Spool
+=============================
+*/
+
+var (
+	spoolDir           = flag.String("spool-dir", "", "directory, ideally on another volume than -log-dir, where alerts a sink gave up on wait and are retried in order until the sink takes them; empty dead-letters them right away")
+	spoolMaxMB         = flag.Int("spool-max-mb", 100, "size of the spool beyond which failed alerts go to the dead-letter file instead")
+	spoolMaxAge        = flag.Duration("spool-max-age", 24*time.Hour, "how long an alert may wait in the spool before it is dead-lettered")
+	spoolRetryInterval = flag.Duration("spool-retry-interval", 30*time.Second, "how often each spooled sink is tried again")
+)
+
+var (
+	spooledTotal   = newCounterVec("spooled_total", "Alerts put in the spool after their sink gave up, by sink.", "sink")
+	spoolDelivered = newCounterVec("spool_delivered_total", "Spooled alerts their sink took on a later try, by sink.", "sink")
+	spoolOverflow  = newCounterVec("spool_overflow_total", "Alerts dead-lettered because the spool was full or they waited too long, by sink.", "sink")
+
+	_ = newGaugeFunc("spool_depth", "Alerts waiting in the spool, by sink.", "sink", func() map[string]float64 { return spool.stats(false) })
+	_ = newGaugeFunc("spool_bytes", "Size of the spool files, by sink.", "sink", func() map[string]float64 { return spool.stats(true) })
+)
+
+var (
+	errSpoolFull     = errors.New("spool full")
+	errSpooledBehind = errors.New("queued behind the sink's spooled alerts")
+)
+
+func spoolEnabled() bool {
+	return *spoolDir != ""
+}
+
+func validSpool() error {
+	if *spoolMaxMB < 1 || *spoolMaxAge <= 0 || *spoolRetryInterval <= 0 {
+		return errors.New("-spool-max-mb, -spool-max-age and -spool-retry-interval must be positive")
+	}
+	return nil
+}
+
+// spoolQueue holds the alerts of one sink, or one target of a sink with
+// several, oldest first, mirrored by a file of dead-letter records.
+type spoolQueue struct {
+	sink, target string
+	path         string
+	entries      []deadLetter
+	sizes        []int64
+	bytes        int64
+}
+
+type alertSpool struct {
+	mu     sync.Mutex
+	queues map[[2]string]*spoolQueue
+}
+
+var spool = &alertSpool{queues: map[[2]string]*spoolQueue{}}
+
+// loadSpool picks up what a previous run left in -spool-dir.
+func loadSpool() error {
+	if !spoolEnabled() {
+		return nil
+	}
+	if err := os.MkdirAll(*spoolDir, 0700); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(*spoolDir, "*.spool"))
+	if err != nil {
+		return err
+	}
+	spool.mu.Lock()
+	defer spool.mu.Unlock()
+	total := 0
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		sc.Buffer(nil, 16<<20)
+		for n := 1; sc.Scan(); n++ {
+			var d deadLetter
+			if err := json.Unmarshal(sc.Bytes(), &d); err != nil || d.Alert == nil {
+				slog.Warn("spool: unreadable record skipped", "file", path, "line", n, "err", err)
+				continue
+			}
+			q := spool.queue(d.Sink, d.Target)
+			q.path = path
+			q.push(d, int64(len(sc.Bytes())+1))
+			total++
+		}
+	}
+	if total > 0 {
+		slog.Info("spool loaded", "alerts", total, "dir", *spoolDir)
+	}
+	return nil
+}
+
+// queue returns the queue of a sink and target, creating it. s.mu is held.
+func (s *alertSpool) queue(sink, target string) *spoolQueue {
+	key := [2]string{sink, target}
+	q, ok := s.queues[key]
+	if !ok {
+		name := sink
+		if target != "" {
+			sum := sha256.Sum256([]byte(target))
+			name += "-" + hex.EncodeToString(sum[:6])
+		}
+		q = &spoolQueue{sink: sink, target: target, path: filepath.Join(*spoolDir, name+".spool")}
+		s.queues[key] = q
+	}
+	return q
+}
+
+func (q *spoolQueue) push(d deadLetter, size int64) {
+	q.entries = append(q.entries, d)
+	q.sizes = append(q.sizes, size)
+	q.bytes += size
+}
+
+// size is the spool's total size. s.mu is held.
+func (s *alertSpool) size() int64 {
+	var n int64
+	for _, q := range s.queues {
+		n += q.bytes
+	}
+	return n
+}
+
+// add appends a failed alert to its queue and file. A full spool refuses
+// it, so the caller dead-letters it instead.
+func (s *alertSpool) add(d deadLetter) error {
+	line, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size()+int64(len(line)) > int64(*spoolMaxMB)<<20 {
+		return errSpoolFull
+	}
+	q := s.queue(d.Sink, d.Target)
+	f, err := os.OpenFile(q.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	q.push(d, int64(len(line)))
+	spooledTotal.add(d.Sink, 1)
+	return nil
+}
+
+// holds reports whether alerts the sink failed as a whole are waiting; new
+// ones then queue behind them, so the sink gets them in order. A failing
+// target of a sink with several holds back only its own alerts.
+func (s *alertSpool) holds(sink string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[[2]string{sink, ""}]
+	return ok && len(q.entries) > 0
+}
+
+func (s *alertSpool) stats(bytes bool) map[string]float64 {
+	if !spoolEnabled() {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string]float64{}
+	for _, q := range s.queues {
+		if bytes {
+			out[q.sink] += float64(q.bytes)
+		} else {
+			out[q.sink] += float64(len(q.entries))
+		}
+	}
+	return out
+}
+
+// spoolAlert queues an alert a sink gave up on; it reports false when the
+// alert has to be dead-lettered instead.
+func spoolAlert(ctx context.Context, sink, target string, a Alert, cause error) bool {
+	if !spoolEnabled() || errors.Is(cause, errOversize) {
+		return false
+	}
+	tenant := tenantOf(ctx)
+	err := spool.add(deadLetter{
+		At:          clock.Now(),
+		Reason:      cause.Error(),
+		RequestID:   requestID(ctx),
+		Fingerprint: a.fingerprint(),
+		Sink:        sink,
+		Target:      target,
+		Tenant:      tenant,
+		Route:       routeOf(ctx).Name,
+		Alert:       &a,
+	})
+	if err != nil {
+		if errors.Is(err, errSpoolFull) {
+			spoolOverflow.add(sink, 1)
+		}
+		slog.Warn("spooling failed, dead-lettering", "sink", sink, "req_id", requestID(ctx), "fingerprint", a.fingerprint(), "err", err)
+		return false
+	}
+	tracef(ctx, sink, a.fingerprint(), "gave up (%v), spooled for a later try", cause)
+	return true
+}
+
+// runSpool retries the spooled sinks until done is closed.
+func runSpool(done <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+	for {
+		var every time.Duration
+		withSettings(func() { every = *spoolRetryInterval })
+		timer := time.NewTimer(every)
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		withSettings(func() { spool.drain(ctx) })
+	}
+}
+
+// drain hands each queue's alerts to their sink, oldest first, until one
+// fails. Alerts past -spool-max-age go to the dead-letter file. A crash
+// between a delivery and the rewrite of the file delivers it once more.
+func (s *alertSpool) drain(ctx context.Context) {
+	s.mu.Lock()
+	queues := make([]*spoolQueue, 0, len(s.queues))
+	for _, q := range s.queues {
+		queues = append(queues, q)
+	}
+	s.mu.Unlock()
+	sort.Slice(queues, func(i, j int) bool { return queues[i].path < queues[j].path })
+
+	for _, q := range queues {
+		done := 0
+		for ctx.Err() == nil {
+			s.mu.Lock()
+			if done >= len(q.entries) {
+				s.mu.Unlock()
+				break
+			}
+			d := q.entries[done]
+			s.mu.Unlock()
+
+			if clock.Now().Sub(d.At) > *spoolMaxAge {
+				spoolOverflow.add(d.Sink, 1)
+				d.At, d.Reason = clock.Now(), fmt.Sprintf("spooled longer than %s: %s", *spoolMaxAge, d.Reason)
+				if err := writeDeadLetter(deadLetterDir(d.Tenant), d); err != nil {
+					slog.Error("dead-lettering failed, alert lost", "sink", d.Sink, "req_id", d.RequestID, "fingerprint", d.Fingerprint, "err", err)
+				} else {
+					deadLettered.add(d.Sink, 1)
+				}
+			} else if err := redeliver(ctx, d); err != nil {
+				slog.Debug("spooled sink still failing", "sink", d.Sink, "target", d.Target, "waiting", len(q.entries)-done, "err", err)
+				break
+			} else {
+				spoolDelivered.add(d.Sink, 1)
+			}
+			done++
+		}
+		if done > 0 {
+			s.mu.Lock()
+			err := q.pop(done)
+			s.mu.Unlock()
+			if err != nil {
+				slog.Error("spool: rewriting queue file", "file", q.path, "err", err)
+			}
+		}
+	}
+}
+
+// pop drops the first n entries and rewrites the file. s.mu is held.
+func (q *spoolQueue) pop(n int) error {
+	for _, size := range q.sizes[:n] {
+		q.bytes -= size
+	}
+	q.entries, q.sizes = q.entries[n:], q.sizes[n:]
+	if len(q.entries) == 0 {
+		q.entries, q.sizes, q.bytes = nil, nil, 0
+		err := os.Remove(q.path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	var buf bytes.Buffer
+	for _, d := range q.entries {
+		line, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
+
+// redeliver makes one try of a spooled alert on its sink, for the route it
+// was first sent on if that still exists. The spool's interval stands in
+// for the retries.
+func redeliver(ctx context.Context, d deadLetter) error {
+	sinksMu.RLock()
+	s := sinkBy[d.Sink]
+	sinksMu.RUnlock()
+	if s == nil {
+		return fmt.Errorf("sink %q is not enabled", d.Sink)
+	}
+	root := currentRoute()
+	rt := root.find(d.Route)
+	if rt == nil {
+		rt = root
+	}
+	ctx = withTenantValue(context.WithValue(ctx, requestIDKey{}, d.RequestID), d.Tenant)
+	ctx = withRoute(ctx, rt)
+	if d.Target != "" {
+		ctx = context.WithValue(ctx, replayTargetKey{}, d.Target)
+	}
+	a := *d.Alert
+	err := s.Write(ctx, a)
+	if err == nil {
+		tracef(ctx, "spool", a.fingerprint(), "%s took the alert spooled at %s", d.Sink, d.At.Format(time.RFC3339))
+	}
+	return err
+}
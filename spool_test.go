@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakySink takes alerts until it is told to fail on one.
+type flakySink struct {
+	failOn string // alertname
+	got    []string
+}
+
+func (s *flakySink) Name() string { return "flaky" }
+
+func (s *flakySink) Write(ctx context.Context, a Alert) error {
+	if a.Labels["alertname"] == s.failOn {
+		return errors.New("down")
+	}
+	s.got = append(s.got, a.Labels["alertname"])
+	return nil
+}
+
+// useSpool points the spool at a fresh directory and registers sink.
+func useSpool(t *testing.T, sink Sink) {
+	t.Helper()
+	dir, maxMB, maxAge, dlDir := *spoolDir, *spoolMaxMB, *spoolMaxAge, *deadLetterDirFlag
+	sinksMu.Lock()
+	by := sinkBy
+	sinkBy = map[string]Sink{}
+	if sink != nil {
+		sinkBy[sink.Name()] = sink
+	}
+	sinksMu.Unlock()
+	t.Cleanup(func() {
+		*spoolDir, *spoolMaxMB, *spoolMaxAge, *deadLetterDirFlag = dir, maxMB, maxAge, dlDir
+		sinksMu.Lock()
+		sinkBy = by
+		sinksMu.Unlock()
+	})
+	*spoolDir, *spoolMaxMB, *spoolMaxAge = t.TempDir(), 1, time.Hour
+	*deadLetterDirFlag = t.TempDir()
+}
+
+func spooled(name string, at time.Time) deadLetter {
+	a := Alert{Status: "firing", Labels: map[string]string{"alertname": name}}
+	return deadLetter{At: at, Reason: "down", Sink: "flaky", Alert: &a}
+}
+
+func TestSpoolDrain(t *testing.T) {
+	now := clock.Now()
+	old := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name          string
+		sink          *flakySink // nil: not enabled
+		entries       []deadLetter
+		wantDelivered string
+		wantLeft      string
+		wantDead      int
+	}{
+		{"all taken", &flakySink{}, []deadLetter{spooled("A", now), spooled("B", now), spooled("C", now)}, "A B C", "", 0},
+		{"stops at the first failure", &flakySink{failOn: "B"}, []deadLetter{spooled("A", now), spooled("B", now), spooled("C", now)}, "A", "B C", 0},
+		{"failing first holds the rest", &flakySink{failOn: "A"}, []deadLetter{spooled("A", now), spooled("B", now)}, "", "A B", 0},
+		{"too old is dead-lettered", &flakySink{}, []deadLetter{spooled("A", old), spooled("B", now)}, "B", "", 1},
+		{"too old even when failing", &flakySink{failOn: "A"}, []deadLetter{spooled("A", old), spooled("B", now)}, "B", "", 1},
+		{"sink not enabled", nil, []deadLetter{spooled("A", now)}, "", "A", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sink Sink
+			if tt.sink != nil {
+				sink = tt.sink
+			}
+			useSpool(t, sink)
+			s := &alertSpool{queues: map[[2]string]*spoolQueue{}}
+			for _, d := range tt.entries {
+				if err := s.add(d); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s.drain(context.Background())
+
+			var delivered []string
+			if tt.sink != nil {
+				delivered = tt.sink.got
+			}
+			if got := strings.Join(delivered, " "); got != tt.wantDelivered {
+				t.Errorf("delivered %q, want %q", got, tt.wantDelivered)
+			}
+			q := s.queues[[2]string{"flaky", ""}]
+			var left []string
+			for _, d := range q.entries {
+				left = append(left, d.Alert.Labels["alertname"])
+			}
+			if got := strings.Join(left, " "); got != tt.wantLeft {
+				t.Errorf("left %q, want %q", got, tt.wantLeft)
+			}
+
+			// The file mirrors the queue, so a restart picks up the same.
+			reloaded := &alertSpool{queues: map[[2]string]*spoolQueue{}}
+			restore := spool
+			spool = reloaded
+			err := loadSpool()
+			spool = restore
+			if err != nil {
+				t.Fatal(err)
+			}
+			var after []string
+			if rq := reloaded.queues[[2]string{"flaky", ""}]; rq != nil {
+				for _, d := range rq.entries {
+					after = append(after, d.Alert.Labels["alertname"])
+				}
+				if rq.bytes != q.bytes {
+					t.Errorf("reloaded %d bytes, queue says %d", rq.bytes, q.bytes)
+				}
+			}
+			if got := strings.Join(after, " "); got != tt.wantLeft {
+				t.Errorf("reloaded %q, want %q", got, tt.wantLeft)
+			}
+			if _, err := os.Stat(q.path); (err == nil) != (tt.wantLeft != "") {
+				t.Errorf("spool file: %v, want it only while alerts wait", err)
+			}
+
+			dead, _ := os.ReadFile(deadLetterPath(*deadLetterDirFlag, clock.Now()))
+			if n := strings.Count(string(dead), "\n"); n != tt.wantDead {
+				t.Errorf("%d dead letter(s), want %d", n, tt.wantDead)
+			}
+		})
+	}
+}
+
+func TestSpoolAdd(t *testing.T) {
+	useSpool(t, &flakySink{})
+	s := &alertSpool{queues: map[[2]string]*spoolQueue{}}
+
+	big := spooled("Big", clock.Now())
+	big.Alert.Annotations = map[string]string{"description": strings.Repeat("x", 600<<10)}
+	tests := []struct {
+		name    string
+		d       deadLetter
+		wantErr error
+	}{
+		{"first", big, nil},
+		{"over -spool-max-mb", big, errSpoolFull},
+		{"small one still fits", spooled("Small", clock.Now()), nil},
+	}
+	for _, tt := range tests {
+		if err := s.add(tt.d); !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: add = %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+	if !s.holds("flaky") || s.holds("other") {
+		t.Error("holds does not report the waiting sink")
+	}
+
+	// A target of its own gets a queue and file of its own.
+	d := spooled("Hook", clock.Now())
+	d.Target = "https://hooks.example/a"
+	if err := s.add(d); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(*spoolDir, "*.spool"))
+	if len(files) != 2 {
+		t.Errorf("spool files %v, want one per sink and target", files)
+	}
+}
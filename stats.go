@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+=============================
+ Alert Statistics
+=============================
+*/
+
+type statsBucket struct {
+	Start string `json:"start"`
+	Count int    `json:"count"`
+}
+
+type hostCount struct {
+	Host  string `json:"host"`
+	Count int    `json:"count"`
+}
+
+type statsGroup struct {
+	Key       string        `json:"key"`
+	Count     int           `json:"count"`
+	FirstSeen string        `json:"first_seen"`
+	LastSeen  string        `json:"last_seen"`
+	TopHosts  []hostCount   `json:"top_hosts"`
+	Buckets   []statsBucket `json:"buckets,omitempty"`
+
+	hosts       map[string]int
+	buckets     map[time.Time]int
+	first, last time.Time
+}
+
+var statsKeys = map[string]func(JSONLog) string{
+	"alertname": func(e JSONLog) string { return e.KPI },
+	"hostname":  func(e JSONLog) string { return e.Hostname },
+	"ip":        func(e JSONLog) string { return e.IP },
+}
+
+const statsTopHosts = 5
+
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	groupBy := q.Get("group_by")
+	if groupBy == "" {
+		groupBy = "alertname"
+	}
+	keyOf, ok := statsKeys[groupBy]
+	if !ok {
+		http.Error(w, "group_by must be alertname, hostname or ip", http.StatusBadRequest)
+		return
+	}
+
+	var interval time.Duration
+	if v := q.Get("interval"); v != "" {
+		d, err := parseInterval(v)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid interval", http.StatusBadRequest)
+			return
+		}
+		interval = d
+	}
+
+	from, err := parseTimeParam(q.Get("from"), false)
+	if err != nil {
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := parseTimeParam(q.Get("to"), true)
+	if err != nil {
+		http.Error(w, "invalid to: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	groups, err := computeStats(tenantOf(r.Context()), from, to, keyOf, interval)
+	if err != nil {
+		http.Error(w, "reading history failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		GroupBy  string        `json:"group_by"`
+		Interval string        `json:"interval,omitempty"`
+		From     string        `json:"from,omitempty"`
+		To       string        `json:"to,omitempty"`
+		Groups   []*statsGroup `json:"groups"`
+	}{groupBy, q.Get("interval"), q.Get("from"), q.Get("to"), groups})
+}
+
+func computeStats(tenant string, from, to time.Time, keyOf func(JSONLog) string, interval time.Duration) ([]*statsGroup, error) {
+	byKey := make(map[string]*statsGroup)
+
+	err := scanHistory(tenant, from, to, func(e JSONLog) error {
+		key := keyOf(e)
+		g, ok := byKey[key]
+		if !ok {
+			g = &statsGroup{Key: key, hosts: map[string]int{}, buckets: map[time.Time]int{}}
+			byKey[key] = g
+		}
+		g.Count++
+		g.hosts[e.Hostname]++
+
+		ts, err := entryTime(e)
+		if err != nil {
+			return nil
+		}
+		if g.first.IsZero() || ts.Before(g.first) {
+			g.first, g.FirstSeen = ts, e.Timestamp
+		}
+		if ts.After(g.last) {
+			g.last, g.LastSeen = ts, e.Timestamp
+		}
+		if interval > 0 {
+			g.buckets[bucketStart(ts, interval)]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make([]*statsGroup, 0, len(byKey))
+	for _, g := range byKey {
+		for h, n := range g.hosts {
+			g.TopHosts = append(g.TopHosts, hostCount{h, n})
+		}
+		sort.Slice(g.TopHosts, func(i, j int) bool {
+			if g.TopHosts[i].Count != g.TopHosts[j].Count {
+				return g.TopHosts[i].Count > g.TopHosts[j].Count
+			}
+			return g.TopHosts[i].Host < g.TopHosts[j].Host
+		})
+		if len(g.TopHosts) > statsTopHosts {
+			g.TopHosts = g.TopHosts[:statsTopHosts]
+		}
+
+		starts := make([]time.Time, 0, len(g.buckets))
+		for start := range g.buckets {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+		for _, start := range starts {
+			g.Buckets = append(g.Buckets, statsBucket{start.Format(time.RFC3339), g.buckets[start]})
+		}
+
+		groups = append(groups, g)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Key < groups[j].Key
+	})
+	return groups, nil
+}
+
+// parseInterval extends time.ParseDuration with a day unit ("1d", "7d").
+func parseInterval(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// bucketStart aligns day-sized intervals to local midnight.
+func bucketStart(t time.Time, d time.Duration) time.Time {
+	if d%(24*time.Hour) == 0 {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		days := int(d / (24 * time.Hour))
+		epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, t.Location())
+		offset := int(math.Round(day.Sub(epoch).Hours()/24)) % days
+		return day.AddDate(0, 0, -offset)
+	}
+	return t.Truncate(d)
+}
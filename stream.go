@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+/*
+=============================
+ Streaming Payload Decoding
+=============================
+*/
+
+var streamBatch = flag.Int("stream-batch", 500,
+	"alerts a streamed webhook body hands to the queue at a time, so that a large group is never held whole")
+
+// errHandedOff stops a streamed decode once a batch was refused; the
+// request has been answered already.
+var errHandedOff = errors.New("batch refused")
+
+func validStreamBatch() error {
+	if *streamBatch < 1 {
+		return fmt.Errorf("-stream-batch must be at least 1")
+	}
+	return nil
+}
+
+// streamDecode reports whether a request's alerts can be decoded as its
+// body comes in and handed on in batches of -stream-batch. Some paths
+// still need the body or every alert at once, and read it first:
+//   - lenient mode repairs the body as a whole before decoding it;
+//   - Grafana's format is converted from the whole payload;
+//   - the archive and -raw-payload keep the body as received;
+//   - webhooks with body: original relay that body.
+func streamDecode(ctx context.Context) bool {
+	if *decodeMode == "lenient" || ctx.Value(intakeKey{}) == "grafana" {
+		return false
+	}
+	if archiveEnabled() || *rawPayloadMode != "off" {
+		return false
+	}
+	for _, e := range webhookTargets {
+		if e.Body == webhookOriginal {
+			return false
+		}
+	}
+	return true
+}
+
+// streamSource counts what is read of the body and keeps the error reading
+// it failed with, which the decoder would pass on as its own.
+type streamSource struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (s *streamSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += n
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// readStreamed decodes a body as it arrives and, like the buffered path of
+// readPayload, answers the request itself when it is rejected. Full
+// batches go to hand as they decode; the payload returned holds the rest.
+func readStreamed(ctx context.Context, w http.ResponseWriter, body io.Reader, hand admitFunc) (AlertmanagerPayload, bool) {
+	src := &streamSource{r: body}
+	in := &utf8Repairer{r: src}
+	_, span := startSpan(ctx, "decode", attribute.String("decode.mode", *decodeMode), attribute.Bool("body.streamed", true))
+	handed := 0
+	payload, problems, err := streamPayload(in, func(g alertGroup, alerts []Alert) bool {
+		tracef(ctx, "decode", "", "handing on a batch of %d alert(s)", len(alerts))
+		handed += len(alerts)
+		return hand(withAlertGroup(ctx, g), alerts)
+	})
+	if errors.Is(err, errHandedOff) {
+		endSpan(span, err)
+		return AlertmanagerPayload{}, false
+	}
+	if err == nil && len(problems) == 0 {
+		err = injectDecodeFault()
+	}
+	if in.repaired {
+		invalidUTF8Total.add(*invalidUTF8, 1)
+		tracef(ctx, "utf8", "", "invalid UTF-8 in body, applied %s policy", *invalidUTF8)
+	}
+	span.SetAttributes(attribute.Int("body.bytes", src.n), attribute.Int("alerts", handed+len(payload.Alerts)))
+
+	reason := rejectSchema
+	var syntax *json.SyntaxError
+	switch {
+	case src.err != nil:
+		err, reason = src.err, readRejectReason(src.err)
+	case errors.As(err, &syntax) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		reason = rejectJSON
+	case err == nil && len(problems) > 0:
+		err = fmt.Errorf("payload does not match the Alertmanager webhook schema: %d problem(s)", len(problems))
+	}
+	if err != nil && handed > 0 {
+		err = fmt.Errorf("%w; the %d alert(s) before it were already queued", err, handed)
+	}
+	endSpan(span, err)
+	if err != nil {
+		slog.Warn("payload rejected", "req_id", requestID(ctx), "reason", reason, "mode", *decodeMode, "err", err, "problems", len(problems))
+		tracef(ctx, "decode", "", "rejected: %v", err)
+		rejectPayload(w, requestID(ctx), reason, err, problems)
+		return AlertmanagerPayload{}, false
+	}
+	tracef(ctx, "decode", "", "accepted %d alert(s) in %s mode, streamed", handed+len(payload.Alerts), *decodeMode)
+	return payload, true
+}
+
+// payloadStream is the state of one streamed decode.
+type payloadStream struct {
+	dec      *json.Decoder
+	strict   bool
+	problems []payloadProblem
+	alerts   []Alert
+	items    int
+	alertErr error // strict mode's first rejected alert
+
+	group map[string]json.RawMessage
+	hand  func(alertGroup, []Alert) bool
+}
+
+func (s *payloadStream) problem(path, format string, args ...any) {
+	s.problems = append(s.problems, payloadProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// streamPayload decodes a webhook body from r an alert at a time: each item
+// of alerts is checked as checkPayload would, then kept only as its Alert.
+// While no item has shown a problem, every -stream-batch alerts go to hand,
+// if set, with the group fields read so far, and are dropped here; the
+// payload returned keeps the rest. Alertmanager sends most group fields
+// after the alerts, so early batches lack them, and a problem found later
+// rejects the request with those batches already passed on. The problems
+// and strict mode's errors are those of the buffered path.
+func streamPayload(r io.Reader, hand func(alertGroup, []Alert) bool) (AlertmanagerPayload, []payloadProblem, error) {
+	s := &payloadStream{dec: json.NewDecoder(r), strict: *decodeMode == "strict", hand: hand}
+	s.dec.UseNumber()
+	tok, err := s.dec.Token()
+	if err != nil {
+		return AlertmanagerPayload{}, nil, err
+	}
+	if tok != json.Delim('{') {
+		s.problem("$", "expected an object, got %s", tokenKind(tok))
+		return AlertmanagerPayload{}, s.problems, nil
+	}
+
+	// The group fields are small; they are kept raw until the end, as
+	// Alertmanager sends most of them after the alerts.
+	group := map[string]json.RawMessage{}
+	s.group = group
+	sawAlerts := false
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return AlertmanagerPayload{}, nil, err
+		}
+		if key, _ := tok.(string); key != "alerts" {
+			var raw json.RawMessage
+			if err := s.dec.Decode(&raw); err != nil {
+				return AlertmanagerPayload{}, nil, err
+			}
+			group[key] = raw
+			continue
+		}
+		sawAlerts = true
+		if err := s.decodeAlerts(); err != nil {
+			return AlertmanagerPayload{}, nil, err
+		}
+	}
+	if _, err := s.dec.Token(); err != nil {
+		return AlertmanagerPayload{}, nil, err
+	}
+
+	// Problems with the group fields come first, as checkPayload lists them.
+	alertProblems := s.problems
+	s.problems = nil
+	root := map[string]any{}
+	for k, raw := range group {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v any
+		dec.Decode(&v)
+		root[k] = v
+	}
+	checkGroupFields(root, s.problem)
+	if !sawAlerts {
+		s.problem("alerts", "missing")
+	}
+	s.problems = append(s.problems, alertProblems...)
+	if !s.strict || len(s.problems) > 0 {
+		var p AlertmanagerPayload
+		if len(s.problems) == 0 {
+			data, _ := json.Marshal(group)
+			if err := json.Unmarshal(data, &p); err != nil {
+				return AlertmanagerPayload{}, nil, err
+			}
+			p.Alerts = s.alerts
+		}
+		return p, s.problems, nil
+	}
+
+	var sp strictPayload
+	data, _ := json.Marshal(group)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sp); err != nil {
+		return AlertmanagerPayload{}, nil, err
+	}
+	p, err := sp.group(s.items)
+	if err == nil {
+		err = s.alertErr
+	}
+	if err != nil {
+		return AlertmanagerPayload{}, nil, err
+	}
+	p.Alerts = s.alerts
+	return p, nil, nil
+}
+
+// decodeAlerts reads the value of alerts, an item at a time.
+func (s *payloadStream) decodeAlerts() error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		s.problem("alerts", "expected an array, got %s", tokenKind(tok))
+		return skipValue(s.dec, tok)
+	}
+	for s.dec.More() {
+		var raw json.RawMessage
+		if err := s.dec.Decode(&raw); err != nil {
+			return err
+		}
+		s.decodeAlert(raw)
+		s.items++
+		if err := s.handOn(); err != nil {
+			return err
+		}
+	}
+	_, err = s.dec.Token()
+	return err
+}
+
+// decodeAlert checks one item and, while no item had problems, decodes it.
+func (s *payloadStream) decodeAlert(raw json.RawMessage) {
+	path := fmt.Sprintf("alerts[%d]", s.items)
+	var item any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	dec.Decode(&item)
+	checkAlertItem(path, item, s.problem)
+	if len(s.problems) > 0 {
+		s.alerts = nil
+		return
+	}
+	if !s.strict {
+		var a Alert
+		if err := json.Unmarshal(raw, &a); err != nil {
+			s.problem(path, "%v", err)
+			return
+		}
+		s.alerts = append(s.alerts, a)
+		return
+	}
+	if s.alertErr != nil {
+		return
+	}
+	var sa strictAlert
+	dec = json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sa); err != nil {
+		s.alertErr = err
+		return
+	}
+	a, err := sa.alert(s.items)
+	if err != nil {
+		s.alertErr = err
+		return
+	}
+	s.alerts = append(s.alerts, a)
+}
+
+// handOn passes a full batch on while the body has shown no problem.
+func (s *payloadStream) handOn() error {
+	if s.hand == nil || len(s.alerts) < *streamBatch || len(s.problems) > 0 || s.alertErr != nil {
+		return nil
+	}
+	var p AlertmanagerPayload
+	data, _ := json.Marshal(s.group)
+	json.Unmarshal(data, &p)
+	if !s.hand(p.group(), s.alerts) {
+		return errHandedOff
+	}
+	s.alerts = nil
+	return nil
+}
+
+// tokenKind names the kind of JSON value tok starts.
+func tokenKind(tok json.Token) string {
+	switch tok {
+	case json.Delim('{'):
+		return "object"
+	case json.Delim('['):
+		return "array"
+	}
+	return schemaKind(tok)
+}
+
+// skipValue reads past the rest of the value tok starts.
+func skipValue(dec *json.Decoder, tok json.Token) error {
+	depth := 0
+	for {
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+		var err error
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// streamPayload has to agree with the buffered checkPayload and decoders,
+// which the streamed path replaces.
+func TestStreamPayload(t *testing.T) {
+	defer func(v string) { *decodeMode = v }(*decodeMode)
+
+	tests := []struct {
+		name       string
+		mode       string
+		body       string
+		wantAlerts int
+		wantErr    bool
+	}{
+		{"default", "default", `{"version":"4","alerts":[{"status":"firing","labels":{"alertname":"A"},"startsAt":"2026-10-15T10:00:00Z"},{"status":"resolved","labels":{"alertname":"B"}}],"status":"firing"}`, 2, false},
+		{"group after alerts", "default", `{"alerts":[{"labels":{"alertname":"A"}}],"receiver":"r","commonLabels":{"x":"y"}}`, 1, false},
+		{"unknown fields", "default", `{"alerts":[{"labels":{"alertname":"A"},"extra":1}],"extra":2}`, 1, false},
+		{"not an object", "default", `"alerts"`, 0, false},
+		{"alerts missing", "default", `{"status":"firing"}`, 0, false},
+		{"alerts not an array", "default", `{"alerts":{"a":[1]},"status":"firing"}`, 0, false},
+		{"problems in group and item", "default", `{"alerts":[{"labels":{"a":1}},{"status":"ok"}],"status":3}`, 0, false},
+		{"truncated", "default", `{"alerts":[{"labels":`, 0, true},
+		{"strict", "strict", `{"version":"4","groupKey":"g","status":"firing","receiver":"r","alerts":[{"status":"firing","labels":{"alertname":"A"},"startsAt":"2026-10-15T10:00:00Z"}]}`, 1, false},
+		{"strict unknown field", "strict", `{"version":"4","groupKey":"g","status":"firing","receiver":"r","alerts":[{"status":"firing","labels":{"alertname":"A"},"startsAt":"2026-10-15T10:00:00Z","extra":1}]}`, 0, true},
+		{"strict no alertname", "strict", `{"version":"4","groupKey":"g","status":"firing","receiver":"r","alerts":[{"status":"firing","labels":{},"startsAt":"2026-10-15T10:00:00Z"}]}`, 0, true},
+		{"strict no alerts", "strict", `{"version":"4","groupKey":"g","status":"firing","receiver":"r","alerts":[]}`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*decodeMode = tt.mode
+			payload, problems, err := streamPayload(strings.NewReader(tt.body), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("streamPayload error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if _, _, bufErr := decodePayload(strings.NewReader(tt.body)); bufErr == nil {
+					t.Errorf("the buffered decoder accepts what streamPayload rejects with %v", err)
+				}
+				return
+			}
+			if len(payload.Alerts) != tt.wantAlerts {
+				t.Errorf("got %d alert(s), want %d", len(payload.Alerts), tt.wantAlerts)
+			}
+
+			buffered, checkErr := checkPayload([]byte(tt.body))
+			if checkErr != nil {
+				t.Fatalf("checkPayload: %v", checkErr)
+			}
+			if !reflect.DeepEqual(problems, buffered) {
+				t.Errorf("problems %v, checkPayload found %v", problems, buffered)
+			}
+			if len(problems) > 0 {
+				return
+			}
+			want, _, err := decodePayload(strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatalf("decodePayload: %v", err)
+			}
+			if !reflect.DeepEqual(payload, want) {
+				t.Errorf("streamed %+v, buffered %+v", payload, want)
+			}
+		})
+	}
+}
+
+func TestStreamPayloadBatches(t *testing.T) {
+	defer func(mode string, n int) { *decodeMode, *streamBatch = mode, n }(*decodeMode, *streamBatch)
+	*decodeMode, *streamBatch = "default", 2
+
+	item := `{"labels":{"alertname":"A"}}`
+	bad := `{"labels":{"alertname":1}}`
+	body := func(items ...string) string {
+		return `{"receiver":"r","alerts":[` + strings.Join(items, ",") + `],"groupKey":"g"}`
+	}
+	tests := []struct {
+		name      string
+		body      string
+		refuse    bool
+		wantHands []int
+		wantLeft  int
+		wantErr   bool
+		problems  bool
+	}{
+		{"fewer than a batch", body(item), false, nil, 1, false, false},
+		{"batches and rest", body(item, item, item, item, item), false, []int{2, 2}, 1, false, false},
+		{"exact batches", body(item, item, item, item), false, []int{2, 2}, 0, false, false},
+		{"problem stops handing on", body(item, item, bad, item, item), false, []int{2}, 0, false, true},
+		{"problem first", body(bad, item, item), false, nil, 0, false, true},
+		{"batch refused", body(item, item, item), true, []int{2}, 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hands []int
+			payload, problems, err := streamPayload(strings.NewReader(tt.body), func(g alertGroup, alerts []Alert) bool {
+				if g.Receiver != "r" || g.GroupKey != "" {
+					t.Errorf("group %+v, want only the fields before the alerts", g)
+				}
+				hands = append(hands, len(alerts))
+				return !tt.refuse
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("streamPayload error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(hands, tt.wantHands) {
+				t.Errorf("handed on %v, want %v", hands, tt.wantHands)
+			}
+			if len(payload.Alerts) != tt.wantLeft {
+				t.Errorf("%d alert(s) left, want %d", len(payload.Alerts), tt.wantLeft)
+			}
+			if (len(problems) > 0) != tt.problems {
+				t.Errorf("problems %v, want some: %v", problems, tt.problems)
+			}
+			if err == nil && !tt.problems && payload.GroupKey != "g" {
+				t.Errorf("group key %q, want the one after the alerts", payload.GroupKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Stub Mode (canned responses)
+=============================
+*/
+
+var (
+	stubMode    = flag.Bool("stub", false, "accept and record /alerts traffic without writing anything, replying with canned responses")
+	stubStatus  = flag.String("stub-status", "200", "comma-separated status codes returned in rotation, e.g. 503,503,200 to exercise retries")
+	stubLatency = flag.Duration("stub-latency", 0, "delay before every stub response")
+	stubJitter  = flag.Duration("stub-jitter", 0, "random extra delay, up to this value, added to -stub-latency")
+	stubHistory = flag.Int("stub-history", 1000, "number of stub requests kept for /api/stub/requests")
+)
+
+type stubHit struct {
+	At         time.Time `json:"at"`
+	RequestID  string    `json:"req_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Bytes      int       `json:"bytes"`
+	Alerts     int       `json:"alerts"`
+	Status     int       `json:"status"`
+	Delay      string    `json:"delay"`
+}
+
+type stubResponder struct {
+	mu    sync.Mutex
+	codes []int
+	next  int
+	hits  []stubHit
+}
+
+var stub = &stubResponder{}
+
+func parseStubStatus(s string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid stub status %q", part)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// nextStatus walks the configured codes in order so a sequence like
+// 503,503,200 reproduces the same retry pattern on every cycle.
+func (s *stubResponder) nextStatus() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.codes) == 0 {
+		return http.StatusOK
+	}
+	code := s.codes[s.next]
+	s.next = (s.next + 1) % len(s.codes)
+	return code
+}
+
+func (s *stubResponder) record(h stubHit) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if *stubHistory <= 0 {
+		return
+	}
+	s.hits = append(s.hits, h)
+	if over := len(s.hits) - *stubHistory; over > 0 {
+		s.hits = append([]stubHit(nil), s.hits[over:]...)
+	}
+}
+
+func (s *stubResponder) snapshot() []stubHit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]stubHit{}, s.hits...)
+}
+
+// respond answers one /alerts request in stub mode. The body is only
+// inspected to count alerts; nothing reaches the tracker, index or sinks.
+func (s *stubResponder) respond(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, _ := io.ReadAll(r.Body)
+
+	h := stubHit{
+		At:         clock.Now(),
+		RequestID:  requestID(ctx),
+		RemoteAddr: r.RemoteAddr,
+		Bytes:      len(body),
+		Alerts:     -1,
+		Status:     s.nextStatus(),
+	}
+	var p AlertmanagerPayload
+	if json.Unmarshal(body, &p) == nil {
+		h.Alerts = len(p.Alerts)
+	}
+
+	delay := *stubLatency
+	if *stubJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(*stubJitter)))
+	}
+	h.Delay = delay.String()
+	tracef(ctx, "stub", "", "replying %d after %s (%d alert(s))", h.Status, delay, h.Alerts)
+	s.record(h)
+
+	if delay > 0 {
+		// Delays may deliberately exceed the server write timeout.
+		http.NewResponseController(w).SetWriteDeadline(time.Time{})
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+	w.WriteHeader(h.Status)
+}
+
+func stubRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stub.snapshot())
+}
+
+func logStubConfig() error {
+	if !*stubMode {
+		return nil
+	}
+	codes, err := parseStubStatus(*stubStatus)
+	if err != nil {
+		return err
+	}
+	stub.codes = codes
+	slog.Warn("STUB MODE: nothing is written", "codes", codes, "latency", *stubLatency, "jitter", *stubJitter)
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Syslog Sink
+=============================
+*/
+
+var (
+	syslogTag         = flag.String("syslog-tag", "hivemq-alerts", "tag (APP-NAME) of records sent to syslog")
+	syslogAddr        = flag.String("syslog-addr", "", "remote syslog receiver for RFC 5424 messages: udp://host:514, tcp://host:601 or tls://host:6514; empty uses the local syslog daemon")
+	syslogFacility    = flag.String("syslog-facility", "daemon", "syslog facility: kern, user, mail, daemon, auth, syslog, ..., local0 to local7")
+	syslogSeverityMap = flag.String("syslog-severity-map", "critical=crit,warning=warning,info=info,resolved=notice",
+		"comma-separated severity label value=syslog severity; the key resolved applies to every resolved alert")
+	syslogCAFile  = flag.String("syslog-ca-file", "", "PEM CA bundle for verifying a tls:// syslog receiver; empty uses the system roots")
+	syslogTimeout = flag.Duration("syslog-timeout", 5*time.Second, "deadline for connecting to and writing one message to a remote syslog receiver")
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// sdID names the structured data elements. 32473 is the private enterprise
+// number RFC 5612 reserves for documentation.
+const sdID = "@32473"
+
+func newSyslogSink() (Sink, error) {
+	facility, ok := syslogFacilities[*syslogFacility]
+	if !ok {
+		return nil, fmt.Errorf("syslog sink: unknown facility %q", *syslogFacility)
+	}
+	severities := map[string]int{}
+	for _, pair := range splitList(*syslogSeverityMap) {
+		value, name, _ := strings.Cut(pair, "=")
+		sev, ok := syslogSeverities[name]
+		if !ok || value == "" {
+			return nil, fmt.Errorf("syslog sink: invalid -syslog-severity-map entry %q", pair)
+		}
+		severities[value] = sev
+	}
+	s := &syslogSink{facility: facility, severities: severities}
+
+	if *syslogAddr == "" {
+		w, err := syslog.New(syslog.Priority(facility<<3)|syslog.LOG_INFO, *syslogTag)
+		if err != nil {
+			return nil, fmt.Errorf("syslog sink: %w", err)
+		}
+		s.local = w
+		return s, nil
+	}
+
+	u, err := url.Parse(*syslogAddr)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("syslog sink: invalid -syslog-addr %q", *syslogAddr)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		s.tls = &tls.Config{MinVersion: tls.VersionTLS12, ServerName: u.Hostname()}
+		if *syslogCAFile != "" {
+			pem, err := os.ReadFile(*syslogCAFile)
+			if err != nil {
+				return nil, err
+			}
+			s.tls.RootCAs = x509.NewCertPool()
+			if !s.tls.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no certificates found", *syslogCAFile)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("syslog sink: -syslog-addr %q must be udp://, tcp:// or tls://", *syslogAddr)
+	}
+	s.network, s.addr = u.Scheme, u.Host
+	s.hostname, _ = os.Hostname()
+	return s, nil
+}
+
+// syslogSink sends each record either to the local daemon or, with
+// -syslog-addr, as an RFC 5424 message carrying the alert's labels and
+// annotations as structured data. Stream transports use octet-counting
+// framing (RFC 6587).
+type syslogSink struct {
+	facility   int
+	severities map[string]int
+	local      *syslog.Writer
+
+	network, addr string
+	tls           *tls.Config
+	hostname      string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (*syslogSink) Name() string { return sinkSyslog }
+
+func (s *syslogSink) Write(ctx context.Context, a Alert) error {
+	line, err := recordLine(ctx, a)
+	if err == nil {
+		if s.local != nil {
+			err = s.writeLocal(s.severity(a), string(line))
+		} else {
+			err = breakerFor(sinkSyslog).call(func() error {
+				return s.send(s.format(a, line, clock.Now()))
+			})
+		}
+	}
+	target := s.addr
+	if s.local != nil {
+		target = *syslogTag
+	}
+	return recordDelivery(ctx, sinkSyslog, target, a, err)
+}
+
+// severity prefers -syslog-severity-map's resolved key, then -severity-map,
+// then -syslog-severity-map by label.
+func (s *syslogSink) severity(a Alert) int {
+	if sev, ok := s.severities["resolved"]; ok && a.Status == "resolved" {
+		return sev
+	}
+	if name := severityOf(a).Syslog; name != "" {
+		return syslogSeverities[name]
+	}
+	if sev, ok := s.severities[a.Labels["severity"]]; ok {
+		return sev
+	}
+	return syslogSeverities["info"]
+}
+
+func (s *syslogSink) writeLocal(sev int, msg string) error {
+	switch sev {
+	case 0:
+		return s.local.Emerg(msg)
+	case 1:
+		return s.local.Alert(msg)
+	case 2:
+		return s.local.Crit(msg)
+	case 3:
+		return s.local.Err(msg)
+	case 4:
+		return s.local.Warning(msg)
+	case 5:
+		return s.local.Notice(msg)
+	case 7:
+		return s.local.Debug(msg)
+	}
+	return s.local.Info(msg)
+}
+
+// format builds the RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] BOM MSG
+func (s *syslogSink) format(a Alert, line []byte, now time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s ",
+		s.facility<<3|s.severity(a),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeaderField(s.hostname, 255),
+		syslogHeaderField(*syslogTag, 48),
+		os.Getpid(),
+		syslogHeaderField(a.Labels["alertname"], 32))
+	writeSDElement(&b, "labels"+sdID, a.Labels)
+	writeSDElement(&b, "annotations"+sdID, a.Annotations)
+	if len(a.Labels)+len(a.Annotations) == 0 {
+		b.WriteString("-")
+	}
+	b.WriteString(" \ufeff")
+	b.Write(line)
+	return []byte(b.String())
+}
+
+// syslogHeaderField keeps the printable ASCII of v, as header fields allow
+// nothing else; empty becomes the nil value "-".
+func syslogHeaderField(v string, limit int) string {
+	out := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, v)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	if out == "" {
+		return "-"
+	}
+	return out
+}
+
+func writeSDElement(b *strings.Builder, id string, params map[string]string) {
+	if len(params) == 0 {
+		return
+	}
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	b.WriteString("[" + id)
+	for _, name := range names {
+		n := strings.Map(func(r rune) rune {
+			if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+				return '_'
+			}
+			return r
+		}, name)
+		if len(n) > 32 {
+			n = n[:32]
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(params[name])
+		fmt.Fprintf(b, ` %s="%s"`, n, v)
+	}
+	b.WriteString("]")
+}
+
+// send writes one message, reconnecting once if the connection was lost.
+func (s *syslogSink) send(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.network != "udp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+	var err error
+	for try := 0; try < 2; try++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(); err != nil {
+				return err
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(*syslogTimeout))
+		if _, err = s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return err
+}
+
+func (s *syslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: *syslogTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", s.addr, s.tls)
+	}
+	return dialer.Dial(s.network, s.addr)
+}
+
+func (s *syslogSink) close() {
+	if s.local != nil {
+		s.local.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Live Entry Feed
+=============================
+*/
+
+type entryFeed struct {
+	mu   sync.Mutex
+	subs map[chan JSONLog]struct{}
+}
+
+var feed = &entryFeed{subs: make(map[chan JSONLog]struct{})}
+
+func (f *entryFeed) subscribe() chan JSONLog {
+	ch := make(chan JSONLog, 256)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+	return ch
+}
+
+func (f *entryFeed) unsubscribe(ch chan JSONLog) {
+	f.mu.Lock()
+	delete(f.subs, ch)
+	f.mu.Unlock()
+}
+
+// publish never blocks the write path; slow followers miss entries.
+func (f *entryFeed) publish(e JSONLog) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+/*
+=============================
+ Tail Handler
+=============================
+*/
+
+const maxTail = 10000
+
+func tailHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	n := 100
+	if v := q.Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 || n > maxTail {
+			http.Error(w, "n must be between 0 and "+strconv.Itoa(maxTail), http.StatusBadRequest)
+			return
+		}
+	}
+	follow := q.Get("follow") == "true"
+
+	// Subscribe before reading history so nothing falls in between. What
+	// was published while history was read may be in both.
+	var live chan JSONLog
+	if follow {
+		live = feed.subscribe()
+		defer feed.unsubscribe(live)
+	}
+
+	tenant := tenantOf(r.Context())
+	entries, err := lastEntries(tenant, n)
+	if err != nil {
+		http.Error(w, "reading history failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for _, e := range entries {
+		enc.Encode(e)
+	}
+	if !follow {
+		return
+	}
+	inHistory := make(map[tailKey]int)
+	for _, e := range entries {
+		inHistory[tailKeyOf(e)]++
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	rc.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-live:
+			if e.Tenant != tenant {
+				continue
+			}
+			if k := tailKeyOf(e); inHistory[k] > 0 {
+				inHistory[k]--
+				continue
+			}
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// tailKey tells entries apart well enough to recognize one that was both
+// read from history and published: the request ID alone leaves only the
+// alerts of one request to separate.
+type tailKey struct {
+	ts, reqID, kpi, host, ip, summary, value string
+}
+
+func tailKeyOf(e JSONLog) tailKey {
+	return tailKey{e.Timestamp, e.RequestID, e.KPI, e.Hostname, e.IP, e.Summary, e.Value}
+}
+
+// lastEntries returns the tenant's newest n entries in the order they were
+// written.
+func lastEntries(tenant string, n int) ([]JSONLog, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	files, err := listLogFiles(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []JSONLog
+	for i := len(files) - 1; i >= 0 && len(out) < n; i-- {
+		want := n - len(out)
+		ring := make([]JSONLog, 0, want)
+		next := 0
+		err := readLogFile(files[i].Path, func(e JSONLog) error {
+			e.Tenant = tenant
+			if len(ring) < want {
+				ring = append(ring, e)
+			} else {
+				ring[next] = e
+				next = (next + 1) % want
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		ordered := append(append([]JSONLog{}, ring[next:]...), ring[:next]...)
+		out = append(ordered, out...)
+	}
+	return out, nil
+}
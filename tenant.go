@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Tenancy
+=============================
+*/
+
+const (
+	tenantKeyHeader = "X-API-Key"
+	// orgIDHeader names a webhook's tenant like /alerts/{tenant}, as
+	// Mimir and Loki clients send it.
+	orgIDHeader = "X-Scope-OrgID"
+)
+
+var (
+	tenantsFlag = flag.String("tenants", "",
+		"comma-separated tenant=apikey pairs; enables per-tenant output directories and tenant-scoped query APIs")
+	tenantsFile = flag.String("tenants-file", "", "YAML file of tenant blocks, each with its API key and optionally its own log directory, email recipients and templates; see examples/tenants.yml")
+)
+
+// tenantConfig is a tenant's block in -tenants-file. Its settings take the
+// place of -log-dir/<tenant>, -email-to and -email-templates; a route's
+// own email_to and email_templates still win.
+type tenantConfig struct {
+	APIKey         string   `yaml:"api_key"`
+	APIKeyFile     string   `yaml:"api_key_file"`
+	LogDir         string   `yaml:"log_dir"`
+	EmailTo        []string `yaml:"email_to"`
+	EmailTemplates string   `yaml:"email_templates"`
+
+	emailT *emailTemplateSet
+}
+
+type tenantsConfig struct {
+	Tenants map[string]*tenantConfig `yaml:"tenants"`
+}
+
+var (
+	tenantKeys    map[string]string // API key -> tenant
+	tenantNames   []string
+	tenantConfigs map[string]*tenantConfig
+
+	validTenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+)
+
+type tenantKey struct{}
+
+func loadTenants() error {
+	tenantKeys, tenantNames, tenantConfigs = nil, nil, nil
+	if *tenantsFlag == "" && *tenantsFile == "" {
+		return nil
+	}
+	keys := make(map[string]string)
+	seen := make(map[string]bool)
+	var names []string
+	addKey := func(name, key, where string) error {
+		if _, dup := keys[key]; dup {
+			return fmt.Errorf("%s: API key for %q is already used", where, name)
+		}
+		keys[key] = name
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	}
+	if *tenantsFlag != "" {
+		for _, pair := range strings.Split(*tenantsFlag, ",") {
+			name, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || key == "" || !validTenantName.MatchString(name) {
+				return fmt.Errorf("invalid -tenants entry %q, want name=apikey with a lowercase name", pair)
+			}
+			if err := addKey(name, key, "-tenants"); err != nil {
+				return err
+			}
+		}
+	}
+	configs, err := readTenantsFile()
+	if err != nil {
+		return err
+	}
+	dirs := map[string]string{}
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		tc := configs[name]
+		where := *tenantsFile
+		if !validTenantName.MatchString(name) {
+			return fmt.Errorf("%s: invalid tenant name %q, want a lowercase name", where, name)
+		}
+		key := tc.APIKey
+		if tc.APIKeyFile != "" {
+			secret, err := readSecret(tc.APIKeyFile)
+			if err != nil {
+				return fmt.Errorf("%s: tenant %s: %w", where, name, err)
+			}
+			key = string(secret)
+		}
+		if key == "" {
+			if !seen[name] {
+				return fmt.Errorf("%s: tenant %s needs api_key or api_key_file", where, name)
+			}
+		} else if err := addKey(name, key, where); err != nil {
+			return err
+		}
+		if len(tc.EmailTo) > 0 {
+			if _, err := mail.ParseAddressList(strings.Join(tc.EmailTo, ", ")); err != nil {
+				return fmt.Errorf("%s: tenant %s: invalid email_to: %w", where, name, err)
+			}
+		}
+		if tc.EmailTemplates != "" {
+			if tc.emailT, err = parseEmailTemplates(tc.EmailTemplates); err != nil {
+				return fmt.Errorf("%s: tenant %s: %w", where, name, err)
+			}
+		}
+		if tc.LogDir != "" {
+			if !filepath.IsAbs(tc.LogDir) {
+				tc.LogDir = filepath.Join(logDir, tc.LogDir)
+			}
+			tc.LogDir = filepath.Clean(tc.LogDir)
+			if other, ok := dirs[tc.LogDir]; ok {
+				return fmt.Errorf("%s: tenants %s and %s share log_dir %s", where, other, name, tc.LogDir)
+			}
+			dirs[tc.LogDir] = name
+		}
+	}
+	sort.Strings(names)
+	tenantKeys, tenantNames, tenantConfigs = keys, names, configs
+	for _, name := range names {
+		if err := makeDir(tenantDir(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readTenantsFile() (map[string]*tenantConfig, error) {
+	if *tenantsFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(*tenantsFile)
+	if err != nil {
+		return nil, err
+	}
+	var cfg tenantsConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", *tenantsFile, err)
+	}
+	if len(cfg.Tenants) == 0 {
+		return nil, fmt.Errorf("%s: no tenants defined", *tenantsFile)
+	}
+	for name, tc := range cfg.Tenants {
+		if tc == nil {
+			cfg.Tenants[name] = &tenantConfig{}
+		}
+	}
+	return cfg.Tenants, nil
+}
+
+// tenantSettings is the tenant's -tenants-file block, nil when it has none.
+func tenantSettings(tenant string) *tenantConfig {
+	return tenantConfigs[tenant]
+}
+
+func tenancyEnabled() bool {
+	return len(tenantKeys) > 0
+}
+
+// allTenants lists every output scope; without tenancy that is the single
+// unnamed one.
+func allTenants() []string {
+	if !tenancyEnabled() {
+		return []string{""}
+	}
+	return tenantNames
+}
+
+func knownTenant(name string) bool {
+	for _, t := range tenantNames {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+func tenantOf(ctx context.Context) string {
+	t, _ := ctx.Value(tenantKey{}).(string)
+	return t
+}
+
+func withTenantValue(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// tenantDir is where a tenant's output files live: its log_dir, else a
+// subdirectory of -log-dir.
+func tenantDir(tenant string) string {
+	if tenant == "" {
+		return logDir
+	}
+	if tc := tenantSettings(tenant); tc != nil && tc.LogDir != "" {
+		return tc.LogDir
+	}
+	return filepath.Join(logDir, tenant)
+}
+
+func callerKey(r *http.Request) string {
+	if k := r.Header.Get(tenantKeyHeader); k != "" {
+		return k
+	}
+	// With -auth=bearer the bearer token is the shared webhook token, and
+	// the admin token is nobody's key.
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && *authMode != "bearer" && !secretEqual(token, adminToken) {
+		return token
+	}
+	return ""
+}
+
+// withTenant resolves the caller's API key. Every /api/ call needs one
+// once tenancy is on; /alerts may instead name its tenant in the path,
+// which alertTenant checks.
+func withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !tenancyEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := callerKey(r)
+		tenant, ok := tenantKeys[key]
+		if key != "" && !ok {
+			http.Error(w, "unknown API key", http.StatusUnauthorized)
+			return
+		}
+		if !ok && strings.HasPrefix(r.URL.Path, "/api/") {
+			http.Error(w, "tenant API key required", http.StatusUnauthorized)
+			return
+		}
+		if ok {
+			r = r.WithContext(withTenantValue(r.Context(), tenant))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// alertTenant decides which tenant a webhook delivery belongs to: the API
+// key's tenant, the /alerts/{tenant} path segment or the X-Scope-OrgID
+// header, all that are given agreeing. Tenants of -tenants-file only take
+// deliveries that carry their key.
+func alertTenant(r *http.Request) (string, int, error) {
+	byPath := r.PathValue("tenant")
+	byHeader := strings.TrimSpace(r.Header.Get(orgIDHeader))
+	if !tenancyEnabled() {
+		if byPath != "" || byHeader != "" {
+			return "", http.StatusNotFound, fmt.Errorf("tenancy is not configured")
+		}
+		return "", 0, nil
+	}
+
+	byKey := tenantOf(r.Context())
+	named := byPath
+	switch {
+	case byPath != "" && byHeader != "" && byPath != byHeader:
+		return "", http.StatusBadRequest, fmt.Errorf("%s %q does not match tenant %q of the path", orgIDHeader, byHeader, byPath)
+	case named == "":
+		named = byHeader
+	}
+	switch {
+	case named != "" && !knownTenant(named):
+		return "", http.StatusNotFound, fmt.Errorf("unknown tenant %q", named)
+	case named != "" && byKey != "" && named != byKey:
+		return "", http.StatusForbidden, fmt.Errorf("API key does not belong to tenant %q", named)
+	case named != "" && byKey == "" && tenantSettings(named) != nil:
+		return "", http.StatusUnauthorized, fmt.Errorf("tenant %q requires its API key", named)
+	case named != "":
+		return named, 0, nil
+	case byKey != "":
+		return byKey, 0, nil
+	}
+	return "", http.StatusUnauthorized, fmt.Errorf("tenant API key, /alerts/{tenant} path or %s header required", orgIDHeader)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withTenants turns tenancy on for a test: team-a and team-b from -tenants,
+// with keys ka and kb.
+func withTenants(t *testing.T) {
+	t.Helper()
+	keys, names, configs := tenantKeys, tenantNames, tenantConfigs
+	t.Cleanup(func() { tenantKeys, tenantNames, tenantConfigs = keys, names, configs })
+	tenantKeys = map[string]string{"ka": "team-a", "kb": "team-b"}
+	tenantNames = []string{"team-a", "team-b"}
+	tenantConfigs = nil
+}
+
+func TestCallerKey(t *testing.T) {
+	defer func(mode string, token []byte) { *authMode, adminToken = mode, token }(*authMode, adminToken)
+	adminToken = []byte("admin")
+
+	tests := []struct {
+		name   string
+		mode   string
+		apiKey string
+		bearer string
+		want   string
+	}{
+		{"api key", "none", "ka", "", "ka"},
+		{"bearer", "none", "", "ka", "ka"},
+		{"api key before bearer", "none", "ka", "kb", "ka"},
+		{"bearer is the webhook token", "bearer", "", "ka", ""},
+		{"api key with bearer auth", "bearer", "ka", "shared", "ka"},
+		{"admin token", "none", "", "admin", ""},
+		{"none", "none", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			*authMode = tt.mode
+			r := httptest.NewRequest("GET", "/api/search", nil)
+			if tt.apiKey != "" {
+				r.Header.Set(tenantKeyHeader, tt.apiKey)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			if got := callerKey(r); got != tt.want {
+				t.Errorf("callerKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithTenant(t *testing.T) {
+	withTenants(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		apiKey     string
+		wantCode   int
+		wantTenant string
+	}{
+		{"api with key", "/api/search", "ka", http.StatusOK, "team-a"},
+		{"api other key", "/api/search", "kb", http.StatusOK, "team-b"},
+		{"api without key", "/api/search", "", http.StatusUnauthorized, ""},
+		{"api unknown key", "/api/search", "kx", http.StatusUnauthorized, ""},
+		{"alerts with key", "/alerts", "kb", http.StatusOK, "team-b"},
+		{"alerts without key", "/alerts", "", http.StatusOK, ""},
+		{"alerts unknown key", "/alerts", "kx", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.apiKey != "" {
+				r.Header.Set(tenantKeyHeader, tt.apiKey)
+			}
+			got := "unset"
+			h := withTenant(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = tenantOf(r.Context())
+			}))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantCode {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusOK && got != tt.wantTenant {
+				t.Errorf("tenant %q, want %q", got, tt.wantTenant)
+			}
+		})
+	}
+}
+
+func TestAlertTenant(t *testing.T) {
+	withTenants(t)
+	// team-c comes from -tenants-file, so it only takes its own key.
+	tenantKeys["kc"] = "team-c"
+	tenantNames = append(tenantNames, "team-c")
+	tenantConfigs = map[string]*tenantConfig{"team-c": {APIKey: "kc"}}
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		path       string
+		orgID      string
+		wantTenant string
+		wantCode   int
+	}{
+		{"key", "ka", "", "", "team-a", 0},
+		{"path", "", "team-a", "", "team-a", 0},
+		{"header", "", "", "team-b", "team-b", 0},
+		{"padded header", "", "", " team-b ", "team-b", 0},
+		{"path and header agree", "", "team-a", "team-a", "team-a", 0},
+		{"path and header differ", "", "team-a", "team-b", "", http.StatusBadRequest},
+		{"key and path agree", "ka", "team-a", "", "team-a", 0},
+		{"key of another tenant", "kb", "team-a", "", "", http.StatusForbidden},
+		{"key and header differ", "ka", "", "team-b", "", http.StatusForbidden},
+		{"unknown tenant", "", "team-x", "", "", http.StatusNotFound},
+		{"file tenant without key", "", "team-c", "", "", http.StatusUnauthorized},
+		{"file tenant by header without key", "", "", "team-c", "", http.StatusUnauthorized},
+		{"file tenant with key", "kc", "team-c", "", "team-c", 0},
+		{"nothing", "", "", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/alerts", nil)
+			r.SetPathValue("tenant", tt.path)
+			if tt.orgID != "" {
+				r.Header.Set(orgIDHeader, tt.orgID)
+			}
+			if k := tt.apiKey; k != "" {
+				r = r.WithContext(withTenantValue(r.Context(), tenantKeys[k]))
+			}
+			tenant, code, err := alertTenant(r)
+			if code != tt.wantCode || tenant != tt.wantTenant {
+				t.Errorf("alertTenant = %q, %d (%v); want %q, %d", tenant, code, err, tt.wantTenant, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAlertTenantWithoutTenancy(t *testing.T) {
+	tests := []struct {
+		name, path, orgID string
+		wantCode          int
+	}{
+		{"plain", "", "", 0},
+		{"path", "team-a", "", http.StatusNotFound},
+		{"header", "", "team-a", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/alerts", nil)
+			r.SetPathValue("tenant", tt.path)
+			if tt.orgID != "" {
+				r.Header.Set(orgIDHeader, tt.orgID)
+			}
+			if _, code, _ := alertTenant(r); code != tt.wantCode {
+				t.Errorf("status %d, want %d", code, tt.wantCode)
+			}
+		})
+	}
+}
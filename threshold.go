@@ -0,0 +1,40 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+/*
+=============================
+ Threshold & Breach Margin
+=============================
+*/
+
+// addBreachMargin sets breach_margin (value - threshold) and, for a
+// non-zero threshold, breach_margin_pct on alerts that carry a numeric
+// threshold annotation. It runs before unit conversion, so both sides are
+// still in the raw Prometheus unit.
+func addBreachMargin(a Alert) (Alert, bool) {
+	threshold, err := strconv.ParseFloat(strings.TrimSpace(a.Annotations["threshold"]), 64)
+	if err != nil {
+		return a, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(a.Annotations["current_value"]), 64)
+	if err != nil {
+		return a, false
+	}
+
+	annotations := make(map[string]string, len(a.Annotations)+2)
+	for k, v := range a.Annotations {
+		annotations[k] = v
+	}
+	margin := value - threshold
+	annotations["breach_margin"] = strconv.FormatFloat(margin, 'f', -1, 64)
+	if threshold != 0 {
+		annotations["breach_margin_pct"] = strconv.FormatFloat(margin/math.Abs(threshold)*100, 'f', 1, 64)
+	}
+	a.Annotations = annotations
+	return a, true
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ HTTPS Listener
+=============================
+*/
+
+var (
+	tlsCertFile     = flag.String("tls-cert-file", "", "PEM certificate (chain) to serve HTTPS with; empty serves plain HTTP")
+	tlsKeyFile      = flag.String("tls-key-file", "", "PEM key of -tls-cert-file")
+	tlsClientCAFile = flag.String("tls-client-ca-file", "", "PEM CA bundle; when set, clients must present a certificate signed by it (mTLS)")
+	tlsReloadEvery  = flag.Duration("tls-reload-interval", 30*time.Second, "how often the certificate, key and client CA files are checked for changes")
+)
+
+func tlsEnabled() bool {
+	return *tlsCertFile != ""
+}
+
+func listenScheme() string {
+	if tlsEnabled() {
+		return "https"
+	}
+	return "http"
+}
+
+func validTLSOptions() error {
+	if !tlsEnabled() {
+		if *tlsKeyFile != "" || *tlsClientCAFile != "" {
+			return errors.New("-tls-key-file and -tls-client-ca-file need -tls-cert-file")
+		}
+		return nil
+	}
+	if *tlsKeyFile == "" {
+		return errors.New("-tls-cert-file needs -tls-key-file")
+	}
+	if *tlsReloadEvery <= 0 {
+		return errors.New("-tls-reload-interval must be positive")
+	}
+	return nil
+}
+
+// certStore holds the serving certificate and client CAs, re-read when
+// their files change so renewed certificates are picked up without a
+// restart. A file that fails to load keeps the previous one in use.
+type certStore struct {
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+}
+
+var certs = &certStore{}
+
+// load reads the files if any of them changed since the last load.
+func (s *certStore) load() error {
+	files := []string{*tlsCertFile, *tlsKeyFile}
+	if *tlsClientCAFile != "" {
+		files = append(files, *tlsClientCAFile)
+	}
+	mod := make(map[string]time.Time, len(files))
+	changed := s.modTimes == nil
+	for _, f := range files {
+		st, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		mod[f] = st.ModTime()
+		changed = changed || !st.ModTime().Equal(s.modTimes[f])
+	}
+	if !changed {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(*tlsCertFile, *tlsKeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if *tlsClientCAFile != "" {
+		pem, err := os.ReadFile(*tlsClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("%s: no certificates found", *tlsClientCAFile)
+		}
+	}
+
+	s.mu.Lock()
+	reloaded := s.cert != nil
+	s.cert, s.clientCAs, s.modTimes = &cert, pool, mod
+	s.mu.Unlock()
+	if reloaded {
+		slog.Info("tls certificate reloaded", "file", *tlsCertFile)
+	}
+	return nil
+}
+
+// config is the server side of every handshake; it always uses the
+// latest certificate and client CAs.
+func (s *certStore) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			conf := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*s.cert},
+			}
+			if s.clientCAs != nil {
+				conf.ClientCAs = s.clientCAs
+				conf.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return conf, nil
+		},
+	}
+}
+
+// tlsListener wraps ln for HTTPS once the certificate loads.
+func tlsListener(ln net.Listener) (net.Listener, error) {
+	if err := certs.load(); err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return tls.NewListener(ln, certs.config()), nil
+}
+
+func runCertReload(done <-chan struct{}) {
+	var every time.Duration
+	withSettings(func() { every = *tlsReloadEvery })
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		withSettings(func() {
+			if err := certs.load(); err != nil {
+				slog.Error("tls certificate reload failed, keeping the previous one", "err", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+=============================
+ Template Data Model
+=============================
+*/
+
+// The data model mirrors Alertmanager's template.Data so the same
+// hivemq-*.tmpl files render both there and here.
+type templateData struct {
+	Receiver          string
+	Status            string
+	Alerts            templateAlerts
+	GroupLabels       KV
+	CommonLabels      KV
+	CommonAnnotations KV
+	ExternalURL       string
+}
+
+type templateAlert struct {
+	Status       string
+	Labels       KV
+	Annotations  KV
+	StartsAt     time.Time
+	EndsAt       time.Time
+	GeneratorURL string
+	Fingerprint  string
+}
+
+type templateAlerts []templateAlert
+
+func (as templateAlerts) Firing() templateAlerts   { return as.withStatus("firing") }
+func (as templateAlerts) Resolved() templateAlerts { return as.withStatus("resolved") }
+
+func (as templateAlerts) withStatus(status string) templateAlerts {
+	out := templateAlerts{}
+	for _, a := range as {
+		if a.Status == status {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// alertGroup is what Alertmanager said about the notification group a
+// webhook request carries, beyond its alerts.
+type alertGroup struct {
+	Receiver    string
+	GroupKey    string
+	GroupLabels map[string]string
+	ExternalURL string
+}
+
+func (p AlertmanagerPayload) group() alertGroup {
+	return alertGroup{
+		Receiver:    p.Receiver,
+		GroupKey:    p.GroupKey,
+		GroupLabels: p.GroupLabels,
+		ExternalURL: strings.TrimSuffix(p.ExternalURL, "/"),
+	}
+}
+
+type alertGroupKey struct{}
+
+func withAlertGroup(ctx context.Context, g alertGroup) context.Context {
+	return context.WithValue(ctx, alertGroupKey{}, g)
+}
+
+// alertGroupOf returns the group of the request; the zero group for
+// alerts that did not come from a webhook request.
+func alertGroupOf(ctx context.Context) alertGroup {
+	g, _ := ctx.Value(alertGroupKey{}).(alertGroup)
+	return g
+}
+
+// newTemplateData builds what Alertmanager would hand its templates for
+// this group of alerts. Common labels and annotations are computed from
+// the alerts as processed here, so filtered or redacted values never
+// reappear through them; group labels are kept only where they still
+// agree with the common labels, for the same reason.
+func newTemplateData(g alertGroup, alerts []Alert) templateData {
+	data := templateData{
+		Receiver:          safeValue(g.Receiver, "hivemq-alert-logger"),
+		Status:            "resolved",
+		GroupLabels:       KV{},
+		CommonLabels:      commonKV(alerts, func(a Alert) map[string]string { return a.Labels }),
+		CommonAnnotations: commonKV(alerts, func(a Alert) map[string]string { return a.Annotations }),
+		ExternalURL:       g.ExternalURL,
+	}
+	for k, v := range g.GroupLabels {
+		if data.CommonLabels[k] == v {
+			data.GroupLabels[k] = v
+		}
+	}
+	for _, a := range alerts {
+		if a.Status != "resolved" {
+			data.Status = "firing"
+		}
+		data.Alerts = append(data.Alerts, templateAlert{
+			Status:       safeValue(a.Status, "firing"),
+			Labels:       KV(a.Labels),
+			Annotations:  KV(a.Annotations),
+			StartsAt:     a.StartsAt,
+			EndsAt:       a.EndsAt,
+			GeneratorURL: a.GeneratorURL,
+			Fingerprint:  a.Fingerprint,
+		})
+	}
+	return data
+}
+
+func commonKV(alerts []Alert, of func(Alert) map[string]string) KV {
+	out := KV{}
+	if len(alerts) == 0 {
+		return out
+	}
+	for k, v := range of(alerts[0]) {
+		out[k] = v
+	}
+	for _, a := range alerts[1:] {
+		m := of(a)
+		for k, v := range out {
+			if m[k] != v {
+				delete(out, k)
+			}
+		}
+	}
+	return out
+}
+
+type KV map[string]string
+
+type Pair struct {
+	Name, Value string
+}
+
+type Pairs []Pair
+
+func (ps Pairs) Names() []string {
+	out := make([]string, len(ps))
+	for i, p := range ps {
+		out[i] = p.Name
+	}
+	return out
+}
+
+func (ps Pairs) Values() []string {
+	out := make([]string, len(ps))
+	for i, p := range ps {
+		out[i] = p.Value
+	}
+	return out
+}
+
+// SortedPairs puts alertname first, like Alertmanager does.
+func (kv KV) SortedPairs() Pairs {
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		if k != "alertname" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if _, ok := kv["alertname"]; ok {
+		keys = append([]string{"alertname"}, keys...)
+	}
+	out := make(Pairs, len(keys))
+	for i, k := range keys {
+		out[i] = Pair{k, kv[k]}
+	}
+	return out
+}
+
+func (kv KV) Names() []string  { return kv.SortedPairs().Names() }
+func (kv KV) Values() []string { return kv.SortedPairs().Values() }
+
+func (kv KV) Remove(keys []string) KV {
+	drop := map[string]bool{}
+	for _, k := range keys {
+		drop[k] = true
+	}
+	out := KV{}
+	for k, v := range kv {
+		if !drop[k] {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+/*
+=============================
+ Template Functions
+=============================
+*/
+
+// templateFuncs is the Alertmanager default function set.
+var templateFuncs = map[string]any{
+	"toUpper":   strings.ToUpper,
+	"toLower":   strings.ToLower,
+	"title":     titleCase,
+	"trimSpace": strings.TrimSpace,
+	"join": func(sep string, s []string) string {
+		return strings.Join(s, sep)
+	},
+	"match": regexp.MatchString,
+	"safeHtml": func(text string) template.HTML {
+		return template.HTML(text)
+	},
+	"safeUrl": func(text string) template.URL {
+		return template.URL(text)
+	},
+	"reReplaceAll": func(pattern, repl, text string) string {
+		return regexp.MustCompile(pattern).ReplaceAllString(text, repl)
+	},
+	"stringSlice": func(s ...string) []string {
+		return s
+	},
+	"date": func(layout string, t time.Time) string {
+		return t.Format(layout)
+	},
+	"tz": func(name string, t time.Time) (time.Time, error) {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return t.In(loc), nil
+	},
+	"since":            since,
+	"humanizeDuration": humanizeDuration,
+}
+
+func titleCase(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		defer func() { prev = r }()
+		if unicode.IsSpace(prev) || prev == '-' || prev == '_' {
+			return unicode.ToTitle(r)
+		}
+		return r
+	}, s)
+}
+
+// humanizeDuration renders seconds (as a number or duration) like
+// Prometheus does: "1d 2h 3m 4s".
+func humanizeDuration(v any) (string, error) {
+	var secs float64
+	switch x := v.(type) {
+	case time.Duration:
+		secs = x.Seconds()
+	case float64:
+		secs = x
+	case int:
+		secs = float64(x)
+	case int64:
+		secs = float64(x)
+	case string:
+		if _, err := fmt.Sscan(x, &secs); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("humanizeDuration: unsupported type %T", v)
+	}
+
+	if math.Abs(secs) < 1 {
+		return fmt.Sprintf("%.4gs", secs), nil
+	}
+	sign := ""
+	if secs < 0 {
+		sign, secs = "-", -secs
+	}
+	d := int64(secs)
+	days, hours, mins, s := d/86400, d/3600%24, d/60%60, d%60
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, fmt.Sprintf("%dd", days))
+	}
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+	}
+	if mins > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", mins))
+	}
+	if s > 0 || len(parts) == 0 {
+		parts = append(parts, fmt.Sprintf("%ds", s))
+	}
+	return sign + strings.Join(parts, " "), nil
+}
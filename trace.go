@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+=============================
+ Decision Trace
+=============================
+*/
+
+const traceHeader = "X-Debug-Trace"
+
+var (
+	traceSample  = flag.Float64("trace-sample", 0, "fraction (0..1) of requests recorded with a full decision trace")
+	traceHistory = flag.Int("trace-history", 500, "number of decision traces kept for /api/traces")
+)
+
+type traceEvent struct {
+	Offset      string `json:"offset"`
+	Stage       string `json:"stage"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Message     string `json:"message"`
+}
+
+type decisionTrace struct {
+	mu        sync.Mutex
+	RequestID string       `json:"req_id"`
+	Tenant    string       `json:"tenant,omitempty"`
+	Started   time.Time    `json:"started"`
+	Events    []traceEvent `json:"events"`
+}
+
+type traceStore struct {
+	mu    sync.Mutex
+	byID  map[string]*decisionTrace
+	order []string
+}
+
+var traces = &traceStore{byID: make(map[string]*decisionTrace)}
+
+type traceKey struct{}
+
+// startTrace attaches a trace to the request when asked for via header or
+// picked by sampling.
+func startTrace(r *http.Request) context.Context {
+	ctx := r.Context()
+	h := strings.ToLower(r.Header.Get(traceHeader))
+	if h != "1" && h != "true" && !chance(*traceSample) {
+		return ctx
+	}
+
+	t := &decisionTrace{RequestID: requestID(ctx), Tenant: tenantOf(ctx), Started: clock.Now()}
+	traces.add(t)
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// tracef records one pipeline decision, in the decision trace and as an
+// event of the OpenTelemetry span; it is a no-op for untraced requests.
+func tracef(ctx context.Context, stage, fingerprint, format string, args ...any) {
+	t, ok := ctx.Value(traceKey{}).(*decisionTrace)
+	recording := spanRecording(ctx)
+	if !ok && !recording {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if recording {
+		spanEvent(ctx, stage, fingerprint, message)
+	}
+	if !ok {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Events = append(t.Events, traceEvent{
+		Offset:      since(t.Started).String(),
+		Stage:       stage,
+		Fingerprint: fingerprint,
+		Message:     message,
+	})
+}
+
+func (s *traceStore) add(t *decisionTrace) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, exists := s.byID[t.RequestID]; !exists {
+		s.order = append(s.order, t.RequestID)
+	}
+	s.byID[t.RequestID] = t
+	for len(s.order) > max(*traceHistory, 1) {
+		delete(s.byID, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+func (s *traceStore) get(id string) (*decisionTrace, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.byID[id]
+	return t, ok
+}
+
+func traceHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := traces.get(r.PathValue("id"))
+	if !ok || t.Tenant != tenantOf(r.Context()) {
+		http.Error(w, "no trace recorded for that request id", http.StatusNotFound)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(t)
+}
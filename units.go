@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Unit Conversion (current_value)
+=============================
+*/
+
+var unitRulesFile = flag.String("unit-rules", "", "YAML file mapping alertname to a current_value conversion (bytes_gib, bytes_mib, bytes_human, ratio_percent, seconds_human, round2)")
+
+var unitConversions = map[string]func(float64) string{
+	"bytes_gib":     func(v float64) string { return fmt.Sprintf("%.2f GiB", v/(1<<30)) },
+	"bytes_mib":     func(v float64) string { return fmt.Sprintf("%.1f MiB", v/(1<<20)) },
+	"bytes_human":   humanizeBytes,
+	"ratio_percent": func(v float64) string { return strconv.FormatFloat(v*100, 'f', 1, 64) + "%" },
+	"seconds_human": func(v float64) string { s, _ := humanizeDuration(v); return s },
+	"round2":        func(v float64) string { return strconv.FormatFloat(v, 'f', 2, 64) },
+}
+
+var unitRules map[string]string
+
+func loadUnitRules() error {
+	unitRules = nil
+	if *unitRulesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*unitRulesFile)
+	if err != nil {
+		return err
+	}
+	var rules map[string]string
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("%s: %w", *unitRulesFile, err)
+	}
+	for alertname, conv := range rules {
+		if unitConversions[conv] == nil {
+			return fmt.Errorf("%s: %s: unknown conversion %q", *unitRulesFile, alertname, conv)
+		}
+	}
+	unitRules = rules
+	return nil
+}
+
+func humanizeBytes(v float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := 0
+	for ; i < len(units)-1 && v >= 1024; i++ {
+		v /= 1024
+	}
+	if i == 0 {
+		return strconv.FormatFloat(v, 'f', -1, 64) + " B"
+	}
+	return fmt.Sprintf("%.2f %s", v, units[i])
+}
+
+// convertUnits rewrites current_value for the alert's alertname. The raw
+// value is kept as current_value_raw so templates can still show it.
+func convertUnits(a Alert) (Alert, bool) {
+	conv, ok := unitRules[a.Labels["alertname"]]
+	if !ok {
+		return a, false
+	}
+	if _, done := a.Annotations["current_value_raw"]; done {
+		return a, false // converted before, e.g. a replayed dead letter
+	}
+	raw := a.Annotations["current_value"]
+	v, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return a, false
+	}
+
+	annotations := make(map[string]string, len(a.Annotations)+1)
+	for k, val := range a.Annotations {
+		annotations[k] = val
+	}
+	annotations["current_value"] = unitConversions[conv](v)
+	annotations["current_value_raw"] = raw
+	a.Annotations = annotations
+	return a, true
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Alertmanager Config Compatibility Check
+=============================
+*/
+
+// Only the parts of an Alertmanager config that matter for talking to us.
+type amConfig struct {
+	Route     *amRoute     `yaml:"route"`
+	Receivers []amReceiver `yaml:"receivers"`
+}
+
+type amRoute struct {
+	Receiver       string     `yaml:"receiver"`
+	GroupBy        []string   `yaml:"group_by"`
+	GroupWait      string     `yaml:"group_wait"`
+	GroupInterval  string     `yaml:"group_interval"`
+	RepeatInterval string     `yaml:"repeat_interval"`
+	Routes         []*amRoute `yaml:"routes"`
+}
+
+type amReceiver struct {
+	Name           string      `yaml:"name"`
+	WebhookConfigs []amWebhook `yaml:"webhook_configs"`
+}
+
+type amWebhook struct {
+	URL          string       `yaml:"url"`
+	URLFile      string       `yaml:"url_file"`
+	SendResolved *bool        `yaml:"send_resolved"`
+	MaxAlerts    int          `yaml:"max_alerts"`
+	HTTPConfig   amHTTPConfig `yaml:"http_config"`
+}
+
+type amHTTPConfig struct {
+	BearerToken     string `yaml:"bearer_token"`
+	BearerTokenFile string `yaml:"bearer_token_file"`
+	Authorization   *struct {
+		Type            string `yaml:"type"`
+		Credentials     string `yaml:"credentials"`
+		CredentialsFile string `yaml:"credentials_file"`
+	} `yaml:"authorization"`
+	BasicAuth *struct {
+		Username string `yaml:"username"`
+	} `yaml:"basic_auth"`
+}
+
+type finding struct {
+	Level    string `json:"level"`
+	Receiver string `json:"receiver,omitempty"`
+	Message  string `json:"message"`
+}
+
+type upstreamReport struct {
+	Findings []finding `json:"findings"`
+	OK       bool      `json:"ok"`
+}
+
+// checkUpstream compares an Alertmanager config against this receiver.
+// expectHost optionally pins the host:port Alertmanager should be calling.
+func checkUpstream(raw []byte, expectHost string) (upstreamReport, error) {
+	var cfg amConfig
+	if err := yaml.Unmarshal(raw, &cfg); err != nil {
+		return upstreamReport{}, fmt.Errorf("parsing Alertmanager config: %w", err)
+	}
+
+	var findings []finding
+	add := func(level, receiver, format string, args ...any) {
+		findings = append(findings, finding{level, receiver, fmt.Sprintf(format, args...)})
+	}
+
+	routed := map[string]*amRoute{}
+	var walk func(r *amRoute, inherited amRoute)
+	walk = func(r *amRoute, inherited amRoute) {
+		if r == nil {
+			return
+		}
+		eff := inherited
+		if r.Receiver != "" {
+			eff.Receiver = r.Receiver
+		}
+		if r.RepeatInterval != "" {
+			eff.RepeatInterval = r.RepeatInterval
+		}
+		if r.GroupInterval != "" {
+			eff.GroupInterval = r.GroupInterval
+		}
+		if r.GroupBy != nil {
+			eff.GroupBy = r.GroupBy
+		}
+		if _, seen := routed[eff.Receiver]; !seen {
+			copied := eff
+			routed[eff.Receiver] = &copied
+		}
+		for _, child := range r.Routes {
+			walk(child, eff)
+		}
+	}
+	walk(cfg.Route, amRoute{})
+
+	_, port, _ := net.SplitHostPort(*listen)
+	matched := 0
+	for _, rcv := range cfg.Receivers {
+		for _, wh := range rcv.WebhookConfigs {
+			if wh.URL == "" {
+				if wh.URLFile != "" {
+					add("info", rcv.Name, "webhook URL is read from %s and cannot be checked", wh.URLFile)
+				}
+				continue
+			}
+			u, err := url.Parse(wh.URL)
+			if err != nil {
+				add("error", rcv.Name, "unparseable webhook URL %q", wh.URL)
+				continue
+			}
+
+			ours := expectHost != "" && u.Host == expectHost
+			if !ours && (u.Path == alertsPath || strings.HasPrefix(u.Path, alertsPath+"/")) {
+				ours = true
+			}
+			if !ours {
+				continue
+			}
+			matched++
+
+			if expectHost != "" && u.Host != expectHost {
+				add("error", rcv.Name, "webhook targets %s, expected %s", u.Host, expectHost)
+			}
+			if u.Path != alertsPath {
+				add("error", rcv.Name, "webhook path is %q, this receiver listens on %q", u.Path, alertsPath)
+			}
+			if scheme := listenScheme(); u.Scheme != scheme {
+				add("error", rcv.Name, "webhook uses %s but this receiver serves %s", u.Scheme, scheme)
+			}
+			if p := u.Port(); p != "" && port != "" && p != port {
+				add("warning", rcv.Name, "webhook port %s differs from listen port %s", p, port)
+			}
+
+			if wh.SendResolved != nil && !*wh.SendResolved {
+				add("warning", rcv.Name, "send_resolved is false: resolved alerts never arrive, so active-alert tracking and acknowledgments go stale")
+			}
+			if wh.MaxAlerts > 0 {
+				add("warning", rcv.Name, "max_alerts=%d truncates large groups; dropped alerts are never logged", wh.MaxAlerts)
+			}
+			checkWebhookAuth(wh.HTTPConfig, func(level, format string, args ...any) {
+				add(level, rcv.Name, format, args...)
+			})
+
+			r, ok := routed[rcv.Name]
+			if !ok {
+				add("warning", rcv.Name, "receiver is not referenced by any route and will never be notified")
+				continue
+			}
+			if len(r.GroupBy) == 1 && r.GroupBy[0] == "..." {
+				add("info", rcv.Name, "group_by: ['...'] sends one notification per alert")
+			}
+			if d, err := parseInterval(r.RepeatInterval); err == nil && d < time.Minute {
+				add("warning", rcv.Name, "repeat_interval %s re-sends firing alerts very frequently", r.RepeatInterval)
+			}
+		}
+	}
+
+	if matched == 0 {
+		add("error", "", "no webhook receiver points at this instance (path %s)", alertsPath)
+	}
+
+	report := upstreamReport{Findings: findings, OK: true}
+	if report.Findings == nil {
+		report.Findings = []finding{}
+	}
+	for _, f := range findings {
+		if f.Level == "error" {
+			report.OK = false
+		}
+	}
+	return report, nil
+}
+
+// checkWebhookAuth compares the webhook's credentials with -auth.
+func checkWebhookAuth(h amHTTPConfig, add func(level, format string, args ...any)) {
+	bearer := h.BearerToken != "" || h.BearerTokenFile != "" ||
+		h.Authorization != nil && (h.Authorization.Type == "" || strings.EqualFold(h.Authorization.Type, "Bearer"))
+	switch *authMode {
+	case "bearer":
+		if !bearer {
+			add("error", "-auth=bearer but the webhook sends no bearer token")
+		}
+	case "basic":
+		if h.BasicAuth == nil {
+			add("error", "-auth=basic but the webhook has no basic_auth")
+		} else if h.BasicAuth.Username != *authUser {
+			add("error", "basic_auth username %q differs from -auth-user %q", h.BasicAuth.Username, *authUser)
+		}
+	case "hmac":
+		add("warning", "-auth=hmac: Alertmanager cannot sign webhook bodies, so it needs a signing proxy in between")
+	default:
+		if hasCredentials(h) && !tenancyEnabled() {
+			add("info", "credentials are configured but this receiver does not require authentication")
+		}
+	}
+}
+
+func hasCredentials(h amHTTPConfig) bool {
+	return h.BearerToken != "" || h.BearerTokenFile != "" || h.Authorization != nil || h.BasicAuth != nil
+}
+
+// loadAlertmanagerConfig reads a config file, or fetches it from a URL. The
+// Alertmanager status API (/api/v2/status) wraps the YAML in JSON.
+func loadAlertmanagerConfig(source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", source, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	var status struct {
+		Config struct {
+			Original string `json:"original"`
+		} `json:"config"`
+	}
+	if json.Unmarshal(body, &status) == nil && status.Config.Original != "" {
+		return []byte(status.Config.Original), nil
+	}
+	return body, nil
+}
+
+/*
+=============================
+ check-upstream Command & Endpoint
+=============================
+*/
+
+func runCheckUpstream(args []string) error {
+	fs := flag.NewFlagSet("check-upstream", flag.ExitOnError)
+	expect := fs.String("expect-host", "", "host:port Alertmanager should use to reach this receiver")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: check-upstream [-expect-host host:port] <alertmanager.yml | URL>")
+	}
+	raw, err := loadAlertmanagerConfig(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	report, err := checkUpstream(raw, *expect)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range report.Findings {
+		if f.Receiver != "" {
+			fmt.Printf("%-7s [%s] %s\n", strings.ToUpper(f.Level), f.Receiver, f.Message)
+		} else {
+			fmt.Printf("%-7s %s\n", strings.ToUpper(f.Level), f.Message)
+		}
+	}
+	if !report.OK {
+		return errors.New("upstream config is not compatible")
+	}
+	fmt.Println("upstream config is compatible")
+	return nil
+}
+
+// checkUpstreamHandler checks the config posted as the request body. It
+// takes the admin token and loads nothing itself: paths and URLs are for
+// the command, run by whoever may read them. Parse errors are not passed
+// on, as they quote the config.
+func checkUpstreamHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := bodyReader(w, r)
+	var raw []byte
+	if err == nil {
+		raw, err = io.ReadAll(body)
+	}
+	if err != nil {
+		switch readRejectReason(err) {
+		case rejectTooLarge:
+			http.Error(w, "body too large", http.StatusRequestEntityTooLarge)
+		case rejectEncoding:
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		default:
+			http.Error(w, "reading body failed", http.StatusBadRequest)
+		}
+		return
+	}
+
+	report, err := checkUpstream(raw, r.URL.Query().Get("expect_host"))
+	if err != nil {
+		slog.Warn("check-upstream: invalid config", "req_id", requestID(r.Context()), "err", err)
+		http.Error(w, "body is not a valid Alertmanager config", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
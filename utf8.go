@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/*
+=============================
+ UTF-8 Repair & Output Escaping
+=============================
+*/
+
+var (
+	invalidUTF8 = flag.String("invalid-utf8", "replace", "invalid UTF-8 in webhook payloads: replace (with U+FFFD) or strip")
+	nonASCII    = flag.String("non-ascii", "keep", `non-ASCII characters in output: keep (raw UTF-8) or escape (as \uXXXX, ASCII-only files)`)
+)
+
+var invalidUTF8Total = newCounterVec("invalid_utf8_requests_total", "Webhook requests whose body contained invalid UTF-8.", "policy")
+
+func validEncodingOptions() error {
+	if *invalidUTF8 != "replace" && *invalidUTF8 != "strip" {
+		return fmt.Errorf("invalid -invalid-utf8 %q", *invalidUTF8)
+	}
+	if *nonASCII != "keep" && *nonASCII != "escape" {
+		return fmt.Errorf("invalid -non-ascii %q", *nonASCII)
+	}
+	return nil
+}
+
+func repairString(s string) string {
+	if *invalidUTF8 == "strip" {
+		return strings.ToValidUTF8(s, "")
+	}
+	return strings.ToValidUTF8(s, "\uFFFD")
+}
+
+// repairUTF8 fixes invalid UTF-8 in a request body before decoding.
+// encoding/json would otherwise substitute U+FFFD silently, without a
+// chance to count it or apply the strip policy. JSON syntax is pure ASCII,
+// so repairing the whole body only touches string contents.
+func repairUTF8(body []byte) ([]byte, bool) {
+	if utf8.Valid(body) {
+		return body, false
+	}
+	invalidUTF8Total.add(*invalidUTF8, 1)
+	return []byte(repairString(string(body))), true
+}
+
+// utf8Repairer does what repairUTF8 does for a body decoded as it comes
+// in. Bytes at the end of a read that may not be complete yet wait for the
+// next, so the body comes out as if it had been repaired whole.
+type utf8Repairer struct {
+	r        io.Reader
+	buf      []byte
+	held     int // bytes at the start of buf kept back from the last read
+	out      []byte
+	err      error
+	repaired bool
+}
+
+func (u *utf8Repairer) Read(p []byte) (int, error) {
+	for len(u.out) == 0 {
+		if u.err != nil {
+			return 0, u.err
+		}
+		if u.buf == nil {
+			u.buf = make([]byte, 32<<10)
+		} else if u.held == len(u.buf) {
+			u.buf = append(u.buf, make([]byte, len(u.buf))...)
+		}
+		n, err := u.r.Read(u.buf[u.held:])
+		n += u.held
+		u.err = err
+		end := n
+		if err == nil {
+			end = runeBoundary(u.buf[:n], u.held)
+		}
+		if chunk := u.buf[:end]; utf8.Valid(chunk) {
+			u.out = append(u.out[:0], chunk...)
+		} else {
+			u.repaired = true
+			u.out = append(u.out[:0], repairString(string(chunk))...)
+		}
+		if u.held = n - end; end > 0 {
+			copy(u.buf, u.buf[end:n])
+		}
+	}
+	n := copy(p, u.out)
+	u.out = u.out[n:]
+	return n, nil
+}
+
+// runeBoundary is where the last valid rune of b ends, 0 if none ends
+// after from. What follows may be a rune cut off or a run of invalid
+// bytes, which repairString replaces as one and which may go on in the
+// next read.
+func runeBoundary(b []byte, from int) int {
+	for end := len(b); end > from; end-- {
+		if r, size := utf8.DecodeLastRune(b[:end]); r != utf8.RuneError || size > 1 {
+			return end
+		}
+	}
+	return 0
+}
+
+// encodeEntry renders one output line, without the trailing newline,
+// honouring the escaping options.
+func encodeEntry(entry JSONLog) ([]byte, error) {
+	var line []byte
+	if entry.custom != nil {
+		var err error
+		if line, err = entry.custom.encode(entry); err != nil {
+			return nil, err
+		}
+	} else {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(entry); err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	}
+	if *nonASCII == "escape" {
+		line = escapeNonASCII(line)
+	}
+	return line, nil
+}
+
+// escapeNonASCII rewrites every non-ASCII rune as a JSON \u escape. In our
+// records such runes only occur inside strings, so the line stays valid.
+func escapeNonASCII(line []byte) []byte {
+	out := make([]byte, 0, len(line))
+	for _, r := range string(line) {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+			continue
+		}
+		if r > 0xFFFF {
+			hi, lo := utf16.EncodeRune(r)
+			out = fmt.Appendf(out, `\u%04x\u%04x`, hi, lo)
+			continue
+		}
+		out = fmt.Appendf(out, `\u%04x`, r)
+	}
+	return out
+}
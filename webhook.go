@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+=============================
+ Webhook Forwarding Sink
+=============================
+*/
+
+var (
+	webhookURL       = flag.String("webhook-url", "", "URL each processed alert is POSTed to as JSON; empty disables the webhook sink unless -webhook-endpoints is set")
+	webhookTimeout   = flag.Duration("webhook-timeout", 5*time.Second, "deadline for one webhook POST")
+	webhookEndpoints = flag.String("webhook-endpoints", "", "YAML file of downstream endpoints with their own headers, timeout, retries, proxy and body (transformed or original)")
+)
+
+// webhookEndpoint is one downstream receiver. Body "transformed" POSTs each
+// alert after the pipeline; "original" relays the Alertmanager payload as
+// it was received, once per request and route.
+type webhookEndpoint struct {
+	Name    string            `yaml:"name"`
+	URL     string            `yaml:"url"`
+	Body    string            `yaml:"body"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+	Proxy   string            `yaml:"proxy"`
+	Retry   struct {
+		Attempts int           `yaml:"attempts"`
+		Initial  time.Duration `yaml:"initial"`
+		Max      time.Duration `yaml:"max"`
+	} `yaml:"retry"`
+
+	client *http.Client
+}
+
+type webhookConfig struct {
+	Endpoints []*webhookEndpoint `yaml:"endpoints"`
+}
+
+const (
+	webhookTransformed = "transformed"
+	webhookOriginal    = "original"
+)
+
+var webhookTargets []*webhookEndpoint
+
+func webhookEnabled() bool {
+	return len(webhookTargets) > 0
+}
+
+// loadWebhooks reads -webhook-endpoints and adds -webhook-url as one more
+// endpoint with the defaults. It runs before the sinks are built.
+func loadWebhooks() error {
+	var endpoints []*webhookEndpoint
+	if *webhookEndpoints != "" {
+		data, err := os.ReadFile(*webhookEndpoints)
+		if err != nil {
+			return err
+		}
+		var cfg webhookConfig
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s: %w", *webhookEndpoints, err)
+		}
+		endpoints = cfg.Endpoints
+	}
+	if *webhookURL != "" {
+		endpoints = append(endpoints, &webhookEndpoint{URL: *webhookURL})
+	}
+
+	names := map[string]bool{}
+	for i, e := range endpoints {
+		if err := e.prepare(); err != nil {
+			return fmt.Errorf("webhook endpoint %d: %w", i+1, err)
+		}
+		if names[e.Name] {
+			return fmt.Errorf("webhook endpoint %q is defined twice", e.Name)
+		}
+		names[e.Name] = true
+	}
+	webhookTargets = endpoints
+	return nil
+}
+
+// prepare fills in defaults and builds the endpoint's client.
+func (e *webhookEndpoint) prepare() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url %q", e.URL)
+	}
+	if e.Name == "" {
+		e.Name = u.Host
+	}
+	switch e.Body {
+	case "":
+		e.Body = webhookTransformed
+	case webhookTransformed, webhookOriginal:
+	default:
+		return fmt.Errorf("%s: invalid body %q, want %s or %s", e.Name, e.Body, webhookTransformed, webhookOriginal)
+	}
+	if e.Timeout <= 0 {
+		e.Timeout = *webhookTimeout
+	}
+	if e.Retry.Attempts <= 0 {
+		e.Retry.Attempts = *retryAttempts
+	}
+	if e.Retry.Initial <= 0 {
+		e.Retry.Initial = *retryInitial
+	}
+	if e.Retry.Max < e.Retry.Initial {
+		e.Retry.Max = max(*retryMax, e.Retry.Initial)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if e.Proxy != "" {
+		proxy, err := url.Parse(e.Proxy)
+		if err != nil || proxy.Host == "" {
+			return fmt.Errorf("%s: invalid proxy %q", e.Name, e.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	e.client = &http.Client{Timeout: e.Timeout, Transport: transport}
+	return nil
+}
+
+// webhookSink forwards to every endpoint. Each endpoint retries on its own
+// policy, so one that recovers is not sent the alerts again for another
+// that stays down; an endpoint that gives up is reported as a
+// targetError, which the dead-letter record keeps for replay.
+type webhookSink struct {
+	endpoints []*webhookEndpoint
+}
+
+func (*webhookSink) Name() string { return sinkWebhook }
+
+func (s *webhookSink) Write(ctx context.Context, a Alert) error {
+	return s.WriteBatch(ctx, []Alert{a})
+}
+
+func (s *webhookSink) WriteBatch(ctx context.Context, alerts []Alert) error {
+	only := replayTarget(ctx)
+	raw := rawPayloadOf(ctx)
+	var errs []error
+	for _, e := range s.endpoints {
+		if only != "" && e.Name != only {
+			continue
+		}
+		if e.Body == webhookOriginal && raw != nil {
+			if !raw.claim(e.Name + "\x00" + routeOf(ctx).Name) {
+				continue // relayed with the other lane's alerts
+			}
+			err := e.send(ctx, raw.body)
+			for _, a := range alerts {
+				recordDelivery(ctx, sinkWebhook, e.URL, a, err)
+			}
+			if err != nil {
+				errs = append(errs, targetError{target: e.Name, err: err})
+			}
+			continue
+		}
+		for _, a := range alerts {
+			body, err := json.Marshal(alertMessage{Alert: a, Tenant: tenantOf(ctx), RequestID: requestID(ctx)})
+			if err == nil {
+				err = e.send(ctx, body)
+			}
+			if recordDelivery(ctx, sinkWebhook, e.URL, a, err) != nil {
+				errs = append(errs, targetError{target: e.Name, fingerprint: a.Fingerprint, err: err})
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// send POSTs body with the endpoint's retries, behind its own breaker.
+func (e *webhookEndpoint) send(ctx context.Context, body []byte) error {
+	breaker := breakerFor(sinkWebhook + ":" + e.Name)
+	var err error
+	for try := 1; ; try++ {
+		err = breaker.call(func() error { return e.post(ctx, body) })
+		if err == nil || try >= e.Retry.Attempts || !retryable(err) {
+			break
+		}
+		wait := backoffWith(try, e.Retry.Initial, e.Retry.Max)
+		tracef(ctx, "retry", "", "%s: try %d failed (%v), next in %s", e.Name, try, err, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", errGaveUp, err)
+		case <-time.After(wait):
+		}
+		deliveryRetries.add(sinkWebhook, 1)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", errGaveUp, err)
+	}
+	return nil
+}
+
+func (e *webhookEndpoint) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	injectTrace(ctx, req)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(requestIDHeader, requestID(ctx))
+	for k, v := range e.Headers {
+		req.Header.Set(k, os.ExpandEnv(v))
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+type rawPayloadKey struct{}
+
+// rawPayload is the request body, kept for sinks that relay it unchanged
+// and for -raw-payload. The priority and normal lanes reach the sinks
+// separately, so it also remembers where it was already relayed.
+type rawPayload struct {
+	body []byte
+	mu   sync.Mutex
+	sent map[string]bool
+
+	copyOnce sync.Once
+	copy     []byte
+}
+
+func withRawPayload(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, rawPayloadKey{}, &rawPayload{body: body, sent: map[string]bool{}})
+}
+
+func rawPayloadOf(ctx context.Context) *rawPayload {
+	p, _ := ctx.Value(rawPayloadKey{}).(*rawPayload)
+	return p
+}
+
+// claim reports whether the payload is yet to be relayed to key.
+func (p *rawPayload) claim(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.sent[key] {
+		return false
+	}
+	p.sent[key] = true
+	return true
+}